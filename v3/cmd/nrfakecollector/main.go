@@ -0,0 +1,103 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Command nrfakecollector runs a fake New Relic collector, which records the
+// payloads sent by the agent and replies with scripted responses.  It allows
+// applications using the agent to be tested end to end without internet
+// access.
+//
+// By default, the collector listens on 127.0.0.1:8443 using a self-signed
+// certificate valid for 127.0.0.1.  Write the certificate with -cert-out, and
+// configure the application to trust it and to connect to the collector, here
+// for an application using newrelic.ConfigFromEnvironment:
+//
+//	nrfakecollector -cert-out /tmp/collector.pem &
+//	SSL_CERT_FILE=/tmp/collector.pem NEW_RELIC_HOST=127.0.0.1:8443 ./myapp
+//
+// The collector is scripted over HTTPS:
+//
+//	# Respond to the next two metric_data requests with 503.
+//	curl --cacert /tmp/collector.pem -X POST -d '[{"status_code":503},{"status_code":503}]' \
+//		'https://127.0.0.1:8443/fakecollector/responses?method=metric_data'
+//	# Send security policies in the preconnect reply.
+//	curl --cacert /tmp/collector.pem -X PUT -d '{"security_policies":{...}}' \
+//		https://127.0.0.1:8443/fakecollector/preconnect_reply
+//	# List the span_event_data requests received.
+//	curl --cacert /tmp/collector.pem 'https://127.0.0.1:8443/fakecollector/requests?method=span_event_data'
+package main
+
+import (
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+
+	"github.com/newrelic/go-agent/v3/newrelic/fakecollector"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8443", "address to listen on")
+	certFile := flag.String("cert", "", "certificate file; a self-signed certificate is used if empty")
+	keyFile := flag.String("key", "", "key file of the certificate")
+	certOut := flag.String("cert-out", "", "file to write the self-signed certificate to")
+	verbose := flag.Bool("v", false, "log the requests of the agent")
+	flag.Parse()
+
+	collector := fakecollector.New()
+	var handler http.Handler = collector
+	if *verbose {
+		handler = logRequests(collector)
+	}
+
+	if *certFile != "" {
+		log.Printf("listening on https://%s", *addr)
+		log.Fatal(http.ListenAndServeTLS(*addr, *certFile, *keyFile, handler))
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener.Close()
+	srv.Listener = ln
+	srv.StartTLS()
+	defer srv.Close()
+
+	if *certOut != "" {
+		cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+		if err := os.WriteFile(*certOut, cert, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("listening on %s", srv.URL)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+}
+
+// logRequests logs the requests of the agent once they have been recorded.
+func logRequests(c *fakecollector.Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, r)
+		if r.URL.Path == fakecollector.ListenerPath {
+			requests := c.Requests()
+			if len(requests) > 0 {
+				last := requests[len(requests)-1]
+				fmt.Fprintf(os.Stderr, "%s %d run_id=%q %s\n", last.Method, rec.Code, last.RunID, last.Body)
+			}
+		}
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		rec.Body.WriteTo(w)
+	})
+}
@@ -0,0 +1,8 @@
+module github.com/newrelic/go-agent/v3/integrations/nrazurefunctions
+
+go 1.20
+
+require github.com/newrelic/go-agent/v3 v3.32.0
+
+
+replace github.com/newrelic/go-agent/v3 => ../..
@@ -0,0 +1,164 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nrazurefunctions adds support for Azure Functions custom handlers.
+//
+// Go functions run on Azure Functions as custom handlers: an HTTP server that
+// the Functions host forwards each invocation to.  Use this package to
+// instrument the handler of each function with the agent in serverless mode.
+// The agent does not connect to New Relic: the data gathered during each
+// invocation is written to stdout when the invocation completes and is
+// collected from the function's logs.
+//
+// Each transaction is named after the function and carries the
+// cloud.provider, cloud.region, faas.name, faas.instance, faas.invocation_id,
+// faas.trigger and faas.coldstart attributes.
+//
+//	app, _ := newrelic.NewApplication(
+//		newrelic.ConfigAppName("my function app"),
+//		nrazurefunctions.ConfigOption(),
+//	)
+//	http.Handle("/api/hello", nrazurefunctions.WrapHandler(app, "hello", newrelic.FaaSTriggerHTTP, helloHandler))
+//	http.Handle("/orders", nrazurefunctions.WrapHandler(app, "orders", newrelic.FaaSTriggerPubSub, ordersHandler))
+//	http.ListenAndServe(":"+os.Getenv("FUNCTIONS_CUSTOMHANDLER_PORT"), nil)
+package nrazurefunctions
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func init() { internal.TrackUsage("integration", "framework", "azurefunctions") }
+
+// ConfigOption populates a newrelic.Config with the settings required to run
+// in serverless mode on Azure Functions.  Environment variables
+// NEW_RELIC_ACCOUNT_ID, NEW_RELIC_TRUSTED_ACCOUNT_KEY, and
+// NEW_RELIC_PRIMARY_APPLICATION_ID configure fields required for distributed
+// tracing.  Environment variable NEW_RELIC_APDEX_T may be used to set a custom
+// apdex threshold.
+func ConfigOption() newrelic.ConfigOption { return newConfigInternal(os.Getenv) }
+
+func newConfigInternal(getenv func(string) string) newrelic.ConfigOption {
+	return func(cfg *newrelic.Config) {
+		cfg.ServerlessMode.Enabled = true
+
+		cfg.ServerlessMode.AccountID = getenv("NEW_RELIC_ACCOUNT_ID")
+		cfg.ServerlessMode.TrustedAccountKey = getenv("NEW_RELIC_TRUSTED_ACCOUNT_KEY")
+		cfg.ServerlessMode.PrimaryAppID = getenv("NEW_RELIC_PRIMARY_APPLICATION_ID")
+
+		cfg.DistributedTracer.Enabled = true
+
+		if s := getenv("NEW_RELIC_APDEX_T"); "" != s {
+			if apdex, err := time.ParseDuration(s + "s"); nil == err {
+				cfg.ServerlessMode.ApdexThreshold = apdex
+			}
+		}
+	}
+}
+
+// invocationIDHeader is set by the Functions host on each request forwarded
+// to the custom handler.
+const invocationIDHeader = "X-Azure-Functions-InvocationId"
+
+// coldStart is shared by all the functions of the function app: they are
+// served by the same custom handler process.
+var coldStart sync.Once
+
+type wrapper struct {
+	app          *newrelic.Application
+	functionName string
+	trigger      string
+	serverless   bool
+	region       string
+	instance     string
+	// writer receives the serverless payload at the end of each
+	// invocation.  This field exists mostly for testing.
+	writer io.Writer
+}
+
+func newWrapper(app *newrelic.Application, functionName, trigger string, getenv func(string) string) *wrapper {
+	cfg, _ := app.Config()
+	return &wrapper{
+		app:          app,
+		functionName: functionName,
+		trigger:      trigger,
+		serverless:   cfg.ServerlessMode.Enabled,
+		region:       getenv("REGION_NAME"),
+		instance:     getenv("WEBSITE_INSTANCE_ID"),
+		writer:       os.Stdout,
+	}
+}
+
+// flush writes the invocation's data.  A fresh context is used since the
+// request context is canceled when the host goes away, and the data should
+// be written regardless.
+func (w *wrapper) flush() {
+	if !w.serverless {
+		return
+	}
+	if err := w.app.FlushServerless(context.Background(), w.writer); nil != err {
+		if cfg, _ := w.app.Config(); nil != cfg.Logger {
+			cfg.Logger.Error("unable to flush serverless data", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+func (w *wrapper) serve(rw http.ResponseWriter, r *http.Request, handler http.Handler) {
+	defer w.flush()
+
+	txn := w.app.StartTransaction(w.functionName)
+	defer txn.End()
+
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeCloudProvider, "azure", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeCloudRegion, w.region, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSName, w.functionName, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSInstance, w.instance, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSInvocationID, r.Header.Get(invocationIDHeader), nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSTrigger, w.trigger, nil)
+	coldStart.Do(func() {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSColdStart, "", true)
+	})
+
+	// Only HTTP triggered functions receive the original request: other
+	// triggers receive the invocation payload of the Functions host.
+	if w.trigger == newrelic.FaaSTriggerHTTP {
+		txn.SetWebRequestHTTP(r)
+		rw = txn.SetWebResponse(rw)
+	}
+	r = newrelic.RequestWithTransactionContext(r, txn)
+
+	handler.ServeHTTP(rw, r)
+}
+
+// WrapHandler instruments the custom handler of an Azure function.
+// functionName is used as the transaction name, and trigger is recorded as
+// the faas.trigger attribute and should be one of the newrelic.FaaSTrigger
+// constants.  HTTP triggered functions (which must have
+// enableForwardingHttpRequest set in host.json) create web transactions,
+// other triggers create background transactions.  In serverless mode the
+// data is written to stdout when the handler returns.  If app is nil, the
+// handler is returned unchanged.
+func WrapHandler(app *newrelic.Application, functionName, trigger string, handler http.Handler) http.Handler {
+	if nil == app {
+		return handler
+	}
+	w := newWrapper(app, functionName, trigger, os.Getenv)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.serve(rw, r, handler)
+	})
+}
+
+// WrapHandlerFunc is the http.HandlerFunc equivalent of WrapHandler.
+func WrapHandlerFunc(app *newrelic.Application, functionName, trigger string, handler http.HandlerFunc) http.HandlerFunc {
+	return WrapHandler(app, functionName, trigger, handler).ServeHTTP
+}
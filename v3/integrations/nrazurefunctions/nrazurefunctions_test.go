@@ -0,0 +1,138 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrazurefunctions
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func testApp(t *testing.T) *newrelic.Application {
+	coldStart = sync.Once{}
+	cfg := newConfigInternal(func(string) string { return "" })
+	app, err := newrelic.NewApplication(cfg, newrelic.ConfigCodeLevelMetricsEnabled(false))
+	if nil != err {
+		t.Fatal(err)
+	}
+	internal.HarvestTesting(app.Private, nil)
+	return app
+}
+
+func testEnv(key string) string {
+	switch key {
+	case "REGION_NAME":
+		return "West Europe"
+	case "WEBSITE_INSTANCE_ID":
+		return "instance-id"
+	default:
+		return ""
+	}
+}
+
+func TestHTTPTrigger(t *testing.T) {
+	app := testApp(t)
+	w := newWrapper(app, "hello", newrelic.FaaSTriggerHTTP, testEnv)
+	buf := &bytes.Buffer{}
+	w.writer = buf
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if nil == newrelic.FromContext(r.Context()) {
+			t.Error("missing transaction")
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	})
+	req := httptest.NewRequest("POST", "/api/hello", nil)
+	req.Header.Set(invocationIDHeader, "invocation-id")
+	w.serve(httptest.NewRecorder(), req, handler)
+
+	app.Private.(internal.Expect).ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":             "WebTransaction/Go/hello",
+			"nr.apdexPerfZone": internal.MatchAnything,
+			"guid":             internal.MatchAnything,
+			"priority":         internal.MatchAnything,
+			"sampled":          internal.MatchAnything,
+			"traceId":          internal.MatchAnything,
+		},
+		UserAttributes: map[string]interface{}{},
+		AgentAttributes: map[string]interface{}{
+			"cloud.provider":       "azure",
+			"cloud.region":         "West Europe",
+			"faas.name":            "hello",
+			"faas.instance":        "instance-id",
+			"faas.invocation_id":   "invocation-id",
+			"faas.trigger":         "http",
+			"faas.coldstart":       true,
+			"request.method":       "POST",
+			"request.uri":          "/api/hello",
+			"request.headers.host": "example.com",
+			"http.statusCode":      202,
+			"httpResponseCode":     "202",
+		},
+	}})
+	if 0 == buf.Len() {
+		t.Error("no output written")
+	}
+}
+
+func TestQueueTrigger(t *testing.T) {
+	app := testApp(t)
+	w := newWrapper(app, "orders", newrelic.FaaSTriggerPubSub, testEnv)
+	buf := &bytes.Buffer{}
+	w.writer = buf
+
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"Outputs":{},"Logs":[]}`))
+	})
+
+	// Invoke twice: the second invocation is not a cold start.
+	for i := 0; i < 2; i++ {
+		internal.HarvestTesting(app.Private, nil)
+		buf.Reset()
+		req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"Data":{},"Metadata":{}}`))
+		w.serve(httptest.NewRecorder(), req, handler)
+
+		want := map[string]interface{}{
+			"cloud.provider": "azure",
+			"cloud.region":   "West Europe",
+			"faas.name":      "orders",
+			"faas.instance":  "instance-id",
+			"faas.trigger":   "pubsub",
+		}
+		if i == 0 {
+			want["faas.coldstart"] = true
+		}
+		app.Private.(internal.Expect).ExpectTxnEvents(t, []internal.WantEvent{{
+			Intrinsics: map[string]interface{}{
+				"name":     "OtherTransaction/Go/orders",
+				"guid":     internal.MatchAnything,
+				"priority": internal.MatchAnything,
+				"sampled":  internal.MatchAnything,
+				"traceId":  internal.MatchAnything,
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: want,
+		}})
+		if 0 == buf.Len() {
+			t.Error("no output written")
+		}
+	}
+}
+
+func TestWrapNilApp(t *testing.T) {
+	called := false
+	h := WrapHandlerFunc(nil, "hello", newrelic.FaaSTriggerHTTP, func(http.ResponseWriter, *http.Request) { called = true })
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !called {
+		t.Error("handler not called")
+	}
+}
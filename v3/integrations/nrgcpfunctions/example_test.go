@@ -0,0 +1,60 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrgcpfunctions_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/newrelic/go-agent/v3/integrations/nrgcpfunctions"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func hello(w http.ResponseWriter, r *http.Request) {
+	txn := newrelic.FromContext(r.Context())
+	txn.AddAttribute("greeting", "hello")
+	w.Write([]byte("hello world\n"))
+}
+
+// This example shows a Cloud Run service instrumented in serverless mode.
+func Example() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("Cloud Run App"),
+		nrgcpfunctions.ConfigOption(),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	http.HandleFunc("/", nrgcpfunctions.WrapHTTPFunction(app, hello))
+	http.ListenAndServe(":"+os.Getenv("PORT"), nil)
+}
+
+type pubSubMessage struct {
+	Data []byte `json:"data"`
+}
+
+func consume(ctx context.Context, m pubSubMessage) error {
+	defer newrelic.FromContext(ctx).StartSegment("process").End()
+	return nil
+}
+
+// This example shows a Pub/Sub triggered function.  Register the wrapped
+// function with the Functions Framework in place of consume.
+func ExampleWrapEventFunction() {
+	app, err := newrelic.NewApplication(
+		newrelic.ConfigAppName("Pub/Sub Function"),
+		nrgcpfunctions.ConfigOption(),
+	)
+	if nil != err {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	handler := nrgcpfunctions.WrapEventFunction(app, newrelic.FaaSTriggerPubSub, consume)
+	_ = handler
+}
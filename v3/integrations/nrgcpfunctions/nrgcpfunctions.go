@@ -0,0 +1,246 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package nrgcpfunctions adds support for Google Cloud Functions and Cloud
+// Run.
+//
+// Use this package to instrument Go functions deployed to Cloud Functions, or
+// HTTP services deployed to Cloud Run, with the agent in serverless mode.  The
+// agent does not connect to New Relic: the data gathered during each
+// invocation is written to stdout when the invocation completes and is
+// collected from the function's logs.
+//
+// Each transaction is named after the function (the FUNCTION_TARGET or
+// K_SERVICE environment variable) and carries the cloud.provider,
+// cloud.region, faas.name, faas.instance, faas.invocation_id, faas.trigger
+// and faas.coldstart attributes.
+//
+//	func init() {
+//		app, _ := newrelic.NewApplication(
+//			newrelic.ConfigAppName("my function"),
+//			nrgcpfunctions.ConfigOption(),
+//		)
+//		functions.HTTP("Hello", nrgcpfunctions.WrapHTTPFunction(app, hello))
+//	}
+package nrgcpfunctions
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/integrationsupport"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func init() { internal.TrackUsage("integration", "framework", "gcpfunctions") }
+
+// ConfigOption populates a newrelic.Config with the settings required to run
+// in serverless mode on Cloud Functions and Cloud Run.  Environment variables
+// NEW_RELIC_ACCOUNT_ID, NEW_RELIC_TRUSTED_ACCOUNT_KEY, and
+// NEW_RELIC_PRIMARY_APPLICATION_ID configure fields required for distributed
+// tracing.  Environment variable NEW_RELIC_APDEX_T may be used to set a custom
+// apdex threshold.
+func ConfigOption() newrelic.ConfigOption { return newConfigInternal(os.Getenv) }
+
+func newConfigInternal(getenv func(string) string) newrelic.ConfigOption {
+	return func(cfg *newrelic.Config) {
+		cfg.ServerlessMode.Enabled = true
+
+		cfg.ServerlessMode.AccountID = getenv("NEW_RELIC_ACCOUNT_ID")
+		cfg.ServerlessMode.TrustedAccountKey = getenv("NEW_RELIC_TRUSTED_ACCOUNT_KEY")
+		cfg.ServerlessMode.PrimaryAppID = getenv("NEW_RELIC_PRIMARY_APPLICATION_ID")
+
+		cfg.DistributedTracer.Enabled = true
+
+		if s := getenv("NEW_RELIC_APDEX_T"); "" != s {
+			if apdex, err := time.ParseDuration(s + "s"); nil == err {
+				cfg.ServerlessMode.ApdexThreshold = apdex
+			}
+		}
+	}
+}
+
+const (
+	// metadataURL is the root of the Compute metadata server, which is
+	// the only source of the region and instance id on Cloud Run and 2nd
+	// gen Cloud Functions.
+	metadataURL     = "http://metadata.google.internal/computeMetadata/v1/"
+	metadataTimeout = time.Second
+
+	// executionIDHeader is set by Cloud Functions on requests to HTTP
+	// functions.
+	executionIDHeader = "Function-Execution-Id"
+)
+
+// coldStart is shared by all the wrapped functions: a Cloud Run service may
+// wrap several handlers, all served by the same instance.
+var coldStart sync.Once
+
+type wrapper struct {
+	app          *newrelic.Application
+	functionName string
+	serverless   bool
+
+	getenv      func(string) string
+	metadataURL string
+	client      *http.Client
+
+	metadataOnce sync.Once
+	region       string
+	instance     string
+
+	// writer receives the serverless payload at the end of each
+	// invocation.  This field exists mostly for testing.
+	writer io.Writer
+}
+
+func newWrapper(app *newrelic.Application, getenv func(string) string) *wrapper {
+	name := getenv("FUNCTION_TARGET")
+	if name == "" {
+		name = getenv("K_SERVICE")
+	}
+	if name == "" {
+		name = getenv("FUNCTION_NAME")
+	}
+	cfg, _ := app.Config()
+	return &wrapper{
+		app:          app,
+		functionName: name,
+		serverless:   cfg.ServerlessMode.Enabled,
+		getenv:       getenv,
+		metadataURL:  metadataURL,
+		client:       &http.Client{Timeout: metadataTimeout},
+		writer:       os.Stdout,
+	}
+}
+
+// queryMetadata returns the value of a metadata server key, or the empty
+// string if the metadata server cannot be reached.
+func (w *wrapper) queryMetadata(key string) string {
+	req, err := http.NewRequest("GET", w.metadataURL+key, nil)
+	if nil != err {
+		return ""
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := w.client.Do(req)
+	if nil != err {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if nil != err {
+		return ""
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func (w *wrapper) resolveMetadata() {
+	w.metadataOnce.Do(func() {
+		// 1st gen Cloud Functions provide the region in the
+		// environment.
+		w.region = w.getenv("FUNCTION_REGION")
+		if w.region == "" {
+			// The metadata server answers with
+			// projects/<project number>/regions/<region>.
+			region := w.queryMetadata("instance/region")
+			w.region = region[strings.LastIndex(region, "/")+1:]
+		}
+		w.instance = w.queryMetadata("instance/id")
+	})
+}
+
+func (w *wrapper) startTransaction(trigger, invocationID string) *newrelic.Transaction {
+	w.resolveMetadata()
+
+	txn := w.app.StartTransaction(w.functionName)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeCloudProvider, "gcp", nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeCloudRegion, w.region, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSName, w.functionName, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSInstance, w.instance, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSInvocationID, invocationID, nil)
+	integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSTrigger, trigger, nil)
+	coldStart.Do(func() {
+		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeFaaSColdStart, "", true)
+	})
+	return txn
+}
+
+// flush writes the invocation's data.  A fresh context is used since the
+// request context is canceled when the client goes away, and the data
+// should be written regardless.
+func (w *wrapper) flush() {
+	if !w.serverless {
+		return
+	}
+	if err := w.app.FlushServerless(context.Background(), w.writer); nil != err {
+		if cfg, _ := w.app.Config(); nil != cfg.Logger {
+			cfg.Logger.Error("unable to flush serverless data", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// WrapHTTPFunction instruments an HTTP function or a Cloud Run request
+// handler.  A web transaction is created for each request and, in serverless
+// mode, the data is written to stdout when the handler returns.  If app is
+// nil, the function is returned unchanged.
+func WrapHTTPFunction(app *newrelic.Application, fn http.HandlerFunc) http.HandlerFunc {
+	if nil == app {
+		return fn
+	}
+	return newWrapper(app, os.Getenv).wrapHTTP(fn)
+}
+
+func (w *wrapper) wrapHTTP(fn http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		defer w.flush()
+
+		txn := w.startTransaction(newrelic.FaaSTriggerHTTP, r.Header.Get(executionIDHeader))
+		defer txn.End()
+
+		txn.SetWebRequestHTTP(r)
+		rw = txn.SetWebResponse(rw)
+		r = newrelic.RequestWithTransactionContext(r, txn)
+
+		fn(rw, r)
+	}
+}
+
+// WrapEventFunction instruments an event-driven function, such as a function
+// triggered by Pub/Sub or Cloud Storage.  trigger is recorded as the
+// faas.trigger attribute and should be one of the newrelic.FaaSTrigger
+// constants.  A background transaction is created for each event, errors
+// returned by the function are noticed, and in serverless mode the data is
+// written to stdout when the function returns.  If app is nil, the function
+// is returned unchanged.
+func WrapEventFunction[T any](app *newrelic.Application, trigger string, fn func(context.Context, T) error) func(context.Context, T) error {
+	if nil == app {
+		return fn
+	}
+	return wrapEvent(newWrapper(app, os.Getenv), trigger, fn)
+}
+
+func wrapEvent[T any](w *wrapper, trigger string, fn func(context.Context, T) error) func(context.Context, T) error {
+	return func(ctx context.Context, event T) error {
+		defer w.flush()
+
+		txn := w.startTransaction(trigger, "")
+		defer txn.End()
+
+		err := fn(newrelic.NewContext(ctx, txn), event)
+		if nil != err {
+			txn.NoticeError(err)
+		}
+		return err
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrgcpfunctions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func testApp(t *testing.T) *newrelic.Application {
+	coldStart = sync.Once{}
+	cfg := newConfigInternal(func(string) string { return "" })
+	app, err := newrelic.NewApplication(cfg, newrelic.ConfigCodeLevelMetricsEnabled(false))
+	if nil != err {
+		t.Fatal(err)
+	}
+	internal.HarvestTesting(app.Private, nil)
+	return app
+}
+
+func testEnv(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func metadataServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/instance/region":
+			w.Write([]byte("projects/123456/regions/us-central1"))
+		case "/instance/id":
+			w.Write([]byte("instance-id"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWrapHTTPFunction(t *testing.T) {
+	app := testApp(t)
+	w := newWrapper(app, testEnv(map[string]string{
+		"K_SERVICE":       "my-service",
+		"FUNCTION_TARGET": "Hello",
+	}))
+	w.metadataURL = metadataServer(t).URL + "/"
+	buf := &bytes.Buffer{}
+	w.writer = buf
+
+	handler := w.wrapHTTP(func(rw http.ResponseWriter, r *http.Request) {
+		if nil == newrelic.FromContext(r.Context()) {
+			t.Error("missing transaction")
+		}
+		rw.WriteHeader(http.StatusTeapot)
+	})
+
+	req := httptest.NewRequest("GET", "/hello", nil)
+	req.Header.Set(executionIDHeader, "execution-id")
+	handler(httptest.NewRecorder(), req)
+
+	app.Private.(internal.Expect).ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":             "WebTransaction/Go/Hello",
+			"nr.apdexPerfZone": internal.MatchAnything,
+			"guid":             internal.MatchAnything,
+			"priority":         internal.MatchAnything,
+			"sampled":          internal.MatchAnything,
+			"traceId":          internal.MatchAnything,
+		},
+		UserAttributes: map[string]interface{}{},
+		AgentAttributes: map[string]interface{}{
+			"cloud.provider":       "gcp",
+			"cloud.region":         "us-central1",
+			"faas.name":            "Hello",
+			"faas.instance":        "instance-id",
+			"faas.invocation_id":   "execution-id",
+			"faas.trigger":         "http",
+			"faas.coldstart":       true,
+			"request.method":       "GET",
+			"request.uri":          "/hello",
+			"request.headers.host": "example.com",
+			"http.statusCode":      418,
+			"httpResponseCode":     "418",
+		},
+	}})
+	if 0 == buf.Len() {
+		t.Error("no output written")
+	}
+}
+
+func TestWrapEventFunction(t *testing.T) {
+	app := testApp(t)
+	w := newWrapper(app, testEnv(map[string]string{
+		"FUNCTION_NAME":   "my-function",
+		"FUNCTION_REGION": "europe-west1",
+	}))
+	// The region comes from the environment and the metadata server is
+	// unreachable: the instance id is omitted.
+	w.metadataURL = "http://127.0.0.1:0/"
+	buf := &bytes.Buffer{}
+	w.writer = buf
+
+	type message struct{ Data []byte }
+	fn := wrapEvent(w, newrelic.FaaSTriggerPubSub, func(ctx context.Context, m message) error {
+		if nil == newrelic.FromContext(ctx) {
+			t.Error("missing transaction")
+		}
+		return errors.New("oops")
+	})
+
+	// Invoke twice: the second invocation is not a cold start.
+	for i := 0; i < 2; i++ {
+		internal.HarvestTesting(app.Private, nil)
+		buf.Reset()
+		if err := fn(context.Background(), message{}); nil == err {
+			t.Error("error not returned")
+		}
+		want := map[string]interface{}{
+			"cloud.provider": "gcp",
+			"cloud.region":   "europe-west1",
+			"faas.name":      "my-function",
+			"faas.trigger":   "pubsub",
+		}
+		if i == 0 {
+			want["faas.coldstart"] = true
+		}
+		app.Private.(internal.Expect).ExpectTxnEvents(t, []internal.WantEvent{{
+			Intrinsics: map[string]interface{}{
+				"name":     "OtherTransaction/Go/my-function",
+				"error":    true,
+				"guid":     internal.MatchAnything,
+				"priority": internal.MatchAnything,
+				"sampled":  internal.MatchAnything,
+				"traceId":  internal.MatchAnything,
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: want,
+		}})
+		if 0 == buf.Len() {
+			t.Error("no output written")
+		}
+	}
+}
+
+func TestWrapNilApp(t *testing.T) {
+	called := false
+	fn := WrapHTTPFunction(nil, func(http.ResponseWriter, *http.Request) { called = true })
+	fn(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !called {
+		t.Error("function not called")
+	}
+	event := WrapEventFunction(nil, newrelic.FaaSTriggerOther, func(context.Context, string) error { return nil })
+	if err := event(context.Background(), ""); nil != err {
+		t.Error(err)
+	}
+}
+
+func TestNotServerless(t *testing.T) {
+	app, err := newrelic.NewApplication(newrelic.ConfigEnabled(false), newrelic.ConfigAppName("app"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	w := newWrapper(app, testEnv(nil))
+	w.metadataURL = "http://127.0.0.1:0/"
+	buf := &bytes.Buffer{}
+	w.writer = buf
+	w.wrapHTTP(func(http.ResponseWriter, *http.Request) {})(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if 0 != buf.Len() {
+		t.Error(buf.String())
+	}
+}
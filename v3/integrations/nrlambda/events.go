@@ -40,12 +40,145 @@ func getEventSourceARN(event interface{}) string {
 		if len(v.Records) > 0 {
 			return v.Records[0].EventSubscriptionArn
 		}
+	case events.KafkaEvent:
+		// Self-managed Kafka clusters have no ARN, so this is only
+		// populated for MSK events.
+		return v.EventSourceARN
+	case events.CloudWatchEvent:
+		// EventBridge places the ARN of the rule (or of the resources
+		// involved in the event) in the resources list.
+		if len(v.Resources) > 0 {
+			return v.Resources[0]
+		}
+	case events.SimpleEmailEvent:
+		if len(v.Records) > 0 {
+			action := v.Records[0].SES.Receipt.Action
+			if action.TopicARN != "" {
+				return action.TopicARN
+			}
+			return action.FunctionARN
+		}
+	case map[string]interface{}:
+		return getStepFunctionsARN(v)
 	}
 	return ""
 }
 
+// getStepFunctionsARN returns the state machine ARN for Step Functions
+// invocations that pass the context object ("Payload.$": "$$") to the
+// function.  Step Functions has no dedicated event type: the state input is
+// handed to the handler as is, so the context object is the only place the
+// state machine is identified.
+func getStepFunctionsARN(event map[string]interface{}) string {
+	stateMachine, ok := event["StateMachine"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	arn, _ := stateMachine["Id"].(string)
+	if !strings.HasPrefix(arn, "arn:aws:states:") {
+		return ""
+	}
+	return arn
+}
+
+// getCognitoUserPoolARN builds the user pool ARN for Cognito User Pools
+// triggers.  The event only carries the region and pool id, so the account
+// is taken from the ARN of the function being invoked.
+func getCognitoUserPoolARN(event interface{}, functionARN string) string {
+	var hdr events.CognitoEventUserPoolsHeader
+	switch v := event.(type) {
+	case events.CognitoEventUserPoolsPreSignup:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsPreAuthentication:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsPostConfirmation:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsPreTokenGen:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsPostAuthentication:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsMigrateUser:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsDefineAuthChallenge:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsCreateAuthChallenge:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsVerifyAuthChallenge:
+		hdr = v.CognitoEventUserPoolsHeader
+	case events.CognitoEventUserPoolsCustomMessage:
+		hdr = v.CognitoEventUserPoolsHeader
+	default:
+		return ""
+	}
+	if hdr.UserPoolID == "" || hdr.Region == "" {
+		return ""
+	}
+	// arn:aws:lambda:<region>:<account>:function:<name>
+	parts := strings.SplitN(functionARN, ":", 6)
+	if len(parts) < 6 || parts[4] == "" {
+		return ""
+	}
+	return "arn:" + parts[1] + ":cognito-idp:" + hdr.Region + ":" + parts[4] + ":userpool/" + hdr.UserPoolID
+}
+
+// eventDistributedTraceHeaders returns the distributed tracing headers
+// carried by message attributes of queue and topic events, along with the
+// transport the payload travelled over.  Only the first record is
+// considered: a batch can only continue a single trace.
+func eventDistributedTraceHeaders(event interface{}) (http.Header, newrelic.TransportType) {
+	hdrs := http.Header{}
+	switch v := event.(type) {
+	case events.SQSEvent:
+		if len(v.Records) == 0 {
+			return nil, newrelic.TransportUnknown
+		}
+		for k, attr := range v.Records[0].MessageAttributes {
+			if attr.StringValue != nil {
+				addDistributedTraceHeader(hdrs, k, *attr.StringValue)
+			}
+		}
+		return hdrs, newrelic.TransportQueue
+	case events.SNSEvent:
+		if len(v.Records) == 0 {
+			return nil, newrelic.TransportUnknown
+		}
+		// SNS message attributes are delivered as
+		// {"Type": "String", "Value": "..."} objects.
+		for k, attr := range v.Records[0].SNS.MessageAttributes {
+			if m, ok := attr.(map[string]interface{}); ok {
+				if val, ok := m["Value"].(string); ok {
+					addDistributedTraceHeader(hdrs, k, val)
+				}
+			}
+		}
+		return hdrs, newrelic.TransportQueue
+	case events.KafkaEvent:
+		for _, records := range v.Records {
+			if len(records) == 0 {
+				continue
+			}
+			for _, h := range records[0].Headers {
+				for k, val := range h {
+					addDistributedTraceHeader(hdrs, k, string(val))
+				}
+			}
+			return hdrs, newrelic.TransportKafka
+		}
+	}
+	return nil, newrelic.TransportUnknown
+}
+
+func addDistributedTraceHeader(hdrs http.Header, key, value string) {
+	switch http.CanonicalHeaderKey(key) {
+	case newrelic.DistributedTraceNewRelicHeader,
+		newrelic.DistributedTraceW3CTraceParentHeader,
+		newrelic.DistributedTraceW3CTraceStateHeader:
+		hdrs.Set(key, value)
+	}
+}
+
 func eventWebRequest(event interface{}) *newrelic.WebRequest {
-	var path string
+	var path, query string
 	var request newrelic.WebRequest
 	var headers map[string]string
 
@@ -59,6 +192,18 @@ func eventWebRequest(event interface{}) *newrelic.WebRequest {
 		request.Method = r.HTTPMethod
 		path = r.Path
 		headers = r.Headers
+	case events.APIGatewayV2HTTPRequest:
+		// https://docs.aws.amazon.com/apigateway/latest/developerguide/http-api-develop-integrations-lambda.html
+		request.Method = r.RequestContext.HTTP.Method
+		path = r.RawPath
+		query = r.RawQueryString
+		headers = r.Headers
+	case events.LambdaFunctionURLRequest:
+		// https://docs.aws.amazon.com/lambda/latest/dg/urls-invocation.html
+		request.Method = r.RequestContext.HTTP.Method
+		path = r.RawPath
+		query = r.RawQueryString
+		headers = r.Headers
 	default:
 		return nil
 	}
@@ -73,8 +218,9 @@ func eventWebRequest(event interface{}) *newrelic.WebRequest {
 		host = ":" + port
 	}
 	request.URL = &url.URL{
-		Path: path,
-		Host: host,
+		Path:     path,
+		RawQuery: query,
+		Host:     host,
 	}
 
 	proto := strings.ToLower(request.Header.Get("X-Forwarded-Proto"))
@@ -101,6 +247,12 @@ func eventResponse(event interface{}) *response {
 	case events.ALBTargetGroupResponse:
 		code = r.StatusCode
 		headers = r.Headers
+	case events.APIGatewayV2HTTPResponse:
+		code = r.StatusCode
+		headers = r.Headers
+	case events.LambdaFunctionURLResponse:
+		code = r.StatusCode
+		headers = r.Headers
 	default:
 		return nil
 	}
@@ -60,6 +60,52 @@ func TestGetEventAttributes(t *testing.T) {
 		{Name: "KinesisFirehoseEvent", Input: events.KinesisFirehoseEvent{
 			DeliveryStreamArn: "ARN",
 		}, Arn: "ARN"},
+		{Name: "KafkaEvent self-managed", Input: events.KafkaEvent{
+			EventSource: "SelfManagedKafka",
+		}, Arn: ""},
+		{Name: "KafkaEvent", Input: events.KafkaEvent{
+			EventSource:    "aws:kafka",
+			EventSourceARN: "ARN",
+		}, Arn: "ARN"},
+		{Name: "CloudWatchEvent empty", Input: events.CloudWatchEvent{}, Arn: ""},
+		{Name: "CloudWatchEvent", Input: events.CloudWatchEvent{
+			Resources: []string{"ARN", "other"},
+		}, Arn: "ARN"},
+		{Name: "SimpleEmailEvent empty", Input: events.SimpleEmailEvent{}, Arn: ""},
+		{Name: "SimpleEmailEvent sns action", Input: events.SimpleEmailEvent{
+			Records: []events.SimpleEmailRecord{{
+				SES: events.SimpleEmailService{
+					Receipt: events.SimpleEmailReceipt{
+						Action: events.SimpleEmailReceiptAction{
+							Type:     "SNS",
+							TopicARN: "ARN",
+						},
+					},
+				},
+			}},
+		}, Arn: "ARN"},
+		{Name: "SimpleEmailEvent lambda action", Input: events.SimpleEmailEvent{
+			Records: []events.SimpleEmailRecord{{
+				SES: events.SimpleEmailService{
+					Receipt: events.SimpleEmailReceipt{
+						Action: events.SimpleEmailReceiptAction{
+							Type:        "Lambda",
+							FunctionARN: "ARN",
+						},
+					},
+				},
+			}},
+		}, Arn: "ARN"},
+		{Name: "Step Functions context object", Input: map[string]interface{}{
+			"StateMachine": map[string]interface{}{
+				"Id": "arn:aws:states:us-east-1:123456789012:stateMachine:sm",
+			},
+		}, Arn: "arn:aws:states:us-east-1:123456789012:stateMachine:sm"},
+		{Name: "Step Functions unrelated input", Input: map[string]interface{}{
+			"StateMachine": map[string]interface{}{
+				"Id": "my-id",
+			},
+		}, Arn: ""},
 	}
 
 	for _, testcase := range testcases {
@@ -108,6 +154,52 @@ func TestEventWebRequest(t *testing.T) {
 			urlString:  "//:4000/the/path",
 			transport:  newrelic.TransportHTTPS,
 		},
+		{
+			testname:   "empty http api request",
+			input:      events.APIGatewayV2HTTPRequest{},
+			numHeaders: 0,
+			method:     "",
+			urlString:  "",
+			transport:  newrelic.TransportUnknown,
+		},
+		{
+			testname: "populated http api request",
+			input: events.APIGatewayV2HTTPRequest{
+				Headers: map[string]string{
+					"x-forwarded-port":  "443",
+					"x-forwarded-proto": "https",
+				},
+				RawPath:        "/the/path",
+				RawQueryString: "a=b",
+				RequestContext: events.APIGatewayV2HTTPRequestContext{
+					HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+						Method: "POST",
+					},
+				},
+			},
+			numHeaders: 2,
+			method:     "POST",
+			urlString:  "//:443/the/path?a=b",
+			transport:  newrelic.TransportHTTPS,
+		},
+		{
+			testname: "populated function url request",
+			input: events.LambdaFunctionURLRequest{
+				Headers: map[string]string{
+					"x-forwarded-proto": "https",
+				},
+				RawPath: "/the/path",
+				RequestContext: events.LambdaFunctionURLRequestContext{
+					HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{
+						Method: "GET",
+					},
+				},
+			},
+			numHeaders: 1,
+			method:     "GET",
+			urlString:  "/the/path",
+			transport:  newrelic.TransportHTTPS,
+		},
 		{
 			testname:   "empty alb request",
 			input:      events.ALBTargetGroupRequest{},
@@ -184,6 +276,25 @@ func TestEventResponse(t *testing.T) {
 			numHeaders: 1,
 			code:       200,
 		},
+		{
+			testname: "populated http api response",
+			input: events.APIGatewayV2HTTPResponse{
+				StatusCode: 201,
+				Headers: map[string]string{
+					"x-custom-header": "my custom header value",
+				},
+			},
+			numHeaders: 1,
+			code:       201,
+		},
+		{
+			testname: "populated function url response",
+			input: events.LambdaFunctionURLResponse{
+				StatusCode: 404,
+			},
+			numHeaders: 0,
+			code:       404,
+		},
 		{
 			testname:   "empty alb response",
 			input:      events.ALBTargetGroupResponse{},
@@ -217,3 +328,117 @@ func TestEventResponse(t *testing.T) {
 		}
 	}
 }
+
+func TestGetCognitoUserPoolARN(t *testing.T) {
+	functionARN := "arn:aws:lambda:us-west-2:123456789012:function:my-function"
+	hdr := events.CognitoEventUserPoolsHeader{
+		Region:     "us-west-2",
+		UserPoolID: "us-west-2_abc",
+	}
+
+	if arn := getCognitoUserPoolARN(events.SQSEvent{}, functionARN); arn != "" {
+		t.Error(arn)
+	}
+	if arn := getCognitoUserPoolARN(events.CognitoEventUserPoolsPreSignup{}, functionARN); arn != "" {
+		t.Error(arn)
+	}
+	if arn := getCognitoUserPoolARN(events.CognitoEventUserPoolsPreSignup{
+		CognitoEventUserPoolsHeader: hdr,
+	}, "function-arn"); arn != "" {
+		t.Error(arn)
+	}
+	arn := getCognitoUserPoolARN(events.CognitoEventUserPoolsPostConfirmation{
+		CognitoEventUserPoolsHeader: hdr,
+	}, functionARN)
+	if arn != "arn:aws:cognito-idp:us-west-2:123456789012:userpool/us-west-2_abc" {
+		t.Error(arn)
+	}
+}
+
+func TestEventDistributedTraceHeaders(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	other := "other"
+
+	testcases := []struct {
+		testname   string
+		input      interface{}
+		numHeaders int
+		transport  newrelic.TransportType
+	}{
+		{
+			testname:  "not a message event",
+			input:     events.APIGatewayProxyRequest{},
+			transport: newrelic.TransportUnknown,
+		},
+		{
+			testname:  "empty sqs event",
+			input:     events.SQSEvent{},
+			transport: newrelic.TransportUnknown,
+		},
+		{
+			testname: "sqs event",
+			input: events.SQSEvent{
+				Records: []events.SQSMessage{{
+					MessageAttributes: map[string]events.SQSMessageAttribute{
+						"traceparent": {StringValue: &traceparent, DataType: "String"},
+						"newrelic":    {StringValue: &other, DataType: "String"},
+						"unrelated":   {StringValue: &other, DataType: "String"},
+					},
+				}},
+			},
+			numHeaders: 2,
+			transport:  newrelic.TransportQueue,
+		},
+		{
+			testname: "sns event",
+			input: events.SNSEvent{
+				Records: []events.SNSEventRecord{{
+					SNS: events.SNSEntity{
+						MessageAttributes: map[string]interface{}{
+							"traceparent": map[string]interface{}{"Type": "String", "Value": traceparent},
+							"tracestate":  map[string]interface{}{"Type": "String", "Value": other},
+							"unrelated":   map[string]interface{}{"Type": "String", "Value": other},
+						},
+					},
+				}},
+			},
+			numHeaders: 2,
+			transport:  newrelic.TransportQueue,
+		},
+		{
+			testname: "kafka event",
+			input: events.KafkaEvent{
+				Records: map[string][]events.KafkaRecord{
+					"topic-0": {{
+						Headers: []map[string]events.JSONNumberBytes{
+							{"traceparent": events.JSONNumberBytes(traceparent)},
+						},
+					}},
+				},
+			},
+			numHeaders: 1,
+			transport:  newrelic.TransportKafka,
+		},
+	}
+
+	for _, tc := range testcases {
+		hdrs, transport := eventDistributedTraceHeaders(tc.input)
+		if len(hdrs) != tc.numHeaders {
+			t.Error(tc.testname, "header len mismatch", hdrs, tc.numHeaders)
+		}
+		if transport != tc.transport {
+			t.Error(tc.testname, "transport mismatch", transport, tc.transport)
+		}
+	}
+
+	hdrs, _ := eventDistributedTraceHeaders(events.SQSEvent{
+		Records: []events.SQSMessage{{
+			MessageAttributes: map[string]events.SQSMessageAttribute{
+				"traceparent": {StringValue: &traceparent, DataType: "String"},
+			},
+		}},
+	})
+	if v := hdrs.Get(newrelic.DistributedTraceW3CTraceParentHeader); v != traceparent {
+		t.Error(v)
+	}
+}
@@ -47,12 +47,20 @@ func requestEvent(ctx context.Context, event interface{}) {
 		return
 	}
 
-	if sourceARN := getEventSourceARN(event); "" != sourceARN {
+	sourceARN := getEventSourceARN(event)
+	if "" == sourceARN {
+		if lctx, ok := lambdacontext.FromContext(ctx); ok {
+			sourceARN = getCognitoUserPoolARN(event, lctx.InvokedFunctionArn)
+		}
+	}
+	if "" != sourceARN {
 		integrationsupport.AddAgentAttribute(txn, newrelic.AttributeAWSLambdaEventSourceARN, sourceARN, nil)
 	}
 
 	if request := eventWebRequest(event); nil != request {
 		txn.SetWebRequest(*request)
+	} else if hdrs, transport := eventDistributedTraceHeaders(event); len(hdrs) > 0 {
+		txn.AcceptDistributedTraceHeaders(transport, hdrs)
 	}
 }
 
@@ -400,6 +400,63 @@ func TestDistributedTracing(t *testing.T) {
 	}
 }
 
+func TestSQSDistributedTracing(t *testing.T) {
+	originalHandler := func(events.SQSEvent) {}
+	app := testApp(distributedTracingEnabled, t)
+	wrapped := Wrap(originalHandler, app)
+	w := wrapped.(*wrappedHandler)
+	w.functionName = "functionName"
+	buf := &bytes.Buffer{}
+	w.hasWriter = bufWriterProvider{buf}
+
+	dtHdr := http.Header{}
+	app.StartTransaction("hello").InsertDistributedTraceHeaders(dtHdr)
+	attrs := map[string]events.SQSMessageAttribute{}
+	for k := range dtHdr {
+		v := dtHdr.Get(k)
+		attrs[strings.ToLower(k)] = events.SQSMessageAttribute{StringValue: &v, DataType: "String"}
+	}
+	req := events.SQSEvent{
+		Records: []events.SQSMessage{{
+			EventSourceARN:    "ARN",
+			MessageAttributes: attrs,
+		}},
+	}
+	reqbytes, err := json.Marshal(req)
+	if err != nil {
+		t.Error("unable to marshal json", err)
+	}
+
+	resp, err := wrapped.Invoke(context.Background(), reqbytes)
+	if err != nil {
+		t.Error(err, string(resp))
+	}
+	app.Private.(internal.Expect).ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":                     "OtherTransaction/Go/functionName",
+			"parent.account":           "1",
+			"parent.app":               "1",
+			"parent.transportType":     "Queue",
+			"parent.type":              "App",
+			"guid":                     internal.MatchAnything,
+			"parent.transportDuration": internal.MatchAnything,
+			"parentId":                 internal.MatchAnything,
+			"parentSpanId":             internal.MatchAnything,
+			"priority":                 internal.MatchAnything,
+			"sampled":                  internal.MatchAnything,
+			"traceId":                  internal.MatchAnything,
+		},
+		UserAttributes: map[string]interface{}{},
+		AgentAttributes: map[string]interface{}{
+			"aws.lambda.coldStart":       true,
+			"aws.lambda.eventSource.arn": "ARN",
+		},
+	}})
+	if 0 == buf.Len() {
+		t.Error("no output written")
+	}
+}
+
 func TestEventARN(t *testing.T) {
 	originalHandler := func(events.DynamoDBEvent) {}
 	app := testApp(nil, t)
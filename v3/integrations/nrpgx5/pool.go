@@ -0,0 +1,56 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpgx5
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// MonitorPool periodically records the statistics of the pool, as returned
+// by its Stat method, as custom metrics named
+// Custom/ConnectionPool/<name>/<statistic>: the gauges TotalConns,
+// AcquiredConns, IdleConns, ConstructingConns and MaxConns, and the counters
+// AcquireCount, AcquireDuration (in seconds), EmptyAcquireCount,
+// CanceledAcquireCount, NewConnsCount, MaxLifetimeDestroyCount and
+// MaxIdleDestroyCount.  EmptyAcquireCount counts the acquisitions which had
+// to wait for a connection.  Call the function returned to stop the
+// monitoring.  See newrelic.MonitorConnectionPool.
+//
+//	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+//	if err != nil {
+//		panic(err)
+//	}
+//	stop := nrpgx5.MonitorPool(app, "orders", pool)
+//	defer stop()
+func MonitorPool(app *newrelic.Application, name string, pool *pgxpool.Pool) (stop func()) {
+	if nil == pool {
+		return func() {}
+	}
+	return newrelic.MonitorConnectionPool(app, name, poolSample(pool))
+}
+
+func poolSample(pool *pgxpool.Pool) func() newrelic.ConnectionPoolSample {
+	return func() newrelic.ConnectionPoolSample {
+		stat := pool.Stat()
+		return newrelic.ConnectionPoolSample{
+			Gauges: map[string]float64{
+				"TotalConns":        float64(stat.TotalConns()),
+				"AcquiredConns":     float64(stat.AcquiredConns()),
+				"IdleConns":         float64(stat.IdleConns()),
+				"ConstructingConns": float64(stat.ConstructingConns()),
+				"MaxConns":          float64(stat.MaxConns()),
+			},
+			Counters: map[string]float64{
+				"AcquireCount":            float64(stat.AcquireCount()),
+				"AcquireDuration":         stat.AcquireDuration().Seconds(),
+				"EmptyAcquireCount":       float64(stat.EmptyAcquireCount()),
+				"CanceledAcquireCount":    float64(stat.CanceledAcquireCount()),
+				"NewConnsCount":           float64(stat.NewConnsCount()),
+				"MaxLifetimeDestroyCount": float64(stat.MaxLifetimeDestroyCount()),
+				"MaxIdleDestroyCount":     float64(stat.MaxIdleDestroyCount()),
+			},
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrpgx5
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestPoolSample(t *testing.T) {
+	cfg, err := pgxpool.ParseConfig("postgres://localhost:5432/db?pool_max_conns=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	s := poolSample(pool)()
+	if s.Gauges["MaxConns"] != 7 || s.Gauges["TotalConns"] != 0 {
+		t.Error(s.Gauges)
+	}
+	for _, stat := range []string{"AcquireCount", "AcquireDuration", "EmptyAcquireCount", "CanceledAcquireCount"} {
+		if _, ok := s.Counters[stat]; !ok {
+			t.Error("missing counter", stat)
+		}
+	}
+}
+
+func TestMonitorPoolNil(t *testing.T) {
+	stop := MonitorPool(nil, "mypool", nil)
+	stop()
+}
@@ -192,3 +192,24 @@ func TestNewHookAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestPoolSample(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+	s := poolSample(client)()
+	for _, stat := range []string{"TotalConns", "IdleConns"} {
+		if v, ok := s.Gauges[stat]; !ok || v != 0 {
+			t.Error(stat, v, ok)
+		}
+	}
+	for _, stat := range []string{"Hits", "Misses", "Timeouts", "StaleConns"} {
+		if _, ok := s.Counters[stat]; !ok {
+			t.Error("missing counter", stat)
+		}
+	}
+}
+
+func TestMonitorPoolNil(t *testing.T) {
+	stop := MonitorPool(nil, "mypool", nil)
+	stop()
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package nrredis
+
+import (
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// MonitorPool periodically records the statistics of the connection pool of
+// the client, as returned by its PoolStats method, as custom metrics named
+// Custom/ConnectionPool/<name>/<statistic>: the gauges TotalConns and
+// IdleConns, and the counters Hits, Misses, Timeouts and StaleConns.
+// Timeouts counts the waits for a connection which timed out.  The client can
+// be a redis.Client, redis.ClusterClient or redis.Ring.  Call the function
+// returned to stop the monitoring.  See newrelic.MonitorConnectionPool.
+//
+//	client := redis.NewClient(opts)
+//	stop := nrredis.MonitorPool(app, "sessions", client)
+//	defer stop()
+func MonitorPool(app *newrelic.Application, name string, client redis.UniversalClient) (stop func()) {
+	if nil == client {
+		return func() {}
+	}
+	return newrelic.MonitorConnectionPool(app, name, poolSample(client))
+}
+
+func poolSample(client redis.UniversalClient) func() newrelic.ConnectionPoolSample {
+	return func() newrelic.ConnectionPoolSample {
+		stats := client.PoolStats()
+		return newrelic.ConnectionPoolSample{
+			Gauges: map[string]float64{
+				"TotalConns": float64(stats.TotalConns),
+				"IdleConns":  float64(stats.IdleConns),
+			},
+			Counters: map[string]float64{
+				"Hits":       float64(stats.Hits),
+				"Misses":     float64(stats.Misses),
+				"Timeouts":   float64(stats.Timeouts),
+				"StaleConns": float64(stats.StaleConns),
+			},
+		}
+	}
+}
@@ -94,6 +94,9 @@ type WantSlowQuery struct {
 	Host         string
 	PortPathOrID string
 	Params       map[string]interface{}
+	// ExplainPlan is the JSON of the explain plan.  It is not validated
+	// if empty.
+	ExplainPlan string
 }
 
 // HarvestTestinger is implemented by the app.  It sets an empty test harvest
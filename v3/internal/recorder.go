@@ -0,0 +1,62 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package internal
+
+// RecordedMetric is a metric of the test harvest.
+type RecordedMetric struct {
+	Name       string
+	Scope      string
+	Forced     bool
+	Count      float64
+	Total      float64
+	Exclusive  float64
+	Min        float64
+	Max        float64
+	SumSquares float64
+}
+
+// RecordedEvent is an event of the test harvest, with its attributes decoded
+// from its JSON.
+type RecordedEvent struct {
+	Intrinsics      map[string]interface{}
+	UserAttributes  map[string]interface{}
+	AgentAttributes map[string]interface{}
+}
+
+// RecordedError is a traced error of the test harvest.
+type RecordedError struct {
+	TxnName         string
+	Msg             string
+	Klass           string
+	Expected        bool
+	UserAttributes  map[string]interface{}
+	AgentAttributes map[string]interface{}
+}
+
+// RecordedLog is a log event of the test harvest.
+type RecordedLog struct {
+	Timestamp int64
+	Severity  string
+	Message   string
+	SpanID    string
+	TraceID   string
+}
+
+// RecordedData is a copy of the contents of the test harvest.
+type RecordedData struct {
+	Metrics      []RecordedMetric
+	SpanEvents   []RecordedEvent
+	TxnEvents    []RecordedEvent
+	CustomEvents []RecordedEvent
+	ErrorEvents  []RecordedEvent
+	Errors       []RecordedError
+	Logs         []RecordedLog
+}
+
+// Recorder is implemented by the app.  It gives access to the data captured
+// by the test harvest set by HarvestTesting.
+type Recorder interface {
+	RecordedData() RecordedData
+	ResetRecordedData()
+}
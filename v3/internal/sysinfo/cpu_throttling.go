@@ -0,0 +1,94 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package sysinfo
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCPUThrottlingNotFound is returned if the cgroup of the process has no CPU
+// bandwidth statistics.
+var ErrCPUThrottlingNotFound = errors.New("cgroup cpu.stat not found")
+
+// CPUThrottling contains the cumulative CPU bandwidth control statistics of
+// the cgroup of the process.
+type CPUThrottling struct {
+	// Periods is the number of enforcement intervals elapsed.
+	Periods uint64
+	// Throttled is the number of intervals in which the cgroup used its
+	// whole quota and was throttled.
+	Throttled uint64
+	// ThrottledTime is the total time the cgroup was throttled for.
+	ThrottledTime time.Duration
+}
+
+// cpuStatPaths lists the cpu.stat file locations of cgroup v2 and cgroup v1,
+// as seen from inside a container.
+var cpuStatPaths = []string{
+	"/sys/fs/cgroup/cpu.stat",
+	"/sys/fs/cgroup/cpu/cpu.stat",
+	"/sys/fs/cgroup/cpu,cpuacct/cpu.stat",
+}
+
+// GetCPUThrottling returns the CPU throttling statistics of the cgroup of the
+// process.
+func GetCPUThrottling() (CPUThrottling, error) {
+	if "linux" != runtime.GOOS {
+		return CPUThrottling{}, ErrFeatureUnsupported
+	}
+	for _, path := range cpuStatPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		return parseCPUStat(f)
+	}
+	return CPUThrottling{}, ErrCPUThrottlingNotFound
+}
+
+// parseCPUStat reads a cpu.stat file.  cgroup v2 reports the throttled time in
+// microseconds as throttled_usec, cgroup v1 in nanoseconds as throttled_time.
+func parseCPUStat(r io.Reader) (CPUThrottling, error) {
+	var t CPUThrottling
+	found := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "nr_periods":
+			t.Periods = v
+			found = true
+		case "nr_throttled":
+			t.Throttled = v
+		case "throttled_usec":
+			t.ThrottledTime = time.Duration(v) * time.Microsecond
+		case "throttled_time":
+			t.ThrottledTime = time.Duration(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return CPUThrottling{}, err
+	}
+	// cgroup v2 cpu.stat files only contain the usage fields when the cpu
+	// controller is not enabled.
+	if !found {
+		return CPUThrottling{}, ErrCPUThrottlingNotFound
+	}
+	return t, nil
+}
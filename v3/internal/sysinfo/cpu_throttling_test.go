@@ -0,0 +1,45 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package sysinfo
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCPUStat(t *testing.T) {
+	testcases := []struct {
+		name   string
+		input  string
+		expect CPUThrottling
+		err    error
+	}{
+		{
+			name: "cgroup v2",
+			input: "usage_usec 3361234\nuser_usec 2514578\nsystem_usec 846656\n" +
+				"nr_periods 1024\nnr_throttled 12\nthrottled_usec 345678\n",
+			expect: CPUThrottling{Periods: 1024, Throttled: 12, ThrottledTime: 345678 * time.Microsecond},
+		},
+		{
+			name:   "cgroup v1",
+			input:  "nr_periods 80\nnr_throttled 3\nthrottled_time 1500000000\n",
+			expect: CPUThrottling{Periods: 80, Throttled: 3, ThrottledTime: 1500 * time.Millisecond},
+		},
+		{
+			name:  "cgroup v2 without cpu limit",
+			input: "usage_usec 3361234\nuser_usec 2514578\nsystem_usec 846656\n",
+			err:   ErrCPUThrottlingNotFound,
+		},
+	}
+	for _, tc := range testcases {
+		got, err := parseCPUStat(strings.NewReader(tc.input))
+		if err != tc.err {
+			t.Error(tc.name, err)
+		}
+		if got != tc.expect {
+			t.Error(tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Names of the agent commands supported.
+const (
+	agentCommandStartProfiler = "start_profiler"
+	agentCommandStopProfiler  = "stop_profiler"
+)
+
+// agentCommand is a command sent by New Relic in reply to get_agent_commands,
+// such as a profile requested from the UI.  It is received as:
+//
+//	[123, {"name": "start_profiler", "arguments": {...}}]
+type agentCommand struct {
+	ID        int64
+	Name      string
+	Arguments json.RawMessage
+}
+
+func (c *agentCommand) UnmarshalJSON(b []byte) error {
+	var body struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(b, &[]interface{}{&c.ID, &body}); nil != err {
+		return err
+	}
+	c.Name = body.Name
+	c.Arguments = body.Arguments
+	return nil
+}
+
+// agentCommandResult is the outcome of an agent command reported to New
+// Relic.
+type agentCommandResult struct {
+	Error string `json:"error,omitempty"`
+}
+
+func parseAgentCommands(body []byte) ([]agentCommand, error) {
+	var reply struct {
+		Commands []agentCommand `json:"return_value"`
+	}
+	if err := json.Unmarshal(body, &reply); nil != err {
+		return nil, fmt.Errorf("unable to parse agent commands: %v", err)
+	}
+	return reply.Commands, nil
+}
+
+// runAgentCommand runs a command, and returns the error to report to New
+// Relic.
+func (app *app) runAgentCommand(c agentCommand) error {
+	switch c.Name {
+	case agentCommandStartProfiler:
+		if !app.config.ThreadProfiler.Enabled {
+			return errProfilerDisabled
+		}
+		var args profileArguments
+		if err := json.Unmarshal(c.Arguments, &args); nil != err {
+			return errInvalidProfileArgs
+		}
+		return app.profiler.start(args, app.shutdownStarted)
+	case agentCommandStopProfiler:
+		args := struct {
+			ProfileID  int64 `json:"profile_id"`
+			ReportData bool  `json:"report_data"`
+		}{ReportData: true}
+		if err := json.Unmarshal(c.Arguments, &args); nil != err {
+			return errInvalidProfileArgs
+		}
+		return app.profiler.stop(args.ProfileID, args.ReportData)
+	default:
+		return fmt.Errorf("unknown agent command %q", c.Name)
+	}
+}
+
+// pollAgentCommands sends the profiles completed, then gets the agent
+// commands, runs them and reports their outcome.
+func (app *app) pollAgentCommands(hp *harvestPosting) {
+	runID := hp.run.Reply.RunID.String()
+
+	for _, p := range app.profiler.takeFinished() {
+		data, err := p.Data(runID)
+		if nil != err {
+			app.Warn("unable to create profile data", map[string]interface{}{
+				"profile_id": p.id,
+				"error":      err.Error(),
+			})
+			continue
+		}
+		resp := app.harvestRequest(hp, cmdProfileData, data)
+		if app.isRunOver(hp, resp) {
+			return
+		}
+		if err := resp.GetError(); nil != err {
+			app.Warn("unable to send profile", map[string]interface{}{
+				"profile_id": p.id,
+				"error":      err.Error(),
+			})
+		}
+	}
+
+	data, err := json.Marshal([]string{runID})
+	if nil != err {
+		return
+	}
+	resp := app.harvestRequest(hp, cmdAgentCommands, data)
+	if app.isRunOver(hp, resp) {
+		return
+	}
+	if err := resp.GetError(); nil != err {
+		app.Warn("unable to get agent commands", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	commands, err := parseAgentCommands(resp.body)
+	if nil != err {
+		app.Warn("unable to get agent commands", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if 0 == len(commands) {
+		return
+	}
+
+	results := make(map[string]agentCommandResult, len(commands))
+	for _, c := range commands {
+		var result agentCommandResult
+		if err := app.runAgentCommand(c); nil != err {
+			result.Error = err.Error()
+			app.Warn("agent command failed", map[string]interface{}{
+				"command": c.Name,
+				"error":   result.Error,
+			})
+		} else {
+			app.Info("agent command run", map[string]interface{}{
+				"command": c.Name,
+			})
+		}
+		results[strconv.FormatInt(c.ID, 10)] = result
+	}
+	data, err = json.Marshal([]interface{}{runID, results})
+	if nil != err {
+		return
+	}
+	resp = app.harvestRequest(hp, cmdAgentCommandResults, data)
+	if app.isRunOver(hp, resp) {
+		return
+	}
+	if err := resp.GetError(); nil != err {
+		app.Warn("unable to send agent command results", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
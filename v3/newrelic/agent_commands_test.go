@@ -0,0 +1,127 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic/fakecollector"
+)
+
+func TestParseAgentCommands(t *testing.T) {
+	commands, err := parseAgentCommands([]byte(`{"return_value":[
+		[12, {"name": "start_profiler", "arguments": {"profile_id": 34, "sample_period": 0.1, "duration": 120}}],
+		[13, {"name": "stop_profiler", "arguments": {"profile_id": 34}}]
+	]}`))
+	if nil != err || len(commands) != 2 {
+		t.Fatal(commands, err)
+	}
+	if c := commands[0]; c.ID != 12 || c.Name != "start_profiler" || !strings.Contains(string(c.Arguments), `"profile_id": 34`) {
+		t.Error(c)
+	}
+	if commands, err := parseAgentCommands([]byte(`{"return_value":[]}`)); nil != err || len(commands) != 0 {
+		t.Error(commands, err)
+	}
+	if _, err := parseAgentCommands([]byte(`{"return_value":[["bad"]]}`)); nil == err {
+		t.Error("error expected")
+	}
+}
+
+// flushUntil flushes the application until the fake collector receives a
+// request for the method given.
+func flushUntil(t *testing.T, app *Application, srv *fakecollector.Server, method string) []fakecollector.Request {
+	for i := 0; i < 100; i++ {
+		if err := app.Flush(context.Background()); nil != err {
+			t.Fatal(err)
+		}
+		if requests := srv.RequestsFor(method); len(requests) > 0 {
+			return requests
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no request for", method)
+	return nil
+}
+
+func TestAgentCommandsProfile(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	srv.Respond(fakecollector.MethodAgentCommands, fakecollector.Response{Body: `{"return_value":[
+		[1, {"name": "start_profiler", "arguments": {"profile_id": 7, "sample_period": 0.01, "duration": 0.1}}],
+		[2, {"name": "restart", "arguments": {}}]
+	]}`})
+
+	requests := flushUntil(t, app, srv, fakecollector.MethodAgentCommandResults)
+	var results []interface{}
+	if err := requests[0].Unmarshal(&results); nil != err || len(results) != 2 {
+		t.Fatal(string(requests[0].Body), err)
+	}
+	if results[0] != "fakecollector-run-1" {
+		t.Error(results[0])
+	}
+	byID := results[1].(map[string]interface{})
+	if r := byID["1"].(map[string]interface{}); len(r) != 0 {
+		t.Error(r)
+	}
+	if r := byID["2"].(map[string]interface{}); r["error"] != `unknown agent command "restart"` {
+		t.Error(r)
+	}
+
+	requests = flushUntil(t, app, srv, fakecollector.MethodProfileData)
+	var payload []interface{}
+	if err := requests[0].Unmarshal(&payload); nil != err || len(payload) != 2 {
+		t.Fatal(string(requests[0].Body), err)
+	}
+	profile := payload[1].([]interface{})[0].([]interface{})
+	if profile[0] != 7.0 || profile[3].(float64) < 1 {
+		t.Error(profile)
+	}
+	trees := decodeProfileTrees(t, profile[4].(string))
+	if len(trees[profileBucketAgent]) == 0 {
+		t.Error("agent goroutines missing", trees)
+	}
+}
+
+func TestAgentCommandsProfilerDisabled(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv, func(cfg *Config) {
+		cfg.ThreadProfiler.Enabled = false
+	})
+	srv.Respond(fakecollector.MethodAgentCommands, fakecollector.Response{Body: `{"return_value":[
+		[1, {"name": "start_profiler", "arguments": {"profile_id": 7, "duration": 60}}]
+	]}`})
+
+	requests := flushUntil(t, app, srv, fakecollector.MethodAgentCommandResults)
+	if body := string(requests[0].Body); !strings.Contains(body, `{"1":{"error":"the thread profiler is disabled"}}`) {
+		t.Error(body)
+	}
+}
+
+func TestAgentCommandsStopProfiler(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	srv.Respond(fakecollector.MethodAgentCommands, fakecollector.Response{Body: `{"return_value":[
+		[1, {"name": "start_profiler", "arguments": {"profile_id": 7, "duration": 600}}],
+		[2, {"name": "stop_profiler", "arguments": {"profile_id": 7, "report_data": false}}]
+	]}`})
+
+	requests := flushUntil(t, app, srv, fakecollector.MethodAgentCommandResults)
+	if body := string(requests[0].Body); !strings.Contains(body, `{"1":{},"2":{}}`) {
+		t.Error(body)
+	}
+	for i := 0; i < 3; i++ {
+		if err := app.Flush(context.Background()); nil != err {
+			t.Fatal(err)
+		}
+	}
+	if requests := srv.RequestsFor(fakecollector.MethodProfileData); len(requests) != 0 {
+		t.Error("discarded profile sent", requests)
+	}
+}
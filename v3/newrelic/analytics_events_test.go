@@ -346,3 +346,41 @@ func TestAnalyticsEventsZeroCapacity(t *testing.T) {
 		t.Error(err, string(js))
 	}
 }
+
+func TestSplitPayload(t *testing.T) {
+	events := newAnalyticsEvents(10)
+	for i := 0; i < 5; i++ {
+		events.addEvent(sampleAnalyticsEvent(priority(float32(i) / 10.0)))
+	}
+	logs := newLogEvents(testCommonAttributes, loggingConfigEnabled(10))
+	for i := 0; i < 5; i++ {
+		logs.Add(sampleLogEvent(priority(float32(i)/10.0), "INFO", "message"))
+	}
+	for _, p := range []splittablePayload{
+		&customEvents{analyticsEvents: events},
+		&errorEvents{analyticsEvents: events},
+		&spanEvents{analyticsEvents: events},
+		&txnEvents{analyticsEvents: events},
+		logs,
+	} {
+		p1, p2 := p.splitPayload()
+		if nil == p1 || nil == p2 {
+			t.Fatal("payload not split", p.EndpointMethod())
+		}
+		if p1.EndpointMethod() != p.EndpointMethod() || p2.EndpointMethod() != p.EndpointMethod() {
+			t.Error(p.EndpointMethod(), p1.EndpointMethod(), p2.EndpointMethod())
+		}
+		n1 := p1.(interface{ NumSaved() float64 }).NumSaved()
+		n2 := p2.(interface{ NumSaved() float64 }).NumSaved()
+		if n1 != 2 || n2 != 3 {
+			t.Error(p.EndpointMethod(), n1, n2)
+		}
+	}
+
+	// A single event cannot be split.
+	single := newCustomEvents(10)
+	single.addEvent(sampleAnalyticsEvent(0.5))
+	if p1, p2 := single.splitPayload(); nil != p1 || nil != p2 {
+		t.Error(p1, p2)
+	}
+}
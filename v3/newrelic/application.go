@@ -4,6 +4,8 @@
 package newrelic
 
 import (
+	"context"
+	"io"
 	"os"
 	"time"
 )
@@ -195,6 +197,28 @@ func (app *Application) WaitForConnection(timeout time.Duration) error {
 	return app.app.WaitForConnection(timeout)
 }
 
+// Flush immediately harvests all of the data gathered so far and sends it to
+// New Relic's servers, without waiting for the regular harvest cycle and
+// without stopping the application.  This method blocks until the data has
+// been sent or ctx is done.  It is useful for batch jobs and command line
+// tools that want to report telemetry at checkpoints, such as after each job,
+// while keeping the application running.
+//
+// An error is returned if the application is not connected, has been shut
+// down, if ctx is done before the data is sent, or if some of the data could
+// not be sent to the account of the application, for example because of a
+// collector error or of Config.Harvest.Timeout.  Data which could not be sent
+// because of a collector error is retained for the next harvest, as it is for
+// regular harvests.  Flush is not supported in serverless mode: use
+// FlushServerless instead.  Span events queued for Infinite Tracing are not
+// affected by Flush.
+func (app *Application) Flush(ctx context.Context) error {
+	if app == nil || app.app == nil {
+		return nil
+	}
+	return app.app.Flush(ctx)
+}
+
 // Shutdown flushes data to New Relic's servers and stops all
 // agent-related goroutines managing this application.  After Shutdown
 // is called, the Application is disabled and will never collect data
@@ -211,6 +235,23 @@ func (app *Application) Shutdown(timeout time.Duration) {
 	app.app.Shutdown(timeout)
 }
 
+// FlushServerless writes the data gathered since the previous flush to the
+// writer provided, in the same format used for AWS Lambda.  It is intended for
+// serverless platforms other than Lambda, such as Google Cloud Functions, Cloud
+// Run and Azure Functions, where data should be flushed at the end of every
+// invocation.  The platform is detected from the environment and recorded in
+// the payload metadata.  An error is returned if the application is not in
+// ServerlessMode, if ctx is done, or if writing fails.
+//
+// Integration packages such as nrgcpfunctions and nrazurefunctions call
+// FlushServerless for you.
+func (app *Application) FlushServerless(ctx context.Context, writer io.Writer) error {
+	if app == nil || app.app == nil {
+		return nil
+	}
+	return app.app.FlushServerless(ctx, writer)
+}
+
 // Config returns a copy of the application's configuration data in case
 // that information is needed (but since it is a copy, this function cannot
 // be used to alter the application's configuration).
@@ -223,7 +264,40 @@ func (app *Application) Config() (Config, bool) {
 	if app == nil || app.app == nil {
 		return defaultConfig(), false
 	}
-	return app.app.config.Config, true
+	return app.app.currentConfig().Config, true
+}
+
+// UpdateConfig changes the configuration of the running application, which is
+// otherwise fixed by NewApplication.  The ConfigOption arguments are applied
+// to a copy of the current Config, of which only the settings that can change
+// at runtime are used:
+//
+//   - the Attributes settings of every destination, and the HTTP headers
+//     captured,
+//   - the ApplicationLogging settings,
+//   - the thresholds of TransactionTracer and of its Segments,
+//   - ErrorCollector.IgnoreStatusCodes and ErrorCollector.ExpectStatusCodes,
+//   - Labels.
+//
+// Changes to the other settings are ignored.  The data gathered with the
+// previous settings is harvested at once, and the transactions already
+// started keep them.  Since the Labels and
+// ApplicationLogging.Forwarding.MaxSamplesStored are sent to New Relic when
+// connecting, changing them reconnects the application.  Settings set by
+// server-side configuration keep their server-side values.  An error is
+// returned if an option sets Config.Error or the Config is invalid, in
+// which case nothing is changed, or if the application is shut down.
+//
+// For example, to start forwarding logs during an incident:
+//
+//	app.UpdateConfig(newrelic.ConfigAppLogForwardingEnabled(true))
+//
+// Config.ConfigFile applies the settings of a file the same way.
+func (app *Application) UpdateConfig(opts ...ConfigOption) error {
+	if app == nil || app.app == nil {
+		return nil
+	}
+	return app.app.UpdateConfig(opts...)
 }
 func newApplication(app *app) *Application {
 	return &Application{
@@ -254,6 +328,11 @@ func NewApplication(opts ...ConfigOption) (*Application, error) {
 	}
 	cfg, err := newInternalConfig(c, os.Getenv, os.Environ())
 	if err != nil {
+		if status, ok := healthStatusFromConfigError(c, err); ok {
+			hr := newHealthReporter(c)
+			hr.set(status)
+			hr.write()
+		}
 		return nil, err
 	}
 	return newApplication(newApp(cfg)), nil
@@ -58,6 +58,15 @@ const (
 	AttributeUserID = "enduser.id"
 	// AttributeLLM tracks LLM transactions
 	AttributeLLM = "llm"
+	// AttributeRepeatedCallCount is the number of times a datastore or
+	// external call was repeated, when it exceeds
+	// Config.RepeatedCalls.Threshold.  Transactions get the largest count
+	// of their calls, and spans the count of their call so far.
+	AttributeRepeatedCallCount = "repeatedCall.count"
+	// AttributeTransactionIncomplete is set on the transactions ended by
+	// the agent because they were still running
+	// Config.LeakedTransactions.MaxAge after they started.
+	AttributeTransactionIncomplete = "incomplete"
 )
 
 // Attributes destined for Errors and Transaction Traces:
@@ -85,6 +94,29 @@ const (
 	AttributeAWSLambdaEventSourceARN = "aws.lambda.eventSource.arn"
 )
 
+// Function as a service attributes added by the serverless integrations for
+// platforms other than AWS Lambda, such as Google Cloud Functions, Cloud Run
+// and Azure Functions.  Their names follow the OpenTelemetry semantic
+// conventions.
+const (
+	AttributeCloudProvider    = "cloud.provider"
+	AttributeCloudRegion      = "cloud.region"
+	AttributeFaaSName         = "faas.name"
+	AttributeFaaSInstance     = "faas.instance"
+	AttributeFaaSInvocationID = "faas.invocation_id"
+	AttributeFaaSColdStart    = "faas.coldstart"
+	AttributeFaaSTrigger      = "faas.trigger"
+)
+
+// Values of the AttributeFaaSTrigger attribute:
+const (
+	FaaSTriggerHTTP       = "http"
+	FaaSTriggerPubSub     = "pubsub"
+	FaaSTriggerDatasource = "datasource"
+	FaaSTriggerTimer      = "timer"
+	FaaSTriggerOther      = "other"
+)
+
 // Attributes for consumed message transactions:
 //
 // When a message is consumed (for example from Kafka or RabbitMQ), supported
@@ -160,6 +192,26 @@ const (
 	SpanAttributeParentTransportDuration = "parent.transportDuration"
 	SpanAttributeParentTransportType     = "parent.transportType"
 
+	// Attributes of the spans and transaction trace segments merged
+	// together when Config.SegmentAggregation is enabled: the number of
+	// segments merged, and the total, minimum and maximum of their
+	// durations in seconds.
+	SpanAttributeAggregateCount         = "aggregate.count"
+	SpanAttributeAggregateTotalDuration = "aggregate.totalDuration"
+	SpanAttributeAggregateMinDuration   = "aggregate.minDuration"
+	SpanAttributeAggregateMaxDuration   = "aggregate.maxDuration"
+
+	// Attributes of the spans and transaction trace segments of external
+	// calls when Config.ExternalTimings is enabled: the durations in
+	// seconds of the DNS lookup, of the connection, of the TLS handshake
+	// and of the wait for the first byte of the response, and whether an
+	// idle connection was reused.
+	SpanAttributeHTTPDNSDuration      = "http.dnsDuration"
+	SpanAttributeHTTPConnectDuration  = "http.connectDuration"
+	SpanAttributeHTTPTLSDuration      = "http.tlsDuration"
+	SpanAttributeHTTPTimeToFirstByte  = "http.timeToFirstByte"
+	SpanAttributeHTTPConnectionReused = "http.connectionReused"
+
 	// Deprecated: This attribute is a duplicate of AttributeResponseCode and
 	// will be removed in a later release.
 	SpanAttributeHTTPStatusCode = "http.statusCode"
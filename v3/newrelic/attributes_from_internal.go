@@ -47,6 +47,13 @@ var (
 		AttributeAWSLambdaARN:               usualDests,
 		AttributeAWSLambdaColdStart:         usualDests,
 		AttributeAWSLambdaEventSourceARN:    usualDests,
+		AttributeCloudProvider:              usualDests,
+		AttributeCloudRegion:                usualDests,
+		AttributeFaaSName:                   usualDests,
+		AttributeFaaSInstance:               usualDests,
+		AttributeFaaSInvocationID:           usualDests,
+		AttributeFaaSColdStart:              usualDests,
+		AttributeFaaSTrigger:                usualDests,
 		AttributeMessageRoutingKey:          usualDests,
 		AttributeMessageQueueName:           usualDests,
 		AttributeMessageHeaders:             usualDests,
@@ -59,6 +66,8 @@ var (
 		AttributeCodeLineno:                 usualDests,
 		AttributeUserID:                     usualDests,
 		AttributeLLM:                        usualDests,
+		AttributeRepeatedCallCount:          usualDests,
+		AttributeTransactionIncomplete:      usualDests,
 
 		// Span specific attributes
 		SpanAttributeDBStatement:             usualDests,
@@ -78,6 +87,15 @@ var (
 		SpanAttributeParentAccount:           usualDests,
 		SpanAttributeParentTransportDuration: usualDests,
 		SpanAttributeParentTransportType:     usualDests,
+		SpanAttributeAggregateCount:          usualDests,
+		SpanAttributeAggregateTotalDuration:  usualDests,
+		SpanAttributeAggregateMinDuration:    usualDests,
+		SpanAttributeAggregateMaxDuration:    usualDests,
+		SpanAttributeHTTPDNSDuration:         usualDests,
+		SpanAttributeHTTPConnectDuration:     usualDests,
+		SpanAttributeHTTPTLSDuration:         usualDests,
+		SpanAttributeHTTPTimeToFirstByte:     usualDests,
+		SpanAttributeHTTPConnectionReused:    usualDests,
 	}
 )
 
@@ -124,6 +142,17 @@ type attributeConfig struct {
 	// over modifiers appearing earlier.
 	wildcardModifiers []*attributeModifier
 	agentDests        map[string]destinationSet
+	// headers contains the HTTP headers captured as agent attributes.
+	headers headerAttributeConfig
+}
+
+// headerAttributeConfig maps the canonical names of the HTTP headers captured
+// to the names of their attributes.
+type headerAttributeConfig struct {
+	request          map[string]string
+	response         map[string]string
+	externalRequest  map[string]string
+	externalResponse map[string]string
 }
 
 type includeExclude struct {
@@ -229,9 +258,56 @@ func createAttributeConfig(input config, includeEnabled bool) *attributeConfig {
 		c.agentDests[name] = applyAttributeConfig(c, name, dest)
 	}
 
+	if includeEnabled && !input.HighSecurity {
+		headers := input.HTTPHeaderAttributes
+		c.headers = headerAttributeConfig{
+			request:          addHeaderAttributes(c, "request.headers.", headers.Request),
+			response:         addHeaderAttributes(c, "response.headers.", headers.Response),
+			externalRequest:  addHeaderAttributes(c, "request.headers.", headers.ExternalRequest),
+			externalResponse: addHeaderAttributes(c, "response.headers.", headers.ExternalResponse),
+		}
+	}
+
 	return c
 }
 
+// addHeaderAttributes adds the agent attributes of the headers to the
+// configuration, and returns the names of their attributes by canonical
+// header name.  The headers which are already captured, such as
+// Content-Type, keep their attributes.
+func addHeaderAttributes(c *attributeConfig, prefix string, headers []string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	names := make(map[string]string, len(headers))
+	for _, h := range headers {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		name := prefix + strings.ToLower(h)
+		if _, ok := agentAttributeDefaultDests[name]; ok {
+			continue
+		}
+		c.agentDests[name] = applyAttributeConfig(c, name, usualDests)
+		names[http.CanonicalHeaderKey(h)] = name
+	}
+	return names
+}
+
+// headerValues calls fn with the attribute name and the value of each of the
+// headers captured, when present.
+func headerValues(captured map[string]string, h http.Header, fn func(name, value string)) {
+	if nil == h {
+		return
+	}
+	for key, name := range captured {
+		if values := h.Values(key); len(values) > 0 {
+			fn(name, strings.Join(values, ","))
+		}
+	}
+}
+
 type userAttribute struct {
 	value interface{}
 	dests destinationSet
@@ -569,6 +645,10 @@ func requestAgentAttributes(a *attributes, method string, hdrs http.Header, u *u
 	if l := getContentLengthFromHeader(hdrs); l >= 0 {
 		a.Agent.Add(AttributeRequestContentLength, "", l)
 	}
+
+	headerValues(a.config.headers.request, hdrs, func(name, value string) {
+		a.Agent.Add(name, value, nil)
+	})
 }
 
 // responseHeaderAttributes gather agent attributes from the response headers.
@@ -581,6 +661,25 @@ func responseHeaderAttributes(a *attributes, h http.Header) {
 	if l := getContentLengthFromHeader(h); l >= 0 {
 		a.Agent.Add(AttributeResponseContentLength, "", l)
 	}
+
+	headerValues(a.config.headers.response, h, func(name, value string) {
+		a.Agent.Add(name, value, nil)
+	})
+}
+
+// externalHeaderAttributes adds the headers captured of the request and of
+// the response of an external segment to the span attributes.
+func externalHeaderAttributes(a *attributes, attrs *spanAttributeMap, request http.Header, response *http.Response) {
+	if nil == a {
+		return
+	}
+	add := func(name, value string) {
+		attrs.addString(name, truncateStringValueIfLong(value))
+	}
+	headerValues(a.config.headers.externalRequest, request, add)
+	if nil != response {
+		headerValues(a.config.headers.externalResponse, response.Header, add)
+	}
 }
 
 var (
@@ -399,6 +399,62 @@ func TestRequestAgentAttributesPresent(t *testing.T) {
 	})
 }
 
+func TestHTTPHeaderAttributes(t *testing.T) {
+	c := config{Config: defaultConfig()}
+	c.HTTPHeaderAttributes.Request = []string{"X-Tenant-Id", "x-feature-flags", "Accept", "X-Missing"}
+	c.HTTPHeaderAttributes.Response = []string{"X-Cache"}
+	c.TransactionEvents.Attributes.Exclude = []string{"request.headers.x-feature-flags"}
+	cfg := createAttributeConfig(c, true)
+
+	hdr := http.Header{}
+	hdr.Set("Accept", "the-accept")
+	hdr.Set("X-Tenant-Id", "acme")
+	hdr.Add("X-Feature-Flags", "beta")
+	hdr.Add("X-Feature-Flags", "dark-mode")
+	attrs := newAttributes(cfg)
+	requestAgentAttributes(attrs, "GET", hdr, nil, "")
+	responseHeaderAttributes(attrs, http.Header{"X-Cache": []string{"HIT"}})
+
+	expectAttributes(t, agentAttributesMap(attrs, destError), map[string]interface{}{
+		"request.method":                  "GET",
+		"request.headers.accept":          "the-accept",
+		"request.headers.x-tenant-id":     "acme",
+		"request.headers.x-feature-flags": "beta,dark-mode",
+		"response.headers.x-cache":        "HIT",
+	})
+	expectAttributes(t, agentAttributesMap(attrs, destTxnEvent), map[string]interface{}{
+		"request.method":              "GET",
+		"request.headers.accept":      "the-accept",
+		"request.headers.x-tenant-id": "acme",
+		"response.headers.x-cache":    "HIT",
+	})
+	if v, _ := attrs.GetAgentValue("request.headers.x-tenant-id", destBrowser); v != "" {
+		t.Error("header sent to the browser", v)
+	}
+}
+
+func TestHTTPHeaderAttributesNotCaptured(t *testing.T) {
+	hdr := http.Header{}
+	hdr.Set("X-Tenant-Id", "acme")
+
+	c := config{Config: defaultConfig()}
+	c.HTTPHeaderAttributes.Request = []string{"X-Tenant-Id"}
+	c.HighSecurity = true
+	attrs := newAttributes(createAttributeConfig(c, true))
+	requestAgentAttributes(attrs, "GET", hdr, nil, "")
+	expectAttributes(t, agentAttributesMap(attrs, destAll), map[string]interface{}{
+		"request.method": "GET",
+	})
+
+	// The attributes_include security policy is disabled.
+	c.HighSecurity = false
+	attrs = newAttributes(createAttributeConfig(c, false))
+	requestAgentAttributes(attrs, "GET", hdr, nil, "")
+	expectAttributes(t, agentAttributesMap(attrs, destAll), map[string]interface{}{
+		"request.method": "GET",
+	})
+}
+
 func BenchmarkAgentAttributes(b *testing.B) {
 	cfg := createAttributeConfig(config{Config: defaultConfig()}, true)
 
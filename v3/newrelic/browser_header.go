@@ -83,6 +83,22 @@ func (h *BrowserTimingHeader) WithoutTags() []byte {
 	return appendSlices([]byte(h.agentLoader), browserInfoPrefix, info)
 }
 
+// loaderWithTags returns the browser agent loader, which is inserted in the
+// head of a page, with its enclosing tags.
+func (h *BrowserTimingHeader) loaderWithTags() []byte {
+	return appendSlices(browserStartTag, []byte(h.agentLoader), browserEndTag)
+}
+
+// infoWithTags returns the browser agent info, which may be inserted at the
+// end of a page, with its enclosing tags.
+func (h *BrowserTimingHeader) infoWithTags() []byte {
+	info, err := json.Marshal(h.info)
+	if err != nil {
+		return nil
+	}
+	return appendSlices(browserStartTag, browserInfoPrefix, info, browserEndTag)
+}
+
 // browserAttributes returns a string with the attributes that are attached to
 // the browser destination encoded in the JSON format expected by the Browser
 // agent.
@@ -0,0 +1,275 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxBrowserInjectionBuffer limits the size of the responses buffered for
+// the injection of the browser timing header.  Larger responses are streamed
+// with the header injected in their beginning, if possible.
+const maxBrowserInjectionBuffer = 1024 * 1024
+
+var (
+	browserXUACompatibleRegex = regexp.MustCompile(`(?is)<\s*meta[^>]+http-equiv\s*=\s*['"]x-ua-compatible['"][^>]*>`)
+	browserCharsetRegex       = regexp.MustCompile(`(?is)<\s*meta[^>]+charset\s*=[^>]*>`)
+	browserHeadOpenRegex      = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+	browserBodyOpenRegex      = regexp.MustCompile(`(?i)<body[\s>]`)
+	browserBodyCloseRegex     = regexp.MustCompile(`(?i)</body>`)
+
+	// browserAgentMarker is found in the pages which already contain the
+	// browser agent.
+	browserAgentMarker = []byte("NREUM")
+)
+
+// browserLoaderIndex returns the index at which the browser agent loader is
+// inserted in the page: after the X-UA-Compatible and charset meta tags,
+// which must come first in the head, otherwise at the start of the head or
+// before the body.  It returns -1 if there is no suitable location.
+func browserLoaderIndex(html []byte) int {
+	idx := -1
+	if loc := browserXUACompatibleRegex.FindIndex(html); nil != loc {
+		idx = loc[1]
+	}
+	if loc := browserCharsetRegex.FindIndex(html); nil != loc && loc[1] > idx {
+		idx = loc[1]
+	}
+	if idx >= 0 {
+		return idx
+	}
+	if loc := browserHeadOpenRegex.FindIndex(html); nil != loc {
+		return loc[1]
+	}
+	if loc := browserBodyOpenRegex.FindIndex(html); nil != loc {
+		return loc[0]
+	}
+	return -1
+}
+
+// browserFooterIndex returns the index of the last </body>, before which the
+// browser agent info is inserted, or -1 if there is none.  Scripts and
+// comments may contain </body> before the actual end of the body.
+func browserFooterIndex(html []byte) int {
+	locs := browserBodyCloseRegex.FindAllIndex(html, -1)
+	if len(locs) == 0 {
+		return -1
+	}
+	return locs[len(locs)-1][0]
+}
+
+// injectBrowserTimingHeader returns the page with the browser agent loader
+// and info inserted.  The info is inserted at the end of the body when the
+// page is complete, and right after the loader otherwise.  The page is
+// returned unchanged if no location is suitable for the loader, or if it
+// already contains the browser agent, for example inserted with
+// Transaction.BrowserTimingHeader.
+func injectBrowserTimingHeader(html []byte, hdr *BrowserTimingHeader, complete bool) []byte {
+	if bytes.Contains(html, browserAgentMarker) {
+		return html
+	}
+	loader := browserLoaderIndex(html)
+	if loader < 0 {
+		return html
+	}
+	footer := -1
+	if complete {
+		footer = browserFooterIndex(html)
+	}
+	if footer < loader {
+		return appendSlices(html[:loader], hdr.WithTags(), html[loader:])
+	}
+	return appendSlices(html[:loader], hdr.loaderWithTags(), html[loader:footer],
+		hdr.infoWithTags(), html[footer:])
+}
+
+// browserInjector buffers the text/html response of a transaction, so that
+// the browser timing header can be injected into it when the transaction
+// ends.  Other responses are written through.
+type browserInjector struct {
+	txn      *txn
+	original http.ResponseWriter
+
+	// code is the status code given to WriteHeader, which is not written
+	// until the response is known not to be buffered.
+	code      int
+	decided   bool
+	buffering bool
+	buf       bytes.Buffer
+}
+
+func newBrowserInjector(txn *txn, original http.ResponseWriter) *browserInjector {
+	return &browserInjector{txn: txn, original: original}
+}
+
+// injectable returns true if a response with the status code, content type
+// and headers given is an HTML page whose encoding is understood.
+func injectable(code int, contentType string, hdr http.Header) bool {
+	if code != http.StatusOK {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "text/html" {
+		return false
+	}
+	if strings.HasPrefix(strings.ToLower(hdr.Get("Content-Disposition")), "attachment") {
+		return false
+	}
+	switch strings.ToLower(hdr.Get("Content-Encoding")) {
+	case "", "identity", "gzip":
+		return true
+	}
+	return false
+}
+
+// decide determines whether the response is buffered when its body starts
+// being written.  The content type is sniffed from the first write if it is
+// not set, as http.ResponseWriter does.
+func (bi *browserInjector) decide(b []byte) {
+	bi.decided = true
+	hdr := bi.original.Header()
+	code := bi.code
+	if 0 == code {
+		code = http.StatusOK
+	}
+	contentType := hdr.Get("Content-Type")
+	_, hasContentType := hdr["Content-Type"]
+	if !hasContentType && len(b) > 0 && "" == hdr.Get("Content-Encoding") {
+		contentType = http.DetectContentType(b)
+	}
+	bi.buffering = injectable(code, contentType, hdr)
+	if bi.buffering && !hasContentType {
+		// The sniffed content type must not change once the page is
+		// modified.
+		hdr.Set("Content-Type", contentType)
+	}
+	if !bi.buffering && 0 != bi.code {
+		bi.original.WriteHeader(bi.code)
+	}
+}
+
+func (bi *browserInjector) WriteHeader(code int) {
+	if bi.decided || 0 != bi.code {
+		bi.original.WriteHeader(code)
+		return
+	}
+	if code >= 100 && code < 200 {
+		// Informational responses precede the final one.
+		bi.original.WriteHeader(code)
+		return
+	}
+	bi.code = code
+	if code != http.StatusOK {
+		bi.decide(nil)
+	}
+}
+
+func (bi *browserInjector) Write(b []byte) (int, error) {
+	if !bi.decided {
+		bi.decide(b)
+	}
+	if !bi.buffering {
+		return bi.original.Write(b)
+	}
+	bi.buf.Write(b)
+	if bi.buf.Len() > maxBrowserInjectionBuffer {
+		if err := bi.stream(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// passThrough returns true if writes are no longer buffered.
+func (bi *browserInjector) passThrough() bool {
+	return bi.decided && !bi.buffering
+}
+
+// stream stops the buffering of the response, because the handler flushes
+// it or because it is too large.  The header is injected in the part of the
+// page which has been buffered, and the rest of the response is written
+// through.
+func (bi *browserInjector) stream() error {
+	if !bi.decided {
+		bi.decide(nil)
+	}
+	if !bi.buffering {
+		return nil
+	}
+	bi.buffering = false
+	hdr := bi.original.Header()
+	body := bi.buf.Bytes()
+	bi.buf = bytes.Buffer{}
+	if len(body) > 0 && "" == hdr.Get("Content-Encoding") {
+		if th, _ := bi.txn.BrowserTimingHeader(); nil != th {
+			body = injectBrowserTimingHeader(body, th, false)
+		}
+	}
+	hdr.Del("Content-Length")
+	bi.writeHeader()
+	_, err := bi.original.Write(body)
+	return err
+}
+
+// finish writes the buffered response with the browser timing header
+// injected.  It is called before the transaction ends.
+func (bi *browserInjector) finish() {
+	if !bi.decided {
+		bi.decide(nil)
+	}
+	if !bi.buffering {
+		return
+	}
+	bi.buffering = false
+	hdr := bi.original.Header()
+	body := bi.buf.Bytes()
+	bi.buf = bytes.Buffer{}
+	if len(body) > 0 {
+		if th, _ := bi.txn.BrowserTimingHeader(); nil != th {
+			body = injectCompressed(body, hdr, th)
+		}
+	}
+	if _, ok := hdr["Content-Length"]; ok {
+		hdr.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	bi.writeHeader()
+	bi.original.Write(body)
+}
+
+func (bi *browserInjector) writeHeader() {
+	if 0 != bi.code {
+		bi.original.WriteHeader(bi.code)
+	}
+}
+
+// injectCompressed injects the header into a complete page, which is
+// decompressed first if it is gzipped.  The page is returned unchanged if
+// it cannot be decompressed.
+func injectCompressed(body []byte, hdr http.Header, th *BrowserTimingHeader) []byte {
+	if "gzip" != strings.ToLower(hdr.Get("Content-Encoding")) {
+		return injectBrowserTimingHeader(body, th, true)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return body
+	}
+	html, err := io.ReadAll(zr)
+	if err != nil {
+		return body
+	}
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write(injectBrowserTimingHeader(html, th, true))
+	if err := zw.Close(); err != nil {
+		return body
+	}
+	return buf.Bytes()
+}
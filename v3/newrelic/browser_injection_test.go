@@ -0,0 +1,215 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal/crossagent"
+)
+
+func testInsertionLocation(t *testing.T, dir, marker string, index func([]byte) int) {
+	files, err := crossagent.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixtures found in", dir)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		input, err := crossagent.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := bytes.Index(input, []byte(marker))
+		if expect < 0 {
+			t.Fatal("fixture has no expected location", name)
+		}
+		html := bytes.Replace(input, []byte(marker), nil, 1)
+		if got := index(html); got != expect {
+			t.Errorf("%s: got=%d want=%d", name, got, expect)
+		}
+	}
+}
+
+func TestBrowserLoaderInsertionLocation(t *testing.T) {
+	testInsertionLocation(t, "rum_loader_insertion_location", "EXPECTED_RUM_LOADER_LOCATION", browserLoaderIndex)
+}
+
+func TestBrowserFooterInsertionLocation(t *testing.T) {
+	testInsertionLocation(t, "rum_footer_insertion_location", "EXPECTED_RUM_FOOTER_LOCATION", browserFooterIndex)
+}
+
+func TestBrowserLoaderNoLocation(t *testing.T) {
+	if idx := browserLoaderIndex([]byte("just some text")); idx != -1 {
+		t.Error(idx)
+	}
+}
+
+const (
+	browserTestPage   = "<html><head><title>hello</title></head><body>hello world</body></html>"
+	browserTestLoader = `<script type="text/javascript">loader</script>`
+	browserTestInfo   = `<script type="text/javascript">window.NREUM||(NREUM={});NREUM.info=`
+)
+
+func browserAutoInstrument(cfg *Config) {
+	cfg.BrowserMonitoring.AutoInstrument = true
+}
+
+func TestBrowserAutoInstrument(t *testing.T) {
+	app := testApp(browserReplyFields, browserAutoInstrument, t)
+	txn := app.StartTransaction("hello")
+	rec := httptest.NewRecorder()
+	rw := txn.SetWebResponse(rec)
+	rw.Header().Set("Content-Length", "123")
+	io.WriteString(rw, browserTestPage[:30])
+	io.WriteString(rw, browserTestPage[30:])
+	if rec.Body.Len() != 0 {
+		t.Error("response written before the transaction ended", rec.Body.String())
+	}
+	txn.End()
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "<html><head>"+browserTestLoader+"<title>hello</title></head><body>hello world"+browserTestInfo) {
+		t.Error(body)
+	}
+	if !strings.HasSuffix(body, "</script></body></html>") {
+		t.Error(body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Error(ct)
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(len(body)) {
+		t.Error(cl, len(body))
+	}
+	app.expectNoLoggedErrors(t)
+}
+
+func TestBrowserAutoInstrumentDisabled(t *testing.T) {
+	app := testApp(browserReplyFields, nil, t)
+	txn := app.StartTransaction("hello")
+	rec := httptest.NewRecorder()
+	rw := txn.SetWebResponse(rec)
+	io.WriteString(rw, browserTestPage)
+	txn.End()
+	if body := rec.Body.String(); body != browserTestPage {
+		t.Error(body)
+	}
+}
+
+func TestBrowserAutoInstrumentNotHTML(t *testing.T) {
+	app := testApp(browserReplyFields, browserAutoInstrument, t)
+	txn := app.StartTransaction("hello")
+	rec := httptest.NewRecorder()
+	rw := txn.SetWebResponse(rec)
+	rw.Header().Set("Content-Type", "application/json")
+	io.WriteString(rw, `{"html":"<head></head>"}`)
+	if body := rec.Body.String(); body != `{"html":"<head></head>"}` {
+		t.Error("response not written through", body)
+	}
+	txn.End()
+}
+
+func TestBrowserAutoInstrumentErrorCode(t *testing.T) {
+	app := testApp(browserReplyFields, browserAutoInstrument, t)
+	txn := app.StartTransaction("hello")
+	rec := httptest.NewRecorder()
+	rw := txn.SetWebResponse(rec)
+	rw.WriteHeader(http.StatusNotFound)
+	io.WriteString(rw, browserTestPage)
+	if rec.Code != http.StatusNotFound || rec.Body.String() != browserTestPage {
+		t.Error("response not written through", rec.Code, rec.Body.String())
+	}
+	txn.End()
+}
+
+func TestBrowserAutoInstrumentGzip(t *testing.T) {
+	app := testApp(browserReplyFields, browserAutoInstrument, t)
+	txn := app.StartTransaction("hello")
+	rec := httptest.NewRecorder()
+	rw := txn.SetWebResponse(rec)
+	rw.Header().Set("Content-Type", "text/html")
+	rw.Header().Set("Content-Encoding", "gzip")
+	rw.WriteHeader(http.StatusOK)
+	zw := gzip.NewWriter(rw)
+	io.WriteString(zw, browserTestPage)
+	zw.Close()
+	txn.End()
+
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, _ := io.ReadAll(zr)
+	if !strings.Contains(string(html), "<head>"+browserTestLoader) || !strings.Contains(string(html), browserTestInfo) {
+		t.Error(string(html))
+	}
+}
+
+func TestBrowserAutoInstrumentAlreadyInjected(t *testing.T) {
+	app := testApp(browserReplyFields, browserAutoInstrument, t)
+	txn := app.StartTransaction("hello")
+	rec := httptest.NewRecorder()
+	rw := txn.SetWebResponse(rec)
+	page := "<html><head>" + string(txn.BrowserTimingHeader().WithTags()) + "<title>hello</title></head><body>hello world</body></html>"
+	io.WriteString(rw, page)
+	txn.End()
+	if body := rec.Body.String(); body != page {
+		t.Error(body)
+	}
+
+	// The header is not injected either when the response is streamed.
+	txn = app.StartTransaction("hello")
+	rec = httptest.NewRecorder()
+	rw = txn.SetWebResponse(rec)
+	io.WriteString(rw, page)
+	rw.(http.Flusher).Flush()
+	txn.End()
+	if body := rec.Body.String(); body != page {
+		t.Error(body)
+	}
+}
+
+func TestBrowserAutoInstrumentFlush(t *testing.T) {
+	app := testApp(browserReplyFields, browserAutoInstrument, t)
+	txn := app.StartTransaction("hello")
+	rec := httptest.NewRecorder()
+	rw := txn.SetWebResponse(rec)
+	rw.Header().Set("Content-Length", "123")
+	io.WriteString(rw, "<html><head><title>hello</title></head>")
+	rw.(http.Flusher).Flush()
+	if body := rec.Body.String(); !strings.HasPrefix(body, `<html><head><script type="text/javascript">loaderwindow.NREUM`) {
+		t.Error("header not injected when flushed", body)
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != "" {
+		t.Error("Content-Length not removed", cl)
+	}
+	io.WriteString(rw, "<body>hello world</body></html>")
+	if body := rec.Body.String(); !strings.HasSuffix(body, "</head><body>hello world</body></html>") {
+		t.Error("response not written through after flush", body)
+	}
+	txn.End()
+}
+
+func TestBrowserAutoInstrumentNotConnected(t *testing.T) {
+	// The page is written unchanged when there is no loader.
+	app := testApp(nil, browserAutoInstrument, t)
+	txn := app.StartTransaction("hello")
+	rec := httptest.NewRecorder()
+	rw := txn.SetWebResponse(rec)
+	io.WriteString(rw, browserTestPage)
+	txn.End()
+	if body := rec.Body.String(); body != browserTestPage {
+		t.Error(body)
+	}
+}
@@ -6,6 +6,7 @@ package newrelic
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -38,6 +39,10 @@ const (
 	cmdTxnTraces    = "transaction_sample_data"
 	cmdSlowSQLs     = "sql_trace_data"
 	cmdSpanEvents   = "span_event_data"
+
+	cmdAgentCommands       = "get_agent_commands"
+	cmdAgentCommandResults = "agent_command_results"
+	cmdProfileData         = "profile_data"
 )
 
 // rpmCmd contains fields specific to an individual call made to RPM.
@@ -48,6 +53,8 @@ type rpmCmd struct {
 	Data              []byte
 	RequestHeadersMap map[string]string
 	MaxPayloadSize    int
+	// Context bounds the request when not nil.
+	Context context.Context
 }
 
 // rpmControls contains fields which will be the same for all calls made
@@ -74,6 +81,7 @@ type rpmControls struct {
 //	410 means shutdown
 //	401, 409 mean restart run
 //	408, 429, 500, 503 mean save data for next harvest
+//	413 means split the data in two and send each half, if possible
 //	all other response codes and errors discard the data and continue the current harvest
 type rpmResponse struct {
 	statusCode int
@@ -84,6 +92,9 @@ type rpmResponse struct {
 	disconnectSecurityPolicy bool
 	// forceSaveHarvestData overrides the status code and forces a save of data
 	forceSaveHarvestData bool
+	// payloadTooLarge indicates that the payload exceeded the max payload
+	// size of the connect reply and was not sent.
+	payloadTooLarge bool
 }
 
 // please create all rpmResponses this way
@@ -149,6 +160,12 @@ func (resp rpmResponse) IsRestartException() bool {
 		resp.statusCode == 409
 }
 
+// IsPayloadTooLarge indicates that the payload was rejected for its size,
+// either by the collector or before being sent.
+func (resp rpmResponse) IsPayloadTooLarge() bool {
+	return resp.statusCode == 413 || resp.payloadTooLarge
+}
+
 func (resp rpmResponse) GetError() error {
 	return resp.err
 }
@@ -211,10 +228,16 @@ func collectorRequestInternal(url string, cmd rpmCmd, cs rpmControls) *rpmRespon
 	}
 
 	if l := compressed.Len(); l > cmd.MaxPayloadSize {
-		return newRPMResponse(fmt.Errorf("Payload size for %s too large: %d greater than %d", cmd.Name, l, cmd.MaxPayloadSize))
+		r := newRPMResponse(fmt.Errorf("Payload size for %s too large: %d greater than %d", cmd.Name, l, cmd.MaxPayloadSize))
+		r.payloadTooLarge = true
+		return r
 	}
 
-	req, err := http.NewRequest("POST", url, compressed)
+	ctx := cmd.Context
+	if nil == ctx {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, compressed)
 	if nil != err {
 		return newRPMResponse(err)
 	}
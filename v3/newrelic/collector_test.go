@@ -5,6 +5,7 @@ package newrelic
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,6 +19,7 @@ import (
 
 	"github.com/newrelic/go-agent/v3/internal"
 	"github.com/newrelic/go-agent/v3/internal/logger"
+	"github.com/newrelic/go-agent/v3/newrelic/fakecollector"
 )
 
 func TestURLErrorRedaction(t *testing.T) {
@@ -81,6 +83,9 @@ func TestCollectorResponseCodeError(t *testing.T) {
 		if tc.saveHarvestData != resp.ShouldSaveHarvestData() {
 			t.Error("save harvest data", tc.code, tc.saveHarvestData, resp.GetError())
 		}
+		if (tc.code == 413) != resp.IsPayloadTooLarge() {
+			t.Error("payload too large", tc.code, resp.GetError())
+		}
 	}
 }
 
@@ -452,6 +457,9 @@ func TestCollectorRequestRespectsMaxPayloadSize(t *testing.T) {
 	if resp.ShouldSaveHarvestData() {
 		t.Error("harvest data should be discarded when max_payload_size_in_bytes is exceeded")
 	}
+	if !resp.IsPayloadTooLarge() {
+		t.Error("payload should be too large")
+	}
 }
 
 func TestConnectReplyMaxPayloadSize(t *testing.T) {
@@ -530,3 +538,169 @@ func TestPreconnectRequestMarshall(t *testing.T) {
 		}
 	}
 }
+
+func fakeCollectorControls(srv *fakecollector.Server) rpmControls {
+	return rpmControls{
+		License: "12345",
+		Client:  &http.Client{Transport: srv.Transport()},
+		Logger:  logger.ShimLogger{IsDebugEnabled: true},
+		GzipWriterPool: &sync.Pool{
+			New: func() interface{} {
+				return gzip.NewWriter(io.Discard)
+			},
+		},
+	}
+}
+
+// fakeCollectorConfig configures the agent to connect to the fake collector,
+// without the detection of the cloud providers and containers.
+func fakeCollectorConfig(srv *fakecollector.Server) ConfigOption {
+	return func(cfg *Config) {
+		cfg.Host = srv.Host()
+		cfg.Transport = srv.Transport()
+		cfg.Logger = logger.ShimLogger{}
+		cfg.Utilization.DetectAWS = false
+		cfg.Utilization.DetectAzure = false
+		cfg.Utilization.DetectGCP = false
+		cfg.Utilization.DetectPCF = false
+		cfg.Utilization.DetectDocker = false
+		cfg.Utilization.DetectKubernetes = false
+	}
+}
+
+func TestConnectAttemptFakeCollector(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	srv.SetConnectReply(map[string]interface{}{
+		"event_harvest_config": map[string]interface{}{
+			"report_period_ms": 5000,
+			"harvest_limits": map[string]interface{}{
+				"analytic_event_data": 100,
+			},
+		},
+	})
+	srv.Respond(fakecollector.MethodPreconnect, fakecollector.Response{StatusCode: 503})
+
+	cfg := config{Config: defaultConfig()}
+	fakeCollectorConfig(srv)(&cfg.Config)
+	cs := fakeCollectorControls(srv)
+
+	run, resp := connectAttempt(cfg, cs)
+	if nil != run || resp.IsDisconnect() || resp.GetError() == nil {
+		t.Fatal(run, resp)
+	}
+	run, resp = connectAttempt(cfg, cs)
+	if nil == run || nil != resp.GetError() {
+		t.Fatal(run, resp.GetError())
+	}
+	if run.Collector != srv.Host() || run.RunID != "fakecollector-run-1" {
+		t.Error(run.Collector, run.RunID)
+	}
+	if p := run.ConfigurablePeriod(); p != 5*time.Second {
+		t.Error(p)
+	}
+	if limit := run.EventData.Limits.TxnEvents; nil == limit || *limit != 100 {
+		t.Error(limit)
+	}
+	if connects := srv.RequestsFor(fakecollector.MethodConnect); len(connects) != 1 || connects[0].License != "12345" {
+		t.Error(connects)
+	}
+}
+
+func TestConnectAttemptFakeCollectorDisconnect(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	srv.Respond(fakecollector.MethodConnect, fakecollector.Response{StatusCode: 410})
+
+	cfg := config{Config: defaultConfig()}
+	fakeCollectorConfig(srv)(&cfg.Config)
+	run, resp := connectAttempt(cfg, fakeCollectorControls(srv))
+	if nil != run || !resp.IsDisconnect() {
+		t.Error(run, resp)
+	}
+}
+
+func TestCollectorRequestFakeCollectorResponseCodes(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	cs := fakeCollectorControls(srv)
+
+	testcases := []struct {
+		code       int
+		disconnect bool
+		restart    bool
+		save       bool
+		tooLarge   bool
+	}{
+		{code: 200},
+		{code: 401, restart: true},
+		{code: 409, restart: true},
+		{code: 410, disconnect: true},
+		{code: 413, tooLarge: true},
+		{code: 429, save: true},
+		{code: 503, save: true},
+		{code: 400},
+	}
+	for _, tc := range testcases {
+		srv.Respond(cmdMetrics, fakecollector.Response{StatusCode: tc.code})
+		resp := collectorRequest(rpmCmd{
+			Name:           cmdMetrics,
+			Collector:      srv.Host(),
+			RunID:          "run",
+			Data:           []byte(`["run",1,2,[]]`),
+			MaxPayloadSize: internal.MaxPayloadSizeInBytes,
+		}, cs)
+		if resp.statusCode != tc.code ||
+			resp.IsDisconnect() != tc.disconnect ||
+			resp.IsRestartException() != tc.restart ||
+			resp.ShouldSaveHarvestData() != tc.save ||
+			resp.IsPayloadTooLarge() != tc.tooLarge ||
+			(resp.GetError() == nil) != (tc.code == 200) {
+			t.Errorf("code %d: %+v", tc.code, resp)
+		}
+	}
+	requests := srv.RequestsFor(cmdMetrics)
+	if len(requests) != len(testcases) {
+		t.Fatal(requests)
+	}
+	if body := string(requests[0].Body); body != `["run",1,2,[]]` {
+		t.Error(body)
+	}
+}
+
+func TestApplicationFakeCollector(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app, err := NewApplication(
+		ConfigAppName("my app"),
+		ConfigLicense(testLicenseKey),
+		fakeCollectorConfig(srv),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Shutdown(10 * time.Second)
+	if err := app.WaitForConnection(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The metrics rejected with a 503 are sent with the next harvest.
+	srv.Respond(cmdMetrics, fakecollector.Response{StatusCode: 503})
+	app.RecordCustomMetric("first", 1)
+	app.Flush(context.Background())
+	app.RecordCustomMetric("second", 1)
+	app.Flush(context.Background())
+
+	requests := srv.RequestsFor(cmdMetrics)
+	if len(requests) != 2 {
+		t.Fatal(requests)
+	}
+	for _, metric := range []string{"Custom/first", "Custom/second"} {
+		if !strings.Contains(string(requests[1].Body), `"`+metric+`"`) {
+			t.Errorf("%s missing: %s", metric, requests[1].Body)
+		}
+	}
+	if runID := requests[1].RunID; runID != "fakecollector-run-1" {
+		t.Error(runID)
+	}
+}
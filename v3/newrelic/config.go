@@ -21,7 +21,10 @@ import (
 
 // Config contains Application and Transaction behavior settings.
 type Config struct {
-	// AppName is used by New Relic to link data across servers.
+	// AppName is used by New Relic to link data across servers.  Up to
+	// three names separated by semicolons roll the data up into each of
+	// the applications named, for example "Gateway;Platform".  The first
+	// name is the entity of the application.
 	//
 	// https://docs.newrelic.com/docs/apm/new-relic-apm/installation-configuration/naming-your-application
 	AppName string
@@ -62,6 +65,22 @@ type Config struct {
 	// https://docs.newrelic.com/docs/agents/manage-apm-agents/configuration/enable-configurable-security-policies
 	SecurityPoliciesToken string
 
+	// Destinations lists the accounts the data of the application is
+	// reported to in addition to the account of License.  Each destination
+	// is connected separately, and receives a copy of every harvest
+	// filtered by its own attribute and high security settings.  Data is
+	// only recorded while the application is connected to its own
+	// account.  Destinations cannot be used in serverless mode.
+	//
+	// For example, to also report to the account of a product team:
+	//
+	//	cfg.Destinations = append(cfg.Destinations, newrelic.DestinationConfig{
+	//		AppName: "Checkout Gateway",
+	//		License: os.Getenv("CHECKOUT_LICENSE_KEY"),
+	//	})
+	//
+	Destinations []DestinationConfig
+
 	// CustomInsightsEvents controls the behavior of
 	// Application.RecordCustomEvent.
 	//
@@ -182,6 +201,18 @@ type Config struct {
 		//
 		//	cfg.BrowserMonitoring.Attributes.Enabled = true
 		Attributes AttributeDestinationConfig
+		// AutoInstrument controls whether the browser timing header is
+		// injected into the HTML responses written with the
+		// http.ResponseWriter returned by Transaction.SetWebResponse,
+		// as done by WrapHandle and WrapHandleFunc.  Responses with a
+		// text/html content type and a 200 status code are buffered
+		// until the transaction ends: the loader is inserted in the
+		// head and the timing information before the closing body tag.
+		// Responses which are flushed or larger than 1MB are written
+		// as they come once the loader has been inserted.  Gzipped
+		// responses are supported.  AutoInstrument is false by
+		// default.
+		AutoInstrument bool
 	}
 
 	// HostDisplayName gives this server a recognizable name in the New
@@ -337,7 +368,202 @@ type Config struct {
 		SlowQuery struct {
 			Enabled   bool
 			Threshold time.Duration
+
+			// ExplainPlan controls the capture of the execution plans
+			// of slow queries.  When enabled, a SELECT query made
+			// with a driver instrumented by InstrumentSQLDriver or
+			// InstrumentSQLConnector (nrpq, nrpgx, nrmysql and
+			// nrsqlite3) which exceeds Threshold is explained on the
+			// same connection once its rows are closed.  Each plan is
+			// an extra query, made before Rows.Close or Exec return,
+			// which adds a round trip to the database to the latency
+			// of the call explained: MaxPerHarvest limits the number
+			// of plans captured by the application between two
+			// harvests.  Queries made in a transaction are not
+			// explained, since a failed explain statement would abort
+			// the transaction.  Postgres plans are obfuscated unless
+			// RawQuery is enabled.
+			ExplainPlan struct {
+				Enabled       bool
+				MaxPerHarvest int
+			}
+		}
+	}
+
+	// RepeatedCalls controls the detection of the datastore and external
+	// calls repeated within a transaction, such as the N+1 queries made by
+	// an ORM loading associations one at a time.  Datastore calls are
+	// identified by their product, operation, collection and parameterized
+	// query, and external calls by their method, host and path, where
+	// numeric and hexadecimal path segments are replaced with "*".  When a
+	// call is made more than Threshold times, the span of each further call
+	// and the transaction event get the repeatedCall.count attribute, and
+	// a RepeatedCall custom event is recorded when the transaction ends.
+	RepeatedCalls struct {
+		Enabled   bool
+		Threshold int
+	}
+
+	// LeakedTransactions controls the detection of the transactions which
+	// are started but never ended, such as those of a forgotten deferred
+	// End or of a leaked goroutine, and which hold their segments in
+	// memory.  When a transaction is still running MaxAge after it
+	// started, a warning is logged with the stack of the call that started
+	// it, and a Supportability/Go/Transaction/Leaked metric and an
+	// AgentLeakedTransaction custom event are recorded.  If ForceEnd is
+	// set, the transaction is also ended with the incomplete attribute so
+	// that its data is reported and released.  Each transaction is
+	// reported once.
+	LeakedTransactions struct {
+		Enabled  bool
+		MaxAge   time.Duration
+		ForceEnd bool
+	}
+
+	// ExternalTimings controls the timing of the phases of the HTTP
+	// requests of the external segments started by StartExternalSegment
+	// and NewRoundTripper, to tell slow DNS lookups, connections and TLS
+	// handshakes apart from server latency.  When enabled, an
+	// httptrace.ClientTrace is added to the context of a copy of the
+	// request, which is the Request of the ExternalSegment, and the span
+	// and the transaction trace segment of the external call get the
+	// http.dnsDuration, http.connectDuration, http.tlsDuration and
+	// http.timeToFirstByte attributes, in seconds, for the phases which
+	// took place, as well as the http.connectionReused attribute.  The
+	// time to first byte is measured from the end of the writing of the
+	// request.  When Segments is also enabled, each phase is recorded as a
+	// child segment of the external segment, named
+	// External/<host>/<phase>, in transaction traces and span events.
+	ExternalTimings struct {
+		Enabled  bool
+		Segments bool
+	}
+
+	// Diagnostics controls the standalone listener serving the agent
+	// state reported by DiagnosticsHandler.  When ListenAddress is not
+	// empty, such as "localhost:8686", the application serves the report
+	// on this address until it is shut down.  The report contains the
+	// configuration of the application, except for the license key: the
+	// address should not be reachable from outside of the host.
+	Diagnostics struct {
+		ListenAddress string
+	}
+
+	// Harvest controls the posting of the data of each harvest to New
+	// Relic.  The payloads of a harvest, one per type of data, are posted
+	// concurrently by at most MaxConcurrentRequests requests, so that a
+	// slow endpoint does not delay the others.  Each request is canceled
+	// after RequestTimeout, which can only shorten the 20 second timeout of
+	// all the requests to New Relic, and the requests of a harvest are
+	// canceled after Timeout.  The data which could not be sent in time is
+	// kept for the next harvest.  Zero timeouts are not applied.
+	Harvest struct {
+		MaxConcurrentRequests int
+		RequestTimeout        time.Duration
+		Timeout               time.Duration
+	}
+
+	// AgentControl controls the reporting of the health of the agent to
+	// New Relic Agent Control, which manages the agents of a fleet.  When
+	// Enabled, the application periodically writes a health file, named
+	// health-<id>.yml, to the Health.DeliveryLocation directory, which is a
+	// path or a file URI such as "file:///newrelic/apm/health".  The file
+	// reports whether the agent is healthy and, if not, the last error
+	// detected, such as an invalid license key or a forced disconnect.
+	AgentControl struct {
+		Enabled bool
+		Health  struct {
+			DeliveryLocation string
+			// Frequency is the interval between two writes of the
+			// health file.
+			Frequency time.Duration
+		}
+	}
+
+	// ThreadProfiler controls the goroutine profiles started from the New
+	// Relic UI.  The agent polls New Relic for commands at each metric
+	// harvest, and a requested profile samples the stacks of all
+	// goroutines at the requested period, which briefly stops the world,
+	// for the requested duration.  The call trees are sent once the
+	// profile is complete.
+	ThreadProfiler struct {
+		Enabled bool
+	}
+
+	// ContinuousProfiler controls the continuous capture of pprof
+	// profiles.  When Enabled, the profiles are captured every Period: a
+	// CPU profile lasting CPU.Duration, a heap profile, and mutex and
+	// block profiles if enabled, using the sampling rates given.  Each
+	// profile is written to Directory as <type>-<unix nanoseconds>.pb.gz
+	// if Directory is not empty, and posted to URL if URL is not empty.
+	//
+	// While the profiler is enabled, the goroutines serving the requests
+	// of WrapHandle, WrapHandleFunc and WrapServeMux, and the functions
+	// run using Transaction.DoWithProfilerLabels, are tagged with the
+	// pprof labels "transaction.name", "trace.id" and "span.id", which
+	// attribute the CPU samples to transactions and traces.
+	ContinuousProfiler struct {
+		Enabled bool
+		Period  time.Duration
+		CPU     struct {
+			Enabled  bool
+			Duration time.Duration
 		}
+		Heap struct {
+			Enabled bool
+		}
+		Mutex struct {
+			Enabled bool
+			// Fraction is passed to
+			// runtime.SetMutexProfileFraction.
+			Fraction int
+		}
+		Block struct {
+			Enabled bool
+			// Rate is passed to runtime.SetBlockProfileRate.
+			Rate int
+		}
+		Directory string
+		URL       string
+	}
+
+	// ConfigFile names a JSON file of settings applied with
+	// Application.UpdateConfig when the application starts, whenever the
+	// modification time of the file changes, which is checked every Period,
+	// and, if ReloadOnSIGHUP is set, whenever the process receives SIGHUP.
+	// The file holds an object with the fields of Config to change, such
+	// as:
+	//
+	//	{"ApplicationLogging": {"Enabled": true, "Forwarding": {"Enabled": true}}}
+	//
+	// Only the settings which can change at runtime are applied, see
+	// Application.UpdateConfig.  Durations are in nanoseconds, and the
+	// Labels of the file replace the current labels.  The file is not
+	// polled if Period is not positive.
+	ConfigFile struct {
+		Path           string
+		Period         time.Duration
+		ReloadOnSIGHUP bool
+	}
+
+	// SegmentAggregation controls the merging of consecutive sibling
+	// segments with the same name, such as the calls made in a loop, into
+	// a single transaction trace node and span event, so that they do not
+	// crowd out the rest of the transaction.  Merging is enabled for each
+	// type of segment: basic segments (Custom), datastore, external and
+	// message segments.  Only segments without child segments are merged.
+	// The merged node and span cover the time from the start of the first
+	// segment to the end of the last, keep the attributes of the first
+	// segment, and get the aggregate.count, aggregate.totalDuration,
+	// aggregate.minDuration and aggregate.maxDuration attributes.  Metrics
+	// still record each segment.  The distributed tracing headers added to
+	// the requests of merged external segments reference spans which are
+	// not recorded.
+	SegmentAggregation struct {
+		Custom    bool
+		Datastore bool
+		External  bool
+		Message   bool
 	}
 
 	// Config Settings for Logs in Context features
@@ -349,22 +575,32 @@ type Config struct {
 	// Events, and Browser timing header.
 	Attributes AttributeDestinationConfig
 
+	// HTTPHeaderAttributes lists the HTTP headers captured as agent
+	// attributes.
+	HTTPHeaderAttributes HTTPHeaderAttributeConfig
+
 	// RuntimeSampler controls the collection of runtime statistics like
-	// CPU/Memory usage, goroutine count, and GC pauses.
+	// CPU/Memory usage, goroutine count, and GC pauses.  The statistics
+	// are read from the runtime/metrics package once a minute, and also
+	// include the GC pause and scheduler latency percentiles, the memory
+	// classes of the runtime, GOMAXPROCS, the proximity to GOMEMLIMIT,
+	// mutex wait time, and the CPU throttling of the process' cgroup when
+	// a CPU limit is set.
 	RuntimeSampler struct {
 		// Enabled controls whether runtime statistics are captured.
 		Enabled bool
 	}
 
 	// ServerlessMode contains fields which control behavior when running in
-	// AWS Lambda.
+	// AWS Lambda, Google Cloud Functions, Cloud Run or Azure Functions.
 	//
 	// https://docs.newrelic.com/docs/serverless-function-monitoring/aws-lambda-monitoring/get-started/introduction-new-relic-monitoring-aws-lambda
 	ServerlessMode struct {
 		// Enabling ServerlessMode will print each transaction's data to
 		// stdout.  No agent goroutines will be spawned in serverless mode, and
 		// no data will be sent directly to the New Relic backend.
-		// nrlambda.NewConfig sets Enabled to true.
+		// nrlambda.NewConfig, nrgcpfunctions.ConfigOption and
+		// nrazurefunctions.ConfigOption set Enabled to true.
 		Enabled bool
 		// ApdexThreshold sets the Apdex threshold when in ServerlessMode.  The
 		// default is 500 milliseconds.  nrlambda.NewConfig populates this
@@ -610,6 +846,57 @@ type AttributeDestinationConfig struct {
 	Exclude []string
 }
 
+// HTTPHeaderAttributeConfig lists the HTTP headers captured as agent
+// attributes, such as the headers identifying a tenant or a feature flag.
+// Header names are case insensitive.  A request header X-Tenant-Id is
+// captured as the request.headers.x-tenant-id attribute, and a response
+// header as a response.headers.<name> attribute, where multiple values are
+// joined with commas.  These attributes go to the same destinations as the
+// other request attributes, and can be excluded from any of them with the
+// Exclude settings.  Headers are not captured in high security mode, nor when
+// the attributes_include security policy is disabled.
+//
+//	cfg.HTTPHeaderAttributes.Request = []string{"X-Tenant-Id", "X-Feature-Flags"}
+type HTTPHeaderAttributeConfig struct {
+	// Request and Response list the headers of the request and of the
+	// response of a web transaction, which are captured as agent
+	// attributes of the transaction.
+	Request  []string
+	Response []string
+	// ExternalRequest and ExternalResponse list the headers of the
+	// request and of the response of an external segment, which are
+	// captured as attributes of its span and transaction trace segment.
+	ExternalRequest  []string
+	ExternalResponse []string
+}
+
+// DestinationConfig is an account which the application reports to in
+// addition to its own.  See Config.Destinations.
+type DestinationConfig struct {
+	// AppName is the name of the application in the account.  It may list
+	// rollup names like Config.AppName.  If empty, Config.AppName is used.
+	AppName string
+	// License is the license key of the account.
+	License string
+	// Host is the collector host of the account.  If empty, it is derived
+	// from the region of License.
+	Host string
+	// HighSecurity must match the high security mode setting of the
+	// account.  The data sent to the destination is scrubbed as in high
+	// security mode when either this or Config.HighSecurity is set.
+	HighSecurity bool
+	// SecurityPoliciesToken enables the security policies of the account.
+	SecurityPoliciesToken string
+	// Labels replace Config.Labels for the destination, if not nil.
+	Labels map[string]string
+	// Attributes lists the attributes included and excluded for the
+	// destination, in addition to those of Config.Attributes.
+	Attributes struct {
+		Include []string
+		Exclude []string
+	}
+}
+
 // defaultConfig creates a Config populated with default settings.
 func defaultConfig() Config {
 	c := Config{}
@@ -670,6 +957,41 @@ func defaultConfig() Config {
 	c.DatastoreTracer.QueryParameters.Enabled = true
 	c.DatastoreTracer.SlowQuery.Enabled = true
 	c.DatastoreTracer.SlowQuery.Threshold = 10 * time.Millisecond
+	c.DatastoreTracer.SlowQuery.ExplainPlan.Enabled = false
+	c.DatastoreTracer.SlowQuery.ExplainPlan.MaxPerHarvest = 10
+	c.RepeatedCalls.Enabled = false
+	c.RepeatedCalls.Threshold = 10
+	c.LeakedTransactions.Enabled = false
+	c.LeakedTransactions.MaxAge = 10 * time.Minute
+	c.LeakedTransactions.ForceEnd = false
+	c.ExternalTimings.Enabled = false
+	c.ExternalTimings.Segments = false
+	c.Diagnostics.ListenAddress = ""
+	c.Harvest.MaxConcurrentRequests = 4
+	c.Harvest.RequestTimeout = collectorTimeout
+	c.Harvest.Timeout = 30 * time.Second
+	c.AgentControl.Enabled = false
+	c.AgentControl.Health.DeliveryLocation = "file:///newrelic/apm/health"
+	c.AgentControl.Health.Frequency = 5 * time.Second
+	c.ThreadProfiler.Enabled = true
+	c.ContinuousProfiler.Enabled = false
+	c.ContinuousProfiler.Period = 60 * time.Second
+	c.ContinuousProfiler.CPU.Enabled = true
+	c.ContinuousProfiler.CPU.Duration = 10 * time.Second
+	c.ContinuousProfiler.Heap.Enabled = true
+	c.ContinuousProfiler.Mutex.Enabled = false
+	c.ContinuousProfiler.Mutex.Fraction = 10
+	c.ContinuousProfiler.Block.Enabled = false
+	c.ContinuousProfiler.Block.Rate = 10000
+	c.ContinuousProfiler.Directory = ""
+	c.ContinuousProfiler.URL = ""
+	c.ConfigFile.Path = ""
+	c.ConfigFile.Period = 10 * time.Second
+	c.ConfigFile.ReloadOnSIGHUP = false
+	c.SegmentAggregation.Custom = false
+	c.SegmentAggregation.Datastore = false
+	c.SegmentAggregation.External = false
+	c.SegmentAggregation.Message = false
 	c.DatastoreTracer.RawQuery.Enabled = false
 
 	c.ServerlessMode.ApdexThreshold = 500 * time.Millisecond
@@ -708,6 +1030,7 @@ var (
 	errAppNameLimit                     = fmt.Errorf("max of %d rollup application names", appNameLimit)
 	errHighSecurityWithSecurityPolicies = errors.New("SecurityPoliciesToken and HighSecurity are incompatible; please ensure HighSecurity is set to false if SecurityPoliciesToken is a non-empty string and a security policy has been set for your account")
 	errInfTracingServerless             = errors.New("ServerlessMode cannot be used with Infinite Tracing")
+	errDestinationsServerless           = errors.New("ServerlessMode cannot be used with Destinations")
 )
 
 // validate checks the config for improper fields.  If the config is invalid,
@@ -735,10 +1058,31 @@ func (c Config) validate() error {
 	if c.InfiniteTracing.TraceObserver.Host != "" && c.ServerlessMode.Enabled {
 		return errInfTracingServerless
 	}
+	if len(c.Destinations) > 0 && c.ServerlessMode.Enabled {
+		return errDestinationsServerless
+	}
+	for i, d := range c.Destinations {
+		if err := c.validateDestination(d); nil != err {
+			return fmt.Errorf("destination %d: %w", i, err)
+		}
+	}
 
 	return nil
 }
 
+func (c Config) validateDestination(d DestinationConfig) error {
+	if len(d.License) != licenseLength {
+		return errLicenseLen
+	}
+	if (c.HighSecurity || d.HighSecurity) && d.SecurityPoliciesToken != "" {
+		return errHighSecurityWithSecurityPolicies
+	}
+	if strings.Count(d.AppName, ";") >= appNameLimit {
+		return errAppNameLimit
+	}
+	return nil
+}
+
 func (c Config) validateTraceObserverConfig() (*observerURL, error) {
 	configHost := c.InfiniteTracing.TraceObserver.Host
 	if configHost == "" {
@@ -802,6 +1146,16 @@ func copyDestConfig(c AttributeDestinationConfig) AttributeDestinationConfig {
 	return cp
 }
 
+func copyHTTPHeaderConfig(c HTTPHeaderAttributeConfig) HTTPHeaderAttributeConfig {
+	cp := c
+	for _, headers := range []*[]string{&cp.Request, &cp.Response, &cp.ExternalRequest, &cp.ExternalResponse} {
+		if nil != *headers {
+			*headers = append([]string(nil), *headers...)
+		}
+	}
+	return cp
+}
+
 func copyConfigReferenceFields(cfg Config) Config {
 	cp := cfg
 	if nil != cfg.Labels {
@@ -815,8 +1169,14 @@ func copyConfigReferenceFields(cfg Config) Config {
 		copy(ignored, cfg.ErrorCollector.IgnoreStatusCodes)
 		cp.ErrorCollector.IgnoreStatusCodes = ignored
 	}
+	if cfg.ErrorCollector.ExpectStatusCodes != nil {
+		expected := make([]int, len(cfg.ErrorCollector.ExpectStatusCodes))
+		copy(expected, cfg.ErrorCollector.ExpectStatusCodes)
+		cp.ErrorCollector.ExpectStatusCodes = expected
+	}
 
 	cp.Attributes = copyDestConfig(cfg.Attributes)
+	cp.HTTPHeaderAttributes = copyHTTPHeaderConfig(cfg.HTTPHeaderAttributes)
 	cp.ErrorCollector.Attributes = copyDestConfig(cfg.ErrorCollector.Attributes)
 	cp.TransactionEvents.Attributes = copyDestConfig(cfg.TransactionEvents.Attributes)
 	cp.TransactionTracer.Attributes = copyDestConfig(cfg.TransactionTracer.Attributes)
@@ -870,6 +1230,8 @@ func (s settings) MarshalJSON() ([]byte, error) {
 	// The License field is not simply ignored by adding the `json:"-"` tag
 	// to it since we want to allow consumers to populate Config from JSON.
 	delete(fields, `License`)
+	// The destinations contain the license keys of other accounts.
+	delete(fields, `Destinations`)
 	fields[`Transport`] = transportSetting(transport)
 	fields[`Logger`] = loggerSetting(l)
 
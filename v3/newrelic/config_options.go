@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -83,6 +84,14 @@ func ConfigDatastoreRawQuery(enabled bool) ConfigOption {
 	}
 }
 
+// ConfigDatastoreExplainPlan turns on or off the capture of the execution
+// plans of slow queries made with instrumented database/sql drivers.
+func ConfigDatastoreExplainPlan(enabled bool) ConfigOption {
+	return func(cfg *Config) {
+		cfg.DatastoreTracer.SlowQuery.ExplainPlan.Enabled = enabled
+	}
+}
+
 // ConfigCodeLevelMetricsIgnoredPrefix alters the way the Code Level Metrics
 // collection code searches for the right function to report for a given
 // telemetry trace. It will find the innermost function whose name does NOT
@@ -388,6 +397,9 @@ func ConfigDebugLogger(w io.Writer) ConfigOption {
 //		NEW_RELIC_AI_MONITORING_ENABLED								sets AIMonitoring.Enabled
 //		NEW_RELIC_AI_MONITORING_STREAMING_ENABLED					sets AIMonitoring.Streaming.Enabled
 //		NEW_RELIC_AI_MONITORING_RECORD_CONTENT_ENABLED				sets AIMonitoring.RecordContent.Enabled
+//		NEW_RELIC_AGENT_CONTROL_ENABLED								sets AgentControl.Enabled
+//		NEW_RELIC_AGENT_CONTROL_HEALTH_DELIVERY_LOCATION			sets AgentControl.Health.DeliveryLocation
+//		NEW_RELIC_AGENT_CONTROL_HEALTH_FREQUENCY					sets AgentControl.Health.Frequency in seconds using strconv.Atoi
 //
 // This function is strict and will assign Config.Error if any of the
 // environment variables cannot be parsed.
@@ -454,6 +466,15 @@ func configFromEnvironment(getenv func(string) string) ConfigOption {
 		assignBool(&cfg.AIMonitoring.Enabled, "NEW_RELIC_AI_MONITORING_ENABLED")
 		assignBool(&cfg.AIMonitoring.Streaming.Enabled, "NEW_RELIC_AI_MONITORING_STREAMING_ENABLED")
 		assignBool(&cfg.AIMonitoring.RecordContent.Enabled, "NEW_RELIC_AI_MONITORING_RECORD_CONTENT_ENABLED")
+		assignBool(&cfg.AgentControl.Enabled, "NEW_RELIC_AGENT_CONTROL_ENABLED")
+		assignString(&cfg.AgentControl.Health.DeliveryLocation, "NEW_RELIC_AGENT_CONTROL_HEALTH_DELIVERY_LOCATION")
+		if env := getenv("NEW_RELIC_AGENT_CONTROL_HEALTH_FREQUENCY"); env != "" {
+			if seconds, err := strconv.Atoi(env); nil != err || seconds <= 0 {
+				cfg.Error = fmt.Errorf("invalid NEW_RELIC_AGENT_CONTROL_HEALTH_FREQUENCY value: %s", env)
+			} else {
+				cfg.AgentControl.Health.Frequency = time.Duration(seconds) * time.Second
+			}
+		}
 
 		if env := getenv("NEW_RELIC_LABELS"); env != "" {
 			if labels := getLabels(getenv("NEW_RELIC_LABELS")); len(labels) > 0 {
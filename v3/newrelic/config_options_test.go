@@ -6,6 +6,7 @@ package newrelic
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestConfigFromEnvironment(t *testing.T) {
@@ -173,6 +174,35 @@ func TestConfigFromEnvironmentAttributes(t *testing.T) {
 	}
 }
 
+func TestConfigFromEnvironmentAgentControl(t *testing.T) {
+	cfgOpt := configFromEnvironment(func(s string) string {
+		switch s {
+		case "NEW_RELIC_AGENT_CONTROL_ENABLED":
+			return "true"
+		case "NEW_RELIC_AGENT_CONTROL_HEALTH_DELIVERY_LOCATION":
+			return "file:///tmp/health"
+		case "NEW_RELIC_AGENT_CONTROL_HEALTH_FREQUENCY":
+			return "10"
+		default:
+			return ""
+		}
+	})
+	cfg := defaultConfig()
+	cfgOpt(&cfg)
+	if cfg.Error != nil {
+		t.Fatal(cfg.Error)
+	}
+	if !cfg.AgentControl.Enabled {
+		t.Error("incorrect config value:", cfg.AgentControl.Enabled)
+	}
+	if cfg.AgentControl.Health.DeliveryLocation != "file:///tmp/health" {
+		t.Error("incorrect config value:", cfg.AgentControl.Health.DeliveryLocation)
+	}
+	if cfg.AgentControl.Health.Frequency != 10*time.Second {
+		t.Error("incorrect config value:", cfg.AgentControl.Health.Frequency)
+	}
+}
+
 func TestConfigFromEnvironmentInvalidBool(t *testing.T) {
 	cfgOpt := configFromEnvironment(func(s string) string {
 		switch s {
@@ -0,0 +1,249 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var errUpdateConfigShutdown = errors.New("application shut down")
+
+// configUpdate is sent to the processor to apply the configuration changed by
+// UpdateConfig.  The processor closes done once the configuration is applied.
+type configUpdate struct {
+	config    config
+	reconnect bool
+	done      chan struct{}
+}
+
+// copyReloadableSettings copies the settings which can change at runtime
+// from src to dst.
+func copyReloadableSettings(dst *Config, src Config) {
+	dst.Attributes = src.Attributes
+	dst.HTTPHeaderAttributes = src.HTTPHeaderAttributes
+	dst.TransactionEvents.Attributes = src.TransactionEvents.Attributes
+	dst.ErrorCollector.Attributes = src.ErrorCollector.Attributes
+	dst.TransactionTracer.Attributes = src.TransactionTracer.Attributes
+	dst.TransactionTracer.Segments.Attributes = src.TransactionTracer.Segments.Attributes
+	dst.BrowserMonitoring.Attributes = src.BrowserMonitoring.Attributes
+	dst.SpanEvents.Attributes = src.SpanEvents.Attributes
+
+	dst.ApplicationLogging = src.ApplicationLogging
+
+	dst.TransactionTracer.Threshold = src.TransactionTracer.Threshold
+	dst.TransactionTracer.Segments.Threshold = src.TransactionTracer.Segments.Threshold
+	dst.TransactionTracer.Segments.StackTraceThreshold = src.TransactionTracer.Segments.StackTraceThreshold
+
+	dst.ErrorCollector.IgnoreStatusCodes = src.ErrorCollector.IgnoreStatusCodes
+	dst.ErrorCollector.ExpectStatusCodes = src.ErrorCollector.ExpectStatusCodes
+
+	dst.Labels = src.Labels
+}
+
+// reconnectRequired reports whether the settings changed are sent to New
+// Relic when connecting.
+func reconnectRequired(old, new Config) bool {
+	if old.ApplicationLogging.Forwarding.MaxSamplesStored != new.ApplicationLogging.Forwarding.MaxSamplesStored {
+		return true
+	}
+	if len(old.Labels) != len(new.Labels) {
+		return true
+	}
+	for key, val := range old.Labels {
+		if v, ok := new.Labels[key]; !ok || v != val {
+			return true
+		}
+	}
+	return false
+}
+
+// currentConfig returns the configuration of the application, including the
+// changes made by UpdateConfig.
+func (app *app) currentConfig() config {
+	app.RLock()
+	defer app.RUnlock()
+
+	if nil != app.updatedConfig {
+		return *app.updatedConfig
+	}
+	return app.config
+}
+
+// setConfig records the configuration changed by UpdateConfig and replaces
+// the runs which are not owned by the processor.
+func (app *app) setConfig(c config) {
+	app.Lock()
+	defer app.Unlock()
+
+	app.updatedConfig = &c
+	app.placeholderRun = newAppRun(c, app.placeholderRun.Reply)
+	if app.config.ServerlessMode.Enabled && nil != app.run {
+		app.run = newAppRun(c, app.run.Reply)
+	}
+}
+
+// reloadRun returns the run of the connection replaced to apply the
+// configuration changed by UpdateConfig.
+func reloadRun(run *appRun, c config) *appRun {
+	reloaded := newAppRun(c, run.Reply)
+	reloaded.adaptiveSampler = run.adaptiveSampler
+	reloaded.harvestConfig.CommonAttributes = run.harvestConfig.CommonAttributes
+	return reloaded
+}
+
+// reconcileRun applies the configuration changed by UpdateConfig while the
+// run was connecting, since the configuration used to connect is read when
+// the connection starts.  configFor returns the configuration of the run from
+// the configuration of the application.  It returns nil if settings sent to
+// New Relic when connecting have changed, in which case the run must connect
+// again.
+func (app *app) reconcileRun(run *appRun, configFor func(config) config) *appRun {
+	app.RLock()
+	updated := app.updatedConfig
+	app.RUnlock()
+
+	if nil == updated {
+		return run
+	}
+	c := configFor(*updated)
+	if reconnectRequired(run.Config.Config, c.Config) {
+		return nil
+	}
+	return reloadRun(run, c)
+}
+
+// UpdateConfig implements newrelic.Application's UpdateConfig.
+func (app *app) UpdateConfig(opts ...ConfigOption) error {
+	// The updates are serialized so that none is lost.
+	app.updateConfigLock.Lock()
+	defer app.updateConfigLock.Unlock()
+
+	current := app.currentConfig()
+	// The options are applied to a copy to leave the slices and maps of the
+	// current configuration unchanged.
+	c := copyConfigReferenceFields(current.Config)
+	for _, fn := range opts {
+		if nil != fn {
+			fn(&c)
+			if nil != c.Error {
+				return c.Error
+			}
+		}
+	}
+	updated := current
+	copyReloadableSettings(&updated.Config, copyConfigReferenceFields(c))
+	if err := updated.Config.validate(); nil != err {
+		return err
+	}
+	reconnect := reconnectRequired(current.Config, updated.Config)
+
+	if !app.config.Enabled || app.config.ServerlessMode.Enabled {
+		// There is no processor.
+		app.setConfig(updated)
+	} else {
+		update := configUpdate{
+			config:    updated,
+			reconnect: reconnect,
+			done:      make(chan struct{}),
+		}
+		select {
+		case app.configChan <- update:
+		case <-app.shutdownStarted:
+			return errUpdateConfigShutdown
+		}
+		<-update.done
+	}
+
+	app.Info("configuration updated", map[string]interface{}{
+		"reconnect": reconnect,
+	})
+	return nil
+}
+
+// configFromJSON returns a ConfigOption setting the fields of the JSON object
+// given.
+func configFromJSON(data []byte) ConfigOption {
+	return func(cfg *Config) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); nil != err {
+			cfg.Error = err
+			return
+		}
+		if _, ok := fields["Labels"]; ok {
+			// Unmarshal adds to the existing map.
+			cfg.Labels = nil
+		}
+		if err := json.Unmarshal(data, cfg); nil != err {
+			cfg.Error = err
+		}
+	}
+}
+
+// reloadConfigFile applies Config.ConfigFile if its modification time is not
+// modTime, or if force is set, and updates modTime.
+func (app *app) reloadConfigFile(modTime *time.Time, force bool) {
+	path := app.config.ConfigFile.Path
+	info, err := os.Stat(path)
+	if nil != err {
+		app.Warn("unable to read config file", map[string]interface{}{
+			"path":  path,
+			"error": err.Error(),
+		})
+		return
+	}
+	if !force && info.ModTime().Equal(*modTime) {
+		return
+	}
+	*modTime = info.ModTime()
+	data, err := os.ReadFile(path)
+	if nil == err {
+		err = app.UpdateConfig(configFromJSON(data))
+	}
+	if nil != err {
+		app.Warn("unable to apply config file", map[string]interface{}{
+			"path":  path,
+			"error": err.Error(),
+		})
+		return
+	}
+	app.Info("config file applied", map[string]interface{}{
+		"path": path,
+	})
+}
+
+// watchConfigFile applies Config.ConfigFile at once, then whenever it changes
+// or the process receives SIGHUP, until done is closed.
+func (app *app) watchConfigFile(done <-chan struct{}) {
+	cfg := app.config.ConfigFile
+	var ticks <-chan time.Time
+	if cfg.Period > 0 {
+		ticker := time.NewTicker(cfg.Period)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	var hangups chan os.Signal
+	if cfg.ReloadOnSIGHUP {
+		hangups = make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		defer signal.Stop(hangups)
+	}
+
+	var modTime time.Time
+	app.reloadConfigFile(&modTime, true)
+	for {
+		select {
+		case <-ticks:
+			app.reloadConfigFile(&modTime, false)
+		case <-hangups:
+			app.reloadConfigFile(&modTime, true)
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic/fakecollector"
+)
+
+func TestUpdateConfigAttributes(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("before")
+	txn.AddAttribute("zip", "zap")
+	txn.End()
+
+	if err := app.UpdateConfig(func(cfg *Config) {
+		cfg.TransactionEvents.Attributes.Exclude = []string{"zip"}
+	}); nil != err {
+		t.Fatal(err)
+	}
+	txn = app.StartTransaction("after")
+	txn.AddAttribute("zip", "zap")
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{
+		{
+			Intrinsics:     map[string]interface{}{"name": "OtherTransaction/Go/before", "guid": internal.MatchAnything, "traceId": internal.MatchAnything, "priority": internal.MatchAnything, "sampled": internal.MatchAnything},
+			UserAttributes: map[string]interface{}{"zip": "zap"},
+		},
+		{
+			Intrinsics:     map[string]interface{}{"name": "OtherTransaction/Go/after", "guid": internal.MatchAnything, "traceId": internal.MatchAnything, "priority": internal.MatchAnything, "sampled": internal.MatchAnything},
+			UserAttributes: map[string]interface{}{},
+		},
+	})
+}
+
+func TestUpdateConfigErrorCodes(t *testing.T) {
+	app := testApp(nil, nil, t)
+	if err := app.UpdateConfig(func(cfg *Config) {
+		cfg.ErrorCollector.IgnoreStatusCodes = append(cfg.ErrorCollector.IgnoreStatusCodes, 503)
+	}); nil != err {
+		t.Fatal(err)
+	}
+	run, _ := app.Application.app.getState()
+	if run.responseCodeIsError(503) || !run.responseCodeIsError(500) {
+		t.Error(run.Config.ErrorCollector.IgnoreStatusCodes)
+	}
+	// The option changed a copy of the codes.
+	cfg, _ := app.Config()
+	if codes := defaultConfig().ErrorCollector.IgnoreStatusCodes; len(cfg.ErrorCollector.IgnoreStatusCodes) != len(codes)+1 {
+		t.Error(cfg.ErrorCollector.IgnoreStatusCodes)
+	}
+}
+
+func TestUpdateConfigIgnoredSettings(t *testing.T) {
+	app := testApp(nil, nil, t)
+	if err := app.UpdateConfig(
+		ConfigAppName("other app"),
+		func(cfg *Config) {
+			cfg.Labels = map[string]string{"zip": "zap"}
+			cfg.TransactionTracer.Threshold.IsApdexFailing = false
+			cfg.TransactionTracer.Threshold.Duration = time.Second
+		},
+	); nil != err {
+		t.Fatal(err)
+	}
+	cfg, _ := app.Config()
+	if cfg.AppName != "my app" {
+		t.Error(cfg.AppName)
+	}
+	if cfg.Labels["zip"] != "zap" || cfg.TransactionTracer.Threshold.Duration != time.Second {
+		t.Error(cfg.Labels, cfg.TransactionTracer.Threshold)
+	}
+}
+
+func TestUpdateConfigError(t *testing.T) {
+	app := testApp(nil, nil, t)
+	errOption := errors.New("bad option")
+	err := app.UpdateConfig(func(cfg *Config) {
+		cfg.Labels = map[string]string{"zip": "zap"}
+	}, func(cfg *Config) {
+		cfg.Error = errOption
+	})
+	if err != errOption {
+		t.Error(err)
+	}
+	if cfg, _ := app.Config(); len(cfg.Labels) != 0 {
+		t.Error(cfg.Labels)
+	}
+	var nilApp *Application
+	if err := nilApp.UpdateConfig(); nil != err {
+		t.Error(err)
+	}
+}
+
+func TestReconnectRequired(t *testing.T) {
+	cfg := defaultConfig()
+	if reconnectRequired(cfg, cfg) {
+		t.Error("reconnect without changes")
+	}
+	labeled := copyConfigReferenceFields(cfg)
+	labeled.Labels["zip"] = "zap"
+	if !reconnectRequired(cfg, labeled) {
+		t.Error("labels changed")
+	}
+	limited := cfg
+	limited.ApplicationLogging.Forwarding.MaxSamplesStored = 10
+	if !reconnectRequired(cfg, limited) {
+		t.Error("log limit changed")
+	}
+	toggled := cfg
+	toggled.ApplicationLogging.Forwarding.Enabled = !cfg.ApplicationLogging.Forwarding.Enabled
+	if reconnectRequired(cfg, toggled) {
+		t.Error("log forwarding toggled")
+	}
+}
+
+func TestUpdateConfigLogForwarding(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv, ConfigAppLogEnabled(false))
+	if err := app.app.RecordLog(&LogData{Message: "dropped"}); err != errAppLoggingDisabled {
+		t.Error(err)
+	}
+
+	if err := app.UpdateConfig(ConfigAppLogForwardingEnabled(true)); nil != err {
+		t.Fatal(err)
+	}
+	if err := app.app.RecordLog(&LogData{Message: "hello"}); nil != err {
+		t.Fatal(err)
+	}
+	requests := flushUntil(t, app, srv, fakecollector.MethodLogEvents)
+	if body := string(requests[0].Body); !strings.Contains(body, "hello") || strings.Contains(body, "dropped") {
+		t.Error(body)
+	}
+	if connects := srv.RequestsFor(fakecollector.MethodConnect); len(connects) != 1 {
+		t.Error("reconnected", len(connects))
+	}
+}
+
+func TestUpdateConfigLabelsReconnect(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	app.RecordCustomEvent("before", nil)
+
+	if err := app.UpdateConfig(func(cfg *Config) {
+		cfg.Labels = map[string]string{"zip": "zap"}
+	}); nil != err {
+		t.Fatal(err)
+	}
+	var connects []fakecollector.Request
+	for i := 0; i < 100 && len(connects) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		connects = srv.RequestsFor(fakecollector.MethodConnect)
+	}
+	if len(connects) != 2 {
+		t.Fatal(connects)
+	}
+	if body := string(connects[1].Body); !strings.Contains(body, `"labels":[{"label_type":"zip","label_value":"zap"}]`) {
+		t.Error(body)
+	}
+	// The data recorded before the update is harvested with the previous
+	// run.
+	events := srv.RequestsFor(fakecollector.MethodCustomEvents)
+	if len(events) != 1 || events[0].RunID != "fakecollector-run-1" {
+		t.Error(events)
+	}
+	if err := app.WaitForConnection(10 * time.Second); nil != err {
+		t.Fatal(err)
+	}
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	metrics := srv.RequestsFor(fakecollector.MethodMetrics)
+	if last := metrics[len(metrics)-1]; last.RunID != "fakecollector-run-2" {
+		t.Error(last.RunID)
+	}
+}
+
+func TestUpdateConfigLabelsRunOver(t *testing.T) {
+	testcases := []struct {
+		code      int
+		connects  int
+		connected bool
+	}{
+		// The application is disconnected, and does not connect again.
+		{code: 410, connects: 1, connected: false},
+		// The application connects once, following the restart.
+		{code: 409, connects: 2, connected: true},
+	}
+	for _, tc := range testcases {
+		srv := fakecollector.NewServer()
+		defer srv.Close()
+		app := newFakeCollectorTestApp(t, srv)
+		srv.Respond(fakecollector.MethodMetrics, fakecollector.Response{StatusCode: tc.code})
+		if err := app.UpdateConfig(func(cfg *Config) {
+			cfg.Labels = map[string]string{"zip": "zap"}
+		}); nil != err {
+			t.Fatal(err)
+		}
+		over := func() bool {
+			run, err := app.app.getState()
+			if tc.connected {
+				return run.Reply.RunID == "fakecollector-run-2"
+			}
+			return nil != err
+		}
+		for i := 0; i < 100 && !over(); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		// Leave time for the connects which should not happen.
+		time.Sleep(100 * time.Millisecond)
+		if connects := srv.RequestsFor(fakecollector.MethodConnect); len(connects) != tc.connects {
+			t.Errorf("%d: %d connects, want %d", tc.code, len(connects), tc.connects)
+		}
+		run, err := app.app.getState()
+		// The placeholder run is returned when the application is not
+		// connected.
+		if connected := "" != run.Reply.RunID; connected != tc.connected || tc.connected == (nil != err) {
+			t.Errorf("%d: run=%q err=%v", tc.code, run.Reply.RunID, err)
+		}
+	}
+}
+
+// newConnectingTestApp returns an application which is connecting to a fake
+// collector which does not answer the first connect until release is closed.
+func newConnectingTestApp(t *testing.T) (app *Application, srv *fakecollector.Server, release chan struct{}) {
+	connecting := make(chan struct{})
+	release = make(chan struct{})
+	var once sync.Once
+	srv = newSlowFakeCollector(t, func(method string) {
+		if method == cmdConnect {
+			once.Do(func() {
+				close(connecting)
+				<-release
+			})
+		}
+	})
+	app, err := NewApplication(ConfigAppName(sampleAppName), ConfigLicense(testLicenseKey), fakeCollectorConfig(srv))
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Shutdown(10 * time.Second) })
+	<-connecting
+	return app, srv, release
+}
+
+func TestUpdateConfigWhileConnecting(t *testing.T) {
+	app, srv, release := newConnectingTestApp(t)
+	if err := app.UpdateConfig(func(cfg *Config) {
+		cfg.ErrorCollector.IgnoreStatusCodes = append(cfg.ErrorCollector.IgnoreStatusCodes, 503)
+	}); nil != err {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := app.WaitForConnection(10 * time.Second); nil != err {
+		t.Fatal(err)
+	}
+	run, _ := app.app.getState()
+	if run.responseCodeIsError(503) || !run.responseCodeIsError(500) {
+		t.Error(run.Config.ErrorCollector.IgnoreStatusCodes)
+	}
+	if connects := srv.RequestsFor(fakecollector.MethodConnect); len(connects) != 1 {
+		t.Error("reconnected", len(connects))
+	}
+}
+
+func TestUpdateConfigLabelsWhileConnecting(t *testing.T) {
+	app, srv, release := newConnectingTestApp(t)
+	if err := app.UpdateConfig(func(cfg *Config) {
+		cfg.Labels = map[string]string{"zip": "zap"}
+	}); nil != err {
+		t.Fatal(err)
+	}
+	close(release)
+	var connects []fakecollector.Request
+	for i := 0; i < 100 && len(connects) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		connects = srv.RequestsFor(fakecollector.MethodConnect)
+	}
+	if len(connects) != 2 {
+		t.Fatal(connects)
+	}
+	if body := string(connects[1].Body); !strings.Contains(body, `"labels":[{"label_type":"zip","label_value":"zap"}]`) {
+		t.Error(body)
+	}
+	if err := app.WaitForConnection(10 * time.Second); nil != err {
+		t.Fatal(err)
+	}
+	if run, _ := app.app.getState(); run.Config.Labels["zip"] != "zap" {
+		t.Error(run.Config.Labels)
+	}
+}
+
+func TestConfigFromJSON(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Labels = map[string]string{"zip": "zap"}
+	configFromJSON([]byte(`{
+		"Labels": {"foo": "bar"},
+		"ApplicationLogging": {"Forwarding": {"MaxSamplesStored": 42}},
+		"TransactionTracer": {"Threshold": {"Duration": 1000000000}}
+	}`))(&cfg)
+	if nil != cfg.Error {
+		t.Fatal(cfg.Error)
+	}
+	if len(cfg.Labels) != 1 || cfg.Labels["foo"] != "bar" {
+		t.Error(cfg.Labels)
+	}
+	if !cfg.ApplicationLogging.Enabled || cfg.ApplicationLogging.Forwarding.MaxSamplesStored != 42 {
+		t.Error(cfg.ApplicationLogging)
+	}
+	if cfg.TransactionTracer.Threshold.Duration != time.Second {
+		t.Error(cfg.TransactionTracer.Threshold)
+	}
+
+	cfg = defaultConfig()
+	configFromJSON([]byte(`{"Labels": 1}`))(&cfg)
+	if nil == cfg.Error {
+		t.Error("error expected")
+	}
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "newrelic.json")
+	app := testApp(nil, func(cfg *Config) {
+		cfg.ConfigFile.Path = path
+	}, t)
+	internalApp := app.Application.app
+
+	var modTime time.Time
+	// A missing file is not applied.
+	internalApp.reloadConfigFile(&modTime, true)
+	if !modTime.IsZero() {
+		t.Error(modTime)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"AppName": "ignored", "Labels": {"zip": "zap"}}`), 0644); nil != err {
+		t.Fatal(err)
+	}
+	internalApp.reloadConfigFile(&modTime, false)
+	cfg, _ := app.Config()
+	if cfg.Labels["zip"] != "zap" || cfg.AppName != "my app" || modTime.IsZero() {
+		t.Error(cfg.Labels, cfg.AppName, modTime)
+	}
+
+	// The file is applied again only if it changes, or if forced.
+	app.UpdateConfig(func(cfg *Config) { cfg.Labels = nil })
+	internalApp.reloadConfigFile(&modTime, false)
+	if cfg, _ := app.Config(); len(cfg.Labels) != 0 {
+		t.Error(cfg.Labels)
+	}
+	internalApp.reloadConfigFile(&modTime, true)
+	if cfg, _ := app.Config(); cfg.Labels["zip"] != "zap" {
+		t.Error(cfg.Labels)
+	}
+}
@@ -89,6 +89,10 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 	cfg.ErrorCollector.ExpectStatusCodes = append(cfg.ErrorCollector.ExpectStatusCodes, 500)
 	cfg.Attributes.Include = append(cfg.Attributes.Include, "1")
 	cfg.Attributes.Exclude = append(cfg.Attributes.Exclude, "2")
+	cfg.HTTPHeaderAttributes.Request = append(cfg.HTTPHeaderAttributes.Request, "15")
+	cfg.HTTPHeaderAttributes.Response = append(cfg.HTTPHeaderAttributes.Response, "16")
+	cfg.HTTPHeaderAttributes.ExternalRequest = append(cfg.HTTPHeaderAttributes.ExternalRequest, "17")
+	cfg.HTTPHeaderAttributes.ExternalResponse = append(cfg.HTTPHeaderAttributes.ExternalResponse, "18")
 	cfg.TransactionEvents.Attributes.Include = append(cfg.TransactionEvents.Attributes.Include, "3")
 	cfg.TransactionEvents.Attributes.Exclude = append(cfg.TransactionEvents.Attributes.Exclude, "4")
 	cfg.ErrorCollector.Attributes.Include = append(cfg.ErrorCollector.Attributes.Include, "5")
@@ -110,6 +114,10 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 	cfg.ErrorCollector.IgnoreStatusCodes[0] = 201
 	cfg.Attributes.Include[0] = "zap"
 	cfg.Attributes.Exclude[0] = "zap"
+	cfg.HTTPHeaderAttributes.Request[0] = "zap"
+	cfg.HTTPHeaderAttributes.Response[0] = "zap"
+	cfg.HTTPHeaderAttributes.ExternalRequest[0] = "zap"
+	cfg.HTTPHeaderAttributes.ExternalResponse[0] = "zap"
 	cfg.TransactionEvents.Attributes.Include[0] = "zap"
 	cfg.TransactionEvents.Attributes.Exclude[0] = "zap"
 	cfg.ErrorCollector.Attributes.Include[0] = "zap"
@@ -139,6 +147,7 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 					"Enabled": true
 				}
 			},
+			"AgentControl":{"Enabled":false,"Health":{"DeliveryLocation":"file:///newrelic/apm/health","Frequency":5000000000}},
 			"AppName":"my appname",
 			"ApplicationLogging": {
 				"Enabled": true,
@@ -156,9 +165,12 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 			"Attributes":{"Enabled":true,"Exclude":["2"],"Include":["1"]},
 			"BrowserMonitoring":{
 				"Attributes":{"Enabled":false,"Exclude":["10"],"Include":["9"]},
+				"AutoInstrument":false,
 				"Enabled":true
 			},
 			"CodeLevelMetrics":{"Enabled":true,"IgnoredPrefix":"","IgnoredPrefixes":null,"PathPrefix":"","PathPrefixes":null,"RedactIgnoredPrefixes":true,"RedactPathPrefixes":true,"Scope":"all"},
+			"ConfigFile":{"Path":"","Period":10000000000,"ReloadOnSIGHUP":false},
+			"ContinuousProfiler":{"Block":{"Enabled":false,"Rate":10000},"CPU":{"Duration":10000000000,"Enabled":true},"Directory":"","Enabled":false,"Heap":{"Enabled":true},"Mutex":{"Enabled":false,"Fraction":10},"Period":60000000000,"URL":""},
 			"CrossApplicationTracer":{"Enabled":false},
 			"CustomInsightsEvents":{
 				"Enabled":true,
@@ -171,9 +183,11 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 				"RawQuery":{"Enabled":false},
 				"SlowQuery":{
 					"Enabled":true,
+					"ExplainPlan":{"Enabled":false,"MaxPerHarvest":10},
 					"Threshold":10000000
 				}
 			},
+			"Diagnostics":{"ListenAddress":""},
 			"DistributedTracer":{"Enabled":true,"ExcludeNewRelicHeader":false,"ReservoirLimit":%d},
 			"Enabled":true,
 			"Error":null,
@@ -185,6 +199,13 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 				"IgnoreStatusCodes":[0,5,404,405],
 				"RecordPanics":false
 			},
+			"ExternalTimings":{"Enabled":false,"Segments":false},
+			"HTTPHeaderAttributes":{"ExternalRequest":["17"],"ExternalResponse":["18"],"Request":["15"],"Response":["16"]},
+			"Harvest":{
+				"MaxConcurrentRequests":4,
+				"RequestTimeout":20000000000,
+				"Timeout":30000000000
+			},
 			"Heroku":{
 				"DynoNamePrefixesToShorten":["scheduler","run"],
 				"UseDynoNames":true
@@ -200,10 +221,13 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
                 }
 			},
 			"Labels":{"zip":"zap"},
+			"LeakedTransactions":{"Enabled":false,"ForceEnd":false,"MaxAge":600000000000},
 			"Logger":"*logger.logFile",
 			"ModuleDependencyMetrics":{"Enabled":true,"IgnoredPrefixes":null,"RedactIgnoredPrefixes":true},
+			"RepeatedCalls":{"Enabled":false,"Threshold":10},
 			"RuntimeSampler":{"Enabled":true},
 			"SecurityPoliciesToken":"",
+			"SegmentAggregation":{"Custom":false,"Datastore":false,"External":false,"Message":false},
 			"ServerlessMode":{
 				"AccountID":"",
 				"ApdexThreshold":500000000,
@@ -217,6 +241,7 @@ func TestCopyConfigReferenceFieldsPresent(t *testing.T) {
 				},
 				"Enabled":true
 			},
+			"ThreadProfiler":{"Enabled":true},
 			"TransactionEvents":{
 				"Attributes":{"Enabled":true,"Exclude":["4"],"Include":["3"]},
 				"Enabled":true,
@@ -344,6 +369,7 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 					"Enabled": true
 				}
 			},
+			"AgentControl":{"Enabled":false,"Health":{"DeliveryLocation":"file:///newrelic/apm/health","Frequency":5000000000}},
 			"AppName":"my appname",
 			"ApplicationLogging": {
 				"Enabled": true,
@@ -365,9 +391,12 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 					"Exclude":null,
 					"Include":null
 				},
+				"AutoInstrument":false,
 				"Enabled":true
 			},
 			"CodeLevelMetrics":{"Enabled":true,"IgnoredPrefix":"","IgnoredPrefixes":null,"PathPrefix":"","PathPrefixes":null,"RedactIgnoredPrefixes":true,"RedactPathPrefixes":true,"Scope":"all"},
+			"ConfigFile":{"Path":"","Period":10000000000,"ReloadOnSIGHUP":false},
+			"ContinuousProfiler":{"Block":{"Enabled":false,"Rate":10000},"CPU":{"Duration":10000000000,"Enabled":true},"Directory":"","Enabled":false,"Heap":{"Enabled":true},"Mutex":{"Enabled":false,"Fraction":10},"Period":60000000000,"URL":""},
 			"CrossApplicationTracer":{"Enabled":false},
 			"CustomInsightsEvents":{
 				"Enabled":true,
@@ -380,9 +409,11 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 				"RawQuery":{"Enabled":false},
 				"SlowQuery":{
 					"Enabled":true,
+					"ExplainPlan":{"Enabled":false,"MaxPerHarvest":10},
 					"Threshold":10000000
 				}
 			},
+			"Diagnostics":{"ListenAddress":""},
 			"DistributedTracer":{"Enabled":true,"ExcludeNewRelicHeader":false,"ReservoirLimit":%d},
 			"Enabled":true,
 			"Error":null,
@@ -394,6 +425,13 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 				"IgnoreStatusCodes":null,
 				"RecordPanics":false
 			},
+			"ExternalTimings":{"Enabled":false,"Segments":false},
+			"HTTPHeaderAttributes":{"ExternalRequest":null,"ExternalResponse":null,"Request":null,"Response":null},
+			"Harvest":{
+				"MaxConcurrentRequests":4,
+				"RequestTimeout":20000000000,
+				"Timeout":30000000000
+			},
 			"Heroku":{
 				"DynoNamePrefixesToShorten":["scheduler","run"],
 				"UseDynoNames":true
@@ -409,10 +447,13 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
                 }
 			},
 			"Labels":null,
+			"LeakedTransactions":{"Enabled":false,"ForceEnd":false,"MaxAge":600000000000},
 			"Logger":null,
 			"ModuleDependencyMetrics":{"Enabled":true,"IgnoredPrefixes":null,"RedactIgnoredPrefixes":true},
+			"RepeatedCalls":{"Enabled":false,"Threshold":10},
 			"RuntimeSampler":{"Enabled":true},
 			"SecurityPoliciesToken":"",
+			"SegmentAggregation":{"Custom":false,"Datastore":false,"External":false,"Message":false},
 			"ServerlessMode":{
 				"AccountID":"",
 				"ApdexThreshold":500000000,
@@ -424,6 +465,7 @@ func TestCopyConfigReferenceFieldsAbsent(t *testing.T) {
 				"Attributes":{"Enabled":true,"Exclude":null,"Include":null},
 				"Enabled":true
 			},
+			"ThreadProfiler":{"Enabled":true},
 			"TransactionEvents":{
 				"Attributes":{"Enabled":true,"Exclude":null,"Include":null},
 				"Enabled":true,
@@ -0,0 +1,261 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// Labels of the goroutines running in a transaction while the continuous
+// profiler is enabled.
+const (
+	profilerLabelTransactionName = "transaction.name"
+	profilerLabelTraceID         = "trace.id"
+	profilerLabelSpanID          = "span.id"
+)
+
+// Types of the profiles captured, which are also the names of the
+// runtime/pprof profiles but for the CPU profile.
+const (
+	profileTypeCPU   = "cpu"
+	profileTypeHeap  = "heap"
+	profileTypeMutex = "mutex"
+	profileTypeBlock = "block"
+)
+
+// DoWithProfilerLabels calls f with the goroutine tagged with the pprof labels
+// of the Transaction: "transaction.name", "trace.id" and "span.id".  The
+// goroutines started by f inherit the labels.  The labels are only set while
+// the continuous profiler is enabled (see Config.ContinuousProfiler): f is
+// simply called with ctx otherwise.  WrapHandle, WrapHandleFunc and
+// WrapServeMux use DoWithProfilerLabels to serve requests.
+func (txn *Transaction) DoWithProfilerLabels(ctx context.Context, f func(ctx context.Context)) {
+	labels, ok := txn.profilerLabels(txn.Name())
+	if !ok {
+		f(ctx)
+		return
+	}
+	pprof.Do(ctx, labels, f)
+}
+
+// profilerLabels returns the labels of the goroutines running in the
+// transaction, and false if the continuous profiler is disabled.
+func (txn *Transaction) profilerLabels(name string) (pprof.LabelSet, bool) {
+	if nil == txn || nil == txn.thread || !txn.thread.Config.ContinuousProfiler.Enabled {
+		return pprof.LabelSet{}, false
+	}
+	labels := []string{profilerLabelTransactionName, name}
+	if md := txn.GetTraceMetadata(); "" != md.TraceID {
+		labels = append(labels, profilerLabelTraceID, md.TraceID, profilerLabelSpanID, md.SpanID)
+	}
+	return pprof.Labels(labels...), true
+}
+
+// serveWithProfilerLabels serves the request with the goroutine tagged with
+// the profiler labels of the transaction.  name is only called if the
+// continuous profiler is enabled.
+func serveWithProfilerLabels(txn *Transaction, name func() string, handler http.Handler, w http.ResponseWriter, r *http.Request) {
+	labels, ok := txn.profilerLabels(name())
+	if !ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	pprof.Do(r.Context(), labels, func(ctx context.Context) {
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// capturedProfile is a profile in the gzip-compressed protocol buffer format of
+// pprof.
+type capturedProfile struct {
+	profileType string
+	start       time.Time
+	end         time.Time
+	data        []byte
+}
+
+// profileSink exports the profiles captured.
+type profileSink interface {
+	export(p capturedProfile) error
+}
+
+// directoryProfileSink writes the profiles to a directory.
+type directoryProfileSink struct {
+	dir string
+}
+
+func (s directoryProfileSink) export(p capturedProfile) error {
+	name := fmt.Sprintf("%s-%d.pb.gz", p.profileType, p.start.UnixNano())
+	return os.WriteFile(filepath.Join(s.dir, name), p.data, 0644)
+}
+
+// httpProfileSink posts the profiles to an HTTP endpoint.
+type httpProfileSink struct {
+	url     string
+	appName string
+	client  *http.Client
+}
+
+func (s httpProfileSink) export(p capturedProfile) error {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(p.data))
+	if nil != err {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Profile-Type", p.profileType)
+	req.Header.Set("X-Profile-Start", p.start.UTC().Format(time.RFC3339Nano))
+	req.Header.Set("X-Profile-End", p.end.UTC().Format(time.RFC3339Nano))
+	req.Header.Set("X-Application-Name", s.appName)
+	resp, err := s.client.Do(req)
+	if nil != err {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("response code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// continuousProfiler captures the profiles periodically.
+type continuousProfiler struct {
+	config Config
+	sinks  []profileSink
+}
+
+// newContinuousProfiler returns the profiler of the configuration, or nil if
+// it is disabled or the profiles have nowhere to go.
+func newContinuousProfiler(c Config) *continuousProfiler {
+	cfg := c.ContinuousProfiler
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Period <= 0 {
+		c.ContinuousProfiler.Period = defaultConfig().ContinuousProfiler.Period
+	}
+	cp := &continuousProfiler{config: c}
+	if "" != cfg.Directory {
+		cp.sinks = append(cp.sinks, directoryProfileSink{dir: cfg.Directory})
+	}
+	if "" != cfg.URL {
+		transport := c.Transport
+		if nil == transport {
+			transport = collectorDefaultTransport
+		}
+		cp.sinks = append(cp.sinks, httpProfileSink{
+			url:     cfg.URL,
+			appName: c.AppName,
+			client: &http.Client{
+				Transport: transport,
+				Timeout:   collectorTimeout,
+			},
+		})
+	}
+	if 0 == len(cp.sinks) {
+		c.Logger.Warn("continuous profiler disabled: no directory or URL configured", nil)
+		return nil
+	}
+	return cp
+}
+
+// run captures the profiles every period until done is closed.
+func (cp *continuousProfiler) run(done <-chan struct{}) {
+	cfg := cp.config.ContinuousProfiler
+	if cfg.Mutex.Enabled {
+		previous := runtime.SetMutexProfileFraction(cfg.Mutex.Fraction)
+		defer runtime.SetMutexProfileFraction(previous)
+	}
+	if cfg.Block.Enabled {
+		runtime.SetBlockProfileRate(cfg.Block.Rate)
+		defer runtime.SetBlockProfileRate(0)
+	}
+
+	ticker := time.NewTicker(cfg.Period)
+	defer ticker.Stop()
+	for {
+		cp.capture(done)
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// capture captures and exports each type of profile enabled.
+func (cp *continuousProfiler) capture(done <-chan struct{}) {
+	cfg := cp.config.ContinuousProfiler
+	if cfg.CPU.Enabled {
+		var buf bytes.Buffer
+		start := time.Now()
+		if err := pprof.StartCPUProfile(&buf); nil != err {
+			// Another CPU profile is running.
+			cp.config.Logger.Debug("unable to start cpu profile", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			timer := time.NewTimer(cfg.CPU.Duration)
+			select {
+			case <-timer.C:
+			case <-done:
+				timer.Stop()
+			}
+			pprof.StopCPUProfile()
+			cp.export(capturedProfile{
+				profileType: profileTypeCPU,
+				start:       start,
+				end:         time.Now(),
+				data:        buf.Bytes(),
+			})
+		}
+	}
+
+	snapshots := []struct {
+		profileType string
+		enabled     bool
+	}{
+		{profileTypeHeap, cfg.Heap.Enabled},
+		{profileTypeMutex, cfg.Mutex.Enabled},
+		{profileTypeBlock, cfg.Block.Enabled},
+	}
+	for _, s := range snapshots {
+		if !s.enabled {
+			continue
+		}
+		var buf bytes.Buffer
+		now := time.Now()
+		if err := pprof.Lookup(s.profileType).WriteTo(&buf, 0); nil != err {
+			cp.config.Logger.Debug("unable to write profile", map[string]interface{}{
+				"type":  s.profileType,
+				"error": err.Error(),
+			})
+			continue
+		}
+		cp.export(capturedProfile{
+			profileType: s.profileType,
+			start:       now,
+			end:         now,
+			data:        buf.Bytes(),
+		})
+	}
+}
+
+func (cp *continuousProfiler) export(p capturedProfile) {
+	for _, sink := range cp.sinks {
+		if err := sink.export(p); nil != err {
+			cp.config.Logger.Warn("unable to export profile", map[string]interface{}{
+				"type":  p.profileType,
+				"error": err.Error(),
+			})
+		}
+	}
+}
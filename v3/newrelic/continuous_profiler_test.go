@@ -0,0 +1,173 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal/logger"
+)
+
+func enableContinuousProfiler(cfg *Config) {
+	cfg.ContinuousProfiler.Enabled = true
+}
+
+func TestDoWithProfilerLabels(t *testing.T) {
+	app := testApp(distributedTracingReplyFields, enableContinuousProfiler, t)
+	txn := app.StartTransaction("hello")
+	md := txn.GetTraceMetadata()
+	called := false
+	txn.DoWithProfilerLabels(context.Background(), func(ctx context.Context) {
+		called = true
+		if name, _ := pprof.Label(ctx, "transaction.name"); name != "hello" {
+			t.Error(name)
+		}
+		if id, _ := pprof.Label(ctx, "trace.id"); id == "" || id != md.TraceID {
+			t.Error(id, md.TraceID)
+		}
+		if id, _ := pprof.Label(ctx, "span.id"); id == "" || id != md.SpanID {
+			t.Error(id, md.SpanID)
+		}
+	})
+	txn.End()
+	if !called {
+		t.Error("function not called")
+	}
+}
+
+func TestDoWithProfilerLabelsDisabled(t *testing.T) {
+	app := testApp(nil, ConfigDistributedTracerEnabled(true), t)
+	for _, txn := range []*Transaction{app.StartTransaction("hello"), nil} {
+		called := false
+		txn.DoWithProfilerLabels(context.Background(), func(ctx context.Context) {
+			called = true
+			if name, ok := pprof.Label(ctx, "transaction.name"); ok {
+				t.Error(name)
+			}
+		})
+		if !called {
+			t.Error("function not called")
+		}
+	}
+}
+
+func TestWrapHandleProfilerLabels(t *testing.T) {
+	app := testApp(nil, enableContinuousProfiler, t)
+	_, h := WrapHandleFunc(app.Application, "/hello", func(w http.ResponseWriter, r *http.Request) {
+		if name, _ := pprof.Label(r.Context(), "transaction.name"); name != "GET /hello" {
+			t.Error(name)
+		}
+		if nil == FromContext(r.Context()) {
+			t.Error("transaction missing")
+		}
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/hello", nil))
+}
+
+func profilerConfig(cfgfn func(*Config)) Config {
+	cfg := defaultConfig()
+	cfg.Logger = logger.ShimLogger{}
+	cfg.AppName = "my app"
+	cfg.ContinuousProfiler.Enabled = true
+	cfg.ContinuousProfiler.CPU.Duration = 10 * time.Millisecond
+	cfgfn(&cfg)
+	return cfg
+}
+
+func TestContinuousProfilerDirectory(t *testing.T) {
+	dir := t.TempDir()
+	cp := newContinuousProfiler(profilerConfig(func(cfg *Config) {
+		cfg.ContinuousProfiler.Directory = dir
+		cfg.ContinuousProfiler.Mutex.Enabled = true
+		cfg.ContinuousProfiler.Block.Enabled = true
+	}))
+	if nil == cp {
+		t.Fatal("profiler not created")
+	}
+	cp.capture(make(chan struct{}))
+
+	for _, profileType := range []string{"cpu", "heap", "mutex", "block"} {
+		files, _ := filepath.Glob(filepath.Join(dir, profileType+"-*.pb.gz"))
+		if len(files) != 1 {
+			t.Error(profileType, files)
+			continue
+		}
+		data, err := os.ReadFile(files[0])
+		// Profiles are gzip compressed.
+		if nil != err || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+			t.Error(profileType, err, data)
+		}
+	}
+}
+
+func TestContinuousProfilerURL(t *testing.T) {
+	var lock sync.Mutex
+	received := map[string]http.Header{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if len(body) == 0 {
+			t.Error("empty profile")
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		received[r.Header.Get("X-Profile-Type")] = r.Header
+	}))
+	defer srv.Close()
+
+	cp := newContinuousProfiler(profilerConfig(func(cfg *Config) {
+		cfg.ContinuousProfiler.URL = srv.URL
+		cfg.ContinuousProfiler.Period = 10 * time.Millisecond
+	}))
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		cp.run(done)
+		close(stopped)
+	}()
+	for i := 0; i < 100; i++ {
+		lock.Lock()
+		n := len(received)
+		lock.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	<-stopped
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != 2 {
+		t.Fatal(received)
+	}
+	cpu := received["cpu"]
+	if cpu.Get("X-Application-Name") != "my app" || cpu.Get("Content-Type") != "application/octet-stream" {
+		t.Error(cpu)
+	}
+	start, err1 := time.Parse(time.RFC3339Nano, cpu.Get("X-Profile-Start"))
+	end, err2 := time.Parse(time.RFC3339Nano, cpu.Get("X-Profile-End"))
+	if nil != err1 || nil != err2 || end.Before(start) {
+		t.Error(cpu)
+	}
+}
+
+func TestContinuousProfilerDisabled(t *testing.T) {
+	if cp := newContinuousProfiler(defaultConfig()); nil != cp {
+		t.Error(cp)
+	}
+	// The profiles have nowhere to go.
+	if cp := newContinuousProfiler(profilerConfig(func(*Config) {})); nil != cp {
+		t.Error(cp)
+	}
+}
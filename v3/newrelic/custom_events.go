@@ -34,3 +34,11 @@ func (cs *customEvents) Data(agentRunID string, harvestStart time.Time) ([]byte,
 func (cs *customEvents) EndpointMethod() string {
 	return cmdCustomEvents
 }
+
+func (cs *customEvents) splitPayload() (payloadCreator, payloadCreator) {
+	if len(cs.events) < 2 {
+		return nil, nil
+	}
+	e1, e2 := cs.split()
+	return &customEvents{analyticsEvents: e1}, &customEvents{analyticsEvents: e2}
+}
@@ -0,0 +1,383 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"sync"
+	"time"
+)
+
+// destination is an account which the harvests of the application are also
+// sent to.  See Config.Destinations.
+type destination struct {
+	// index is the position of the destination in Config.Destinations.
+	index       int
+	settings    DestinationConfig
+	rpmControls rpmControls
+}
+
+func newDestinations(c Config, controls rpmControls) []*destination {
+	if 0 == len(c.Destinations) {
+		return nil
+	}
+	dests := make([]*destination, len(c.Destinations))
+	for i, dc := range c.Destinations {
+		d := &destination{
+			index:       i,
+			settings:    dc,
+			rpmControls: controls,
+		}
+		// The client and the gzip writers are shared.
+		d.rpmControls.License = dc.License
+		dests[i] = d
+	}
+	return dests
+}
+
+// configFor returns the configuration of the application used to connect and
+// report to the destination.
+func (d *destination) configFor(c config) config {
+	c.Config = copyConfigReferenceFields(c.Config)
+	c.License = d.settings.License
+	if "" != d.settings.AppName {
+		c.AppName = d.settings.AppName
+	}
+	c.Host = d.settings.Host
+	c.HighSecurity = c.HighSecurity || d.settings.HighSecurity
+	c.SecurityPoliciesToken = d.settings.SecurityPoliciesToken
+	if nil != d.settings.Labels {
+		c.Labels = d.settings.Labels
+	}
+	c.Attributes.Include = append(c.Attributes.Include, d.settings.Attributes.Include...)
+	c.Attributes.Exclude = append(c.Attributes.Exclude, d.settings.Attributes.Exclude...)
+	c.Destinations = nil
+	return c
+}
+
+// destinationRun tells the processor that a destination connected, in which
+// case run is set, or that the collector ended the run ended, with resp.
+type destinationRun struct {
+	dest  *destination
+	run   *appRun
+	ended *appRun
+	resp  *rpmResponse
+}
+
+func (app *app) sendDestinationRun(dr destinationRun) {
+	select {
+	case app.destinationChan <- dr:
+	case <-app.shutdownStarted:
+	}
+}
+
+// connectDestination connects the destination, retrying with the backoff of
+// connectRoutine, and sends the run to the processor.
+func (app *app) connectDestination(d *destination) {
+	attempts := 0
+	for {
+		cfg := d.configFor(app.currentConfig())
+		reply, resp := connectAttempt(cfg, d.rpmControls)
+
+		if reply != nil {
+			app.sendDestinationRun(destinationRun{dest: d, run: newAppRun(cfg, reply)})
+			return
+		}
+
+		if resp.IsDisconnect() {
+			app.Error("destination disconnected", map[string]interface{}{
+				"app": cfg.AppName,
+			})
+			return
+		}
+
+		if nil != resp.GetError() {
+			app.Warn("destination connect failure", map[string]interface{}{
+				"app":   cfg.AppName,
+				"error": resp.GetError().Error(),
+			})
+		}
+
+		backoff := time.NewTimer(time.Duration(getConnectBackoffTime(attempts)) * time.Second)
+		select {
+		case <-backoff.C:
+		case <-app.shutdownStarted:
+			backoff.Stop()
+			return
+		}
+		attempts++
+	}
+}
+
+// destinationConnected prepares the run of a destination which connected.
+func (app *app) destinationConnected(run *appRun) {
+	run.harvestConfig.CommonAttributes = commonAttributes{
+		hostname:   app.config.hostname,
+		entityName: run.firstAppName,
+		entityGUID: run.Reply.EntityGUID,
+	}
+	app.Info("destination connected", map[string]interface{}{
+		"app": run.Config.AppName,
+		"run": run.Reply.RunID.String(),
+	})
+	processConnectMessages(run, app)
+}
+
+// destinationRunOver handles the end of the run of a destination.  It reports
+// whether the run was the current run of the destination.
+func (app *app) destinationRunOver(dr destinationRun, current *appRun) bool {
+	if current != dr.ended {
+		// A previous run of the destination is over.
+		return false
+	}
+	if dr.resp.IsRestartException() {
+		app.Info("destination restarted", map[string]interface{}{
+			"app": current.Config.AppName,
+		})
+		go app.connectDestination(dr.dest)
+	} else {
+		app.Error("destination disconnected", map[string]interface{}{
+			"app": current.Config.AppName,
+		})
+	}
+	return true
+}
+
+// harvestDestinations posts a copy of the harvest to each destination
+// connected.  The copies are made before the harvest is posted, which adds to
+// its metrics.  The WaitGroup returned is done once every destination has been
+// posted to.
+func (app *app) harvestDestinations(ready *harvest, harvestStart time.Time, runs []*appRun) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i, run := range runs {
+		if nil == run {
+			continue
+		}
+		wg.Add(1)
+		go func(h *harvest, hp *harvestPosting) {
+			defer wg.Done()
+			app.postHarvest(h, hp)
+		}(ready.forDestination(run), &harvestPosting{
+			run:          run,
+			harvestStart: harvestStart,
+			controls:     app.destinations[i].rpmControls,
+			dest:         app.destinations[i],
+		})
+	}
+	return &wg
+}
+
+// destinationFilter applies the attribute, high security and security policy
+// settings of the run of a destination to the data harvested.
+type destinationFilter struct {
+	attrs *attributeConfig
+	// userAttributes is set when custom attributes may be sent.
+	userAttributes bool
+	highSecurity   bool
+	// rawErrorMessages is set when error messages may be sent.
+	rawErrorMessages bool
+	// queries is set when the queries of datastore calls may be sent, and
+	// queryParameters when their parameters may be sent.
+	queries         bool
+	queryParameters bool
+}
+
+func newDestinationFilter(run *appRun) *destinationFilter {
+	policies := run.Reply.SecurityPolicies
+	return &destinationFilter{
+		attrs:            run.AttributeConfig,
+		userAttributes:   !run.Config.HighSecurity && policies.CustomParameters.Enabled(),
+		highSecurity:     run.Config.HighSecurity,
+		rawErrorMessages: policies.AllowRawExceptionMessages.Enabled(),
+		queries:          policies.RecordSQL.Enabled(),
+		queryParameters:  !run.Config.HighSecurity && !policies.RecordSQL.IsSet(),
+	}
+}
+
+// attributes returns a copy of the attributes of a transaction written with
+// the attribute configuration of the destination.  The destinations of the
+// custom attributes are computed again since they are computed when the
+// attributes are added.
+func (f *destinationFilter) attributes(a *attributes) *attributes {
+	if nil == a {
+		return nil
+	}
+	cp := &attributes{
+		config: f.attrs,
+		Agent:  a.Agent,
+	}
+	if f.userAttributes && len(a.user) > 0 {
+		cp.user = make(map[string]userAttribute, len(a.user))
+		for key, atr := range a.user {
+			if dests := applyAttributeConfig(f.attrs, key, atr.dests); destNone != dests {
+				cp.user[key] = userAttribute{value: atr.value, dests: dests}
+			}
+		}
+	}
+	return cp
+}
+
+// spanAgentAttributes returns a copy of the agent attributes of a span or of a
+// trace segment kept for the destination.
+func (f *destinationFilter) spanAgentAttributes(attrs spanAttributeMap, d destinationSet) spanAttributeMap {
+	if nil == attrs {
+		return nil
+	}
+	cp := make(spanAttributeMap, len(attrs))
+	for key, val := range attrs {
+		if f.attrs.agentDests[key]&d == 0 {
+			continue
+		}
+		if !f.queryParameters && key == spanAttributeQueryParameters {
+			continue
+		}
+		if !f.queries && key == SpanAttributeDBStatement {
+			continue
+		}
+		if key == SpanAttributeErrorMessage {
+			if msg := f.errorMessage(""); "" != msg {
+				val = stringJSONWriter(msg)
+			}
+		}
+		cp[key] = val
+	}
+	return cp
+}
+
+// spanUserAttributes returns a copy of the custom attributes of a span kept
+// for the destination.
+func (f *destinationFilter) spanUserAttributes(attrs spanAttributeMap, d destinationSet) spanAttributeMap {
+	if nil == attrs || !f.userAttributes {
+		return nil
+	}
+	cp := make(spanAttributeMap, len(attrs))
+	for key, val := range attrs {
+		if applyAttributeConfig(f.attrs, key, d)&d != 0 {
+			cp[key] = val
+		}
+	}
+	return cp
+}
+
+// errorMessage returns the message replacing the error messages for the
+// destination, or msg if they may be sent.
+func (f *destinationFilter) errorMessage(msg string) string {
+	if f.highSecurity {
+		return highSecurityErrorMsg
+	}
+	if !f.rawErrorMessages {
+		return securityPolicyErrorMsg
+	}
+	return msg
+}
+
+func (f *destinationFilter) txnError(e txnError) txnError {
+	e.Attrs = f.attributes(e.Attrs)
+	e.Msg = f.errorMessage(e.Msg)
+	if !f.userAttributes {
+		e.ExtraAttributes = nil
+	}
+	return e
+}
+
+// filterEvents returns a copy of the events, each replaced by fn.
+func filterEvents(events *analyticsEvents, fn func(jsonWriter) jsonWriter) *analyticsEvents {
+	cp := &analyticsEvents{
+		numSeen:        events.numSeen,
+		failedHarvests: events.failedHarvests,
+		events:         make(analyticsEventHeap, len(events.events), cap(events.events)),
+	}
+	for i, e := range events.events {
+		cp.events[i] = analyticsEvent{priority: e.priority, jsonWriter: fn(e.jsonWriter)}
+	}
+	return cp
+}
+
+func (f *destinationFilter) traces(heap *txnTraceHeap) *txnTraceHeap {
+	cp := make(txnTraceHeap, len(*heap), cap(*heap))
+	for i, t := range *heap {
+		trace := *t
+		trace.Attrs = f.attributes(t.Attrs)
+		trace.Trace.nodes = make(traceNodeHeap, len(t.Trace.nodes))
+		for j, node := range t.Trace.nodes {
+			node.attributes = f.spanAgentAttributes(node.attributes, destSegment)
+			trace.Trace.nodes[j] = node
+		}
+		cp[i] = &trace
+	}
+	return &cp
+}
+
+// forDestination returns a copy of the harvest containing the data which the
+// run of a destination accepts, filtered by its attribute, high security and
+// security policy settings.  The data is shared when it needs no filtering,
+// since the harvest is not modified once posted.  The configuration of the run
+// is disabled by newAppRun for the data which the connect reply does not
+// collect, apart from the error traces.
+func (h *harvest) forDestination(run *appRun) *harvest {
+	f := newDestinationFilter(run)
+	cfg := run.Config
+	dh := &harvest{}
+
+	if nil != h.Metrics {
+		dh.Metrics = newMetricTable(maxMetrics, h.Metrics.metricPeriodStart)
+		dh.Metrics.merge(h.Metrics, "")
+	}
+	if nil != h.CustomEvents && cfg.CustomInsightsEvents.Enabled && !cfg.HighSecurity &&
+		run.Reply.CollectCustomEvents && run.Reply.SecurityPolicies.CustomEvents.Enabled() {
+		dh.CustomEvents = h.CustomEvents
+	}
+	if nil != h.LogEvents && run.harvestConfig.LoggingConfig.collectEvents {
+		logs := *h.LogEvents
+		logs.commonAttributes = run.harvestConfig.CommonAttributes
+		dh.LogEvents = &logs
+	}
+	if nil != h.TxnEvents && cfg.TransactionEvents.Enabled {
+		dh.TxnEvents = &txnEvents{filterEvents(h.TxnEvents.analyticsEvents, func(w jsonWriter) jsonWriter {
+			e := *w.(*txnEvent)
+			e.Attrs = f.attributes(e.Attrs)
+			return &e
+		})}
+	}
+	if nil != h.ErrorEvents && cfg.ErrorCollector.CaptureEvents {
+		dh.ErrorEvents = &errorEvents{filterEvents(h.ErrorEvents.analyticsEvents, func(w jsonWriter) jsonWriter {
+			e := errorEvent(f.txnError(txnError(*w.(*errorEvent))))
+			return &e
+		})}
+	}
+	if nil != h.SpanEvents && cfg.SpanEvents.Enabled && cfg.DistributedTracer.Enabled {
+		dh.SpanEvents = &spanEvents{filterEvents(h.SpanEvents.analyticsEvents, func(w jsonWriter) jsonWriter {
+			e := *w.(*spanEvent)
+			e.AgentAttributes = f.spanAgentAttributes(e.AgentAttributes, destSpan)
+			e.UserAttributes = f.spanUserAttributes(e.UserAttributes, destSpan)
+			return &e
+		})}
+	}
+	if nil != h.ErrorTraces && cfg.ErrorCollector.Enabled && run.Reply.CollectErrors {
+		dh.ErrorTraces = make(harvestErrors, len(h.ErrorTraces), cap(h.ErrorTraces))
+		for i, e := range h.ErrorTraces {
+			traced := tracedError(f.txnError(txnError(*e)))
+			dh.ErrorTraces[i] = &traced
+		}
+	}
+	if nil != h.TxnTraces && cfg.TransactionTracer.Enabled {
+		dh.TxnTraces = &harvestTraces{
+			regular:    f.traces(h.TxnTraces.regular),
+			synthetics: f.traces(h.TxnTraces.synthetics),
+		}
+	}
+	// Slow queries are not sent when the queries may not be recorded.
+	if nil != h.SlowSQLs && cfg.DatastoreTracer.SlowQuery.Enabled && f.queries {
+		dh.SlowSQLs = newSlowQueries(cap(h.SlowSQLs.priorityQueue))
+		for i, s := range h.SlowSQLs.priorityQueue {
+			slow := *s
+			slow.Attrs = f.attributes(s.Attrs)
+			if !f.queryParameters {
+				slow.QueryParameters = nil
+			}
+			dh.SlowSQLs.priorityQueue = append(dh.SlowSQLs.priorityQueue, &slow)
+			dh.SlowSQLs.lookup[slow.ParameterizedQuery] = i
+		}
+	}
+	return dh
+}
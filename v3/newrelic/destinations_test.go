@@ -0,0 +1,299 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic/fakecollector"
+)
+
+const testDestinationLicense = "9876543210987654321098765432109876543210"
+
+func TestDestinationConfigFor(t *testing.T) {
+	cfg := config{Config: defaultConfig()}
+	cfg.AppName = "gateway;platform"
+	cfg.License = testLicenseKey
+	cfg.Host = "collector.example.com"
+	cfg.Labels = map[string]string{"team": "platform"}
+	cfg.Attributes.Exclude = []string{"zip"}
+	cfg.Destinations = []DestinationConfig{{License: testDestinationLicense, HighSecurity: true}}
+	d := newDestinations(cfg.Config, rpmControls{License: testLicenseKey})[0]
+	d.settings.Attributes.Exclude = []string{"zap"}
+
+	dc := d.configFor(cfg)
+	if dc.AppName != cfg.AppName || dc.License != testDestinationLicense || dc.Host != "" {
+		t.Error(dc.AppName, dc.License, dc.Host)
+	}
+	if !dc.HighSecurity || nil != dc.Destinations || dc.Labels["team"] != "platform" {
+		t.Error(dc.HighSecurity, dc.Destinations, dc.Labels)
+	}
+	if excluded := strings.Join(dc.Attributes.Exclude, ","); excluded != "zip,zap" {
+		t.Error(excluded)
+	}
+	if len(cfg.Attributes.Exclude) != 1 {
+		t.Error("application config changed", cfg.Attributes.Exclude)
+	}
+	if d.rpmControls.License != testDestinationLicense {
+		t.Error(d.rpmControls.License)
+	}
+
+	d.settings.AppName = "checkout"
+	d.settings.Labels = map[string]string{"team": "checkout"}
+	if dc := d.configFor(cfg); dc.AppName != "checkout" || dc.Labels["team"] != "checkout" {
+		t.Error(dc.AppName, dc.Labels)
+	}
+}
+
+func TestValidateDestinations(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AppName = "my app"
+	cfg.License = testLicenseKey
+	cfg.Destinations = []DestinationConfig{{License: testDestinationLicense}}
+	if err := cfg.validate(); nil != err {
+		t.Error(err)
+	}
+
+	cfg.Destinations = []DestinationConfig{{License: testDestinationLicense}, {License: "short"}}
+	if err := cfg.validate(); !errors.Is(err, errLicenseLen) || !strings.HasPrefix(err.Error(), "destination 1:") {
+		t.Error(err)
+	}
+	cfg.Destinations = []DestinationConfig{{License: testDestinationLicense, AppName: "a;b;c;d"}}
+	if err := cfg.validate(); !errors.Is(err, errAppNameLimit) {
+		t.Error(err)
+	}
+	cfg.HighSecurity = true
+	cfg.Destinations = []DestinationConfig{{License: testDestinationLicense, SecurityPoliciesToken: "token"}}
+	if err := cfg.validate(); !errors.Is(err, errHighSecurityWithSecurityPolicies) {
+		t.Error(err)
+	}
+
+	cfg = defaultConfig()
+	cfg.ServerlessMode.Enabled = true
+	cfg.Destinations = []DestinationConfig{{License: testDestinationLicense}}
+	if err := cfg.validate(); err != errDestinationsServerless {
+		t.Error(err)
+	}
+}
+
+func TestSettingsOmitDestinations(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Destinations = []DestinationConfig{{License: testDestinationLicense}}
+	js, err := json.Marshal(settings(cfg))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), testDestinationLicense) || strings.Contains(string(js), "Destinations") {
+		t.Error(string(js))
+	}
+}
+
+func harvestData(t *testing.T, p payloadCreator) string {
+	data, err := p.Data("run", time.Now())
+	if nil != err {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHarvestForDestination(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("hello")
+	txn.AddAttribute("zip", 1)
+	txn.AddAttribute("zap", 2)
+	txn.NoticeError(errors.New("my error"))
+	txn.End()
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	internalApp := app.Application.app
+	h := internalApp.testHarvest
+
+	destinations := newDestinations(Config{Destinations: []DestinationConfig{
+		{License: testDestinationLicense},
+		{License: testDestinationLicense, HighSecurity: true},
+	}}, internalApp.rpmControls)
+	destinations[0].settings.Attributes.Exclude = []string{"zip"}
+	reply := internal.ConnectReplyDefaults()
+	filtered := h.forDestination(newAppRun(destinations[0].configFor(internalApp.config), reply))
+	highSecurity := h.forDestination(newAppRun(destinations[1].configFor(internalApp.config), reply))
+
+	if events := harvestData(t, filtered.TxnEvents); strings.Contains(events, `"zip"`) || !strings.Contains(events, `"zap"`) {
+		t.Error(events)
+	}
+	if errs := harvestData(t, filtered.ErrorEvents); !strings.Contains(errs, "my error") || strings.Contains(errs, `"zip"`) {
+		t.Error(errs)
+	}
+	if nil == filtered.CustomEvents || nil == filtered.Metrics || filtered.Metrics == h.Metrics {
+		t.Error(filtered.CustomEvents, filtered.Metrics)
+	}
+
+	if events := harvestData(t, highSecurity.TxnEvents); strings.Contains(events, `"zip"`) || strings.Contains(events, `"zap"`) {
+		t.Error(events)
+	}
+	for _, p := range []payloadCreator{highSecurity.ErrorEvents, highSecurity.ErrorTraces} {
+		if errs := harvestData(t, p); strings.Contains(errs, "my error") || !strings.Contains(errs, highSecurityErrorMsg) {
+			t.Error(errs)
+		}
+	}
+	if nil != highSecurity.CustomEvents || nil != highSecurity.LogEvents {
+		t.Error(highSecurity.CustomEvents, highSecurity.LogEvents)
+	}
+
+	// The harvest of the application is unchanged.
+	if events := harvestData(t, h.TxnEvents); !strings.Contains(events, `"zip"`) || !strings.Contains(events, `"zap"`) {
+		t.Error(events)
+	}
+	if errs := harvestData(t, h.ErrorTraces); !strings.Contains(errs, "my error") {
+		t.Error(errs)
+	}
+}
+
+func TestHarvestForDestinationSecurityPolicies(t *testing.T) {
+	app := testApp(func(reply *internal.ConnectReply) {
+		reply.SetSampleEverything()
+	}, func(cfg *Config) {
+		cfg.TransactionTracer.Threshold.IsApdexFailing = false
+		cfg.TransactionTracer.Threshold.Duration = 0
+		cfg.TransactionTracer.Segments.Threshold = 0
+		cfg.DatastoreTracer.SlowQuery.Threshold = 0
+	}, t)
+	txn := app.StartTransaction("hello")
+	s := DatastoreSegment{
+		StartTime:          txn.StartSegmentNow(),
+		Product:            DatastorePostgres,
+		Collection:         "users",
+		Operation:          "select",
+		ParameterizedQuery: "SELECT name FROM users WHERE id = $1",
+		QueryParameters:    map[string]interface{}{"id": 42},
+	}
+	s.End()
+	txn.NoticeError(errors.New("my error"))
+	txn.End()
+	internalApp := app.Application.app
+	h := internalApp.testHarvest
+	d := newDestinations(Config{Destinations: []DestinationConfig{{License: testDestinationLicense}}}, internalApp.rpmControls)[0]
+	cfg := d.configFor(internalApp.config)
+
+	// The queries are not sent to a destination whose record_sql policy is
+	// disabled.
+	reply := internal.ConnectReplyDefaults()
+	reply.SecurityPolicies.RecordSQL.SetEnabled(false)
+	noSQL := h.forDestination(newAppRun(cfg, reply))
+	if nil != noSQL.SlowSQLs {
+		t.Error(harvestData(t, noSQL.SlowSQLs))
+	}
+	for _, p := range []payloadCreator{noSQL.SpanEvents, noSQL.TxnTraces} {
+		if data := harvestData(t, p); strings.Contains(data, "SELECT") || strings.Contains(data, SpanAttributeDBStatement) || strings.Contains(data, "query_parameters") {
+			t.Error(data)
+		}
+	}
+	if data := harvestData(t, h.SpanEvents); !strings.Contains(data, "SELECT") {
+		t.Error("application harvest changed", data)
+	}
+
+	// The queries but not their parameters are sent when the policy is
+	// enabled.
+	reply = internal.ConnectReplyDefaults()
+	reply.SecurityPolicies.RecordSQL.SetEnabled(true)
+	noParams := h.forDestination(newAppRun(cfg, reply))
+	var slowSQLs [][][]interface{}
+	data := harvestData(t, noParams.SlowSQLs)
+	if err := json.Unmarshal([]byte(data), &slowSQLs); nil != err || len(slowSQLs) != 1 || len(slowSQLs[0]) != 1 {
+		t.Fatal(err, data)
+	}
+	slow := slowSQLs[0][0]
+	params, _ := slow[len(slow)-1].(map[string]interface{})
+	if _, ok := params["query_parameters"]; slow[3] != "SELECT name FROM users WHERE id = $1" || nil == params || ok {
+		t.Error(data)
+	}
+
+	// The data not collected by the destination is not sent.
+	reply = internal.ConnectReplyDefaults()
+	reply.CollectAnalyticsEvents = false
+	reply.CollectErrors = false
+	reply.CollectTraces = false
+	reply.CollectSpanEvents = false
+	notCollected := h.forDestination(newAppRun(cfg, reply))
+	if nil != notCollected.TxnEvents || nil != notCollected.ErrorTraces || nil != notCollected.TxnTraces ||
+		nil != notCollected.SlowSQLs || nil != notCollected.SpanEvents {
+		t.Error(notCollected.TxnEvents, notCollected.ErrorTraces, notCollected.TxnTraces,
+			notCollected.SlowSQLs, notCollected.SpanEvents)
+	}
+	if nil == notCollected.ErrorEvents || nil == notCollected.Metrics {
+		t.Error(notCollected.ErrorEvents, notCollected.Metrics)
+	}
+}
+
+func TestDestinationsReported(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv, func(cfg *Config) {
+		cfg.AppName = "gateway;platform"
+		cfg.Destinations = []DestinationConfig{{
+			AppName: "checkout",
+			License: testDestinationLicense,
+			Host:    srv.Host(),
+		}}
+	})
+
+	var connects []fakecollector.Request
+	for i := 0; i < 100 && len(connects) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		connects = srv.RequestsFor(fakecollector.MethodConnect)
+	}
+	appNames := make(map[string]string)
+	for _, c := range connects {
+		var payload []struct {
+			AppName []string `json:"app_name"`
+		}
+		if err := c.Unmarshal(&payload); nil != err || len(payload) != 1 {
+			t.Fatal(err, string(c.Body))
+		}
+		appNames[c.License] = strings.Join(payload[0].AppName, ";")
+	}
+	if appNames[testLicenseKey] != "gateway;platform" || appNames[testDestinationLicense] != "checkout" {
+		t.Fatal(appNames)
+	}
+
+	reported := func() bool {
+		licenses := make(map[string]bool)
+		for _, r := range srv.RequestsFor(fakecollector.MethodCustomEvents) {
+			licenses[r.License] = true
+		}
+		return licenses[testLicenseKey] && licenses[testDestinationLicense]
+	}
+	for i := 0; i < 100 && !reported(); i++ {
+		app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+		if err := app.Flush(context.Background()); nil != err {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !reported() {
+		t.Fatal(srv.RequestsFor(fakecollector.MethodCustomEvents))
+	}
+}
+
+func TestDestinationRunOver(t *testing.T) {
+	app := testApp(nil, nil, t)
+	internalApp := app.Application.app
+	d := &destination{}
+	current, ended := &appRun{Config: internalApp.config}, &appRun{Config: internalApp.config}
+
+	// The end of a previous run is ignored, which would otherwise connect
+	// the destination again.
+	restart := newRPMResponse(nil).AddStatusCode(401)
+	if internalApp.destinationRunOver(destinationRun{dest: d, ended: ended, resp: restart}, current) {
+		t.Error("previous run ended the current run")
+	}
+	disconnect := newRPMResponse(nil).AddStatusCode(410)
+	if !internalApp.destinationRunOver(destinationRun{dest: d, ended: current, resp: disconnect}, current) {
+		t.Error("current run not ended")
+	}
+}
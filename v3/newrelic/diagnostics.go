@@ -0,0 +1,317 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+// diagnosticsTimeout bounds the time waited for the state of the current
+// harvest, which is owned by the goroutine of the application.
+const diagnosticsTimeout = 2 * time.Second
+
+// DiagnosticsHandler returns an http.Handler reporting the state of the agent
+// as JSON, to help find out why data is missing: whether the application is
+// connected, its run ID, the time and the outcome of the last request to each
+// endpoint of New Relic, the number of events seen and saved by each
+// reservoir of the current harvest, the state of the Infinite Tracing trace
+// observer, the effective configuration, without the license key, and the
+// settings sent by New Relic when connecting.
+//
+// The handler is not registered anywhere: serve it on an internal port, as
+// the report contains the configuration of the application.  Set
+// Config.Diagnostics.ListenAddress to have the application serve it on its
+// own.  DiagnosticsHandler is safe to call if app is nil.
+func DiagnosticsHandler(app *Application) http.Handler {
+	if nil == app {
+		return http.HandlerFunc(disabledApp.serveDiagnostics)
+	}
+	return http.HandlerFunc(app.app.serveDiagnostics)
+}
+
+// disabledApp reports the state of a nil Application.
+var disabledApp *app
+
+// endpointDiagnostics is the outcome of the last request to an endpoint of the
+// collector.
+type endpointDiagnostics struct {
+	Time       time.Time `json:"time"`
+	DurationMS float64   `json:"duration_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Retained   bool      `json:"data_retained"`
+}
+
+// harvestResults records the outcome of the harvests.
+type harvestResults struct {
+	sync.Mutex
+	lastStart time.Time
+	lastEnd   time.Time
+	endpoints map[string]endpointDiagnostics
+}
+
+func (hr *harvestResults) recordEndpoint(cmd string, start time.Time, resp *rpmResponse) {
+	result := endpointDiagnostics{
+		Time:       start,
+		DurationMS: time.Since(start).Seconds() * 1000,
+		StatusCode: resp.statusCode,
+		Retained:   resp.ShouldSaveHarvestData(),
+	}
+	if err := resp.GetError(); nil != err {
+		result.Error = err.Error()
+	}
+
+	hr.Lock()
+	defer hr.Unlock()
+	if nil == hr.endpoints {
+		hr.endpoints = make(map[string]endpointDiagnostics)
+	}
+	hr.endpoints[cmd] = result
+}
+
+func (hr *harvestResults) recordHarvest(start, end time.Time) {
+	hr.Lock()
+	defer hr.Unlock()
+	// Harvests may overlap: the latest one is kept.
+	if start.After(hr.lastStart) {
+		hr.lastStart = start
+		hr.lastEnd = end
+	}
+}
+
+// reservoirDiagnostics is the fill level of a reservoir of the current
+// harvest.
+type reservoirDiagnostics struct {
+	Seen     int `json:"seen"`
+	Saved    int `json:"saved"`
+	Capacity int `json:"capacity"`
+	Dropped  int `json:"dropped"`
+}
+
+func newReservoirDiagnostics(seen, saved, capacity int) reservoirDiagnostics {
+	return reservoirDiagnostics{
+		Seen:     seen,
+		Saved:    saved,
+		Capacity: capacity,
+		Dropped:  seen - saved,
+	}
+}
+
+// reservoirDiagnostics returns the fill levels of the reservoirs of the
+// harvest, by endpoint.  It must be called by the goroutine of the
+// application.
+func (h *harvest) reservoirDiagnostics() map[string]reservoirDiagnostics {
+	if nil == h {
+		return nil
+	}
+	r := make(map[string]reservoirDiagnostics)
+	events := map[string]*analyticsEvents{
+		cmdCustomEvents: h.CustomEvents.analyticsEvents,
+		cmdTxnEvents:    h.TxnEvents.analyticsEvents,
+		cmdErrorEvents:  h.ErrorEvents.analyticsEvents,
+		cmdSpanEvents:   h.SpanEvents.analyticsEvents,
+	}
+	for cmd, e := range events {
+		r[cmd] = newReservoirDiagnostics(e.numSeen, len(e.events), e.capacity())
+	}
+	r[cmdLogEvents] = newReservoirDiagnostics(h.LogEvents.numSeen, len(h.LogEvents.logs), h.LogEvents.capacity())
+	// The metrics dropped once the table is full are counted by the
+	// Supportability/MetricsDropped metric.
+	dropped := 0
+	if m := h.Metrics.metrics[metricID{Name: supportabilityDropped}]; nil != m {
+		dropped = int(m.data.countSatisfied)
+	}
+	r[cmdMetrics] = reservoirDiagnostics{
+		Seen:     len(h.Metrics.metrics) + dropped,
+		Saved:    len(h.Metrics.metrics),
+		Capacity: h.Metrics.maxTableSize,
+		Dropped:  dropped,
+	}
+	r[cmdErrorData] = newReservoirDiagnostics(len(h.ErrorTraces), len(h.ErrorTraces), cap(h.ErrorTraces))
+	return r
+}
+
+// reservoirs returns the fill levels of the reservoirs of the current
+// harvest, or nil if the application is not connected.
+func (app *app) reservoirs(ctx context.Context) map[string]reservoirDiagnostics {
+	if !app.config.Enabled || app.config.ServerlessMode.Enabled {
+		return nil
+	}
+	done := make(chan map[string]reservoirDiagnostics, 1)
+	select {
+	case app.reservoirsChan <- done:
+	case <-app.shutdownStarted:
+		return nil
+	case <-ctx.Done():
+		return nil
+	}
+	select {
+	case r := <-done:
+		return r
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// serverSideDiagnostics contains the settings sent by New Relic when
+// connecting.
+type serverSideDiagnostics struct {
+	AgentConfig        interface{}                 `json:"agent_config"`
+	EventHarvestConfig internal.EventHarvestConfig `json:"event_harvest_config"`
+	ApdexThreshold     float64                     `json:"apdex_t"`
+	SamplingTarget     uint64                      `json:"sampling_target"`
+	CollectAnalytics   bool                        `json:"collect_analytics_events"`
+	CollectCustom      bool                        `json:"collect_custom_events"`
+	CollectTraces      bool                        `json:"collect_traces"`
+	CollectErrors      bool                        `json:"collect_errors"`
+	CollectErrorEvents bool                        `json:"collect_error_events"`
+	CollectSpanEvents  bool                        `json:"collect_span_events"`
+	SecurityPolicies   bool                        `json:"security_policies"`
+}
+
+type lastHarvest struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// diagnosticsReport is the report written by DiagnosticsHandler.
+type diagnosticsReport struct {
+	State         string                          `json:"state"`
+	Error         string                          `json:"error,omitempty"`
+	AppName       string                          `json:"app_name,omitempty"`
+	AgentVersion  string                          `json:"agent_version"`
+	GoVersion     string                          `json:"go_version"`
+	RunID         string                          `json:"run_id,omitempty"`
+	EntityGUID    string                          `json:"entity_guid,omitempty"`
+	Collector     string                          `json:"collector,omitempty"`
+	LastHarvest   *lastHarvest                    `json:"last_harvest,omitempty"`
+	Endpoints     map[string]endpointDiagnostics  `json:"endpoints,omitempty"`
+	Reservoirs    map[string]reservoirDiagnostics `json:"reservoirs,omitempty"`
+	TraceObserver *observerDiagnostics            `json:"trace_observer,omitempty"`
+	Config        json.RawMessage                 `json:"config,omitempty"`
+	ServerSide    *serverSideDiagnostics          `json:"server_side_config,omitempty"`
+}
+
+func (app *app) diagnosticsReport(ctx context.Context) diagnosticsReport {
+	report := diagnosticsReport{
+		AgentVersion: Version,
+		GoVersion:    runtime.Version(),
+	}
+	if nil == app {
+		report.State = "disabled"
+		return report
+	}
+	report.AppName = app.config.AppName
+
+	run, err := app.getState()
+	switch {
+	case !app.config.Enabled:
+		report.State = "disabled"
+	case app.config.ServerlessMode.Enabled:
+		report.State = "serverless"
+	case isChanClosed(app.shutdownStarted):
+		report.State = "shutdown"
+	case nil != err:
+		report.State = "disconnected"
+		report.Error = err.Error()
+	case "" == run.Reply.RunID:
+		report.State = "connecting"
+	default:
+		report.State = "connected"
+		report.RunID = run.Reply.RunID.String()
+		report.EntityGUID = run.Reply.EntityGUID
+		report.Collector = run.Reply.Collector
+		report.ServerSide = &serverSideDiagnostics{
+			AgentConfig:        run.Reply.ServerSideConfig,
+			EventHarvestConfig: run.Reply.EventData,
+			ApdexThreshold:     run.Reply.ApdexThresholdSeconds,
+			SamplingTarget:     run.Reply.SamplingTarget,
+			CollectAnalytics:   run.Reply.CollectAnalyticsEvents,
+			CollectCustom:      run.Reply.CollectCustomEvents,
+			CollectTraces:      run.Reply.CollectTraces,
+			CollectErrors:      run.Reply.CollectErrors,
+			CollectErrorEvents: run.Reply.CollectErrorEvents,
+			CollectSpanEvents:  run.Reply.CollectSpanEvents,
+			SecurityPolicies:   run.Reply.SecurityPolicies.PointerIfPopulated() != nil,
+		}
+	}
+
+	// The configuration of the run includes the server side settings.
+	cfg := app.config.Config
+	if nil != run {
+		cfg = run.Config.Config
+	}
+	if cfg.SecurityPoliciesToken != "" {
+		cfg.SecurityPoliciesToken = "[redacted]"
+	}
+	if js, err := json.Marshal(settings(cfg)); nil == err {
+		report.Config = js
+	}
+
+	app.harvestResults.Lock()
+	if !app.harvestResults.lastStart.IsZero() {
+		report.LastHarvest = &lastHarvest{
+			Start: app.harvestResults.lastStart,
+			End:   app.harvestResults.lastEnd,
+		}
+	}
+	if len(app.harvestResults.endpoints) > 0 {
+		report.Endpoints = make(map[string]endpointDiagnostics, len(app.harvestResults.endpoints))
+		for cmd, result := range app.harvestResults.endpoints {
+			report.Endpoints[cmd] = result
+		}
+	}
+	app.harvestResults.Unlock()
+
+	if obs := app.getObserver(); nil != obs {
+		d := obs.diagnostics()
+		report.TraceObserver = &d
+	}
+	report.Reservoirs = app.reservoirs(ctx)
+	return report
+}
+
+func (app *app) serveDiagnostics(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), diagnosticsTimeout)
+	defer cancel()
+
+	js, err := json.MarshalIndent(app.diagnosticsReport(ctx), "", "  ")
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(js)
+}
+
+// startDiagnosticsServer serves DiagnosticsHandler on the address given until
+// the application is shut down.
+func (app *app) startDiagnosticsServer(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if nil != err {
+		app.Error("unable to start diagnostics listener", map[string]interface{}{
+			"address": addr,
+			"error":   err.Error(),
+		})
+		return
+	}
+	app.diagnosticsServer = &http.Server{
+		Addr:              ln.Addr().String(),
+		Handler:           http.HandlerFunc(app.serveDiagnostics),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	app.Info("diagnostics listener started", map[string]interface{}{
+		"address": ln.Addr().String(),
+	})
+	go app.diagnosticsServer.Serve(ln)
+}
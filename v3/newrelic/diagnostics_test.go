@@ -0,0 +1,154 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic/fakecollector"
+)
+
+func getDiagnostics(t *testing.T, h http.Handler) (diagnosticsReport, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/diagnostics", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatal(w.Code, w.Header())
+	}
+	var report diagnosticsReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); nil != err {
+		t.Fatal(err, w.Body.String())
+	}
+	return report, w.Body.String()
+}
+
+// waitForCustomEvents waits for the custom events recorded to reach the
+// harvest.
+func waitForCustomEvents(app *Application, seen int) {
+	for i := 0; i < 100; i++ {
+		if r := app.app.reservoirs(context.Background()); r[cmdCustomEvents].Seen == seen {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDiagnosticsHandlerConnected(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	srv.SetConnectReply(map[string]interface{}{
+		"agent_config": map[string]interface{}{
+			"transaction_tracer.enabled": false,
+		},
+	})
+	app := newFakeCollectorTestApp(t, srv)
+	srv.Respond(fakecollector.MethodCustomEvents, fakecollector.Response{StatusCode: 503})
+
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 2})
+	waitForCustomEvents(app, 2)
+
+	report, js := getDiagnostics(t, DiagnosticsHandler(app))
+	if report.State != "connected" || report.RunID != "fakecollector-run-1" ||
+		report.EntityGUID != "fakecollector-entity-guid" || report.AppName != sampleAppName {
+		t.Error(js)
+	}
+	if r := report.Reservoirs[cmdCustomEvents]; r.Seen != 2 || r.Saved != 2 || r.Dropped != 0 || r.Capacity == 0 {
+		t.Error(r)
+	}
+	if report.LastHarvest != nil || len(report.Endpoints) != 0 {
+		t.Error(js)
+	}
+	if report.ServerSide == nil || !strings.Contains(js, `"transaction_tracer.enabled": false`) {
+		t.Error(js)
+	}
+	if strings.Contains(js, testLicenseKey) {
+		t.Error("license key reported", js)
+	}
+
+	if err := app.Flush(context.Background()); !errors.Is(err, errHarvestFailed) {
+		t.Fatal(err)
+	}
+	report, js = getDiagnostics(t, DiagnosticsHandler(app))
+	if report.LastHarvest == nil || report.LastHarvest.End.Before(report.LastHarvest.Start) {
+		t.Error(js)
+	}
+	if e := report.Endpoints[cmdCustomEvents]; e.StatusCode != 503 || e.Error == "" || !e.Retained {
+		t.Error(e)
+	}
+	if e := report.Endpoints[cmdMetrics]; e.StatusCode != 200 || e.Error != "" {
+		t.Error(e)
+	}
+	// The events retained are merged into the next harvest.
+	waitForCustomEvents(app, 2)
+	report, js = getDiagnostics(t, DiagnosticsHandler(app))
+	if r := report.Reservoirs[cmdCustomEvents]; r.Seen != 2 {
+		t.Error(r)
+	}
+}
+
+func TestDiagnosticsHandlerDisabled(t *testing.T) {
+	report, js := getDiagnostics(t, DiagnosticsHandler(nil))
+	if report.State != "disabled" || report.AgentVersion != Version {
+		t.Error(js)
+	}
+
+	app, err := NewApplication(ConfigAppName("my app"), ConfigEnabled(false))
+	if nil != err {
+		t.Fatal(err)
+	}
+	report, js = getDiagnostics(t, DiagnosticsHandler(app))
+	if report.State != "disabled" || report.AppName != "my app" || report.Reservoirs != nil {
+		t.Error(js)
+	}
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(report.Config, &cfg); nil != err || cfg["AppName"] != "my app" {
+		t.Error(cfg, err)
+	}
+}
+
+func TestDiagnosticsHandlerShutdown(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	app.Shutdown(10 * time.Second)
+
+	report, js := getDiagnostics(t, DiagnosticsHandler(app))
+	if report.State != "shutdown" || report.RunID != "" || report.Reservoirs != nil {
+		t.Error(js)
+	}
+}
+
+func TestDiagnosticsListener(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv, func(cfg *Config) {
+		cfg.Diagnostics.ListenAddress = "127.0.0.1:0"
+	})
+	if nil == app.app.diagnosticsServer {
+		t.Fatal("diagnostics listener not started")
+	}
+	url := "http://" + app.app.diagnosticsServer.Addr + "/"
+	resp, err := http.Get(url)
+	if nil != err {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"state": "connected"`) {
+		t.Error(string(body))
+	}
+
+	app.Shutdown(10 * time.Second)
+	if _, err := http.Get(url); nil == err {
+		t.Error("diagnostics listener not closed")
+	}
+}
@@ -77,3 +77,11 @@ func (events *errorEvents) Data(agentRunID string, harvestStart time.Time) ([]by
 func (events *errorEvents) EndpointMethod() string {
 	return cmdErrorEvents
 }
+
+func (events *errorEvents) splitPayload() (payloadCreator, payloadCreator) {
+	if len(events.events) < 2 {
+		return nil, nil
+	}
+	e1, e2 := events.split()
+	return &errorEvents{analyticsEvents: e1}, &errorEvents{analyticsEvents: e2}
+}
@@ -547,6 +547,11 @@ func expectSlowQuery(t internal.Validator, slowQuery *slowQuery, want internal.W
 	validateStringField(t, "Host", want.Host, slowQuery.Host)
 	validateStringField(t, "PortPathOrID", want.PortPathOrID, slowQuery.PortPathOrID)
 	expectAttributes(t, map[string]interface{}(slowQuery.QueryParameters), want.Params)
+	plan := &bytes.Buffer{}
+	if nil != slowQuery.ExplainPlan {
+		slowQuery.ExplainPlan.WriteJSON(plan)
+	}
+	validateStringField(t, "ExplainPlan", want.ExplainPlan, plan.String())
 }
 
 // expectSlowQueries allows testing of slow queries.
@@ -0,0 +1,172 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// externalTimings records the phases of the HTTP request of an external
+// segment.  Its hooks are called by the transport, possibly from other
+// goroutines, and even after the segment has ended when a dial outlives the
+// request.
+type externalTimings struct {
+	sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	gotConn      bool
+	reused       bool
+}
+
+// externalPhase is a phase of an HTTP request.
+type externalPhase struct {
+	name      string
+	attribute string
+	start     time.Time
+	stop      time.Time
+}
+
+func (et *externalTimings) record(fn func()) {
+	et.Lock()
+	defer et.Unlock()
+	fn()
+}
+
+func (et *externalTimings) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			et.record(func() { et.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			et.record(func() { et.dnsDone = time.Now() })
+		},
+		ConnectStart: func(string, string) {
+			// Several addresses may be dialed in parallel: the
+			// phase covers all the attempts.
+			et.record(func() {
+				if et.connectStart.IsZero() {
+					et.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(string, string, error) {
+			et.record(func() { et.connectDone = time.Now() })
+		},
+		TLSHandshakeStart: func() {
+			et.record(func() { et.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			et.record(func() { et.tlsDone = time.Now() })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			et.record(func() {
+				et.gotConn = true
+				et.reused = info.Reused
+			})
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			et.record(func() { et.wroteRequest = time.Now() })
+		},
+		GotFirstResponseByte: func() {
+			et.record(func() { et.firstByte = time.Now() })
+		},
+	}
+}
+
+// phases returns the phases of the request which have completed before the
+// time given.  It also returns whether the connection was reused, or nil if
+// no connection was obtained.
+func (et *externalTimings) phases(now time.Time) ([]externalPhase, *bool) {
+	if nil == et {
+		return nil, nil
+	}
+	et.Lock()
+	defer et.Unlock()
+
+	var phases []externalPhase
+	add := func(name, attribute string, start, stop time.Time) {
+		if start.IsZero() || stop.IsZero() || stop.Before(start) || stop.After(now) {
+			return
+		}
+		phases = append(phases, externalPhase{
+			name:      name,
+			attribute: attribute,
+			start:     start,
+			stop:      stop,
+		})
+	}
+	add("DNS", SpanAttributeHTTPDNSDuration, et.dnsStart, et.dnsDone)
+	add("Connect", SpanAttributeHTTPConnectDuration, et.connectStart, et.connectDone)
+	add("TLS", SpanAttributeHTTPTLSDuration, et.tlsStart, et.tlsDone)
+	add("FirstByte", SpanAttributeHTTPTimeToFirstByte, et.wroteRequest, et.firstByte)
+
+	if !et.gotConn {
+		return phases, nil
+	}
+	reused := et.reused
+	return phases, &reused
+}
+
+// traceExternalRequest returns a copy of the request whose context records
+// the phases of the request, if enabled by the configuration of the
+// transaction, along with the timings recorded.  Otherwise the request is
+// returned unchanged.  The request given is never modified, since it may be
+// shared.
+func traceExternalRequest(txn *Transaction, request *http.Request) (*externalTimings, *http.Request) {
+	if nil == txn || nil == txn.thread || nil == txn.thread.txn || nil == request {
+		return nil, request
+	}
+	if !txn.thread.Config.ExternalTimings.Enabled {
+		return nil, request
+	}
+	et := &externalTimings{}
+	ctx := httptrace.WithClientTrace(request.Context(), et.clientTrace())
+	return et, request.WithContext(ctx)
+}
+
+// addExternalPhaseSegments records the phases of the request as child
+// segments of the external segment, which must be the current segment of
+// the thread.
+func addExternalPhaseSegments(t *txnData, thread *tracingThread, parent segmentStartTime, host string, phases []externalPhase) {
+	if parent.Depth != len(thread.stack)-1 || parent.Depth < 0 || thread.stack[parent.Depth].Stamp != parent.Stamp {
+		return
+	}
+	for _, phase := range phases {
+		start := startSegment(t, thread, phase.start)
+		end, err := endSegment(t, thread, start, phase.stop)
+		if err != nil {
+			return
+		}
+		name := "External/" + host + "/" + phase.name
+		if t.TxnTrace.considerNode(end) {
+			t.saveTraceSegment(end, name, end.agentAttributes.copy(), "")
+		}
+		if evt := end.spanEvent(); evt != nil {
+			evt.Name = name
+			evt.Category = spanCategoryGeneric
+			t.saveSpanEvent(evt)
+		}
+	}
+}
+
+// addExternalPhaseAttributes adds the durations of the phases of the request
+// and whether the connection was reused to the attributes.
+func addExternalPhaseAttributes(attrs *spanAttributeMap, phases []externalPhase, reused *bool) {
+	for _, phase := range phases {
+		attrs.addFloat(phase.attribute, phase.stop.Sub(phase.start).Seconds())
+	}
+	if nil != reused {
+		attrs.addBool(SpanAttributeHTTPConnectionReused, *reused)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func TestExternalTimingsPhases(t *testing.T) {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	at := func(millis int) time.Time { return start.Add(time.Duration(millis) * time.Millisecond) }
+	et := &externalTimings{
+		dnsStart:     at(0),
+		dnsDone:      at(10),
+		connectStart: at(10),
+		connectDone:  at(30),
+		tlsStart:     at(30),
+		// The handshake has not completed.
+		wroteRequest: at(60),
+		firstByte:    at(160),
+	}
+	phases, reused := et.phases(at(200))
+	if nil != reused {
+		t.Error("no connection obtained", *reused)
+	}
+	expect := []externalPhase{
+		{name: "DNS", attribute: "http.dnsDuration", start: at(0), stop: at(10)},
+		{name: "Connect", attribute: "http.connectDuration", start: at(10), stop: at(30)},
+		{name: "FirstByte", attribute: "http.timeToFirstByte", start: at(60), stop: at(160)},
+	}
+	if len(phases) != len(expect) {
+		t.Fatal(phases)
+	}
+	for i := range expect {
+		if phases[i] != expect[i] {
+			t.Errorf("phase %d: got=%v want=%v", i, phases[i], expect[i])
+		}
+	}
+
+	// Phases completing after the end of the segment are ignored.
+	et.gotConn = true
+	et.reused = true
+	phases, reused = et.phases(at(100))
+	if len(phases) != 2 {
+		t.Error(phases)
+	}
+	if nil == reused || !*reused {
+		t.Error("connection reused", reused)
+	}
+
+	var disabled *externalTimings
+	if phases, reused := disabled.phases(at(200)); nil != phases || nil != reused {
+		t.Error(phases, reused)
+	}
+}
+
+func externalTimingsServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func externalTimingsGet(t *testing.T, client *http.Client, txn *Transaction, url string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(RequestWithTransactionContext(req, txn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func TestExternalTimingsRoundTripper(t *testing.T) {
+	srv := externalTimingsServer(t)
+	app := testApp(func(reply *internal.ConnectReply) {
+		reply.SetSampleEverything()
+	}, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = true
+		cfg.ExternalTimings.Enabled = true
+	}, t)
+	client := &http.Client{Transport: NewRoundTripper(srv.Client().Transport)}
+	txn := app.StartTransaction("hello")
+	externalTimingsGet(t, client, txn, srv.URL)
+	externalTimingsGet(t, client, txn, srv.URL)
+	txn.End()
+	app.expectNoLoggedErrors(t)
+
+	host := srv.Listener.Addr().String()
+	externalSpan := func(agentAttributes map[string]interface{}) internal.WantEvent {
+		agentAttributes["http.url"] = srv.URL
+		agentAttributes["http.method"] = "GET"
+		agentAttributes["http.statusCode"] = 200
+		agentAttributes["http.timeToFirstByte"] = internal.MatchAnything
+		return internal.WantEvent{
+			Intrinsics: map[string]interface{}{
+				"parentId":  internal.MatchAnything,
+				"name":      "External/" + host + "/http/GET",
+				"category":  "http",
+				"component": "http",
+				"span.kind": "client",
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: agentAttributes,
+		}
+	}
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		externalSpan(map[string]interface{}{
+			"http.connectDuration":  internal.MatchAnything,
+			"http.tlsDuration":      internal.MatchAnything,
+			"http.connectionReused": false,
+		}),
+		externalSpan(map[string]interface{}{
+			"http.connectionReused": true,
+		}),
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/hello",
+				"transaction.name": "OtherTransaction/Go/hello",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{},
+		},
+	})
+}
+
+func TestExternalTimingsSegments(t *testing.T) {
+	srv := externalTimingsServer(t)
+	app := testApp(func(reply *internal.ConnectReply) {
+		reply.SetSampleEverything()
+	}, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = true
+		cfg.ExternalTimings.Enabled = true
+		cfg.ExternalTimings.Segments = true
+	}, t)
+	txn := app.StartTransaction("hello")
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := StartExternalSegment(txn, req)
+	if s.Request == req || nil != httptrace.ContextClientTrace(req.Context()) {
+		t.Error("request given modified")
+	}
+	resp, err := srv.Client().Do(s.Request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	s.Response = resp
+	s.End()
+	txn.End()
+	app.expectNoLoggedErrors(t)
+
+	host := srv.Listener.Addr().String()
+	phaseSpan := func(phase string) internal.WantEvent {
+		return internal.WantEvent{
+			Intrinsics: map[string]interface{}{
+				"parentId": internal.MatchAnything,
+				"name":     "External/" + host + "/" + phase,
+				"category": "generic",
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{},
+		}
+	}
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		phaseSpan("Connect"),
+		phaseSpan("TLS"),
+		phaseSpan("FirstByte"),
+		{
+			Intrinsics: map[string]interface{}{
+				"parentId":  internal.MatchAnything,
+				"name":      "External/" + host + "/http/GET",
+				"category":  "http",
+				"component": "http",
+				"span.kind": "client",
+			},
+			UserAttributes: map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{
+				"http.url":              srv.URL,
+				"http.method":           "GET",
+				"http.statusCode":       200,
+				"http.connectDuration":  internal.MatchAnything,
+				"http.tlsDuration":      internal.MatchAnything,
+				"http.timeToFirstByte":  internal.MatchAnything,
+				"http.connectionReused": false,
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/hello",
+				"transaction.name": "OtherTransaction/Go/hello",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{},
+		},
+	})
+}
+
+func TestExternalTimingsDisabled(t *testing.T) {
+	app := testApp(nil, nil, t)
+	txn := app.StartTransaction("hello")
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := StartExternalSegment(txn, req)
+	if nil != s.timings || s.Request != req || nil != httptrace.ContextClientTrace(req.Context()) {
+		t.Error("request traced")
+	}
+	s.End()
+	txn.End()
+}
@@ -0,0 +1,357 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package fakecollector implements a fake New Relic collector, which records
+// the payloads sent by the agent and replies with scripted responses.  It
+// allows the communication of the agent with New Relic to be tested end to end
+// without internet access.
+//
+// The agent sends its data over HTTPS.  Point it to a Server using its Host
+// and Transport:
+//
+//	srv := fakecollector.NewServer()
+//	defer srv.Close()
+//	app, _ := newrelic.NewApplication(
+//		newrelic.ConfigLicense("0123456789012345678901234567890123456789"),
+//		func(cfg *newrelic.Config) {
+//			cfg.Host = srv.Host()
+//			cfg.Transport = srv.Transport()
+//		},
+//	)
+//
+// The Collector can also be scripted over HTTP, using the endpoints under
+// ControlPath, which is how the nrfakecollector command is used.
+package fakecollector
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Methods of the collector protocol.
+const (
+	MethodPreconnect   = "preconnect"
+	MethodConnect      = "connect"
+	MethodMetrics      = "metric_data"
+	MethodTxnEvents    = "analytic_event_data"
+	MethodCustomEvents = "custom_event_data"
+	MethodErrorEvents  = "error_event_data"
+	MethodSpanEvents   = "span_event_data"
+	MethodLogEvents    = "log_event_data"
+	MethodErrors       = "error_data"
+	MethodTxnTraces    = "transaction_sample_data"
+	MethodSlowSQLs     = "sql_trace_data"
+
+	MethodAgentCommands       = "get_agent_commands"
+	MethodAgentCommandResults = "agent_command_results"
+	MethodProfileData         = "profile_data"
+)
+
+const (
+	// ListenerPath is the path of the requests of the agent.
+	ListenerPath = "/agent_listener/invoke_raw_method"
+	// ControlPath is the prefix of the paths of the endpoints scripting
+	// the Collector:
+	//
+	//	GET    /fakecollector/requests[?method=M]  lists the requests received
+	//	DELETE /fakecollector/requests             forgets the requests and responses
+	//	POST   /fakecollector/responses?method=M   queues a JSON array of Response
+	//	PUT    /fakecollector/preconnect_reply     sets the preconnect reply fields
+	//	PUT    /fakecollector/connect_reply        sets the connect reply fields
+	ControlPath = "/fakecollector/"
+)
+
+// Request is a request of the agent received by the Collector.
+type Request struct {
+	Method  string
+	RunID   string
+	License string
+	Header  http.Header
+	// Body is the decompressed body of the request.
+	Body []byte
+}
+
+// Unmarshal decodes the JSON payload of the request into v.
+func (r Request) Unmarshal(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// MarshalJSON is used by the control endpoints.  The body is written as JSON
+// when it is valid, and as a string otherwise.
+func (r Request) MarshalJSON() ([]byte, error) {
+	var body interface{} = string(r.Body)
+	if json.Valid(r.Body) {
+		body = json.RawMessage(r.Body)
+	}
+	return json.Marshal(struct {
+		Method  string      `json:"method"`
+		RunID   string      `json:"run_id,omitempty"`
+		License string      `json:"license_key"`
+		Header  http.Header `json:"header"`
+		Body    interface{} `json:"body"`
+	}{r.Method, r.RunID, r.License, r.Header, body})
+}
+
+// Response is a scripted response of the Collector.
+type Response struct {
+	// StatusCode is the code of the response.  Zero means 200.
+	StatusCode int `json:"status_code"`
+	// Body is the body of the response.  When empty, the usual reply of
+	// the method is sent.
+	Body string `json:"body,omitempty"`
+}
+
+// Collector is an http.Handler implementing the collector protocol.  The
+// agent must have been configured to reach it over HTTPS.  Its methods are
+// safe to call from multiple goroutines.
+type Collector struct {
+	lock            sync.Mutex
+	requests        []Request
+	responses       map[string][]Response
+	preconnectReply map[string]interface{}
+	connectReply    map[string]interface{}
+	connects        int
+}
+
+// New creates a Collector.  By default, it accepts all the requests.  The
+// preconnect reply redirects the agent to the host of the request, each
+// connect reply has a new agent run ID, and there are no agent commands.
+func New() *Collector {
+	return &Collector{
+		responses: make(map[string][]Response),
+	}
+}
+
+// Respond queues responses to the method given.  Each response is used for a
+// single request, in order, after which the Collector accepts the requests
+// again.
+func (c *Collector) Respond(method string, responses ...Response) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.responses[method] = append(c.responses[method], responses...)
+}
+
+// SetPreconnectReply sets fields of the return value of the preconnect
+// replies, such as "security_policies".  The fields replace those of the
+// default reply, and a nil value removes them.
+func (c *Collector) SetPreconnectReply(fields map[string]interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.preconnectReply = fields
+}
+
+// SetConnectReply sets fields of the return value of the connect replies,
+// such as "event_harvest_config" or "metric_name_rules".  The fields replace
+// those of the default reply, and a nil value removes them.
+func (c *Collector) SetConnectReply(fields map[string]interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.connectReply = fields
+}
+
+// Requests returns the requests received, in order.
+func (c *Collector) Requests() []Request {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]Request(nil), c.requests...)
+}
+
+// RequestsFor returns the requests received for the method given, in order.
+func (c *Collector) RequestsFor(method string) []Request {
+	var requests []Request
+	for _, r := range c.Requests() {
+		if r.Method == method {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// Reset forgets the requests received and the responses queued.
+func (c *Collector) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.requests = nil
+	c.responses = make(map[string][]Response)
+}
+
+// ServeHTTP implements http.Handler.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == ListenerPath:
+		c.serveAgent(w, r)
+	case strings.HasPrefix(r.URL.Path, ControlPath):
+		c.serveControl(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if nil != err {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	case "deflate":
+		zl, err := zlib.NewReader(r.Body)
+		if nil != err {
+			return nil, err
+		}
+		defer zl.Close()
+		body = zl
+	}
+	return io.ReadAll(body)
+}
+
+func (c *Collector) serveAgent(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if nil != err {
+		http.Error(w, fmt.Sprintf("unable to read payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	req := Request{
+		Method:  query.Get("method"),
+		RunID:   query.Get("run_id"),
+		License: query.Get("license_key"),
+		Header:  r.Header.Clone(),
+		Body:    body,
+	}
+
+	c.lock.Lock()
+	c.requests = append(c.requests, req)
+	var resp Response
+	if queued := c.responses[req.Method]; len(queued) > 0 {
+		resp = queued[0]
+		c.responses[req.Method] = queued[1:]
+	}
+	reply := resp.Body
+	if reply == "" {
+		reply = c.defaultReply(req.Method, r.Host)
+	}
+	c.lock.Unlock()
+
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	io.WriteString(w, reply)
+}
+
+// defaultReply returns the usual reply to the method.  It must be called with
+// the lock held.
+func (c *Collector) defaultReply(method, host string) string {
+	var value interface{}
+	switch method {
+	case MethodPreconnect:
+		value = mergeFields(map[string]interface{}{
+			"redirect_host": host,
+		}, c.preconnectReply)
+	case MethodConnect:
+		c.connects++
+		value = mergeFields(map[string]interface{}{
+			"agent_run_id": "fakecollector-run-" + strconv.Itoa(c.connects),
+			"entity_guid":  "fakecollector-entity-guid",
+		}, c.connectReply)
+	case MethodAgentCommands:
+		value = []interface{}{}
+	}
+	js, _ := json.Marshal(map[string]interface{}{"return_value": value})
+	return string(js)
+}
+
+func mergeFields(defaults, fields map[string]interface{}) map[string]interface{} {
+	for k, v := range fields {
+		if nil == v {
+			delete(defaults, k)
+		} else {
+			defaults[k] = v
+		}
+	}
+	return defaults
+}
+
+func (c *Collector) serveControl(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, ControlPath)
+	switch {
+	case endpoint == "requests" && r.Method == http.MethodGet:
+		requests := c.Requests()
+		if method := r.URL.Query().Get("method"); method != "" {
+			requests = c.RequestsFor(method)
+		}
+		if nil == requests {
+			requests = []Request{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requests)
+	case endpoint == "requests" && r.Method == http.MethodDelete:
+		c.Reset()
+	case endpoint == "responses" && r.Method == http.MethodPost:
+		var responses []Response
+		if err := json.NewDecoder(r.Body).Decode(&responses); nil != err {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.Respond(r.URL.Query().Get("method"), responses...)
+	case (endpoint == "preconnect_reply" || endpoint == "connect_reply") && r.Method == http.MethodPut:
+		var fields map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&fields); nil != err {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if endpoint == "preconnect_reply" {
+			c.SetPreconnectReply(fields)
+		} else {
+			c.SetConnectReply(fields)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Server is a Collector listening on a local HTTPS address.
+type Server struct {
+	*Collector
+	*httptest.Server
+}
+
+// NewServer starts a Server with a new Collector.  It should be closed when
+// no longer used.
+func NewServer() *Server {
+	c := New()
+	return &Server{
+		Collector: c,
+		Server:    httptest.NewTLSServer(c),
+	}
+}
+
+// Host returns the address of the Server, to be used as the Host of the
+// configuration of the agent.
+func (s *Server) Host() string {
+	return s.Listener.Addr().String()
+}
+
+// Transport returns a transport trusting the certificate of the Server, to be
+// used as the Transport of the configuration of the agent.
+func (s *Server) Transport() http.RoundTripper {
+	return s.Client().Transport
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package fakecollector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func agentRequest(t *testing.T, h http.Handler, method, runID, payload string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(payload))
+	gz.Close()
+	url := "https://collector.example" + ListenerPath + "?marshal_format=json&protocol_version=17&license_key=key&method=" + method
+	if runID != "" {
+		url += "&run_id=" + runID
+	}
+	req := httptest.NewRequest("POST", url, &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestCollectorDefaultReplies(t *testing.T) {
+	c := New()
+	w := agentRequest(t, c, MethodPreconnect, "", `[{"high_security":false}]`)
+	if w.Code != 200 || w.Body.String() != `{"return_value":{"redirect_host":"collector.example"}}` {
+		t.Error(w.Code, w.Body.String())
+	}
+	for _, runID := range []string{"fakecollector-run-1", "fakecollector-run-2"} {
+		w = agentRequest(t, c, MethodConnect, "", `[{}]`)
+		var reply struct {
+			Value struct {
+				RunID string `json:"agent_run_id"`
+			} `json:"return_value"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || reply.Value.RunID != runID {
+			t.Error(w.Body.String(), err)
+		}
+	}
+	w = agentRequest(t, c, MethodMetrics, "fakecollector-run-2", `["fakecollector-run-2",1,2,[]]`)
+	if w.Code != 200 || w.Body.String() != `{"return_value":null}` {
+		t.Error(w.Code, w.Body.String())
+	}
+
+	requests := c.Requests()
+	if len(requests) != 4 {
+		t.Fatal(requests)
+	}
+	metrics := c.RequestsFor(MethodMetrics)
+	if len(metrics) != 1 || metrics[0].RunID != "fakecollector-run-2" || metrics[0].License != "key" {
+		t.Fatal(metrics)
+	}
+	var payload []interface{}
+	if err := metrics[0].Unmarshal(&payload); err != nil || len(payload) != 4 {
+		t.Error(payload, err)
+	}
+}
+
+func TestCollectorScriptedResponses(t *testing.T) {
+	c := New()
+	c.Respond(MethodSpanEvents, Response{StatusCode: 503}, Response{StatusCode: 413, Body: "too large"})
+	c.SetConnectReply(map[string]interface{}{
+		"entity_guid": nil,
+		"event_harvest_config": map[string]interface{}{
+			"report_period_ms": 5000,
+		},
+	})
+	c.SetPreconnectReply(map[string]interface{}{
+		"redirect_host": "other.example",
+	})
+
+	for _, code := range []int{503, 413, 200} {
+		if w := agentRequest(t, c, MethodSpanEvents, "run", `[]`); w.Code != code {
+			t.Error(w.Code, code)
+		}
+	}
+	if w := agentRequest(t, c, MethodPreconnect, "", `[]`); w.Body.String() != `{"return_value":{"redirect_host":"other.example"}}` {
+		t.Error(w.Body.String())
+	}
+	w := agentRequest(t, c, MethodConnect, "", `[]`)
+	if w.Body.String() != `{"return_value":{"agent_run_id":"fakecollector-run-1","event_harvest_config":{"report_period_ms":5000}}}` {
+		t.Error(w.Body.String())
+	}
+
+	c.Respond(MethodMetrics, Response{StatusCode: 401})
+	c.Reset()
+	if w := agentRequest(t, c, MethodMetrics, "run", `[]`); w.Code != 200 {
+		t.Error(w.Code)
+	}
+	if requests := c.Requests(); len(requests) != 1 {
+		t.Error(requests)
+	}
+}
+
+func TestCollectorControlEndpoints(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	control := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+ControlPath+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := control("POST", "responses?method=metric_data", `[{"status_code":409}]`); resp.StatusCode != 200 {
+		t.Error(resp.StatusCode)
+	}
+	if resp := control("PUT", "connect_reply", `{"agent_run_id":"scripted"}`); resp.StatusCode != 200 {
+		t.Error(resp.StatusCode)
+	}
+	if resp := control("PUT", "connect_reply", `not json`); resp.StatusCode != 400 {
+		t.Error(resp.StatusCode)
+	}
+	if w := agentRequest(t, srv.Collector, MethodMetrics, "run", `["run"]`); w.Code != 409 {
+		t.Error(w.Code)
+	}
+	if w := agentRequest(t, srv.Collector, MethodConnect, "", `[]`); !strings.Contains(w.Body.String(), `"scripted"`) {
+		t.Error(w.Body.String())
+	}
+	agentRequest(t, srv.Collector, MethodErrors, "run", `not json`)
+
+	resp := control("GET", "requests?method=metric_data", "")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	var requests []struct {
+		Method string          `json:"method"`
+		RunID  string          `json:"run_id"`
+		Body   json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(body, &requests); err != nil || len(requests) != 1 {
+		t.Fatal(string(body), err)
+	}
+	if requests[0].Method != MethodMetrics || requests[0].RunID != "run" || string(requests[0].Body) != `["run"]` {
+		t.Error(requests[0])
+	}
+	resp = control("GET", "requests?method=error_data", "")
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"body":"not json"`) {
+		t.Error(string(body))
+	}
+
+	control("DELETE", "requests", "")
+	if requests := srv.Requests(); len(requests) != 0 {
+		t.Error(requests)
+	}
+	if resp := control("GET", "unknown", ""); resp.StatusCode != 404 {
+		t.Error(resp.StatusCode)
+	}
+}
@@ -57,6 +57,13 @@ func (timer *harvestTimer) ready(now time.Time) (ready harvestTypes) {
 	return
 }
 
+// reset restarts the report period of every harvest type at now.
+func (timer *harvestTimer) reset(now time.Time) {
+	for tp := range timer.periods {
+		timer.lastHarvest[tp] = now
+	}
+}
+
 // harvest contains collected data.
 type harvest struct {
 	timer *harvestTimer
@@ -81,12 +88,24 @@ const (
 // Ready returns a new harvest which contains the data types ready for harvest,
 // or nil if no data is ready for harvest.
 func (h *harvest) Ready(now time.Time) *harvest {
-	ready := &harvest{}
-
 	types := h.timer.ready(now)
 	if 0 == types {
 		return nil
 	}
+	return h.swap(types, now)
+}
+
+// Flush returns a new harvest which contains all of the data types regardless
+// of their report periods, and restarts the report periods.
+func (h *harvest) Flush(now time.Time) *harvest {
+	h.timer.reset(now)
+	return h.swap(harvestTypesAll, now)
+}
+
+// swap moves the data of the types given into a new harvest and replaces it
+// with empty data.
+func (h *harvest) swap(types harvestTypes, now time.Time) *harvest {
+	ready := &harvest{}
 
 	if 0 != types&harvestCustomEvents {
 		h.Metrics.addCount(customEventsSeen, h.CustomEvents.NumSeen(), forced)
@@ -263,6 +282,40 @@ type payloadCreator interface {
 	EndpointMethod() string
 }
 
+// splittablePayload is a payloadCreator whose events can be divided between
+// two payloads when the collector rejects it as too large.
+type splittablePayload interface {
+	payloadCreator
+	// splitPayload returns two payloads holding half of the events each,
+	// or nils if there are too few events to split.
+	splitPayload() (payloadCreator, payloadCreator)
+}
+
+// payloadTooLarge records the supportability metrics of a payload rejected
+// by the collector for its size.  They are merged into the next harvest.
+type payloadTooLarge struct {
+	cmd   string
+	split bool
+}
+
+func (p payloadTooLarge) MergeIntoHarvest(h *harvest) {
+	h.Metrics.addSingleCount(supportPayloadSizeLimit(p.cmd), forced)
+	if p.split {
+		h.Metrics.addSingleCount(supportPayloadSplit(p.cmd), forced)
+	}
+}
+
+// collectorCallDuration records the duration of a request to the collector.
+// It is merged into the next harvest.
+type collectorCallDuration struct {
+	cmd      string
+	duration time.Duration
+}
+
+func (d collectorCallDuration) MergeIntoHarvest(h *harvest) {
+	h.Metrics.addDuration(supportCollectorDuration(d.cmd), "", d.duration, d.duration, forced)
+}
+
 // createTxnMetrics creates metrics for a transaction.
 func createTxnMetrics(args *txnData, metrics *metricTable) {
 	withoutFirstSegment := removeFirstSegment(args.FinalName)
@@ -264,6 +264,41 @@ func TestHarvestNothingReady(t *testing.T) {
 	expectMetrics(t, h.Metrics, []internal.WantMetric{})
 }
 
+func TestHarvestFlush(t *testing.T) {
+	now := time.Now()
+	h := newHarvest(now, testHarvestCfgr)
+	ce, _ := createCustomEvent("myEvent", map[string]interface{}{"zip": 1}, now)
+	h.CustomEvents.Add(ce)
+	h.Metrics.addCount("myMetric", 1, forced)
+
+	flushed := h.Flush(now.Add(10 * time.Second))
+	if flushed.Metrics == nil || flushed.ErrorTraces == nil || flushed.TxnTraces == nil ||
+		flushed.SlowSQLs == nil || flushed.SpanEvents == nil || flushed.CustomEvents == nil ||
+		flushed.LogEvents == nil || flushed.TxnEvents == nil || flushed.ErrorEvents == nil {
+		t.Fatal("flushed harvest is missing data types", flushed)
+	}
+	if n := flushed.CustomEvents.NumSaved(); n != 1 {
+		t.Error(n)
+	}
+	if n := h.CustomEvents.NumSaved(); n != 0 {
+		t.Error(n)
+	}
+	// The event supportability metrics are part of the flushed metrics.
+	for _, name := range []string{"myMetric", customEventsSent, txnEventsSent, errorEventsSent, spanEventsSent} {
+		if _, ok := flushed.Metrics.metrics[metricID{Name: name}]; !ok {
+			t.Error("missing metric", name)
+		}
+	}
+
+	// The report periods restart at the flush.
+	if ready := h.Ready(now.Add(61 * time.Second)); ready != nil {
+		t.Error("harvest should not be ready", ready)
+	}
+	if ready := h.Ready(now.Add(71 * time.Second)); ready == nil {
+		t.Error("harvest should be ready")
+	}
+}
+
 func TestHarvestCustomEventsReady(t *testing.T) {
 	now := time.Now()
 	fixedHarvestTypes := harvestMetricsTraces & harvestTxnEvents & harvestSpanEvents & harvestErrorEvents
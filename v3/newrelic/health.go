@@ -0,0 +1,210 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// healthStatus is a status of the agent reported to Agent Control.  The codes
+// from NR-APM-000 to NR-APM-099 are shared by the agents of all languages, the
+// codes from NR-APM-300 are specific to the Go agent.
+type healthStatus struct {
+	code    string
+	message string
+}
+
+var (
+	healthHealthy          = healthStatus{"NR-APM-000", "Healthy"}
+	healthInvalidLicense   = healthStatus{"NR-APM-001", "Invalid license key (HTTP status code 401)"}
+	healthMissingLicense   = healthStatus{"NR-APM-002", "License key missing in configuration"}
+	healthForcedDisconnect = healthStatus{"NR-APM-003", "Forced disconnect received from New Relic (HTTP status code 410)"}
+	healthMissingAppName   = healthStatus{"NR-APM-005", "Missing application name in agent configuration"}
+	healthAgentDisabled    = healthStatus{"NR-APM-008", "Agent is disabled via configuration"}
+	healthConnectFailure   = healthStatus{"NR-APM-009", "Failed to connect to New Relic data collector"}
+	healthShutdown         = healthStatus{"NR-APM-099", "Agent has shutdown"}
+	healthSecurityPolicies = healthStatus{"NR-APM-300", "Security policies of the configuration do not match those of the account"}
+)
+
+func healthHTTPError(statusCode int, cmd string) healthStatus {
+	return healthStatus{"NR-APM-004", fmt.Sprintf("HTTP error response code [%d] received from New Relic while sending data type [%s]", statusCode, cmd)}
+}
+
+func healthMaxPayloadSizeExceeded(cmd string) healthStatus {
+	return healthStatus{"NR-APM-301", fmt.Sprintf("Payload of data type [%s] exceeds the maximum payload size", cmd)}
+}
+
+// isHealthy reports whether the status is the healthy one.
+func (s healthStatus) isHealthy() bool {
+	return s.code == healthHealthy.code
+}
+
+// isFinal reports whether the agent can no longer recover from the status.
+func (s healthStatus) isFinal() bool {
+	switch s.code {
+	case healthForcedDisconnect.code, healthShutdown.code, healthSecurityPolicies.code,
+		healthMissingLicense.code, healthMissingAppName.code, healthAgentDisabled.code:
+		return true
+	}
+	return false
+}
+
+// healthStatusFromResponse returns the status of the agent after a request to
+// the collector.
+func healthStatusFromResponse(cmd string, resp *rpmResponse) healthStatus {
+	switch {
+	case resp.disconnectSecurityPolicy:
+		return healthSecurityPolicies
+	case resp.statusCode == 401:
+		return healthInvalidLicense
+	case resp.statusCode == 410:
+		return healthForcedDisconnect
+	case resp.IsPayloadTooLarge():
+		return healthMaxPayloadSizeExceeded(cmd)
+	case nil == resp.GetError():
+		return healthHealthy
+	case resp.statusCode != 0:
+		return healthHTTPError(resp.statusCode, cmd)
+	default:
+		return healthConnectFailure
+	}
+}
+
+// healthStatusFromConfigError returns the status of the agent when the
+// configuration is invalid, if the error is reported to Agent Control.
+func healthStatusFromConfigError(c Config, err error) (healthStatus, bool) {
+	switch {
+	case err == errAppNameMissing:
+		return healthMissingAppName, true
+	case err == errLicenseLen && "" == c.License:
+		return healthMissingLicense, true
+	case err == errLicenseLen:
+		return healthInvalidLicense, true
+	}
+	return healthStatus{}, false
+}
+
+// healthReporter writes the health file read by Agent Control.  A nil
+// healthReporter, used when Agent Control is disabled, does nothing.
+type healthReporter struct {
+	sync.Mutex
+	path      string
+	frequency time.Duration
+	logger    Logger
+	startTime time.Time
+	status    healthStatus
+}
+
+// newHealthReporter returns the health reporter of the configuration, or nil
+// if Agent Control is not enabled.
+func newHealthReporter(c Config) *healthReporter {
+	if !c.AgentControl.Enabled {
+		return nil
+	}
+	dir := c.AgentControl.Health.DeliveryLocation
+	if u, err := url.Parse(dir); nil == err && u.Scheme == "file" {
+		dir = u.Path
+	}
+	frequency := c.AgentControl.Health.Frequency
+	if frequency <= 0 {
+		frequency = defaultConfig().AgentControl.Health.Frequency
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &healthReporter{
+		path:      filepath.Join(dir, "health-"+hex.EncodeToString(id)+".yml"),
+		frequency: frequency,
+		logger:    c.Logger,
+		startTime: time.Now(),
+		status:    healthHealthy,
+	}
+}
+
+// set changes the status of the agent, unless it can no longer recover.
+func (hr *healthReporter) set(s healthStatus) {
+	if nil == hr {
+		return
+	}
+	hr.Lock()
+	defer hr.Unlock()
+	if !hr.status.isFinal() {
+		hr.status = s
+	}
+}
+
+// getStatus returns the current status of the agent.
+func (hr *healthReporter) getStatus() healthStatus {
+	hr.Lock()
+	defer hr.Unlock()
+	return hr.status
+}
+
+func (hr *healthReporter) marshal(now time.Time) []byte {
+	status := hr.getStatus()
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "healthy: %t\n", status.isHealthy())
+	fmt.Fprintf(buf, "status: %s\n", strconv.Quote(status.message))
+	if !status.isHealthy() {
+		fmt.Fprintf(buf, "last_error: %s\n", status.code)
+	}
+	fmt.Fprintf(buf, "start_time_unix_nano: %d\n", hr.startTime.UnixNano())
+	fmt.Fprintf(buf, "status_time_unix_nano: %d\n", now.UnixNano())
+	return buf.Bytes()
+}
+
+// write writes the health file.  The file is replaced atomically so that
+// Agent Control never reads a partial file.
+func (hr *healthReporter) write() {
+	if nil == hr {
+		return
+	}
+	err := func() error {
+		tmp, err := os.CreateTemp(filepath.Dir(hr.path), ".health-*.tmp")
+		if nil != err {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(hr.marshal(time.Now())); nil != err {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); nil != err {
+			return err
+		}
+		return os.Rename(tmp.Name(), hr.path)
+	}()
+	if nil != err && nil != hr.logger {
+		hr.logger.Warn("unable to write agent control health file", map[string]interface{}{
+			"path":  hr.path,
+			"error": err.Error(),
+		})
+	}
+}
+
+// run writes the health file periodically until done is closed.
+func (hr *healthReporter) run(done <-chan struct{}) {
+	if nil == hr {
+		return
+	}
+	hr.write()
+	ticker := time.NewTicker(hr.frequency)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			hr.write()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/newrelic/fakecollector"
+)
+
+func TestHealthStatusFromResponse(t *testing.T) {
+	testcases := []struct {
+		resp *rpmResponse
+		code string
+	}{
+		{resp: newRPMResponse(nil).AddStatusCode(200), code: "NR-APM-000"},
+		{resp: newRPMResponse(nil).AddStatusCode(401), code: "NR-APM-001"},
+		{resp: newRPMResponse(nil).AddStatusCode(410), code: "NR-APM-003"},
+		{resp: newRPMResponse(nil).AddStatusCode(503), code: "NR-APM-004"},
+		{resp: newRPMResponse(errors.New("dial tcp: connection refused")), code: "NR-APM-009"},
+		{resp: newRPMResponse(errors.New("security policies")).DisconnectSecurityPolicy(), code: "NR-APM-300"},
+		{resp: newRPMResponse(nil).AddStatusCode(413), code: "NR-APM-301"},
+	}
+	for _, tc := range testcases {
+		if s := healthStatusFromResponse(cmdSpanEvents, tc.resp); s.code != tc.code {
+			t.Error(tc.resp.statusCode, s, tc.code)
+		}
+	}
+	s := healthStatusFromResponse(cmdSpanEvents, newRPMResponse(nil).AddStatusCode(503))
+	if s.message != "HTTP error response code [503] received from New Relic while sending data type [span_event_data]" {
+		t.Error(s.message)
+	}
+}
+
+func TestHealthReporter(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.AgentControl.Enabled = true
+	cfg.AgentControl.Health.DeliveryLocation = "file:///newrelic/apm/health"
+	hr := newHealthReporter(cfg)
+	if dir := filepath.Dir(hr.path); dir != "/newrelic/apm/health" {
+		t.Error(dir)
+	}
+	if name := filepath.Base(hr.path); !strings.HasPrefix(name, "health-") || !strings.HasSuffix(name, ".yml") {
+		t.Error(name)
+	}
+
+	hr.startTime = time.Unix(1, 0)
+	if js := string(hr.marshal(time.Unix(2, 0))); js != "healthy: true\n"+
+		"status: \"Healthy\"\n"+
+		"start_time_unix_nano: 1000000000\n"+
+		"status_time_unix_nano: 2000000000\n" {
+		t.Error(js)
+	}
+	hr.set(healthInvalidLicense)
+	if js := string(hr.marshal(time.Unix(2, 0))); !strings.Contains(js, "healthy: false\n") ||
+		!strings.Contains(js, "last_error: NR-APM-001\n") {
+		t.Error(js)
+	}
+	// The agent recovers from an invalid license once connected, but
+	// never from a forced disconnect.
+	hr.set(healthHealthy)
+	hr.set(healthForcedDisconnect)
+	hr.set(healthHealthy)
+	if s := hr.getStatus(); s != healthForcedDisconnect {
+		t.Error(s)
+	}
+
+	cfg.AgentControl.Enabled = false
+	if hr := newHealthReporter(cfg); nil != hr {
+		t.Error(hr)
+	}
+}
+
+func agentControlConfig(dir string) ConfigOption {
+	return func(cfg *Config) {
+		cfg.AgentControl.Enabled = true
+		cfg.AgentControl.Health.DeliveryLocation = dir
+		cfg.AgentControl.Health.Frequency = 10 * time.Millisecond
+	}
+}
+
+// readHealthFile returns the content of the single health file written to
+// the directory.
+func readHealthFile(t *testing.T, dir string) string {
+	files, err := filepath.Glob(filepath.Join(dir, "health-*.yml"))
+	if nil != err || len(files) != 1 {
+		t.Fatal(files, err)
+	}
+	content, err := os.ReadFile(files[0])
+	if nil != err {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestHealthFileConnectedAndShutdown(t *testing.T) {
+	dir := t.TempDir()
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv, agentControlConfig(dir))
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if content := readHealthFile(t, dir); !strings.Contains(content, "healthy: true\n") {
+		t.Error(content)
+	}
+
+	app.Shutdown(10 * time.Second)
+	if content := readHealthFile(t, dir); !strings.Contains(content, "last_error: NR-APM-099\n") {
+		t.Error(content)
+	}
+}
+
+func TestHealthFileForcedDisconnect(t *testing.T) {
+	dir := t.TempDir()
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv, agentControlConfig(dir))
+	srv.Respond(fakecollector.MethodMetrics, fakecollector.Response{StatusCode: 410})
+	if err := app.Flush(context.Background()); err != errHarvestRunOver {
+		t.Fatal(err)
+	}
+
+	app.Shutdown(10 * time.Second)
+	content := readHealthFile(t, dir)
+	if !strings.Contains(content, "healthy: false\n") || !strings.Contains(content, "last_error: NR-APM-003\n") {
+		t.Error(content)
+	}
+}
+
+func TestHealthFileHTTPError(t *testing.T) {
+	dir := t.TempDir()
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv, agentControlConfig(dir))
+	srv.Respond(fakecollector.MethodMetrics, fakecollector.Response{StatusCode: 503})
+	if err := app.Flush(context.Background()); !errors.Is(err, errHarvestFailed) {
+		t.Fatal(err)
+	}
+	if s := app.app.health.getStatus(); s.code != "NR-APM-004" || !strings.Contains(s.message, "[metric_data]") {
+		t.Error(s)
+	}
+	// The next successful harvest recovers.
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if s := app.app.health.getStatus(); s != healthHealthy {
+		t.Error(s)
+	}
+}
+
+func TestHealthFileInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewApplication(ConfigLicense(testLicenseKey), agentControlConfig(dir))
+	if err != errAppNameMissing {
+		t.Fatal(err)
+	}
+	if content := readHealthFile(t, dir); !strings.Contains(content, "last_error: NR-APM-005\n") {
+		t.Error(content)
+	}
+
+	dir = t.TempDir()
+	if _, err := NewApplication(ConfigAppName("my app"), ConfigEnabled(false), agentControlConfig(dir)); nil != err {
+		t.Fatal(err)
+	}
+	if content := readHealthFile(t, dir); !strings.Contains(content, "last_error: NR-APM-008\n") {
+		t.Error(content)
+	}
+}
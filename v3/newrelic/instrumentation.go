@@ -49,26 +49,7 @@ func WrapHandle(app *Application, pattern string, handler http.Handler, options
 	}
 
 	return pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var tOptions *traceOptSet
-		var txnOptionList []TraceOption
-
-		if app.app != nil && app.app.run != nil && app.app.run.Config.CodeLevelMetrics.Enabled {
-			tOptions = resolveCLMTraceOptions(options)
-			if tOptions != nil && !tOptions.SuppressCLM && (tOptions.DemandCLM || app.app.run.Config.CodeLevelMetrics.Scope == 0 || (app.app.run.Config.CodeLevelMetrics.Scope&TransactionCLM) != 0) {
-				// we are for sure collecting CLM here, so go to the trouble of collecting this code location if nothing else has yet.
-				if tOptions.LocationOverride == nil {
-					if loc, err := cache.FunctionLocation(handler, handler.ServeHTTP); err == nil {
-						WithCodeLocation(loc)(tOptions)
-					}
-				}
-			}
-		}
-		if tOptions == nil {
-			// we weren't able to curate the options above, so pass whatever we were given downstream
-			txnOptionList = options
-		} else {
-			txnOptionList = append(txnOptionList, withPreparedOptions(tOptions))
-		}
+		txnOptionList := handlerTraceOptions(app, cache, handler, options)
 
 		txn := app.StartTransaction(r.Method+" "+pattern, txnOptionList...)
 		defer txn.End()
@@ -78,10 +59,46 @@ func WrapHandle(app *Application, pattern string, handler http.Handler, options
 
 		r = RequestWithTransactionContext(r, txn)
 
-		handler.ServeHTTP(w, r)
+		serveWithProfilerLabels(txn, txn.Name, handler, w, r)
 	})
 }
 
+// handlerTraceOptions adds the code location of the handler to the trace
+// options if code level metrics are collected for the transaction.
+func handlerTraceOptions(app *Application, cache *CachedCodeLocation, handler http.Handler, options []TraceOption) []TraceOption {
+	var tOptions *traceOptSet
+	var txnOptionList []TraceOption
+
+	if run := handlerAppRun(app); run != nil && run.Config.CodeLevelMetrics.Enabled {
+		tOptions = resolveCLMTraceOptions(options)
+		if tOptions != nil && !tOptions.SuppressCLM && (tOptions.DemandCLM || run.Config.CodeLevelMetrics.Scope == 0 || (run.Config.CodeLevelMetrics.Scope&TransactionCLM) != 0) {
+			// we are for sure collecting CLM here, so go to the trouble of collecting this code location if nothing else has yet.
+			if tOptions.LocationOverride == nil {
+				if loc, err := cache.FunctionLocation(handler, handler.ServeHTTP); err == nil {
+					WithCodeLocation(loc)(tOptions)
+				}
+			}
+		}
+	}
+	if tOptions == nil {
+		// we weren't able to curate the options above, so pass whatever we were given downstream
+		txnOptionList = options
+	} else {
+		txnOptionList = append(txnOptionList, withPreparedOptions(tOptions))
+	}
+
+	return txnOptionList
+}
+
+// handlerAppRun returns the current run of the application, if any.
+func handlerAppRun(app *Application) *appRun {
+	if app == nil || app.app == nil {
+		return nil
+	}
+	run, _ := app.app.getState()
+	return run
+}
+
 // AddCodeLevelMetricsTraceOptions adds trace options to an existing slice of TraceOption objects depending on how code level metrics is configured
 // in your application.
 // Please call cache:=newrelic.NewCachedCodeLocation() before calling this function, and pass the cache to us in order to allow you to optimize the
@@ -181,7 +198,9 @@ func NewRoundTripper(original http.RoundTripper) http.RoundTripper {
 		request = cloneRequest(request)
 		segment := StartExternalSegment(nil, request)
 
-		response, err := original.RoundTrip(request)
+		// The Request of the segment records the phases of the request
+		// when Config.ExternalTimings is enabled.
+		response, err := original.RoundTrip(segment.Request)
 
 		segment.Response = response
 		segment.End()
@@ -5,6 +5,7 @@ package newrelic
 
 import (
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/newrelic/go-agent/v3/internal"
@@ -28,6 +30,9 @@ type app struct {
 	config      config
 	rpmControls rpmControls
 	testHarvest *harvest
+	// testHarvestLock protects the test harvest from the transactions
+	// ending in other goroutines.
+	testHarvestLock sync.Mutex
 
 	trObserver traceObserver
 
@@ -52,6 +57,50 @@ type app struct {
 	dataChan           chan appData
 	collectorErrorChan chan rpmResponse
 	connectChan        chan *appRun
+	// reconnectChan is used once the final harvest of a run replaced by a
+	// configuration update is posted, unless it ended the run, so that the
+	// processor connects again.
+	reconnectChan chan struct{}
+	// flushChan is used by Flush to request an immediate harvest.  The
+	// processor sends the outcome of the flush on the channel received,
+	// which must be buffered.
+	flushChan chan chan error
+	// reservoirsChan is used by DiagnosticsHandler to get the fill levels
+	// of the reservoirs of the current harvest, which the processor sends
+	// on the channel received, which must be buffered.
+	reservoirsChan chan chan map[string]reservoirDiagnostics
+	// configChan is used by UpdateConfig to apply a configuration change.
+	configChan chan configUpdate
+	// updateConfigLock serializes the calls of UpdateConfig.
+	updateConfigLock sync.Mutex
+	// destinationChan is used to tell the processor that a destination
+	// connected or that its run is over.
+	destinationChan chan destinationRun
+
+	// destinations are the accounts reported to in addition to the
+	// account of the application.
+	destinations []*destination
+
+	// harvestResults records the outcome of the harvests for
+	// DiagnosticsHandler.
+	harvestResults harvestResults
+	// diagnosticsServer serves DiagnosticsHandler when
+	// Config.Diagnostics.ListenAddress is set.
+	diagnosticsServer *http.Server
+	// health reports the status of the agent to Agent Control.  It is nil
+	// unless Config.AgentControl.Enabled is set.
+	health *healthReporter
+	// profiler runs the goroutine profiles requested by the agent
+	// commands.
+	profiler goroutineProfiler
+
+	// liveTxns tracks the running transactions to report those leaked.
+	// It is nil unless Config.LeakedTransactions.Enabled is set.
+	liveTxns *liveTransactions
+
+	// explainPlans limits the number of slow queries explained between
+	// two harvests.
+	explainPlans explainPlanBudget
 
 	// This mutex protects both `run` and `err`, both of which should only
 	// be accessed using getState and setState.
@@ -62,6 +111,10 @@ type app struct {
 	// err is non-nil if the application will never be connected again
 	// (disconnect, license exception, shutdown).
 	err error
+	// updatedConfig is the configuration changed by UpdateConfig, if
+	// any, which is used instead of config to connect and create runs.
+	// It is also protected by this mutex.
+	updatedConfig *config
 
 	// registered callback functions
 	llmTokenCountCallback func(string, string) int
@@ -69,80 +122,262 @@ type app struct {
 	serverless *serverlessHarvest
 }
 
-func (app *app) doHarvest(h *harvest, harvestStart time.Time, run *appRun) {
-	h.CreateFinalMetrics(run, app.getObserver())
+// harvestPosting contains the state shared by the goroutines posting the
+// payloads of a harvest.
+type harvestPosting struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	run          *appRun
+	harvestStart time.Time
+	// controls are used to post to the account of the run.
+	controls rpmControls
+	// dest is the destination posted to, or nil for the account of the
+	// application.
+	dest *destination
+	// runOver reports the end of the run once, however many requests
+	// fail.
+	runOver sync.Once
+	// failed is set when a request of the harvest fails.
+	failed int32
+}
+
+func (app *app) doHarvest(h *harvest, harvestStart time.Time, run *appRun) error {
+	return app.postHarvest(h, &harvestPosting{
+		run:          run,
+		harvestStart: harvestStart,
+		controls:     app.rpmControls,
+	})
+}
+
+// postHarvest posts the payloads of the harvest to the account of the run.
+// errHarvestRunOver is returned if a response ended the run, and an error
+// wrapping errHarvestFailed if some of the data could not be sent.
+func (app *app) postHarvest(h *harvest, hp *harvestPosting) error {
+	var observer traceObserver
+	if nil == hp.dest {
+		observer = app.getObserver()
+	}
+	h.CreateFinalMetrics(hp.run, observer)
 
 	payloads := h.Payloads(app.config.DistributedTracer.Enabled)
-	for _, p := range payloads {
-		cmd := p.EndpointMethod()
-		var data []byte
 
+	if timeout := app.config.Harvest.Timeout; timeout > 0 {
+		hp.ctx, hp.cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		hp.ctx, hp.cancel = context.WithCancel(context.Background())
+	}
+	defer hp.cancel()
+	if nil == hp.dest {
 		defer func() {
-			if r := recover(); r != nil {
-				app.Warn("panic occured when creating harvest data", map[string]interface{}{
-					"cmd":   cmd,
-					"panic": r,
-				})
-
-				// make sure the loop continues
-				data = nil
-			}
+			app.harvestResults.recordHarvest(hp.harvestStart, time.Now())
 		}()
+	}
 
-		data, err := p.Data(run.Reply.RunID.String(), harvestStart)
-
-		if err != nil {
-			app.Warn("unable to create harvest data", map[string]interface{}{
-				"cmd":   cmd,
-				"error": err.Error(),
+	workers := app.config.Harvest.MaxConcurrentRequests
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for _, p := range payloads {
+		select {
+		case sem <- struct{}{}:
+		case <-hp.ctx.Done():
+		}
+		switch hp.ctx.Err() {
+		case context.Canceled:
+			// The run is over: the rest of the harvest is abandoned.
+			continue
+		case context.DeadlineExceeded:
+			// The data is kept for the next harvest.
+			app.Warn("harvest timeout: payload not sent", map[string]interface{}{
+				"cmd": p.EndpointMethod(),
 			})
+			app.consumePosted(hp, p)
 			continue
 		}
-		if data == nil {
-			continue
+		wg.Add(1)
+		go func(p payloadCreator) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			app.sendPayload(hp, p)
+		}(p)
+	}
+	wg.Wait()
+
+	if nil == hp.dest {
+		// Agent commands are polled at each metric harvest.
+		if nil != h.Metrics && nil == hp.ctx.Err() && !isChanClosed(app.shutdownStarted) {
+			app.pollAgentCommands(hp)
 		}
 
-		call := rpmCmd{
-			Collector:         run.Reply.Collector,
-			RunID:             run.Reply.RunID.String(),
-			Name:              cmd,
-			Data:              data,
-			RequestHeadersMap: run.Reply.RequestHeadersMap,
-			MaxPayloadSize:    run.Reply.MaxPayloadSizeInBytes,
+		if 0 == atomic.LoadInt32(&hp.failed) && nil == hp.ctx.Err() {
+			app.health.set(healthHealthy)
 		}
+	}
+
+	switch err := hp.ctx.Err(); {
+	case err == context.Canceled:
+		// The context is only canceled by isRunOver before returning.
+		return errHarvestRunOver
+	case nil != err:
+		return fmt.Errorf("%w: %v", errHarvestFailed, err)
+	case 0 != atomic.LoadInt32(&hp.failed):
+		return errHarvestFailed
+	}
+	return nil
+}
 
-		resp := collectorRequest(call, app.rpmControls)
+// consumePosted records data of the posting, such as the payloads to retry,
+// into the harvest of the run.  The data of the destinations is dropped.
+func (app *app) consumePosted(hp *harvestPosting, data harvestable) {
+	if nil == hp.dest {
+		app.Consume(hp.run.Reply.RunID, data)
+	}
+}
 
-		if resp.IsDisconnect() || resp.IsRestartException() {
-			select {
-			case app.collectorErrorChan <- *resp:
-			case <-app.shutdownStarted:
-			}
+// harvestRequest posts data to the collector during a harvest, and records
+// the outcome of the request.
+func (app *app) harvestRequest(hp *harvestPosting, cmd string, data []byte) *rpmResponse {
+	run := hp.run
+	call := rpmCmd{
+		Collector:         run.Reply.Collector,
+		RunID:             run.Reply.RunID.String(),
+		Name:              cmd,
+		Data:              data,
+		RequestHeadersMap: run.Reply.RequestHeadersMap,
+		MaxPayloadSize:    run.Reply.MaxPayloadSizeInBytes,
+		Context:           hp.ctx,
+	}
+	if timeout := app.config.Harvest.RequestTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		call.Context, cancel = context.WithTimeout(hp.ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	resp := collectorRequest(call, hp.controls)
+	// The payloads too large are split and sent again when possible.
+	if nil != resp.GetError() && !resp.IsPayloadTooLarge() {
+		atomic.StoreInt32(&hp.failed, 1)
+	}
+	if nil != hp.dest {
+		return resp
+	}
+	app.harvestResults.recordEndpoint(cmd, start, resp)
+	if nil != resp.GetError() {
+		app.health.set(healthStatusFromResponse(cmd, resp))
+	}
+	if !resp.payloadTooLarge {
+		app.Consume(run.Reply.RunID, collectorCallDuration{cmd: cmd, duration: time.Since(start)})
+	}
+	return resp
+}
+
+// isRunOver reports whether the response ends the run, in which case the other
+// requests of the harvest are canceled and the processor is told once.
+func (app *app) isRunOver(hp *harvestPosting, resp *rpmResponse) bool {
+	if !resp.IsDisconnect() && !resp.IsRestartException() {
+		return false
+	}
+	hp.runOver.Do(func() {
+		hp.cancel()
+		if nil != hp.dest {
+			app.sendDestinationRun(destinationRun{dest: hp.dest, ended: hp.run, resp: resp})
 			return
 		}
+		select {
+		case app.collectorErrorChan <- *resp:
+		case <-app.shutdownStarted:
+		}
+	})
+	return true
+}
 
-		if resp.GetError() != nil {
-			app.Warn("harvest failure", map[string]interface{}{
-				"cmd":         cmd,
-				"error":       resp.GetError().Error(),
-				"retain_data": resp.ShouldSaveHarvestData(),
+// sendPayload posts a payload to the collector.  Payloads rejected for their
+// size are split in two and each half is sent in turn.  false is returned if
+// the harvest must be abandoned because the run is over, in which case the
+// other requests of the harvest are canceled.
+func (app *app) sendPayload(hp *harvestPosting, p payloadCreator) (ok bool) {
+	cmd := p.EndpointMethod()
+	run := hp.run
+
+	defer func() {
+		if r := recover(); r != nil {
+			app.Warn("panic occured when creating harvest data", map[string]interface{}{
+				"cmd":   cmd,
+				"panic": r,
 			})
+
+			// make sure the harvest continues
+			ok = true
 		}
+	}()
+
+	data, err := p.Data(run.Reply.RunID.String(), hp.harvestStart)
 
-		if resp.ShouldSaveHarvestData() {
-			app.Consume(run.Reply.RunID, p)
+	if err != nil {
+		app.Warn("unable to create harvest data", map[string]interface{}{
+			"cmd":   cmd,
+			"error": err.Error(),
+		})
+		return true
+	}
+	if data == nil {
+		return true
+	}
+
+	resp := app.harvestRequest(hp, cmd, data)
+	if app.isRunOver(hp, resp) {
+		return false
+	}
+
+	if resp.IsPayloadTooLarge() {
+		var p1, p2 payloadCreator
+		if s, isSplittable := p.(splittablePayload); isSplittable {
+			p1, p2 = s.splitPayload()
+		}
+		app.consumePosted(hp, payloadTooLarge{cmd: cmd, split: nil != p1})
+		if nil == p1 {
+			atomic.StoreInt32(&hp.failed, 1)
+			app.Warn("harvest failure: payload too large", map[string]interface{}{
+				"cmd":   cmd,
+				"error": resp.GetError().Error(),
+			})
+			return true
 		}
+		app.Debug("payload too large, splitting", map[string]interface{}{
+			"cmd": cmd,
+		})
+		return app.sendPayload(hp, p1) && app.sendPayload(hp, p2)
+	}
+
+	if resp.GetError() != nil {
+		app.Warn("harvest failure", map[string]interface{}{
+			"cmd":         cmd,
+			"error":       resp.GetError().Error(),
+			"retain_data": resp.ShouldSaveHarvestData(),
+		})
 	}
+
+	if resp.ShouldSaveHarvestData() {
+		app.consumePosted(hp, p)
+	}
+	return true
 }
 
 func (app *app) connectRoutine() {
 	attempts := 0
 	for {
-		reply, resp := connectAttempt(app.config, app.rpmControls)
+		cfg := app.currentConfig()
+		reply, resp := connectAttempt(cfg, app.rpmControls)
+		app.health.set(healthStatusFromResponse(cmdConnect, resp))
 
 		if reply != nil {
 			select {
-			case app.connectChan <- newAppRun(app.config, reply):
+			case app.connectChan <- newAppRun(cfg, reply):
 			case <-app.shutdownStarted:
 			}
 			return
@@ -233,6 +468,8 @@ func (app *app) process() {
 	// and nil otherwise.
 	var h *harvest
 	var run *appRun
+	// destRuns contains the runs of the destinations connected, by index.
+	destRuns := make([]*appRun, len(app.destinations))
 
 	harvestTicker := time.NewTicker(time.Second)
 	defer harvestTicker.Stop()
@@ -240,9 +477,14 @@ func (app *app) process() {
 	for {
 		select {
 		case <-harvestTicker.C:
+			app.checkLeakedTransactions(h, run, time.Now())
 			if nil != run {
 				now := time.Now()
 				if ready := h.Ready(now); nil != ready {
+					if nil != ready.SlowSQLs {
+						app.explainPlans.reset()
+					}
+					app.harvestDestinations(ready, now, destRuns)
 					go app.doHarvest(ready, now, run)
 				}
 			}
@@ -266,23 +508,80 @@ func (app *app) process() {
 			}
 
 			if nil != run {
-				for done := false; !done; {
-					select {
-					case d := <-app.dataChan:
-						if run.Reply.RunID == d.id {
-							d.data.MergeIntoHarvest(h)
-						}
-					default:
-						done = true
-					}
-				}
-				app.doHarvest(h, time.Now(), run)
+				app.mergePendingData(h, run)
+				now := time.Now()
+				destinations := app.harvestDestinations(h, now, destRuns)
+				app.doHarvest(h, now, run)
+				destinations.Wait()
 			}
 
+			if nil != app.diagnosticsServer {
+				app.diagnosticsServer.Close()
+			}
+			app.health.set(healthShutdown)
+			app.health.write()
 			close(app.shutdownComplete)
 			app.setObserver(nil)
 			secureAgent.DeactivateSecurity()
 			return
+		case done := <-app.reservoirsChan:
+			done <- h.reservoirDiagnostics()
+		case update := <-app.configChan:
+			app.setConfig(update.config)
+			if nil != run {
+				// The data recorded so far is harvested with
+				// the previous configuration.
+				app.mergePendingData(h, run)
+				now := time.Now()
+				ready := h.Flush(now)
+				app.explainPlans.reset()
+				app.harvestDestinations(ready, now, destRuns)
+				if update.reconnect {
+					// The processor connects again once the
+					// final harvest of the run is posted,
+					// unless its response ended the run.
+					go func(run *appRun) {
+						if err := app.doHarvest(ready, now, run); err == errHarvestRunOver {
+							return
+						}
+						select {
+						case app.reconnectChan <- struct{}{}:
+						case <-app.shutdownStarted:
+						}
+					}(run)
+					run = nil
+					h = nil
+					app.setState(nil, nil)
+				} else {
+					go app.doHarvest(ready, now, run)
+					run = reloadRun(run, update.config)
+					h = newHarvest(now, run.harvestConfig)
+					app.setState(run, nil)
+				}
+			}
+			for i, r := range destRuns {
+				if nil != r {
+					destRuns[i] = reloadRun(r, app.destinations[i].configFor(update.config))
+				}
+			}
+			close(update.done)
+		case done := <-app.flushChan:
+			if nil == run {
+				done <- errFlushNotConnected
+				continue
+			}
+			// Merge the data recorded before Flush was called so
+			// that it is part of this harvest.
+			app.mergePendingData(h, run)
+			now := time.Now()
+			ready := h.Flush(now)
+			app.explainPlans.reset()
+			destinations := app.harvestDestinations(ready, now, destRuns)
+			go func(run *appRun) {
+				err := app.doHarvest(ready, now, run)
+				destinations.Wait()
+				done <- err
+			}(run)
 		case resp := <-app.collectorErrorChan:
 			run = nil
 			h = nil
@@ -300,7 +599,16 @@ func (app *app) process() {
 				})
 				go app.connectRoutine()
 			}
+		case <-app.reconnectChan:
+			go app.connectRoutine()
 		case run = <-app.connectChan:
+			if run = app.reconcileRun(run, func(c config) config { return c }); nil == run {
+				app.Info("configuration updated while connecting, reconnecting", map[string]interface{}{
+					"app": app.config.AppName,
+				})
+				go app.connectRoutine()
+				continue
+			}
 			if shouldUseTraceObserver(run.Config) {
 				app.connectTraceObserver(run.Reply)
 			} else if shouldUseTraceObserver(app.config) {
@@ -314,7 +622,7 @@ func (app *app) process() {
 
 			run.harvestConfig.CommonAttributes = commonAttributes{
 				hostname:   app.config.hostname,
-				entityName: app.config.AppName,
+				entityName: run.firstAppName,
 				entityGUID: run.Reply.EntityGUID,
 			}
 
@@ -327,10 +635,75 @@ func (app *app) process() {
 			})
 			processConnectMessages(run, app)
 			secureAgent.RefreshState(getLinkedMetaData(app))
+		case dr := <-app.destinationChan:
+			i := dr.dest.index
+			if nil != dr.run {
+				r := app.reconcileRun(dr.run, dr.dest.configFor)
+				if nil == r {
+					go app.connectDestination(dr.dest)
+					continue
+				}
+				app.destinationConnected(r)
+				destRuns[i] = r
+			} else if app.destinationRunOver(dr, destRuns[i]) {
+				destRuns[i] = nil
+			}
 		}
 	}
 }
 
+// mergePendingData merges the data waiting in dataChan into the harvest
+// without blocking.
+func (app *app) mergePendingData(h *harvest, run *appRun) {
+	for {
+		select {
+		case d := <-app.dataChan:
+			if run.Reply.RunID == d.id {
+				d.data.MergeIntoHarvest(h)
+			}
+		default:
+			return
+		}
+	}
+}
+
+var (
+	errFlushNotConnected = errors.New("application not connected")
+	errFlushServerless   = errors.New("flush is not supported in serverless mode, use FlushServerless")
+	errFlushShutdown     = errors.New("application shut down")
+	errHarvestRunOver    = errors.New("harvest abandoned: the run is over")
+	errHarvestFailed     = errors.New("harvest failed: some data was not sent")
+)
+
+// Flush implements newrelic.Application's Flush.
+func (app *app) Flush(ctx context.Context) error {
+	if nil == app {
+		return nil
+	}
+	if !app.config.Enabled {
+		return nil
+	}
+	if app.config.ServerlessMode.Enabled {
+		return errFlushServerless
+	}
+
+	done := make(chan error, 1)
+	select {
+	case app.flushChan <- done:
+	case <-app.shutdownStarted:
+		return errFlushShutdown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (app *app) Shutdown(timeout time.Duration) {
 	if nil == app {
 		return
@@ -431,8 +804,14 @@ func newApp(c config) *app {
 		shutdownStarted:    make(chan struct{}),
 		shutdownComplete:   make(chan struct{}),
 		connectChan:        make(chan *appRun, 1),
+		reconnectChan:      make(chan struct{}),
+		flushChan:          make(chan chan error),
+		reservoirsChan:     make(chan chan map[string]reservoirDiagnostics),
+		configChan:         make(chan configUpdate),
+		destinationChan:    make(chan destinationRun),
 		collectorErrorChan: make(chan rpmResponse, 1),
 		dataChan:           make(chan appData, appDataChanSize),
+		health:             newHealthReporter(c.Config),
 		rpmControls: rpmControls{
 			License: c.License,
 			Client: &http.Client{
@@ -447,6 +826,7 @@ func newApp(c config) *app {
 			},
 		},
 	}
+	app.destinations = newDestinations(c.Config, app.rpmControls)
 
 	app.Info("application created", map[string]interface{}{
 		"app":          app.config.AppName,
@@ -455,17 +835,36 @@ func newApp(c config) *app {
 		"grpc-version": grpcVersion,
 	})
 
+	if !app.config.Enabled {
+		app.health.set(healthAgentDisabled)
+		app.health.write()
+	}
+
 	if app.config.Enabled {
 		if app.config.ServerlessMode.Enabled {
 			reply := newServerlessConnectReply(c)
 			app.run = newAppRun(c, reply)
 			app.serverless = newServerlessHarvest(c.Logger, os.Getenv)
 		} else {
+			app.liveTxns = newLiveTransactions(app.config.Config)
 			go app.process()
 			go app.connectRoutine()
+			for _, d := range app.destinations {
+				go app.connectDestination(d)
+			}
+			go app.health.run(app.shutdownStarted)
+			if cp := newContinuousProfiler(app.config.Config); nil != cp {
+				go cp.run(app.shutdownStarted)
+			}
 			if app.config.RuntimeSampler.Enabled {
 				go runSampler(app, runtimeSamplerPeriod)
 			}
+			if addr := app.config.Diagnostics.ListenAddress; "" != addr {
+				app.startDiagnosticsServer(addr)
+			}
+			if "" != app.config.ConfigFile.Path {
+				go app.watchConfigFile(app.shutdownStarted)
+			}
 		}
 	}
 
@@ -479,6 +878,7 @@ func shouldUseTraceObserver(c config) bool {
 var (
 	_ internal.HarvestTestinger = &app{}
 	_ internal.Expect           = &app{}
+	_ internal.Recorder         = &app{}
 )
 
 func (app *app) HarvestTesting(replyfn func(*internal.ConnectReply)) {
@@ -534,7 +934,11 @@ func (app *app) StartTransaction(name string, opts ...TraceOption) *Transaction
 		return nil
 	}
 	run, _ := app.getState()
-	return newTransaction(newTxn(app, run, name, opts...))
+	thd := newTxn(app, run, name, opts...)
+	if nil != app.liveTxns {
+		app.liveTxns.add(thd.txn, getStackTrace())
+	}
+	return newTransaction(thd)
 }
 
 var (
@@ -621,7 +1025,8 @@ var (
 
 // RecordLog implements newrelic.Application's RecordLog.
 func (app *app) RecordLog(log *LogData) error {
-	if !app.config.ApplicationLogging.Enabled {
+	run, _ := app.getState()
+	if !run.Config.ApplicationLogging.Enabled {
 		return errAppLoggingDisabled
 	}
 
@@ -630,7 +1035,6 @@ func (app *app) RecordLog(log *LogData) error {
 		return err
 	}
 
-	run, _ := app.getState()
 	app.Consume(run.Reply.RunID, &event)
 	return nil
 }
@@ -643,14 +1047,34 @@ func (app *app) ServerlessWrite(arn string, writer io.Writer) {
 	app.serverless.Write(arn, writer)
 }
 
+var (
+	errServerlessModeDisabled = errors.New("serverless data can only be flushed in serverless mode")
+)
+
+// FlushServerless implements newrelic.Application's FlushServerless.
+func (app *app) FlushServerless(ctx context.Context, writer io.Writer) error {
+	if !app.config.ServerlessMode.Enabled {
+		return errServerlessModeDisabled
+	}
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+	return app.serverless.write("", writer)
+}
+
 func (app *app) Consume(id internal.AgentRunID, data harvestable) {
 
 	app.serverless.Consume(data)
 
+	// The test harvest is replaced by ResetRecordedData, so it is read
+	// under the lock.
+	app.testHarvestLock.Lock()
 	if nil != app.testHarvest {
 		data.MergeIntoHarvest(app.testHarvest)
+		app.testHarvestLock.Unlock()
 		return
 	}
+	app.testHarvestLock.Unlock()
 
 	if id == "" {
 		return
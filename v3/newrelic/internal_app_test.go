@@ -4,12 +4,21 @@
 package newrelic
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic/fakecollector"
 )
 
 func TestConnectBackoff(t *testing.T) {
@@ -149,3 +158,348 @@ func TestRecordLog(t *testing.T) {
 		},
 	})
 }
+
+func TestFlush(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	app.StartTransaction("hello").End()
+
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	for _, m := range []string{cmdCustomEvents, cmdTxnEvents, cmdMetrics} {
+		if len(srv.RequestsFor(m)) != 1 {
+			t.Error("missing request", m, srv.Requests())
+		}
+	}
+
+	// Nothing is left to harvest after the flush, but the metrics, and
+	// the agent commands are polled.
+	before := len(srv.Requests())
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	for _, r := range srv.Requests()[before:] {
+		if r.Method != cmdMetrics && r.Method != cmdAgentCommands {
+			t.Error("unexpected request", r.Method)
+		}
+	}
+}
+
+func TestFlushPostFailure(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	srv.Respond(fakecollector.MethodCustomEvents, fakecollector.Response{StatusCode: 503})
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	if err := app.Flush(context.Background()); !errors.Is(err, errHarvestFailed) {
+		t.Fatal(err)
+	}
+	// The event is retained and sent by the next flush.
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if requests := srv.RequestsFor(fakecollector.MethodCustomEvents); len(requests) != 2 || !bytes.Contains(requests[1].Body, []byte("myEvent")) {
+		t.Error(requests)
+	}
+}
+
+func TestFlushContextDone(t *testing.T) {
+	release := make(chan struct{})
+	srv := newSlowFakeCollector(t, func(method string) {
+		if method != cmdPreconnect && method != cmdConnect {
+			<-release
+		}
+	})
+	app := newFakeCollectorTestApp(t, srv)
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := app.Flush(ctx); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
+
+func TestFlushNotConnected(t *testing.T) {
+	// The application is disabled: Flush does nothing.
+	app := testApp(nil, nil, t)
+	if err := app.Flush(context.Background()); nil != err {
+		t.Error(err)
+	}
+
+	var nilApp *Application
+	if err := nilApp.Flush(context.Background()); nil != err {
+		t.Error(err)
+	}
+
+	serverless := testApp(nil, func(cfg *Config) { cfg.ServerlessMode.Enabled = true }, t)
+	if err := serverless.Flush(context.Background()); err != errFlushServerless {
+		t.Error(err)
+	}
+}
+
+func TestFlushAfterShutdown(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	app.Shutdown(10 * time.Second)
+	if err := app.Flush(context.Background()); err != errFlushShutdown {
+		t.Error(err)
+	}
+}
+
+// eventsPosted returns the number of events in the event payload posted.
+func eventsPosted(t *testing.T, r fakecollector.Request) int {
+	var payload []json.RawMessage
+	if err := r.Unmarshal(&payload); nil != err || len(payload) != 3 {
+		t.Fatal(err, string(r.Body))
+	}
+	var events []json.RawMessage
+	if err := json.Unmarshal(payload[2], &events); nil != err {
+		t.Fatal(err)
+	}
+	return len(events)
+}
+
+// metricPosted returns whether the metric given was posted to the fake
+// collector.
+func metricPosted(srv *fakecollector.Server, name string) bool {
+	for _, r := range srv.RequestsFor(fakecollector.MethodMetrics) {
+		if bytes.Contains(r.Body, []byte(`"`+name+`"`)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHarvestSplitsPayloadTooLarge(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	// The payloads of 20 events and of 10 events are too large.  The halves
+	// of a split payload are posted in turn.
+	tooLarge := fakecollector.Response{StatusCode: 413}
+	srv.Respond(fakecollector.MethodCustomEvents, tooLarge, tooLarge, fakecollector.Response{}, fakecollector.Response{}, tooLarge)
+	for i := 0; i < 20; i++ {
+		app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": strings.Repeat("z", 100)})
+	}
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	// The rejected payloads are posted too: the 20 events are accepted in
+	// 4 payloads of 5, after 3 rejected payloads of 20, 10 and 10 events.
+	posts := srv.RequestsFor(fakecollector.MethodCustomEvents)
+	if len(posts) != 7 {
+		t.Fatal("payloads posted", len(posts))
+	}
+	for i, want := range []int{20, 10, 5, 5, 10, 5, 5} {
+		if events := eventsPosted(t, posts[i]); events != want {
+			t.Error("events posted", i, events)
+		}
+	}
+
+	// The supportability metrics are sent with the next harvest.
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if !metricPosted(srv, supportPayloadSizeLimit(cmdCustomEvents)) {
+		t.Error("size limit metric not posted")
+	}
+	if !metricPosted(srv, supportPayloadSplit(cmdCustomEvents)) {
+		t.Error("split metric not posted")
+	}
+}
+
+func TestHarvestDropsUnsplittablePayload(t *testing.T) {
+	srv := fakecollector.NewServer()
+	defer srv.Close()
+	app := newFakeCollectorTestApp(t, srv)
+	srv.Respond(fakecollector.MethodCustomEvents, fakecollector.Response{StatusCode: 413})
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	if err := app.Flush(context.Background()); !errors.Is(err, errHarvestFailed) {
+		t.Fatal(err)
+	}
+	if posts := srv.RequestsFor(fakecollector.MethodCustomEvents); len(posts) != 1 {
+		t.Error("payloads posted", len(posts))
+	}
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	// The event is dropped rather than retained.
+	if posts := srv.RequestsFor(fakecollector.MethodCustomEvents); len(posts) != 1 {
+		t.Error("payloads posted", len(posts))
+	}
+	if !metricPosted(srv, supportPayloadSizeLimit(cmdCustomEvents)) {
+		t.Error("size limit metric not posted")
+	}
+	if metricPosted(srv, supportPayloadSplit(cmdCustomEvents)) {
+		t.Error("split metric posted")
+	}
+}
+
+func TestHarvestPostsConcurrently(t *testing.T) {
+	var lock sync.Mutex
+	var inFlight, maxInFlight int
+	srv := newSlowFakeCollector(t, func(method string) {
+		if method == cmdPreconnect || method == cmdConnect {
+			return
+		}
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	})
+	app := newFakeCollectorTestApp(t, srv, func(cfg *Config) {
+		cfg.Harvest.MaxConcurrentRequests = 2
+	})
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	app.RecordLog(LogData{Message: "hello"})
+	app.StartTransaction("hello").End()
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	// At least 3 payloads follow the preconnect and connect requests.
+	if n := len(srv.Requests()); n < 5 {
+		t.Fatal("requests", srv.Requests())
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if maxInFlight != 2 {
+		t.Error("concurrent requests", maxInFlight)
+	}
+}
+
+// newSlowFakeCollector starts a fake collector which calls delay with the
+// method of each request before answering it.
+func newSlowFakeCollector(t *testing.T, delay func(method string)) *fakecollector.Server {
+	c := fakecollector.New()
+	srv := &fakecollector.Server{
+		Collector: c,
+		Server: httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			delay(r.URL.Query().Get("method"))
+			c.ServeHTTP(w, r)
+		})),
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newFakeCollectorTestApp creates an application connected to the fake
+// collector server provided.
+func newFakeCollectorTestApp(t *testing.T, srv *fakecollector.Server, cfgFn ...ConfigOption) *Application {
+	cfgFn = append([]ConfigOption{
+		ConfigAppName(sampleAppName),
+		ConfigLicense(testLicenseKey),
+		fakeCollectorConfig(srv),
+	}, cfgFn...)
+	app, err := NewApplication(cfgFn...)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := app.WaitForConnection(10 * time.Second); nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Shutdown(10 * time.Second) })
+	return app
+}
+
+func TestHarvestRequestTimeout(t *testing.T) {
+	var slow int32
+	srv := newSlowFakeCollector(t, func(method string) {
+		if method == cmdCustomEvents && atomic.CompareAndSwapInt32(&slow, 0, 1) {
+			time.Sleep(500 * time.Millisecond)
+		}
+	})
+	app := newFakeCollectorTestApp(t, srv, func(cfg *Config) {
+		cfg.Harvest.RequestTimeout = 50 * time.Millisecond
+	})
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	start := time.Now()
+	if err := app.Flush(context.Background()); !errors.Is(err, errHarvestFailed) {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 400*time.Millisecond {
+		t.Error("flush duration", d)
+	}
+	// The first request times out: the event is sent again with the next
+	// harvest, along with the duration metrics.
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if requests := srv.RequestsFor(cmdCustomEvents); len(requests) != 1 || !bytes.Contains(requests[0].Body, []byte("myEvent")) {
+		t.Error("custom events", requests)
+	}
+	metrics := srv.RequestsFor(cmdMetrics)
+	for _, cmd := range []string{cmdMetrics, cmdCustomEvents} {
+		if !bytes.Contains(metrics[len(metrics)-1].Body, []byte(`"`+supportCollectorDuration(cmd)+`"`)) {
+			t.Error("duration metric not posted", cmd)
+		}
+	}
+}
+
+func TestHarvestTimeoutRetainsPayloads(t *testing.T) {
+	release := make(chan struct{})
+	var slow int32
+	srv := newSlowFakeCollector(t, func(method string) {
+		if method == cmdCustomEvents && atomic.CompareAndSwapInt32(&slow, 0, 1) {
+			<-release
+		}
+	})
+	defer close(release)
+	app := newFakeCollectorTestApp(t, srv, func(cfg *Config) {
+		cfg.Harvest.MaxConcurrentRequests = 1
+		cfg.Harvest.Timeout = 50 * time.Millisecond
+	})
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	app.StartTransaction("hello").End()
+	if err := app.Flush(context.Background()); !errors.Is(err, errHarvestFailed) || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatal(err)
+	}
+	// The custom events are posted first and block the only worker until
+	// the harvest times out, so the other payloads are not posted.
+	if requests := srv.RequestsFor(cmdTxnEvents); len(requests) != 0 {
+		t.Error("txn events", requests)
+	}
+
+	if err := app.Flush(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if requests := srv.RequestsFor(cmdCustomEvents); len(requests) != 1 || !bytes.Contains(requests[0].Body, []byte("myEvent")) {
+		t.Error("custom events", requests)
+	}
+	if requests := srv.RequestsFor(cmdTxnEvents); len(requests) != 1 || !bytes.Contains(requests[0].Body, []byte("OtherTransaction/Go/hello")) {
+		t.Error("txn events", requests)
+	}
+}
+
+func TestHarvestRestartsOnce(t *testing.T) {
+	srv := newSlowFakeCollector(t, func(string) {})
+	app := newFakeCollectorTestApp(t, srv)
+	// All the requests of the harvest fail, but the application only
+	// reconnects once.
+	for _, cmd := range []string{cmdCustomEvents, cmdTxnEvents, cmdSpanEvents, cmdMetrics} {
+		srv.Respond(cmd, fakecollector.Response{StatusCode: 401})
+	}
+	app.RecordCustomEvent("myEvent", map[string]interface{}{"zip": 1})
+	app.StartTransaction("hello").End()
+	if err := app.Flush(context.Background()); err != errHarvestRunOver {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(srv.RequestsFor(cmdConnect)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if connects := srv.RequestsFor(cmdConnect); len(connects) != 2 {
+		t.Error("connects", len(connects))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 
@@ -888,6 +889,86 @@ func TestAddSpanAttr_ExternalSegment(t *testing.T) {
 	})
 }
 
+func TestExternalSegmentHeaderAttributes(t *testing.T) {
+	app := testApp(distributedTracingReplyFields, func(cfg *Config) {
+		enableBetterCAT(cfg)
+		cfg.HTTPHeaderAttributes.ExternalRequest = []string{"X-Tenant-Id"}
+		cfg.HTTPHeaderAttributes.ExternalResponse = []string{"X-RateLimit-Remaining"}
+	}, t)
+	txn := app.StartTransaction("txn")
+	req, _ := http.NewRequest("GET", "http://www.example.com", nil)
+	req.Header.Set("X-Tenant-Id", "acme")
+	seg := StartExternalSegment(txn, req)
+	seg.Response = &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"X-Ratelimit-Remaining": []string{"42"}},
+	}
+	seg.End()
+	txn.End()
+
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		{
+			Intrinsics: map[string]interface{}{
+				"name":      "External/www.example.com/http/GET",
+				"category":  "http",
+				"parentId":  internal.MatchAnything,
+				"component": "http",
+				"span.kind": "client",
+			},
+			UserAttributes: map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{
+				"http.url":                               "http://www.example.com",
+				"http.method":                            "GET",
+				"http.statusCode":                        200,
+				"request.headers.x-tenant-id":            "acme",
+				"response.headers.x-ratelimit-remaining": "42",
+			},
+		},
+		{
+			Intrinsics: map[string]interface{}{
+				"transaction.name": "OtherTransaction/Go/txn",
+				"name":             "OtherTransaction/Go/txn",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{},
+		},
+	})
+}
+
+func TestWebTransactionHeaderAttributes(t *testing.T) {
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = false
+		cfg.HTTPHeaderAttributes.Request = []string{"X-Tenant-Id"}
+		cfg.HTTPHeaderAttributes.Response = []string{"X-Cache"}
+	}, t)
+	req, _ := http.NewRequest("GET", "http://www.example.com/hello", nil)
+	req.Header.Set("X-Tenant-Id", "acme")
+	txn := app.StartTransaction("hello")
+	txn.SetWebRequestHTTP(req)
+	rw := txn.SetWebResponse(httptest.NewRecorder())
+	rw.Header().Set("X-Cache", "MISS")
+	rw.WriteHeader(200)
+	txn.End()
+
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":             "WebTransaction/Go/hello",
+			"nr.apdexPerfZone": internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{
+			"request.method":              "GET",
+			"request.uri":                 "http://www.example.com/hello",
+			"request.headers.host":        "www.example.com",
+			"request.headers.x-tenant-id": "acme",
+			"response.headers.x-cache":    "MISS",
+			"http.statusCode":             200,
+			"httpResponseCode":            "200",
+		},
+	}})
+}
+
 func TestAddSpanAttr_SpanEventsDisabled_TxnTracesNoAttrs(t *testing.T) {
 	app := testApp(distributedTracingReplyFields, func(c *Config) {
 		enableBetterCAT(c)
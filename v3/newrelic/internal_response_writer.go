@@ -13,6 +13,9 @@ import (
 type replacementResponseWriter struct {
 	thd      *thread
 	original http.ResponseWriter
+	// browser is non-nil if the browser timing header is injected into
+	// the response.
+	browser *browserInjector
 }
 
 func (rw *replacementResponseWriter) Header() http.Header {
@@ -26,7 +29,11 @@ func (rw *replacementResponseWriter) Write(b []byte) (n int, err error) {
 	// times; see also the commentary in addCrossProcessHeaders().
 	addCrossProcessHeaders(rw.thd.txn, hdr)
 
-	n, err = rw.original.Write(b)
+	if nil != rw.browser {
+		n, err = rw.browser.Write(b)
+	} else {
+		n, err = rw.original.Write(b)
+	}
 
 	headersJustWritten(rw.thd, http.StatusOK, hdr)
 
@@ -41,7 +48,11 @@ func (rw *replacementResponseWriter) WriteHeader(code int) {
 
 	addCrossProcessHeaders(rw.thd.txn, hdr)
 
-	rw.original.WriteHeader(code)
+	if nil != rw.browser {
+		rw.browser.WriteHeader(code)
+	} else {
+		rw.original.WriteHeader(code)
+	}
 
 	headersJustWritten(rw.thd, code, hdr)
 }
@@ -50,12 +61,19 @@ func (rw *replacementResponseWriter) CloseNotify() <-chan bool {
 	return rw.original.(http.CloseNotifier).CloseNotify()
 }
 func (rw *replacementResponseWriter) Flush() {
+	if nil != rw.browser {
+		rw.browser.stream()
+	}
 	rw.original.(http.Flusher).Flush()
 }
 func (rw *replacementResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return rw.original.(http.Hijacker).Hijack()
 }
 func (rw *replacementResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if nil != rw.browser && !rw.browser.passThrough() {
+		// The response may be buffered: io.Copy must use Write.
+		return io.Copy(struct{ io.Writer }{rw}, r)
+	}
 	return rw.original.(io.ReaderFrom).ReadFrom(r)
 }
 
@@ -5,6 +5,7 @@ package newrelic
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"strings"
 	"testing"
@@ -264,6 +265,72 @@ func TestServerlessJSON(t *testing.T) {
 	}
 }
 
+func TestFlushServerless(t *testing.T) {
+	cfgFn := func(cfg *Config) {
+		cfg.ServerlessMode.Enabled = true
+		cfg.DistributedTracer.Enabled = false
+	}
+	app := testApp(nil, cfgFn, t)
+	txn := app.StartTransaction("hello")
+	txn.Private.(internal.AddAgentAttributer).AddAgentAttribute(AttributeFaaSColdStart, "", true)
+	txn.End()
+
+	buf := &bytes.Buffer{}
+	if err := app.FlushServerless(context.Background(), buf); nil != err {
+		t.Fatal(err)
+	}
+	_, data, err := parseServerlessPayload(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := data["metric_data"]; nil == v {
+		t.Fatal(data)
+	}
+	if v := string(data["analytic_event_data"]); !strings.Contains(v, `"faas.coldstart":true`) {
+		t.Error(v)
+	}
+
+	// The harvest is replaced after each flush.
+	buf = &bytes.Buffer{}
+	if err := app.FlushServerless(context.Background(), buf); nil != err {
+		t.Fatal(err)
+	}
+	if 0 != buf.Len() {
+		t.Error(buf.String())
+	}
+}
+
+func TestFlushServerlessCanceledContext(t *testing.T) {
+	cfgFn := func(cfg *Config) {
+		cfg.ServerlessMode.Enabled = true
+		cfg.DistributedTracer.Enabled = false
+	}
+	app := testApp(nil, cfgFn, t)
+	app.StartTransaction("hello").End()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf := &bytes.Buffer{}
+	if err := app.FlushServerless(ctx, buf); err != context.Canceled {
+		t.Error(err)
+	}
+	if 0 != buf.Len() {
+		t.Error(buf.String())
+	}
+}
+
+func TestFlushServerlessNotServerless(t *testing.T) {
+	app := testApp(nil, nil, t)
+	buf := &bytes.Buffer{}
+	if err := app.FlushServerless(context.Background(), buf); err != errServerlessModeDisabled {
+		t.Error(err)
+	}
+	var nilApp *Application
+	if err := nilApp.FlushServerless(context.Background(), buf); nil != err {
+		t.Error(err)
+	}
+}
+
 func TestServerlessConnectReply(t *testing.T) {
 	cfg := config{Config: defaultConfig()}
 	cfg.ServerlessMode.ApdexThreshold = 2 * time.Second
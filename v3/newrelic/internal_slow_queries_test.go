@@ -42,6 +42,76 @@ func TestSlowQueryBasic(t *testing.T) {
 	}})
 }
 
+func TestSlowQueryRawQueryObfuscated(t *testing.T) {
+	cfgfn := func(cfg *Config) {
+		cfg.DatastoreTracer.SlowQuery.Threshold = 0
+		cfg.DistributedTracer.Enabled = false
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello")
+	txn.SetWebRequestHTTP(helloRequest)
+	s1 := DatastoreSegment{
+		StartTime:  txn.StartSegmentNow(),
+		Product:    DatastoreMySQL,
+		Collection: "users",
+		Operation:  "INSERT",
+		RawQuery:   `INSERT INTO users (name, age) VALUES ("Alice", 42)`,
+	}
+	s1.End()
+	// Queries of datastores which do not use SQL are not obfuscated.
+	s2 := DatastoreSegment{
+		StartTime:  txn.StartSegmentNow(),
+		Product:    DatastoreMongoDB,
+		Collection: "users",
+		Operation:  "find",
+		RawQuery:   `{"name": "Alice"}`,
+	}
+	s2.End()
+	txn.End()
+
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:      1,
+		MetricName: "Datastore/statement/MySQL/users/INSERT",
+		Query:      "INSERT INTO users (name, age) VALUES (?, ?)",
+		TxnName:    "WebTransaction/Go/hello",
+		TxnURL:     "/hello",
+	}, {
+		Count:      1,
+		MetricName: "Datastore/statement/MongoDB/users/find",
+		Query:      "'find' on 'users' using 'MongoDB'",
+		TxnName:    "WebTransaction/Go/hello",
+		TxnURL:     "/hello",
+	}})
+}
+
+func TestSlowQueryRawQueryEnabled(t *testing.T) {
+	cfgfn := func(cfg *Config) {
+		cfg.DatastoreTracer.SlowQuery.Threshold = 0
+		cfg.DatastoreTracer.RawQuery.Enabled = true
+		cfg.DistributedTracer.Enabled = false
+	}
+	app := testApp(nil, cfgfn, t)
+	txn := app.StartTransaction("hello")
+	txn.SetWebRequestHTTP(helloRequest)
+	s1 := DatastoreSegment{
+		StartTime:  txn.StartSegmentNow(),
+		Product:    DatastoreMySQL,
+		Collection: "users",
+		Operation:  "INSERT",
+		RawQuery:   `INSERT INTO users (name, age) VALUES ("Alice", 42)`,
+	}
+	s1.End()
+	txn.End()
+
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:      1,
+		MetricName: "Datastore/statement/MySQL/users/INSERT",
+		Query:      `INSERT INTO users (name, age) VALUES ("Alice", 42)`,
+		TxnName:    "WebTransaction/Go/hello",
+		TxnURL:     "/hello",
+	}})
+}
+
 func TestSlowQueryLocallyDisabled(t *testing.T) {
 	cfgfn := func(cfg *Config) {
 		cfg.DatastoreTracer.SlowQuery.Threshold = 0
@@ -35,6 +35,13 @@ type txn struct {
 	// wroteHeader prevents capturing multiple response code errors if the
 	// user erroneously calls WriteHeader multiple times.
 	wroteHeader bool
+	// responseCode is the code of the response written, if any.
+	responseCode int
+
+	// browser buffers the response written with the first response writer
+	// returned by SetWebResponse when BrowserMonitoring.AutoInstrument is
+	// enabled.
+	browser *browserInjector
 
 	txnData
 
@@ -136,6 +143,10 @@ func newTxn(app *app, run *appRun, name string, opts ...TraceOption) *thread {
 	txn.TxnTrace.StackTraceThreshold = txn.Config.TransactionTracer.Segments.StackTraceThreshold
 	txn.SlowQueriesEnabled = txn.Config.DatastoreTracer.SlowQuery.Enabled
 	txn.SlowQueryThreshold = txn.Config.DatastoreTracer.SlowQuery.Threshold
+	txn.aggregatedKinds = aggregatedSegmentKinds(&txn.Config.Config)
+	if txn.Config.RepeatedCalls.Enabled {
+		txn.repeatedCalls = newRepeatedCalls(txn.Config.RepeatedCalls.Threshold)
+	}
 
 	// Synthetics support is tied up with a transaction's Old CAT field,
 	// CrossProcess. To support Synthetics with either BetterCAT or Old CAT,
@@ -251,6 +262,14 @@ func (thd *thread) SetWebResponse(w http.ResponseWriter) http.ResponseWriter {
 		//    txn.SetWebResponse(nil).WriteHeader(500)
 		//
 		w = dummyResponseWriter{}
+	} else if txn.Config.BrowserMonitoring.Enabled && txn.Config.BrowserMonitoring.AutoInstrument &&
+		nil == txn.browser && !txn.finished {
+		txn.browser = newBrowserInjector(txn, w)
+		return upgradeResponseWriter(&replacementResponseWriter{
+			thd:      thd,
+			original: w,
+			browser:  txn.browser,
+		})
 	}
 
 	return upgradeResponseWriter(&replacementResponseWriter{
@@ -259,6 +278,20 @@ func (thd *thread) SetWebResponse(w http.ResponseWriter) http.ResponseWriter {
 	})
 }
 
+// finishBrowserInjection writes the response buffered for the injection of
+// the browser timing header.  It must be called before the transaction
+// finishes, without holding the transaction lock.
+func (txn *txn) finishBrowserInjection() {
+	txn.Lock()
+	browser := txn.browser
+	finished := txn.finished
+	txn.Unlock()
+
+	if nil != browser && !finished {
+		browser.finish()
+	}
+}
+
 func (thd *thread) StoreLog(log *logEvent) {
 	txn := thd.txn
 	txn.Lock()
@@ -309,6 +342,7 @@ func (txn *txn) MergeIntoHarvest(h *harvest) {
 
 	createTxnMetrics(&txn.txnData, h.Metrics)
 	mergeBreakdownMetrics(&txn.txnData, h.Metrics)
+	mergeRepeatedCalls(txn, h)
 
 	// Dump log events into harvest
 	// Note: this will create a surge of log events that could affect sampling.
@@ -380,6 +414,7 @@ func headersJustWritten(thd *thread, code int, hdr http.Header) {
 		return
 	}
 	txn.wroteHeader = true
+	txn.responseCode = code
 
 	responseHeaderAttributes(txn.Attrs, hdr)
 	responseCodeAttribute(txn.Attrs, code)
@@ -435,6 +470,8 @@ func addCrossProcessHeaders(txn *txn, hdr http.Header) {
 
 func (thd *thread) End(recovered interface{}) error {
 	txn := thd.txn
+	txn.finishBrowserInjection()
+
 	txn.Lock()
 	defer txn.Unlock()
 
@@ -443,6 +480,7 @@ func (thd *thread) End(recovered interface{}) error {
 	}
 
 	txn.finished = true
+	txn.app.liveTxns.remove(txn)
 
 	if nil != recovered {
 		e := txnErrorFromPanic(time.Now(), recovered)
@@ -453,6 +491,9 @@ func (thd *thread) End(recovered interface{}) error {
 
 	txn.markEnd(time.Now(), thd.thread)
 	txn.freezeName()
+	if count := txn.repeatedCalls.maxCount(); count > 0 {
+		txn.Attrs.Agent.Add(AttributeRepeatedCallCount, "", count)
+	}
 	// Make a sampling decision if there have been no segments or outbound
 	// payloads.
 	txn.lazilyCalculateSampled()
@@ -929,6 +970,10 @@ func endDatastore(s *DatastoreSegment) error {
 	}
 	if txn.Config.DatastoreTracer.RawQuery.Enabled {
 		s.ParameterizedQuery = s.RawQuery
+	} else if s.ParameterizedQuery == "" && s.RawQuery != "" {
+		if dialect, ok := sqlDialects[s.Product]; ok {
+			s.ParameterizedQuery = obfuscateSQL(s.RawQuery, dialect)
+		}
 	}
 	if txn.Reply.SecurityPolicies.RecordSQL.IsSet() {
 		s.QueryParameters = nil
@@ -936,6 +981,15 @@ func endDatastore(s *DatastoreSegment) error {
 			s.ParameterizedQuery = ""
 		}
 	}
+	// Repeated calls are counted by the obfuscated raw query, so that calls
+	// differing only by their literals are counted together, and so that
+	// the RepeatedCall event never contains a literal.
+	repeatedCallQuery := s.ParameterizedQuery
+	if txn.Config.DatastoreTracer.RawQuery.Enabled && repeatedCallQuery != "" {
+		if dialect, ok := sqlDialects[s.Product]; ok {
+			repeatedCallQuery = obfuscateSQL(repeatedCallQuery, dialect)
+		}
+	}
 	if !txn.Config.DatastoreTracer.DatabaseNameReporting.Enabled {
 		s.DatabaseName = ""
 	}
@@ -957,9 +1011,52 @@ func endDatastore(s *DatastoreSegment) error {
 		PortPathOrID:       s.PortPathOrID,
 		Database:           s.DatabaseName,
 		ThisHost:           txn.appRun.Config.hostname,
+		RepeatedCallQuery:  repeatedCallQuery,
 	})
 }
 
+// explainPlanWanted returns true if the query has been recorded as a slow
+// query without a plan, and the application may capture another plan.
+func (txn *txn) explainPlanWanted(query string) bool {
+	txn.Lock()
+	defer txn.Unlock()
+
+	cfg := txn.Config.DatastoreTracer.SlowQuery.ExplainPlan
+	if txn.finished || !cfg.Enabled || nil == txn.SlowQueries {
+		return false
+	}
+	if !txn.SlowQueries.needsExplainPlan(query) {
+		return false
+	}
+	return txn.app.explainPlans.take(cfg.MaxPerHarvest)
+}
+
+// addExplainPlan adds the plan to the slow query, unless the transaction has
+// already finished.
+func (txn *txn) addExplainPlan(query string, product DatastoreProduct, plan *explainPlan) {
+	txn.Lock()
+	defer txn.Unlock()
+
+	if txn.finished || nil == txn.SlowQueries {
+		return
+	}
+	if !txn.Config.DatastoreTracer.RawQuery.Enabled {
+		plan.obfuscate(product)
+	}
+	txn.SlowQueries.addExplainPlan(query, plan)
+}
+
+func externalSegmentRequestHeader(s *ExternalSegment) http.Header {
+	r := s.Request
+	if nil != s.Response && nil != s.Response.Request {
+		r = s.Response.Request
+	}
+	if nil != r {
+		return r.Header
+	}
+	return nil
+}
+
 func externalSegmentMethod(s *ExternalSegment) string {
 	if s.Procedure != "" {
 		return s.Procedure
@@ -1023,6 +1120,10 @@ func endExternal(s *ExternalSegment) error {
 		Library:    s.Library,
 		Method:     externalSegmentMethod(s),
 		StatusCode: s.statusCode,
+
+		Timings:       s.timings,
+		PhaseSegments: txn.Config.ExternalTimings.Segments,
+		RequestHeader: externalSegmentRequestHeader(s),
 	})
 }
 
@@ -0,0 +1,165 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const leakedTransactionEventType = "AgentLeakedTransaction"
+
+// liveTransaction is a transaction started but not yet ended.
+type liveTransaction struct {
+	start time.Time
+	// stack is the stack of the call that started the transaction.
+	stack stackTrace
+}
+
+// liveTransactions tracks the transactions running when
+// Config.LeakedTransactions is enabled.  A nil *liveTransactions tracks
+// nothing.
+type liveTransactions struct {
+	sync.Mutex
+	txns map[*txn]liveTransaction
+}
+
+func newLiveTransactions(c Config) *liveTransactions {
+	if !c.LeakedTransactions.Enabled {
+		return nil
+	}
+	return &liveTransactions{txns: make(map[*txn]liveTransaction)}
+}
+
+func (lt *liveTransactions) add(txn *txn, stack stackTrace) {
+	if nil == lt {
+		return
+	}
+	lt.Lock()
+	defer lt.Unlock()
+	lt.txns[txn] = liveTransaction{start: txn.Start, stack: stack}
+}
+
+func (lt *liveTransactions) remove(txn *txn) {
+	if nil == lt {
+		return
+	}
+	lt.Lock()
+	defer lt.Unlock()
+	delete(lt.txns, txn)
+}
+
+// leakedTransaction is a transaction still running past the maximum age.
+type leakedTransaction struct {
+	txn *txn
+	liveTransaction
+}
+
+// takeLeaked removes and returns the transactions started more than maxAge
+// before now, so that each is reported once.
+func (lt *liveTransactions) takeLeaked(now time.Time, maxAge time.Duration) []leakedTransaction {
+	if nil == lt {
+		return nil
+	}
+	lt.Lock()
+	defer lt.Unlock()
+	var leaked []leakedTransaction
+	for txn, live := range lt.txns {
+		if now.Sub(live.start) > maxAge {
+			leaked = append(leaked, leakedTransaction{txn: txn, liveTransaction: live})
+			delete(lt.txns, txn)
+		}
+	}
+	return leaked
+}
+
+// formatStack formats the stack for the logs, one frame per line.
+func formatStack(st stackTrace) string {
+	var b strings.Builder
+	for _, f := range st.frames() {
+		if f.Name == "" {
+			continue
+		}
+		b.WriteString(f.Name)
+		b.WriteString("\n\t")
+		b.WriteString(f.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.FormatInt(f.Line, 10))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// checkLeakedTransactions reports the transactions still running past
+// Config.LeakedTransactions.MaxAge.  The metric and the event are recorded
+// into the harvest, if the application is connected.  It is called by the
+// processor, which owns the harvest.
+func (app *app) checkLeakedTransactions(h *harvest, run *appRun, now time.Time) {
+	maxAge := app.config.LeakedTransactions.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultConfig().LeakedTransactions.MaxAge
+	}
+	leaked := app.liveTxns.takeLeaked(now, maxAge)
+	if 0 == len(leaked) {
+		return
+	}
+	for _, l := range leaked {
+		l.txn.Lock()
+		name, finished := l.txn.Name, l.txn.finished
+		traceID := l.txn.BetterCAT.TraceID
+		l.txn.Unlock()
+		if finished {
+			// The transaction ended while being reported.
+			continue
+		}
+		age := now.Sub(l.start)
+		app.Warn("transaction leaked: started but not ended", map[string]interface{}{
+			"name":        name,
+			"age_seconds": age.Seconds(),
+			"trace_id":    traceID,
+			"force_ended": app.config.LeakedTransactions.ForceEnd,
+			"start_stack": formatStack(l.stack),
+		})
+		if nil != h {
+			h.Metrics.addSingleCount(supportLeakedTransaction, forced)
+			recordEvent := run.Config.CustomInsightsEvents.Enabled &&
+				!run.Config.HighSecurity &&
+				run.Reply.CollectCustomEvents &&
+				run.Reply.SecurityPolicies.CustomEvents.Enabled()
+			if recordEvent {
+				params := map[string]interface{}{
+					"transactionName": name,
+					"ageSeconds":      age.Seconds(),
+					"forceEnded":      app.config.LeakedTransactions.ForceEnd,
+				}
+				if "" != traceID {
+					params["traceId"] = traceID
+				}
+				if event, err := createCustomEvent(leakedTransactionEventType, params, now); nil == err {
+					h.CustomEvents.Add(event)
+				}
+			}
+		}
+		if app.config.LeakedTransactions.ForceEnd {
+			// Ending the transaction sends its data to the processor,
+			// which must not wait for itself.
+			go endLeakedTransaction(l.txn)
+		}
+	}
+}
+
+// endLeakedTransaction ends the transaction with the incomplete attribute.
+func endLeakedTransaction(txn *txn) {
+	txn.Lock()
+	if txn.finished {
+		txn.Unlock()
+		return
+	}
+	txn.Attrs.Agent.Add(AttributeTransactionIncomplete, "", true)
+	txn.Unlock()
+	thd := &thread{txn: txn, thread: &txn.mainThread}
+	thd.End(nil)
+}
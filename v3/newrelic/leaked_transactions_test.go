@@ -0,0 +1,136 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+// leakedTransactionsTestApp returns a test application tracking the
+// transactions, which the processor does not do for disabled applications.
+func leakedTransactionsTestApp(t *testing.T, forceEnd bool) (expectApp, *app) {
+	app := testApp(nil, func(cfg *Config) {
+		cfg.LeakedTransactions.Enabled = true
+		cfg.LeakedTransactions.MaxAge = time.Minute
+		cfg.LeakedTransactions.ForceEnd = forceEnd
+	}, t)
+	internalApp := app.Application.app
+	internalApp.liveTxns = newLiveTransactions(internalApp.config.Config)
+	return app, internalApp
+}
+
+func TestLiveTransactionsTakeLeaked(t *testing.T) {
+	lt := newLiveTransactions(defaultConfig())
+	if nil != lt {
+		t.Error("tracking while disabled")
+	}
+	// A nil *liveTransactions tracks nothing.
+	lt.add(&txn{}, nil)
+	lt.remove(&txn{})
+	if leaked := lt.takeLeaked(time.Now(), 0); nil != leaked {
+		t.Error(leaked)
+	}
+
+	cfg := defaultConfig()
+	cfg.LeakedTransactions.Enabled = true
+	lt = newLiveTransactions(cfg)
+	now := time.Now()
+	old, recent, ended := &txn{}, &txn{}, &txn{}
+	old.Start = now.Add(-2 * time.Minute)
+	recent.Start = now.Add(-30 * time.Second)
+	ended.Start = old.Start
+	for _, txn := range []*txn{old, recent, ended} {
+		lt.add(txn, getStackTrace())
+	}
+	lt.remove(ended)
+
+	leaked := lt.takeLeaked(now, time.Minute)
+	if len(leaked) != 1 || leaked[0].txn != old || !leaked[0].start.Equal(old.Start) {
+		t.Fatal(leaked)
+	}
+	// Each transaction is reported once.
+	if leaked := lt.takeLeaked(now, time.Minute); len(leaked) != 0 {
+		t.Error(leaked)
+	}
+	if leaked := lt.takeLeaked(now.Add(time.Minute), time.Minute); len(leaked) != 1 || leaked[0].txn != recent {
+		t.Error(leaked)
+	}
+}
+
+func transactionFinished(txn *Transaction) bool {
+	txn.thread.Lock()
+	defer txn.thread.Unlock()
+	return txn.thread.finished
+}
+
+func TestLeakedTransactionReported(t *testing.T) {
+	app, internalApp := leakedTransactionsTestApp(t, false)
+	txn := app.StartTransaction("hello")
+	app.StartTransaction("ended").End()
+
+	internalApp.checkLeakedTransactions(internalApp.testHarvest, internalApp.placeholderRun, time.Now())
+	app.ExpectCustomEvents(t, []internal.WantEvent{})
+
+	internalApp.checkLeakedTransactions(internalApp.testHarvest, internalApp.placeholderRun, time.Now().Add(2*time.Minute))
+	app.ExpectCustomEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"type":      "AgentLeakedTransaction",
+			"timestamp": internal.MatchAnything,
+		},
+		UserAttributes: map[string]interface{}{
+			"transactionName": "hello",
+			"ageSeconds":      internal.MatchAnything,
+			"forceEnded":      false,
+			"traceId":         internal.MatchAnything,
+		},
+	}})
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Supportability/Go/Transaction/Leaked", Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+	})
+	// The transaction is not ended unless ForceEnd is set.
+	if transactionFinished(txn) {
+		t.Error("transaction ended")
+	}
+	txn.End()
+	app.ExpectTxnEvents(t, []internal.WantEvent{
+		{Intrinsics: map[string]interface{}{"name": "OtherTransaction/Go/ended", "guid": internal.MatchAnything, "traceId": internal.MatchAnything, "priority": internal.MatchAnything, "sampled": internal.MatchAnything}},
+		{Intrinsics: map[string]interface{}{"name": "OtherTransaction/Go/hello", "guid": internal.MatchAnything, "traceId": internal.MatchAnything, "priority": internal.MatchAnything, "sampled": internal.MatchAnything}},
+	})
+}
+
+func TestLeakedTransactionForceEnded(t *testing.T) {
+	app, internalApp := leakedTransactionsTestApp(t, true)
+	txn := app.StartTransaction("hello")
+
+	internalApp.checkLeakedTransactions(internalApp.testHarvest, internalApp.placeholderRun, time.Now().Add(2*time.Minute))
+	for i := 0; i < 100 && !transactionFinished(txn); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !transactionFinished(txn) {
+		t.Fatal("transaction not ended")
+	}
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":     "OtherTransaction/Go/hello",
+			"guid":     internal.MatchAnything,
+			"traceId":  internal.MatchAnything,
+			"priority": internal.MatchAnything,
+			"sampled":  internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{
+			"incomplete": true,
+		},
+	}})
+}
+
+func TestFormatStack(t *testing.T) {
+	stack := formatStack(getStackTrace())
+	if !strings.Contains(stack, "newrelic.TestFormatStack\n\t") || !strings.Contains(stack, "leaked_transactions_test.go:") {
+		t.Error(stack)
+	}
+}
@@ -55,4 +55,9 @@ const (
 	// be changed without notifying customers that they must update all
 	// instance simultaneously for valid runtime metrics.
 	runtimeSamplerPeriod = 60 * time.Second
+
+	// connectionPoolSamplerPeriod is the period of the connection pool
+	// monitors.  Pools are sampled more often than the runtime so that
+	// the metrics catch their brief exhaustion.
+	connectionPoolSamplerPeriod = 5 * time.Second
 )
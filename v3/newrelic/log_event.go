@@ -175,7 +175,7 @@ func EnrichLog(buf *bytes.Buffer, opts EnricherOption) error {
 	}
 
 	md.entityGUID = reply.Reply.EntityGUID
-	md.entityName = app.app.config.AppName
+	md.entityName = reply.firstAppName
 	md.hostname = app.app.config.hostname
 
 	if reply.Config.ApplicationLogging.Enabled && reply.Config.ApplicationLogging.LocalDecorating.Enabled {
@@ -239,3 +239,10 @@ func (events *logEvents) Data(agentRunID string, harvestStart time.Time) ([]byte
 func (events *logEvents) EndpointMethod() string {
 	return cmdLogEvents
 }
+
+func (events *logEvents) splitPayload() (payloadCreator, payloadCreator) {
+	if len(events.logs) < 2 {
+		return nil, nil
+	}
+	return events.split()
+}
@@ -57,6 +57,21 @@ const (
 	gcPauseFraction      = "GC/System/Pause Fraction"
 	gcPauses             = "GC/System/Pauses"
 
+	// Runtime Metrics v2, read from runtime/metrics
+	schedLatency           = "Go/Runtime/Scheduler/Latency"
+	runGOMAXPROCS          = "Go/Runtime/GOMAXPROCS"
+	memoryStacks           = "Go/Runtime/Memory/Stacks"
+	memoryHeapReleased     = "Go/Runtime/Memory/HeapReleased"
+	memoryMetadata         = "Go/Runtime/Memory/Metadata"
+	memoryTotal            = "Go/Runtime/Memory/Total"
+	memoryHeapGoal         = "Go/Runtime/Memory/HeapGoal"
+	memoryLimit            = "Go/Runtime/Memory/Limit"
+	memoryLimitUtilization = "Go/Runtime/Memory/Limit/Utilization"
+	mutexWaitTime          = "Go/Runtime/Mutex/WaitTime"
+	cpuThrottledPeriods    = "CPU/Throttled/Periods"
+	cpuThrottledTime       = "CPU/Throttled/Time"
+	cpuThrottledFraction   = "CPU/Throttled/Fraction"
+
 	// Configurable event harvest supportability metrics
 	supportReportPeriod     = "Supportability/EventHarvest/ReportPeriod"
 	supportTxnEventLimit    = "Supportability/EventHarvest/AnalyticEventData/HarvestLimit"
@@ -81,6 +96,33 @@ const (
 	logEventsSent = "Supportability/Logging/Forwarding/Sent"
 )
 
+const (
+	// Supportability (once per repeated call of a transaction)
+	supportRepeatedCallDatastore = "Supportability/RepeatedCall/Datastore"
+	supportRepeatedCallExternal  = "Supportability/RepeatedCall/External"
+)
+
+// supportLeakedTransaction is recorded once per transaction still running
+// Config.LeakedTransactions.MaxAge after it started.
+const supportLeakedTransaction = "Supportability/Go/Transaction/Leaked"
+
+// supportPayloadSizeLimit is recorded each time a payload is rejected for being
+// larger than the collector's limit, supportPayloadSplit each time such a
+// payload is split in two and sent again.
+func supportPayloadSizeLimit(cmd string) string {
+	return "Supportability/Agent/Collector/" + cmd + "/MaxPayloadSizeLimit"
+}
+
+func supportPayloadSplit(cmd string) string {
+	return "Supportability/Agent/Collector/" + cmd + "/PayloadSplit"
+}
+
+// supportCollectorDuration records the duration of each request to an
+// endpoint of the collector.
+func supportCollectorDuration(cmd string) string {
+	return "Supportability/Agent/Collector/" + cmd + "/Duration"
+}
+
 func supportMetric(metrics *metricTable, b bool, metricName string) {
 	if b {
 		metrics.addSingleCount(metricName, forced)
@@ -0,0 +1,386 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package newrelictest provides an Application which records the data it
+// captures in memory instead of sending it to New Relic, so that tests can
+// assert on their instrumentation.
+//
+//	func TestGetUser(t *testing.T) {
+//		app := newrelictest.NewApplication(t)
+//		handler := newrelic.WrapHandleFunc(app.Application, "/users", getUser)
+//		...
+//		app.AssertSpan(t, "Datastore/statement/Postgres/users/select", map[string]interface{}{
+//			"db.statement": newrelictest.Any,
+//		})
+//		app.AssertMetricCount(t, "WebTransaction/Go/users", 1)
+//	}
+//
+// The Application never connects.  Every transaction is sampled, so that all
+// of its spans are recorded.
+package newrelictest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+const testLicenseKey = "0123456789012345678901234567890123456789"
+
+// Any matches any value of an attribute in the assertions.
+var Any interface{} = anyValue{}
+
+type anyValue struct{}
+
+func (anyValue) String() string { return "<any>" }
+
+// Application is a newrelic.Application which records the data it captures.
+// Its methods are safe to call from multiple goroutines.
+type Application struct {
+	*newrelic.Application
+	recorder internal.Recorder
+}
+
+// NewApplication creates an Application recording its data.  The options are
+// applied to a configuration with an application name and a license set.
+// The agent is disabled after the options have been applied, so that it
+// never connects.  The test fails if the configuration is invalid, and the
+// Application is shut down once the test has completed.
+func NewApplication(t testing.TB, options ...newrelic.ConfigOption) *Application {
+	t.Helper()
+	options = append([]newrelic.ConfigOption{
+		newrelic.ConfigAppName("newrelictest"),
+		newrelic.ConfigLicense(testLicenseKey),
+	}, options...)
+	options = append(options, func(cfg *newrelic.Config) {
+		if !cfg.ServerlessMode.Enabled {
+			cfg.Enabled = false
+		}
+	})
+	app, err := newrelic.NewApplication(options...)
+	if nil != err {
+		t.Fatal("unable to create application:", err)
+	}
+	internal.HarvestTesting(app.Private, func(reply *internal.ConnectReply) {
+		reply.SetSampleEverything()
+	})
+	t.Cleanup(func() { app.Shutdown(0) })
+	return &Application{
+		Application: app,
+		recorder:    app.Private.(internal.Recorder),
+	}
+}
+
+// Event is an event recorded by the Application: a span, a transaction, an
+// error or a custom event.  The values of the attributes are decoded from
+// JSON: numbers are float64.
+type Event struct {
+	// Type is the type of the event, such as "Span", "Transaction",
+	// "TransactionError" or the type of a custom event.
+	Type string
+	// Name is the name of the span or transaction.  It is empty for
+	// custom events.
+	Name            string
+	Intrinsics      map[string]interface{}
+	UserAttributes  map[string]interface{}
+	AgentAttributes map[string]interface{}
+}
+
+// Attribute returns the value of the attribute of the event.  The agent
+// attributes take precedence over the user attributes, which take precedence
+// over the intrinsics.
+func (e Event) Attribute(key string) (interface{}, bool) {
+	for _, attrs := range []map[string]interface{}{e.AgentAttributes, e.UserAttributes, e.Intrinsics} {
+		if val, ok := attrs[key]; ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// Metric is a metric recorded by the Application.  The durations are in
+// seconds.  For Apdex metrics, Count, Total and Exclusive are the counts of
+// satisfied, tolerated and failed transactions.
+type Metric struct {
+	Name       string
+	Scope      string
+	Forced     bool
+	Count      float64
+	Total      float64
+	Exclusive  float64
+	Min        float64
+	Max        float64
+	SumSquares float64
+}
+
+// Error is a traced error recorded by the Application.
+type Error struct {
+	TransactionName string
+	Message         string
+	Class           string
+	Expected        bool
+	UserAttributes  map[string]interface{}
+	AgentAttributes map[string]interface{}
+}
+
+// Log is a log event recorded by the Application.
+type Log struct {
+	// Timestamp is in milliseconds since the Unix epoch.
+	Timestamp int64
+	Severity  string
+	Message   string
+	SpanID    string
+	TraceID   string
+}
+
+// Reset discards the data recorded.
+func (app *Application) Reset() {
+	app.recorder.ResetRecordedData()
+}
+
+func newEvents(recorded []internal.RecordedEvent) []Event {
+	events := make([]Event, 0, len(recorded))
+	for _, e := range recorded {
+		typ, _ := e.Intrinsics["type"].(string)
+		name, _ := e.Intrinsics["name"].(string)
+		events = append(events, Event{
+			Type:            typ,
+			Name:            name,
+			Intrinsics:      e.Intrinsics,
+			UserAttributes:  e.UserAttributes,
+			AgentAttributes: e.AgentAttributes,
+		})
+	}
+	return events
+}
+
+// Spans returns the span events recorded.
+func (app *Application) Spans() []Event {
+	return newEvents(app.recorder.RecordedData().SpanEvents)
+}
+
+// SpansByName returns the span events recorded with the name given.
+func (app *Application) SpansByName(name string) []Event {
+	var spans []Event
+	for _, s := range app.Spans() {
+		if s.Name == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// Events returns all the events recorded: the spans, transactions, errors
+// and custom events.
+func (app *Application) Events() []Event {
+	data := app.recorder.RecordedData()
+	var events []Event
+	for _, recorded := range [][]internal.RecordedEvent{
+		data.TxnEvents,
+		data.SpanEvents,
+		data.ErrorEvents,
+		data.CustomEvents,
+	} {
+		events = append(events, newEvents(recorded)...)
+	}
+	return events
+}
+
+// EventsByType returns the events recorded with the type given, such as
+// "Transaction", "TransactionError" or the type of a custom event.
+func (app *Application) EventsByType(eventType string) []Event {
+	var events []Event
+	for _, e := range app.Events() {
+		if e.Type == eventType {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Metrics returns the metrics recorded, sorted by name and scope.
+func (app *Application) Metrics() []Metric {
+	recorded := app.recorder.RecordedData().Metrics
+	metrics := make([]Metric, 0, len(recorded))
+	for _, m := range recorded {
+		metrics = append(metrics, Metric(m))
+	}
+	return metrics
+}
+
+// Metric returns the unscoped metric recorded with the name given.
+func (app *Application) Metric(name string) (Metric, bool) {
+	return app.ScopedMetric(name, "")
+}
+
+// ScopedMetric returns the metric recorded with the name and scope given.
+// The scope of the metrics of the segments is the name of their transaction.
+func (app *Application) ScopedMetric(name, scope string) (Metric, bool) {
+	for _, m := range app.Metrics() {
+		if m.Name == name && m.Scope == scope {
+			return m, true
+		}
+	}
+	return Metric{}, false
+}
+
+// Errors returns the traced errors recorded.
+func (app *Application) Errors() []Error {
+	recorded := app.recorder.RecordedData().Errors
+	errs := make([]Error, 0, len(recorded))
+	for _, e := range recorded {
+		errs = append(errs, Error{
+			TransactionName: e.TxnName,
+			Message:         e.Msg,
+			Class:           e.Klass,
+			Expected:        e.Expected,
+			UserAttributes:  e.UserAttributes,
+			AgentAttributes: e.AgentAttributes,
+		})
+	}
+	return errs
+}
+
+// Logs returns the log events recorded, sorted by timestamp.
+func (app *Application) Logs() []Log {
+	recorded := app.recorder.RecordedData().Logs
+	logs := make([]Log, 0, len(recorded))
+	for _, l := range recorded {
+		logs = append(logs, Log(l))
+	}
+	return logs
+}
+
+// matchAttributes returns the differences between the attributes of the
+// event and those expected.  The attributes which are not expected are
+// ignored.
+func matchAttributes(e Event, expect map[string]interface{}) []string {
+	var diffs []string
+	for key, want := range expect {
+		got, ok := e.Attribute(key)
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("attribute %q missing", key))
+		case !matchValue(got, want):
+			diffs = append(diffs, fmt.Sprintf("attribute %q is %v, want %v", key, got, want))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// matchValue compares the values through their formatting, since numbers
+// are decoded as float64.
+func matchValue(got, want interface{}) bool {
+	if want == Any {
+		return true
+	}
+	return fmt.Sprint(got) == fmt.Sprint(want)
+}
+
+func assertEvent(t testing.TB, kind string, events []Event, attrs map[string]interface{}) Event {
+	t.Helper()
+	if len(events) == 0 {
+		t.Errorf("no %s recorded", kind)
+		return Event{}
+	}
+	var closest []string
+	for _, e := range events {
+		diffs := matchAttributes(e, attrs)
+		if len(diffs) == 0 {
+			return e
+		}
+		if nil == closest || len(diffs) < len(closest) {
+			closest = diffs
+		}
+	}
+	t.Errorf("no %s recorded with the attributes expected; closest match: %s", kind, strings.Join(closest, ", "))
+	return Event{}
+}
+
+// AssertSpan checks that a span with the name given has been recorded, with
+// at least the attributes given.  The attributes are looked up with
+// Event.Attribute.  It returns the first span matching.
+func (app *Application) AssertSpan(t testing.TB, name string, attrs map[string]interface{}) Event {
+	t.Helper()
+	return assertEvent(t, fmt.Sprintf("span %q", name), app.SpansByName(name), attrs)
+}
+
+// AssertNoSpan checks that no span with the name given has been recorded.
+func (app *Application) AssertNoSpan(t testing.TB, name string) {
+	t.Helper()
+	if spans := app.SpansByName(name); len(spans) > 0 {
+		t.Errorf("%d spans %q recorded", len(spans), name)
+	}
+}
+
+// AssertEvent checks that an event of the type given has been recorded, with
+// at least the attributes given.  It returns the first event matching.
+func (app *Application) AssertEvent(t testing.TB, eventType string, attrs map[string]interface{}) Event {
+	t.Helper()
+	return assertEvent(t, fmt.Sprintf("event %q", eventType), app.EventsByType(eventType), attrs)
+}
+
+// AssertMetric checks that the unscoped metric with the name given has been
+// recorded, and returns it.
+func (app *Application) AssertMetric(t testing.TB, name string) Metric {
+	t.Helper()
+	m, ok := app.Metric(name)
+	if !ok {
+		t.Errorf("metric %q not recorded", name)
+	}
+	return m
+}
+
+// AssertMetricCount checks that the unscoped metric with the name given has
+// been recorded the number of times given.
+func (app *Application) AssertMetricCount(t testing.TB, name string, count int) {
+	t.Helper()
+	m, ok := app.Metric(name)
+	switch {
+	case !ok:
+		t.Errorf("metric %q not recorded", name)
+	case m.Count != float64(count):
+		t.Errorf("metric %q recorded %v times, want %d", name, m.Count, count)
+	}
+}
+
+// AssertError checks that an error with the class and message given has been
+// traced, and returns it.  An empty class or message matches any.
+func (app *Application) AssertError(t testing.TB, class, message string) Error {
+	t.Helper()
+	errs := app.Errors()
+	for _, e := range errs {
+		if (class == "" || e.Class == class) && (message == "" || e.Message == message) {
+			return e
+		}
+	}
+	t.Errorf("no error recorded with class %q and message %q among %d errors", class, message, len(errs))
+	return Error{}
+}
+
+// AssertNoErrors checks that no error has been traced.
+func (app *Application) AssertNoErrors(t testing.TB) {
+	t.Helper()
+	for _, e := range app.Errors() {
+		t.Errorf("error recorded in %q: %s: %s", e.TransactionName, e.Class, e.Message)
+	}
+}
+
+// AssertLog checks that a log event with the severity and message given has
+// been recorded, and returns it.  An empty severity matches any.
+func (app *Application) AssertLog(t testing.TB, severity, message string) Log {
+	t.Helper()
+	logs := app.Logs()
+	for _, l := range logs {
+		if (severity == "" || l.Severity == severity) && l.Message == message {
+			return l
+		}
+	}
+	t.Errorf("no log recorded with severity %q and message %q among %d logs", severity, message, len(logs))
+	return Log{}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelictest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// failures records the failures of the assertions.
+type failures struct {
+	testing.TB
+	errors []string
+}
+
+func (f *failures) Helper() {}
+
+func (f *failures) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestApplicationRecordsTransactions(t *testing.T) {
+	app := NewApplication(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		txn := newrelic.FromContext(r.Context())
+		txn.AddAttribute("user", "alice")
+		s := newrelic.DatastoreSegment{
+			StartTime:  txn.StartSegmentNow(),
+			Product:    newrelic.DatastorePostgres,
+			Collection: "users",
+			Operation:  "select",
+		}
+		s.End()
+		txn.NoticeError(errors.New("oops"))
+		w.Write([]byte("alice"))
+	})
+	_, h := newrelic.WrapHandle(app.Application, "/users", handler)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+
+	span := app.AssertSpan(t, "Datastore/statement/Postgres/users/select", map[string]interface{}{
+		"category":  "datastore",
+		"component": Any,
+	})
+	if span.Type != "Span" {
+		t.Error(span.Type)
+	}
+	app.AssertSpan(t, "WebTransaction/Go/GET /users", map[string]interface{}{
+		"user":            "alice",
+		"http.statusCode": 200,
+	})
+	app.AssertNoSpan(t, "Custom/missing")
+	app.AssertEvent(t, "Transaction", map[string]interface{}{
+		"name":  "WebTransaction/Go/GET /users",
+		"error": true,
+	})
+	app.AssertEvent(t, "TransactionError", map[string]interface{}{
+		"error.message": "oops",
+	})
+	app.AssertMetricCount(t, "WebTransaction/Go/GET /users", 1)
+	app.AssertMetricCount(t, "Datastore/Postgres/all", 1)
+	if _, ok := app.ScopedMetric("Datastore/statement/Postgres/users/select", "WebTransaction/Go/GET /users"); !ok {
+		t.Error("scoped metric missing")
+	}
+	e := app.AssertError(t, "*errors.errorString", "oops")
+	if e.TransactionName != "WebTransaction/Go/GET /users" || e.UserAttributes["user"] != "alice" {
+		t.Error(e)
+	}
+}
+
+func TestApplicationRecordsCustomDataAndLogs(t *testing.T) {
+	app := NewApplication(t)
+	app.RecordCustomEvent("Signup", map[string]interface{}{"plan": "gold", "seats": 3})
+	app.RecordCustomMetric("signups", 1)
+	app.RecordLog(newrelic.LogData{Severity: "INFO", Message: "first", Timestamp: 2000})
+	app.RecordLog(newrelic.LogData{Severity: "WARN", Message: "second", Timestamp: 3000})
+
+	app.AssertEvent(t, "Signup", map[string]interface{}{"plan": "gold", "seats": 3})
+	m := app.AssertMetric(t, "Custom/signups")
+	if m.Count != 1 || m.Total != 1 {
+		t.Error(m)
+	}
+	app.AssertLog(t, "WARN", "second")
+	if logs := app.Logs(); len(logs) != 2 || logs[0].Message != "first" || logs[1].Timestamp != 3000 {
+		t.Error(logs)
+	}
+	app.AssertNoErrors(t)
+
+	app.Reset()
+	if events := app.Events(); len(events) != 0 {
+		t.Error(events)
+	}
+	if logs := app.Logs(); len(logs) != 0 {
+		t.Error(logs)
+	}
+}
+
+func TestApplicationConcurrentTransactions(t *testing.T) {
+	app := NewApplication(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.StartTransaction("job").End()
+		}()
+	}
+	wg.Wait()
+	if spans := app.SpansByName("OtherTransaction/Go/job"); len(spans) != 10 {
+		t.Error(len(spans))
+	}
+	app.AssertMetricCount(t, "OtherTransaction/Go/job", 10)
+}
+
+func TestApplicationResetConcurrentTransactions(t *testing.T) {
+	app := NewApplication(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			app.StartTransaction("job").End()
+		}()
+		go func() {
+			defer wg.Done()
+			app.Reset()
+		}()
+	}
+	wg.Wait()
+	app.Reset()
+	app.StartTransaction("job").End()
+	app.AssertMetricCount(t, "OtherTransaction/Go/job", 1)
+}
+
+func TestApplicationConfigOptions(t *testing.T) {
+	app := NewApplication(t, func(cfg *newrelic.Config) {
+		cfg.DistributedTracer.Enabled = false
+	})
+	app.StartTransaction("job").End()
+	if spans := app.Spans(); len(spans) != 0 {
+		t.Error(spans)
+	}
+	app.AssertEvent(t, "Transaction", map[string]interface{}{"name": "OtherTransaction/Go/job"})
+}
+
+func TestAssertionFailures(t *testing.T) {
+	app := NewApplication(t)
+	app.StartTransaction("job").End()
+
+	f := &failures{TB: t}
+	app.AssertSpan(f, "OtherTransaction/Go/job", map[string]interface{}{"nr.entryPoint": false})
+	app.AssertSpan(f, "OtherTransaction/Go/missing", nil)
+	app.AssertMetricCount(f, "OtherTransaction/Go/job", 2)
+	app.AssertMetric(f, "missing")
+	app.AssertError(f, "", "oops")
+	app.AssertLog(f, "", "missing")
+	expect := []string{
+		`no span "OtherTransaction/Go/job" recorded with the attributes expected; closest match: attribute "nr.entryPoint" is true, want false`,
+		`no span "OtherTransaction/Go/missing" recorded`,
+		`metric "OtherTransaction/Go/job" recorded 1 times, want 2`,
+		`metric "missing" not recorded`,
+		`no error recorded with class "" and message "oops" among 0 errors`,
+		`no log recorded with severity "" and message "missing" among 0 logs`,
+	}
+	if len(f.errors) != len(expect) {
+		t.Fatal(f.errors)
+	}
+	for i := range expect {
+		if f.errors[i] != expect[i] {
+			t.Errorf("got=%s\nwant=%s", f.errors[i], expect[i])
+		}
+	}
+}
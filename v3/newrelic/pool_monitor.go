@@ -0,0 +1,137 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// ConnectionPoolSample contains the statistics of a connection pool, by name.
+// Gauges, such as the number of connections in use, are recorded as they are.
+// Counters, such as the number of waits for a connection, accumulate since the
+// creation of the pool: they are recorded as their increase since the
+// previous sample.
+type ConnectionPoolSample struct {
+	Gauges   map[string]float64
+	Counters map[string]float64
+}
+
+// MonitorConnectionPool periodically records the statistics of a connection
+// pool, returned by the sample function, as custom metrics named
+// Custom/ConnectionPool/<name>/<statistic>.  The pool is sampled every five
+// seconds, until the function returned is called or the application shuts
+// down.  Each metric aggregates the samples of a harvest: its minimum and
+// maximum show the extremes reached by the pool.
+//
+// Use MonitorDBStats for a *sql.DB.  The nrpgx5 and nrredis-v9 integrations
+// provide MonitorPool functions for their pools.
+func MonitorConnectionPool(app *Application, name string, sample func() ConnectionPoolSample) (stop func()) {
+	if nil == app || nil == app.app || nil == sample {
+		return func() {}
+	}
+	if app.app.config.ServerlessMode.Enabled {
+		// Custom metrics are not supported in serverless mode.
+		return func() {}
+	}
+	m := newPoolMonitor(app, name, sample)
+	done := make(chan struct{})
+	var once sync.Once
+	go m.run(connectionPoolSamplerPeriod, done, app.app.shutdownStarted)
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// MonitorDBStats periodically records the statistics of the connection pool
+// of the database, as returned by its Stats method, as custom metrics named
+// Custom/ConnectionPool/<name>/<statistic>: the gauges MaxOpenConnections,
+// OpenConnections, InUse and Idle, and the counters WaitCount, WaitDuration
+// (in seconds), MaxIdleClosed, MaxIdleTimeClosed and MaxLifetimeClosed.  See
+// MonitorConnectionPool.
+//
+//	db, err := sql.Open("nrpostgres", dsn)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	stop := newrelic.MonitorDBStats(app, "orders", db)
+//	defer stop()
+func MonitorDBStats(app *Application, name string, db *sql.DB) (stop func()) {
+	if nil == db {
+		return func() {}
+	}
+	return MonitorConnectionPool(app, name, dbStatsSample(db))
+}
+
+func dbStatsSample(db *sql.DB) func() ConnectionPoolSample {
+	return func() ConnectionPoolSample {
+		stats := db.Stats()
+		return ConnectionPoolSample{
+			Gauges: map[string]float64{
+				"MaxOpenConnections": float64(stats.MaxOpenConnections),
+				"OpenConnections":    float64(stats.OpenConnections),
+				"InUse":              float64(stats.InUse),
+				"Idle":               float64(stats.Idle),
+			},
+			Counters: map[string]float64{
+				"WaitCount":         float64(stats.WaitCount),
+				"WaitDuration":      stats.WaitDuration.Seconds(),
+				"MaxIdleClosed":     float64(stats.MaxIdleClosed),
+				"MaxIdleTimeClosed": float64(stats.MaxIdleTimeClosed),
+				"MaxLifetimeClosed": float64(stats.MaxLifetimeClosed),
+			},
+		}
+	}
+}
+
+// poolMonitor records the samples of a connection pool.
+type poolMonitor struct {
+	app    *Application
+	prefix string
+	sample func() ConnectionPoolSample
+	// previous contains the counters of the previous sample.
+	previous map[string]float64
+}
+
+func newPoolMonitor(app *Application, name string, sample func() ConnectionPoolSample) *poolMonitor {
+	return &poolMonitor{
+		app:      app,
+		prefix:   "ConnectionPool/" + name + "/",
+		sample:   sample,
+		previous: sample().Counters,
+	}
+}
+
+func (m *poolMonitor) record() {
+	s := m.sample()
+	for stat, value := range s.Gauges {
+		m.app.RecordCustomMetric(m.prefix+stat, value)
+	}
+	for stat, value := range s.Counters {
+		delta := value - m.previous[stat]
+		if delta < 0 {
+			// The counter has been reset, as when a pool is
+			// replaced.
+			delta = value
+		}
+		m.app.RecordCustomMetric(m.prefix+stat, delta)
+	}
+	m.previous = s.Counters
+}
+
+func (m *poolMonitor) run(period time.Duration, done, shutdown <-chan struct{}) {
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			m.record()
+		case <-done:
+			return
+		case <-shutdown:
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func poolMetricData(values ...float64) []float64 {
+	var data [6]float64
+	for i, v := range values {
+		if i == 0 {
+			data[3], data[4] = v, v
+		}
+		if v < data[3] {
+			data[3] = v
+		}
+		if v > data[4] {
+			data[4] = v
+		}
+		data[0]++
+		data[1] += v
+		data[5] += v * v
+	}
+	data[2] = data[1]
+	return data[:]
+}
+
+func TestPoolMonitorRecord(t *testing.T) {
+	app := testApp(nil, nil, t)
+	samples := []ConnectionPoolSample{
+		{Gauges: map[string]float64{"InUse": 1}, Counters: map[string]float64{"WaitCount": 10}},
+		{Gauges: map[string]float64{"InUse": 4}, Counters: map[string]float64{"WaitCount": 12}},
+		{Gauges: map[string]float64{"InUse": 2}, Counters: map[string]float64{"WaitCount": 17}},
+		{Gauges: map[string]float64{"InUse": 3}, Counters: map[string]float64{"WaitCount": 3}},
+	}
+	m := newPoolMonitor(app.Application, "mypool", func() ConnectionPoolSample {
+		s := samples[0]
+		samples = samples[1:]
+		return s
+	})
+	m.record()
+	m.record()
+	m.record()
+	app.expectNoLoggedErrors(t)
+	app.ExpectMetrics(t, []internal.WantMetric{
+		{Name: "Custom/ConnectionPool/mypool/InUse", Scope: "", Forced: false, Data: poolMetricData(4, 2, 3)},
+		// The counter is reset by the last sample.
+		{Name: "Custom/ConnectionPool/mypool/WaitCount", Scope: "", Forced: false, Data: poolMetricData(2, 5, 3)},
+	})
+}
+
+func TestDBStatsSample(t *testing.T) {
+	db := sql.OpenDB(explainTestConnector{conn: &explainTestConn{}})
+	defer db.Close()
+	db.SetMaxOpenConns(5)
+	if err := db.PingContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := dbStatsSample(db)()
+	expectGauges := map[string]float64{
+		"MaxOpenConnections": 5,
+		"OpenConnections":    1,
+		"InUse":              0,
+		"Idle":               1,
+	}
+	for stat, value := range expectGauges {
+		if got, ok := s.Gauges[stat]; !ok || got != value {
+			t.Errorf("%s: got=%v want=%v", stat, got, value)
+		}
+	}
+	for _, stat := range []string{"WaitCount", "WaitDuration", "MaxIdleClosed", "MaxIdleTimeClosed", "MaxLifetimeClosed"} {
+		if _, ok := s.Counters[stat]; !ok {
+			t.Error("missing counter", stat)
+		}
+	}
+}
+
+func TestMonitorDBStatsNilApplication(t *testing.T) {
+	db := sql.OpenDB(explainTestConnector{conn: &explainTestConn{}})
+	defer db.Close()
+	stop := MonitorDBStats(nil, "mydb", db)
+	stop()
+	stop()
+}
+
+func TestMonitorConnectionPoolStop(t *testing.T) {
+	app := testApp(nil, nil, t)
+	stop := MonitorConnectionPool(app.Application, "mypool", func() ConnectionPoolSample {
+		return ConnectionPoolSample{}
+	})
+	stop()
+	// Stopping is idempotent.
+	stop()
+}
@@ -0,0 +1,365 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxStackBufferSize limits the memory used to sample the stacks of
+	// the goroutines.
+	maxStackBufferSize = 64 * 1024 * 1024
+	// maxProfileDuration limits the duration of a profile.
+	maxProfileDuration = 24 * time.Hour
+
+	defaultProfileSamplePeriod = 100 * time.Millisecond
+)
+
+// The call trees of a profile are grouped by the kind of goroutine.
+const (
+	profileBucketRequest = "REQUEST"
+	profileBucketAgent   = "AGENT"
+	profileBucketOther   = "OTHER"
+)
+
+var (
+	errProfilerDisabled   = errors.New("the thread profiler is disabled")
+	errProfileInProgress  = errors.New("a profile is already in progress")
+	errProfileNotFound    = errors.New("no profile in progress with the profile ID given")
+	errInvalidProfileArgs = errors.New("invalid profile arguments")
+)
+
+// profileFrame is a function call of a goroutine stack.
+type profileFrame struct {
+	function string
+	file     string
+	line     int
+}
+
+// profileNode is a node of the call tree of a profile.
+type profileNode struct {
+	frame    profileFrame
+	count    int
+	children []*profileNode
+}
+
+func (n *profileNode) child(f profileFrame) *profileNode {
+	for _, c := range n.children {
+		if c.frame == f {
+			return c
+		}
+	}
+	c := &profileNode{frame: f}
+	n.children = append(n.children, c)
+	return c
+}
+
+// MarshalJSON writes the node in the format expected by the collector:
+// [[file, function, line], call count, 0, [children]].
+func (n *profileNode) MarshalJSON() ([]byte, error) {
+	children := n.children
+	if nil == children {
+		children = []*profileNode{}
+	}
+	return json.Marshal([]interface{}{
+		[]interface{}{n.frame.file, n.frame.function, n.frame.line},
+		n.count,
+		0,
+		children,
+	})
+}
+
+// goroutineStack is the stack of a goroutine from its root function to the
+// function currently running.
+type goroutineStack struct {
+	id     string
+	state  string
+	frames []profileFrame
+}
+
+// bucket returns the kind of the goroutine.
+func (s goroutineStack) bucket() string {
+	if len(s.frames) > 0 && strings.HasPrefix(s.frames[0].function, "github.com/newrelic/go-agent/v3/") {
+		return profileBucketAgent
+	}
+	for _, f := range s.frames {
+		if f.function == "net/http.(*conn).serve" {
+			return profileBucketRequest
+		}
+	}
+	return profileBucketOther
+}
+
+// isRunnable reports whether the goroutine is running or waiting to run, as
+// opposed to blocked.
+func (s goroutineStack) isRunnable() bool {
+	return s.state == "running" || s.state == "runnable"
+}
+
+// parseGoroutineStacks parses the output of runtime.Stack.  The stack of the
+// calling goroutine, which comes first, is skipped.
+func parseGoroutineStacks(buf []byte) []goroutineStack {
+	blocks := strings.Split(strings.TrimSpace(string(buf)), "\n\n")
+	stacks := make([]goroutineStack, 0, len(blocks))
+	for i, block := range blocks {
+		if 0 == i {
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		// goroutine 18 [chan receive, 2 minutes]:
+		header := strings.TrimPrefix(lines[0], "goroutine ")
+		sp := strings.IndexByte(header, ' ')
+		if sp < 0 || !strings.HasPrefix(lines[0], "goroutine ") {
+			continue
+		}
+		s := goroutineStack{id: header[:sp]}
+		state := strings.TrimSuffix(strings.TrimPrefix(header[sp+1:], "["), "]:")
+		if comma := strings.IndexByte(state, ','); comma >= 0 {
+			state = state[:comma]
+		}
+		s.state = state
+
+		for j := 1; j < len(lines); j++ {
+			fn := lines[j]
+			if strings.HasPrefix(fn, "...") || j+1 == len(lines) {
+				// ...additional frames elided...
+				continue
+			}
+			j++
+			loc := strings.TrimSpace(lines[j])
+			if strings.HasPrefix(fn, "created by ") {
+				fn = strings.TrimPrefix(fn, "created by ")
+				if in := strings.Index(fn, " in goroutine "); in >= 0 {
+					fn = fn[:in]
+				}
+			} else if paren := strings.LastIndexByte(fn, '('); paren > 0 {
+				fn = fn[:paren]
+			}
+			if sp := strings.LastIndexByte(loc, ' '); sp >= 0 {
+				loc = loc[:sp]
+			}
+			f := profileFrame{function: fn, file: loc}
+			if colon := strings.LastIndexByte(loc, ':'); colon >= 0 {
+				f.file = loc[:colon]
+				f.line, _ = strconv.Atoi(loc[colon+1:])
+			}
+			// Frames are listed from the function running to the
+			// root function.
+			s.frames = append([]profileFrame{f}, s.frames...)
+		}
+		stacks = append(stacks, s)
+	}
+	return stacks
+}
+
+// sampleGoroutineStacks returns the stacks of all the goroutines but the
+// calling one.
+func sampleGoroutineStacks() []goroutineStack {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackBufferSize {
+			return parseGoroutineStacks(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// profileArguments are the arguments of the start_profiler command.
+type profileArguments struct {
+	ProfileID           int64   `json:"profile_id"`
+	SamplePeriod        float64 `json:"sample_period"`
+	Duration            float64 `json:"duration"`
+	OnlyRunnableThreads bool    `json:"only_runnable_threads"`
+}
+
+// goroutineProfile samples the stacks of the goroutines periodically and
+// aggregates them into call trees.
+type goroutineProfile struct {
+	id           int64
+	samplePeriod time.Duration
+	duration     time.Duration
+	onlyRunnable bool
+
+	// stop is closed to stop the profile.
+	stop     chan struct{}
+	stopOnce sync.Once
+	// report is false if the profile must be discarded once stopped.
+	report bool
+
+	start       time.Time
+	end         time.Time
+	sampleCount int
+	goroutines  map[string]struct{}
+	trees       map[string]*profileNode
+}
+
+func newGoroutineProfile(args profileArguments) (*goroutineProfile, error) {
+	if args.SamplePeriod < 0 || args.Duration <= 0 {
+		return nil, errInvalidProfileArgs
+	}
+	p := &goroutineProfile{
+		id:           args.ProfileID,
+		samplePeriod: time.Duration(args.SamplePeriod * float64(time.Second)),
+		duration:     time.Duration(args.Duration * float64(time.Second)),
+		onlyRunnable: args.OnlyRunnableThreads,
+		stop:         make(chan struct{}),
+		report:       true,
+		goroutines:   make(map[string]struct{}),
+		trees: map[string]*profileNode{
+			profileBucketRequest: {},
+			profileBucketAgent:   {},
+			profileBucketOther:   {},
+		},
+	}
+	if p.samplePeriod <= 0 {
+		p.samplePeriod = defaultProfileSamplePeriod
+	}
+	if p.duration > maxProfileDuration {
+		p.duration = maxProfileDuration
+	}
+	return p, nil
+}
+
+func (p *goroutineProfile) addSample(stacks []goroutineStack) {
+	p.sampleCount++
+	for _, s := range stacks {
+		if p.onlyRunnable && !s.isRunnable() {
+			continue
+		}
+		p.goroutines[s.id] = struct{}{}
+		node := p.trees[s.bucket()]
+		for _, f := range s.frames {
+			node = node.child(f)
+			node.count++
+		}
+	}
+}
+
+// run samples the goroutines until the end of the profile, or until stop or
+// done is closed.  false is returned if the profile was interrupted by done.
+func (p *goroutineProfile) run(done <-chan struct{}) bool {
+	p.start = time.Now()
+	ticker := time.NewTicker(p.samplePeriod)
+	defer ticker.Stop()
+	timer := time.NewTimer(p.duration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.addSample(sampleGoroutineStacks())
+		case <-timer.C:
+			p.end = time.Now()
+			return true
+		case <-p.stop:
+			p.end = time.Now()
+			return true
+		case <-done:
+			return false
+		}
+	}
+}
+
+// Data creates the payload of the profile_data command.
+func (p *goroutineProfile) Data(agentRunID string) ([]byte, error) {
+	trees := make(map[string][]*profileNode, len(p.trees))
+	for bucket, root := range p.trees {
+		trees[bucket] = root.children
+		if nil == trees[bucket] {
+			trees[bucket] = []*profileNode{}
+		}
+	}
+	js, err := json.Marshal(trees)
+	if nil != err {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(js)
+	if err := w.Close(); nil != err {
+		return nil, err
+	}
+	return json.Marshal([]interface{}{
+		agentRunID,
+		[]interface{}{
+			[]interface{}{
+				p.id,
+				timeToIntMillis(p.start),
+				timeToIntMillis(p.end),
+				p.sampleCount,
+				base64.StdEncoding.EncodeToString(buf.Bytes()),
+				len(p.goroutines),
+				0,
+			},
+		},
+	})
+}
+
+// goroutineProfiler runs the profiles requested by the agent commands, one at
+// a time.
+type goroutineProfiler struct {
+	sync.Mutex
+	running *goroutineProfile
+	// finished contains the profiles to send at the next harvest.
+	finished []*goroutineProfile
+}
+
+// start starts a profile.  The profile is stopped early if done is closed.
+func (gp *goroutineProfiler) start(args profileArguments, done <-chan struct{}) error {
+	p, err := newGoroutineProfile(args)
+	if nil != err {
+		return err
+	}
+
+	gp.Lock()
+	defer gp.Unlock()
+	if nil != gp.running {
+		return errProfileInProgress
+	}
+	gp.running = p
+	go func() {
+		completed := p.run(done)
+
+		gp.Lock()
+		defer gp.Unlock()
+		gp.running = nil
+		if completed && p.report {
+			gp.finished = append(gp.finished, p)
+		}
+	}()
+	return nil
+}
+
+// stop stops the profile given early.  Its data is sent at the next harvest
+// if report is true, and discarded otherwise.
+func (gp *goroutineProfiler) stop(profileID int64, report bool) error {
+	gp.Lock()
+	defer gp.Unlock()
+	p := gp.running
+	if nil == p || p.id != profileID {
+		return errProfileNotFound
+	}
+	p.report = report
+	p.stopOnce.Do(func() { close(p.stop) })
+	return nil
+}
+
+// takeFinished returns the profiles to send, and forgets them.
+func (gp *goroutineProfiler) takeFinished() []*goroutineProfile {
+	gp.Lock()
+	defer gp.Unlock()
+	finished := gp.finished
+	gp.finished = nil
+	return finished
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGoroutineStacks = `goroutine 7 [running]:
+github.com/newrelic/go-agent/v3/newrelic.sampleGoroutineStacks()
+	/go/newrelic/profiler.go:160 +0x2c
+created by github.com/newrelic/go-agent/v3/newrelic.(*goroutineProfiler).start in goroutine 6
+	/go/newrelic/profiler.go:300 +0x1a8
+
+goroutine 1 [chan receive, 2 minutes]:
+main.(*worker).wait(0xc000010000, ...)
+	/app/main.go:25 +0x45
+main.main()
+	/app/main.go:12 +0x1d
+
+goroutine 21 [IO wait]:
+main.handler({0x7f, 0xc0}, 0xc000200000)
+	/app/main.go:40 +0x11
+net/http.HandlerFunc.ServeHTTP(...)
+	/go/src/net/http/server.go:2166
+net/http.(*conn).serve(0xc000100000, {0x8a, 0xc0})
+	/go/src/net/http/server.go:2009 +0x5f4
+...additional frames elided...
+created by net/http.(*Server).Serve in goroutine 1
+	/go/src/net/http/server.go:3285 +0x4b4
+
+goroutine 9 [runnable]:
+github.com/newrelic/go-agent/v3/newrelic.(*app).process(0xc000180000)
+	/go/newrelic/internal_app.go:400 +0x100
+created by github.com/newrelic/go-agent/v3/newrelic.newApp in goroutine 1
+	/go/newrelic/internal_app.go:650 +0x5a5
+`
+
+func TestParseGoroutineStacks(t *testing.T) {
+	stacks := parseGoroutineStacks([]byte(testGoroutineStacks))
+	if len(stacks) != 3 {
+		t.Fatal(stacks)
+	}
+	if s := stacks[0]; s.id != "1" || s.state != "chan receive" || s.isRunnable() ||
+		!reflect.DeepEqual(s.frames, []profileFrame{
+			{function: "main.main", file: "/app/main.go", line: 12},
+			{function: "main.(*worker).wait", file: "/app/main.go", line: 25},
+		}) {
+		t.Error(s)
+	}
+	if s := stacks[1]; s.id != "21" || s.state != "IO wait" || len(s.frames) != 4 ||
+		s.frames[0].function != "net/http.(*Server).Serve" || s.frames[2].line != 2166 {
+		t.Error(s)
+	}
+	if s := stacks[2]; s.state != "runnable" || !s.isRunnable() {
+		t.Error(s)
+	}
+
+	buckets := []string{profileBucketOther, profileBucketRequest, profileBucketAgent}
+	for i, s := range stacks {
+		if b := s.bucket(); b != buckets[i] {
+			t.Error(i, b)
+		}
+	}
+}
+
+func TestSampleGoroutineStacks(t *testing.T) {
+	wait := make(chan struct{})
+	defer close(wait)
+	go func() { <-wait }()
+
+	found := false
+	for _, s := range sampleGoroutineStacks() {
+		for _, f := range s.frames {
+			if f.function == "github.com/newrelic/go-agent/v3/newrelic.TestSampleGoroutineStacks.func1" {
+				found = true
+			}
+			if f.function == "github.com/newrelic/go-agent/v3/newrelic.sampleGoroutineStacks" {
+				t.Error("calling goroutine sampled")
+			}
+		}
+	}
+	if !found {
+		t.Error("goroutine not sampled")
+	}
+}
+
+// decodeProfileTrees decodes the call trees of a profile_data payload.
+func decodeProfileTrees(t *testing.T, encoded string) map[string][]interface{} {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if nil != err {
+		t.Fatal(err)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if nil != err {
+		t.Fatal(err)
+	}
+	js, err := io.ReadAll(r)
+	if nil != err {
+		t.Fatal(err)
+	}
+	var trees map[string][]interface{}
+	if err := json.Unmarshal(js, &trees); nil != err {
+		t.Fatal(err)
+	}
+	return trees
+}
+
+func TestGoroutineProfileData(t *testing.T) {
+	p, err := newGoroutineProfile(profileArguments{ProfileID: 42, Duration: 1, OnlyRunnableThreads: true})
+	if nil != err {
+		t.Fatal(err)
+	}
+	stacks := parseGoroutineStacks([]byte(testGoroutineStacks))
+	p.addSample(stacks)
+	p.addSample(stacks)
+
+	data, err := p.Data("run-id")
+	if nil != err {
+		t.Fatal(err)
+	}
+	var payload []interface{}
+	if err := json.Unmarshal(data, &payload); nil != err {
+		t.Fatal(err)
+	}
+	profile := payload[1].([]interface{})[0].([]interface{})
+	if payload[0] != "run-id" || profile[0] != 42.0 || profile[3] != 2.0 || profile[5] != 1.0 {
+		t.Fatal(string(data))
+	}
+
+	trees := decodeProfileTrees(t, profile[4].(string))
+	if len(trees[profileBucketOther]) != 0 || len(trees[profileBucketRequest]) != 0 {
+		t.Error(trees)
+	}
+	js, _ := json.Marshal(trees[profileBucketAgent])
+	expect := `[[["/go/newrelic/internal_app.go","github.com/newrelic/go-agent/v3/newrelic.newApp",650],2,0,` +
+		`[[["/go/newrelic/internal_app.go","github.com/newrelic/go-agent/v3/newrelic.(*app).process",400],2,0,[]]]]]`
+	if string(js) != expect {
+		t.Error(string(js))
+	}
+}
+
+func TestGoroutineProfilerStartStop(t *testing.T) {
+	var gp goroutineProfiler
+	done := make(chan struct{})
+	defer close(done)
+	if err := gp.start(profileArguments{ProfileID: 1, Duration: -1}, done); err != errInvalidProfileArgs {
+		t.Error(err)
+	}
+	if err := gp.start(profileArguments{ProfileID: 1, SamplePeriod: 0.001, Duration: 60}, done); nil != err {
+		t.Fatal(err)
+	}
+	if err := gp.start(profileArguments{ProfileID: 2, Duration: 60}, done); err != errProfileInProgress {
+		t.Error(err)
+	}
+	if err := gp.stop(2, true); err != errProfileNotFound {
+		t.Error(err)
+	}
+	if err := gp.stop(1, true); nil != err {
+		t.Error(err)
+	}
+	var finished []*goroutineProfile
+	for i := 0; i < 100 && len(finished) == 0; i++ {
+		finished = gp.takeFinished()
+		if len(finished) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if len(finished) != 1 || finished[0].id != 1 || finished[0].end.IsZero() {
+		t.Fatal(finished)
+	}
+	if !strings.Contains(string(mustProfileData(t, finished[0])), `"run"`) {
+		t.Error("profile data missing")
+	}
+}
+
+func mustProfileData(t *testing.T, p *goroutineProfile) []byte {
+	data, err := p.Data("run")
+	if nil != err {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+// RecordedData returns a copy of the data captured by the test harvest.  It
+// is used by the newrelictest package.
+func (app *app) RecordedData() internal.RecordedData {
+	app.testHarvestLock.Lock()
+	defer app.testHarvestLock.Unlock()
+
+	h := app.testHarvest
+	if nil == h {
+		return internal.RecordedData{}
+	}
+	return internal.RecordedData{
+		Metrics:      recordedMetrics(h.Metrics),
+		SpanEvents:   recordedEvents(h.SpanEvents.analyticsEvents),
+		TxnEvents:    recordedEvents(h.TxnEvents.analyticsEvents),
+		CustomEvents: recordedEvents(h.CustomEvents.analyticsEvents),
+		ErrorEvents:  recordedEvents(h.ErrorEvents.analyticsEvents),
+		Errors:       recordedErrors(h.ErrorTraces),
+		Logs:         recordedLogs(h.LogEvents),
+	}
+}
+
+// ResetRecordedData empties the test harvest.
+func (app *app) ResetRecordedData() {
+	app.testHarvestLock.Lock()
+	defer app.testHarvestLock.Unlock()
+
+	if nil != app.testHarvest {
+		app.testHarvest = newHarvest(time.Now(), app.placeholderRun.harvestConfig)
+	}
+}
+
+func recordedMetrics(mt *metricTable) []internal.RecordedMetric {
+	metrics := make([]internal.RecordedMetric, 0, len(mt.metrics))
+	for id, m := range mt.metrics {
+		metrics = append(metrics, internal.RecordedMetric{
+			Name:       id.Name,
+			Scope:      id.Scope,
+			Forced:     forced == m.forced,
+			Count:      m.data.countSatisfied,
+			Total:      m.data.totalTolerated,
+			Exclusive:  m.data.exclusiveFailed,
+			Min:        m.data.min,
+			Max:        m.data.max,
+			SumSquares: m.data.sumSquares,
+		})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Name != metrics[j].Name {
+			return metrics[i].Name < metrics[j].Name
+		}
+		return metrics[i].Scope < metrics[j].Scope
+	})
+	return metrics
+}
+
+// recordedEvents decodes the events, which are written by the agent as
+// arrays of intrinsics, user attributes and agent attributes.
+func recordedEvents(events *analyticsEvents) []internal.RecordedEvent {
+	recorded := make([]internal.RecordedEvent, 0, len(events.events))
+	for _, e := range events.events {
+		buf := &bytes.Buffer{}
+		e.WriteJSON(buf)
+		var fields []map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &fields); nil != err || len(fields) < 3 {
+			continue
+		}
+		recorded = append(recorded, internal.RecordedEvent{
+			Intrinsics:      fields[0],
+			UserAttributes:  fields[1],
+			AgentAttributes: fields[2],
+		})
+	}
+	return recorded
+}
+
+func recordedErrors(errors harvestErrors) []internal.RecordedError {
+	recorded := make([]internal.RecordedError, 0, len(errors))
+	for _, e := range errors {
+		var fields []json.RawMessage
+		var attributes struct {
+			AgentAttributes map[string]interface{} `json:"agentAttributes"`
+			UserAttributes  map[string]interface{} `json:"userAttributes"`
+		}
+		js, _ := e.MarshalJSON()
+		if err := json.Unmarshal(js, &fields); nil != err || len(fields) < 5 {
+			continue
+		}
+		if err := json.Unmarshal(fields[4], &attributes); nil != err {
+			continue
+		}
+		recorded = append(recorded, internal.RecordedError{
+			TxnName:         e.FinalName,
+			Msg:             e.Msg,
+			Klass:           e.Klass,
+			Expected:        e.Expect,
+			UserAttributes:  attributes.UserAttributes,
+			AgentAttributes: attributes.AgentAttributes,
+		})
+	}
+	return recorded
+}
+
+func recordedLogs(events *logEvents) []internal.RecordedLog {
+	recorded := make([]internal.RecordedLog, 0, len(events.logs))
+	for _, e := range events.logs {
+		recorded = append(recorded, internal.RecordedLog{
+			Timestamp: e.timestamp,
+			Severity:  e.severity,
+			Message:   e.message,
+			SpanID:    e.spanID,
+			TraceID:   e.traceID,
+		})
+	}
+	// The logs are stored in a heap once the limit has been reached.
+	sort.SliceStable(recorded, func(i, j int) bool {
+		return recorded[i].Timestamp < recorded[j].Timestamp
+	})
+	return recorded
+}
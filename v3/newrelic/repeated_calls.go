@@ -0,0 +1,153 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"net/url"
+	"strings"
+)
+
+// maxRepeatedCallFingerprints limits the number of distinct calls counted by
+// a transaction.
+const maxRepeatedCallFingerprints = 1000
+
+const (
+	repeatedCallEventType = "RepeatedCall"
+	repeatedCallDatastore = "datastore"
+	repeatedCallExternal  = "external"
+)
+
+// repeatedCallKey is the fingerprint of a datastore or external call.
+type repeatedCallKey struct {
+	category string
+	// name is the scoped metric of datastore calls, which contains their
+	// product, collection and operation, and the host of external calls.
+	name   string
+	method string
+	// detail is the parameterized query of datastore calls and the
+	// normalized path of external calls.
+	detail string
+}
+
+// repeatedCalls counts the calls of a transaction by fingerprint.  A nil
+// *repeatedCalls counts nothing, when the detection is disabled.
+type repeatedCalls struct {
+	threshold int
+	counts    map[repeatedCallKey]int
+}
+
+func newRepeatedCalls(threshold int) *repeatedCalls {
+	return &repeatedCalls{
+		threshold: threshold,
+		counts:    make(map[repeatedCallKey]int),
+	}
+}
+
+// observe counts a call.  It returns the number of times the call has been
+// made if this exceeds the threshold, and zero otherwise.
+func (rc *repeatedCalls) observe(key repeatedCallKey) int {
+	if nil == rc {
+		return 0
+	}
+	count, ok := rc.counts[key]
+	if !ok && len(rc.counts) >= maxRepeatedCallFingerprints {
+		return 0
+	}
+	count++
+	rc.counts[key] = count
+	if count > rc.threshold {
+		return count
+	}
+	return 0
+}
+
+// maxCount returns the number of times the most repeated call has been made
+// if this exceeds the threshold, and zero otherwise.
+func (rc *repeatedCalls) maxCount() int {
+	if nil == rc {
+		return 0
+	}
+	max := 0
+	for _, count := range rc.counts {
+		if count > rc.threshold && count > max {
+			max = count
+		}
+	}
+	return max
+}
+
+// repeatedCallPath returns the path of the URL with the segments which look
+// like identifiers, such as "1234" or "3f2a9c0e-77d1", replaced with "*".
+func repeatedCallPath(u *url.URL) string {
+	if nil == u {
+		return ""
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, s := range segments {
+		if pathSegmentIsID(s) {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// pathSegmentIsID returns true if the segment is a number, or a hexadecimal
+// identifier with at least eight characters and a digit.
+func pathSegmentIsID(s string) bool {
+	digits := 0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r >= 'a' && r <= 'f', r >= 'A' && r <= 'F', r == '-':
+		default:
+			return false
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	return digits == len(s) || len(s) >= 8
+}
+
+// mergeRepeatedCalls records a supportability metric and, when custom events
+// are allowed, a custom event for each call of the transaction which has been
+// repeated more than the threshold.
+func mergeRepeatedCalls(txn *txn, h *harvest) {
+	rc := txn.repeatedCalls
+	if nil == rc {
+		return
+	}
+	recordEvents := txn.Config.CustomInsightsEvents.Enabled &&
+		!txn.Config.HighSecurity &&
+		txn.Reply.CollectCustomEvents &&
+		txn.Reply.SecurityPolicies.CustomEvents.Enabled()
+	for key, count := range rc.counts {
+		if count <= rc.threshold {
+			continue
+		}
+		params := map[string]interface{}{
+			"transactionName": txn.FinalName,
+			"category":        key.category,
+			"count":           count,
+		}
+		switch key.category {
+		case repeatedCallDatastore:
+			h.Metrics.addSingleCount(supportRepeatedCallDatastore, forced)
+			params["name"] = key.name
+			params["query"] = key.detail
+		case repeatedCallExternal:
+			h.Metrics.addSingleCount(supportRepeatedCallExternal, forced)
+			params["host"] = key.name
+			params["method"] = key.method
+			params["path"] = key.detail
+		}
+		if !recordEvents {
+			continue
+		}
+		if event, err := createCustomEvent(repeatedCallEventType, params, txn.Stop); nil == err {
+			h.CustomEvents.Add(event)
+		}
+	}
+}
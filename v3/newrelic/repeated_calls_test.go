@@ -0,0 +1,279 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func TestRepeatedCallPath(t *testing.T) {
+	testcases := []struct {
+		input  string
+		expect string
+	}{
+		{input: "http://example.com", expect: ""},
+		{input: "http://example.com/users", expect: "/users"},
+		{input: "http://example.com/users/1234?page=2", expect: "/users/*"},
+		{input: "http://example.com/v2/users/42/posts", expect: "/v2/users/*/posts"},
+		{input: "http://example.com/orders/3f2a9c0e-77d1-4b8e-9a51-0c7d2e6b1f00", expect: "/orders/*"},
+		{input: "http://example.com/blobs/deadbeef", expect: "/blobs/deadbeef"},
+		{input: "http://example.com/blobs/deadbeef0", expect: "/blobs/*"},
+		{input: "http://example.com/files/face", expect: "/files/face"},
+	}
+	for _, tc := range testcases {
+		u, _ := url.Parse(tc.input)
+		if got := repeatedCallPath(u); got != tc.expect {
+			t.Errorf("%s: got=%q want=%q", tc.input, got, tc.expect)
+		}
+	}
+	if got := repeatedCallPath(nil); got != "" {
+		t.Error(got)
+	}
+}
+
+func TestRepeatedCallsObserve(t *testing.T) {
+	rc := newRepeatedCalls(2)
+	key := repeatedCallKey{category: repeatedCallDatastore, name: "Datastore/statement/MySQL/users/select", detail: "SELECT"}
+	for i, expect := range []int{0, 0, 3, 4} {
+		if got := rc.observe(key); got != expect {
+			t.Errorf("call %d: got=%d want=%d", i+1, got, expect)
+		}
+	}
+	if max := rc.maxCount(); max != 4 {
+		t.Error(max)
+	}
+	var disabled *repeatedCalls
+	if got := disabled.observe(key); got != 0 {
+		t.Error(got)
+	}
+	if max := disabled.maxCount(); max != 0 {
+		t.Error(max)
+	}
+}
+
+func TestRepeatedCallsFingerprintLimit(t *testing.T) {
+	rc := newRepeatedCalls(0)
+	for i := 0; i < maxRepeatedCallFingerprints; i++ {
+		rc.observe(repeatedCallKey{name: "Datastore/operation/MySQL/select", detail: string(rune(i))})
+	}
+	if got := rc.observe(repeatedCallKey{name: "Datastore/operation/MySQL/select", detail: "new"}); got != 0 {
+		t.Error("call counted beyond the limit", got)
+	}
+	if len(rc.counts) != maxRepeatedCallFingerprints {
+		t.Error(len(rc.counts))
+	}
+}
+
+func repeatedCallsCfgFn(cfg *Config) {
+	cfg.DistributedTracer.Enabled = true
+	cfg.RepeatedCalls.Enabled = true
+	cfg.RepeatedCalls.Threshold = 2
+}
+
+func repeatedCallsReplyFn(reply *internal.ConnectReply) {
+	reply.SetSampleEverything()
+}
+
+func TestRepeatedDatastoreCalls(t *testing.T) {
+	app := testApp(repeatedCallsReplyFn, repeatedCallsCfgFn, t)
+	txn := app.StartTransaction("hello")
+	for i := 0; i < 3; i++ {
+		segment := DatastoreSegment{
+			StartTime:          txn.StartSegmentNow(),
+			Product:            DatastoreMySQL,
+			Collection:         "users",
+			Operation:          "select",
+			ParameterizedQuery: "SELECT * FROM users WHERE id = ?",
+		}
+		segment.End()
+	}
+	segment := DatastoreSegment{
+		StartTime:          txn.StartSegmentNow(),
+		Product:            DatastoreMySQL,
+		Collection:         "users",
+		Operation:          "select",
+		ParameterizedQuery: "SELECT * FROM users",
+	}
+	segment.End()
+	txn.End()
+	app.expectNoLoggedErrors(t)
+
+	datastoreSpan := func(query string, agentAttributes map[string]interface{}) internal.WantEvent {
+		agentAttributes["db.statement"] = query
+		agentAttributes["db.collection"] = "users"
+		return internal.WantEvent{
+			Intrinsics: map[string]interface{}{
+				"parentId":  internal.MatchAnything,
+				"name":      "Datastore/statement/MySQL/users/select",
+				"category":  "datastore",
+				"component": "MySQL",
+				"span.kind": "client",
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: agentAttributes,
+		}
+	}
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		datastoreSpan("SELECT * FROM users WHERE id = ?", map[string]interface{}{}),
+		datastoreSpan("SELECT * FROM users WHERE id = ?", map[string]interface{}{}),
+		datastoreSpan("SELECT * FROM users WHERE id = ?", map[string]interface{}{"repeatedCall.count": 3}),
+		datastoreSpan("SELECT * FROM users", map[string]interface{}{}),
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/hello",
+				"transaction.name": "OtherTransaction/Go/hello",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+			UserAttributes: map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{
+				"repeatedCall.count": 3,
+			},
+		},
+	})
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":              "OtherTransaction/Go/hello",
+			"guid":              internal.MatchAnything,
+			"priority":          internal.MatchAnything,
+			"sampled":           internal.MatchAnything,
+			"traceId":           internal.MatchAnything,
+			"databaseCallCount": 4,
+			"databaseDuration":  internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{
+			"repeatedCall.count": 3,
+		},
+	}})
+	app.ExpectCustomEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"type":      "RepeatedCall",
+			"timestamp": internal.MatchAnything,
+		},
+		UserAttributes: map[string]interface{}{
+			"transactionName": "OtherTransaction/Go/hello",
+			"category":        "datastore",
+			"count":           3,
+			"name":            "Datastore/statement/MySQL/users/select",
+			"query":           "SELECT * FROM users WHERE id = ?",
+		},
+	}})
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Supportability/RepeatedCall/Datastore", Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+	})
+}
+
+func TestRepeatedDatastoreCallsRawQuery(t *testing.T) {
+	app := testApp(repeatedCallsReplyFn, func(cfg *Config) {
+		repeatedCallsCfgFn(cfg)
+		cfg.DatastoreTracer.RawQuery.Enabled = true
+	}, t)
+	txn := app.StartTransaction("hello")
+	for _, name := range []string{"alice", "bob", "carol"} {
+		segment := DatastoreSegment{
+			StartTime:  txn.StartSegmentNow(),
+			Product:    DatastorePostgres,
+			Collection: "users",
+			Operation:  "select",
+			RawQuery:   "SELECT * FROM users WHERE name = '" + name + "'",
+		}
+		segment.End()
+	}
+	txn.End()
+	app.expectNoLoggedErrors(t)
+
+	// The calls differing by their literals are counted together, and the
+	// event contains the obfuscated query.
+	app.ExpectCustomEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"type":      "RepeatedCall",
+			"timestamp": internal.MatchAnything,
+		},
+		UserAttributes: map[string]interface{}{
+			"transactionName": "OtherTransaction/Go/hello",
+			"category":        "datastore",
+			"count":           3,
+			"name":            "Datastore/statement/Postgres/users/select",
+			"query":           "SELECT * FROM users WHERE name = ?",
+		},
+	}})
+}
+
+func TestRepeatedExternalCalls(t *testing.T) {
+	app := testApp(repeatedCallsReplyFn, repeatedCallsCfgFn, t)
+	txn := app.StartTransaction("hello")
+	for _, id := range []string{"1", "2", "3"} {
+		req, _ := http.NewRequest("GET", "http://example.com/users/"+id, nil)
+		StartExternalSegment(txn, req).End()
+	}
+	req, _ := http.NewRequest("POST", "http://example.com/users/4", nil)
+	StartExternalSegment(txn, req).End()
+	txn.End()
+	app.expectNoLoggedErrors(t)
+
+	app.ExpectCustomEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"type":      "RepeatedCall",
+			"timestamp": internal.MatchAnything,
+		},
+		UserAttributes: map[string]interface{}{
+			"transactionName": "OtherTransaction/Go/hello",
+			"category":        "external",
+			"count":           3,
+			"host":            "example.com",
+			"method":          "GET",
+			"path":            "/users/*",
+		},
+	}})
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Supportability/RepeatedCall/External", Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+	})
+}
+
+func TestRepeatedCallsNoCustomEvents(t *testing.T) {
+	app := testApp(repeatedCallsReplyFn, func(cfg *Config) {
+		repeatedCallsCfgFn(cfg)
+		cfg.CustomInsightsEvents.Enabled = false
+	}, t)
+	txn := app.StartTransaction("hello")
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "http://example.com/users", nil)
+		StartExternalSegment(txn, req).End()
+	}
+	txn.End()
+	app.ExpectCustomEvents(t, []internal.WantEvent{})
+	app.ExpectMetricsPresent(t, []internal.WantMetric{
+		{Name: "Supportability/RepeatedCall/External", Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+	})
+}
+
+func TestRepeatedCallsDisabled(t *testing.T) {
+	app := testApp(repeatedCallsReplyFn, func(cfg *Config) {
+		repeatedCallsCfgFn(cfg)
+		cfg.RepeatedCalls.Enabled = false
+	}, t)
+	txn := app.StartTransaction("hello")
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "http://example.com/users", nil)
+		StartExternalSegment(txn, req).End()
+	}
+	txn.End()
+	app.ExpectCustomEvents(t, []internal.WantEvent{})
+	app.ExpectTxnEvents(t, []internal.WantEvent{{
+		Intrinsics: map[string]interface{}{
+			"name":              "OtherTransaction/Go/hello",
+			"guid":              internal.MatchAnything,
+			"priority":          internal.MatchAnything,
+			"sampled":           internal.MatchAnything,
+			"traceId":           internal.MatchAnything,
+			"externalCallCount": 3,
+			"externalDuration":  internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{},
+	}})
+}
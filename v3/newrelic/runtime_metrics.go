@@ -0,0 +1,231 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+)
+
+// Names of the runtime/metrics read by the sampler.  Metrics which are not
+// supported by the Go version running the application read as
+// metrics.KindBad and are ignored.
+const (
+	rmHeapObjects     = "/gc/heap/objects:objects"
+	rmHeapObjectBytes = "/memory/classes/heap/objects:bytes"
+	rmHeapReleased    = "/memory/classes/heap/released:bytes"
+	rmHeapStacks      = "/memory/classes/heap/stacks:bytes"
+	rmOSStacks        = "/memory/classes/os-stacks:bytes"
+	rmMetadataPrefix  = "/memory/classes/metadata/"
+	rmTotal           = "/memory/classes/total:bytes"
+	rmHeapGoal        = "/gc/heap/goal:bytes"
+	rmMemoryLimit     = "/gc/gomemlimit:bytes"
+	rmGCPauses        = "/sched/pauses/total/gc:seconds"
+	rmGCPausesLegacy  = "/gc/pauses:seconds"
+	rmSchedLatencies  = "/sched/latencies:seconds"
+	rmGOMAXPROCS      = "/sched/gomaxprocs:threads"
+	rmMutexWait       = "/sync/mutex/wait/total:seconds"
+)
+
+// runtimeMetricNames are the names passed to metrics.Read.  The metadata
+// memory classes differ between Go versions and are discovered.
+var runtimeMetricNames = getRuntimeMetricNames(metrics.All())
+
+func getRuntimeMetricNames(descs []metrics.Description) []string {
+	names := []string{
+		rmHeapObjects, rmHeapObjectBytes, rmHeapReleased, rmHeapStacks,
+		rmOSStacks, rmTotal, rmHeapGoal, rmMemoryLimit, rmSchedLatencies,
+		rmGOMAXPROCS, rmMutexWait,
+	}
+	// Go 1.22 deprecated /gc/pauses:seconds in favor of
+	// /sched/pauses/total/gc:seconds.
+	pauses := rmGCPausesLegacy
+	for _, d := range descs {
+		if d.Name == rmGCPauses {
+			pauses = rmGCPauses
+		}
+		if strings.HasPrefix(d.Name, rmMetadataPrefix) {
+			names = append(names, d.Name)
+		}
+	}
+	return append(names, pauses)
+}
+
+// histogram is a copy of a cumulative metrics.Float64Histogram.
+type histogram struct {
+	counts  []uint64
+	buckets []float64
+}
+
+func newHistogram(h *metrics.Float64Histogram) histogram {
+	return histogram{
+		counts: append([]uint64(nil), h.Counts...),
+		// Buckets are never modified by the runtime: only the counts
+		// must be copied.
+		buckets: h.Buckets,
+	}
+}
+
+// runtimeSample is a snapshot of the runtime/metrics.  Sizes are in bytes.
+type runtimeSample struct {
+	heapObjects     uint64
+	heapObjectBytes uint64
+	heapReleased    uint64
+	stacks          uint64
+	metadata        uint64
+	total           uint64
+	heapGoal        uint64
+	// memoryLimit is math.MaxInt64 when no limit is set.
+	memoryLimit    int64
+	gomaxprocs     int
+	gcPauses       histogram
+	schedLatencies histogram
+	// mutexWait is the cumulative time goroutines spent blocked on a
+	// sync.Mutex or sync.RWMutex, in seconds.  It is negative if the
+	// metric is not supported.
+	mutexWait float64
+}
+
+// readRuntimeSample reads the runtime/metrics.  Unlike runtime.ReadMemStats,
+// it does not stop the world.
+func readRuntimeSample() runtimeSample {
+	samples := make([]metrics.Sample, len(runtimeMetricNames))
+	for i, name := range runtimeMetricNames {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+
+	s := runtimeSample{
+		// /gc/gomemlimit:bytes and /sched/gomaxprocs:threads are not
+		// available before Go 1.21 and 1.20.
+		memoryLimit: debug.SetMemoryLimit(-1),
+		gomaxprocs:  runtime.GOMAXPROCS(0),
+		mutexWait:   -1,
+	}
+	for _, sample := range samples {
+		v := sample.Value
+		switch v.Kind() {
+		case metrics.KindUint64:
+			switch name := sample.Name; {
+			case name == rmHeapObjects:
+				s.heapObjects = v.Uint64()
+			case name == rmHeapObjectBytes:
+				s.heapObjectBytes = v.Uint64()
+			case name == rmHeapReleased:
+				s.heapReleased = v.Uint64()
+			case name == rmHeapStacks, name == rmOSStacks:
+				s.stacks += v.Uint64()
+			case strings.HasPrefix(name, rmMetadataPrefix):
+				s.metadata += v.Uint64()
+			case name == rmTotal:
+				s.total = v.Uint64()
+			case name == rmHeapGoal:
+				s.heapGoal = v.Uint64()
+			case name == rmMemoryLimit:
+				s.memoryLimit = int64(v.Uint64())
+			case name == rmGOMAXPROCS:
+				s.gomaxprocs = int(v.Uint64())
+			}
+		case metrics.KindFloat64:
+			if sample.Name == rmMutexWait {
+				s.mutexWait = v.Float64()
+			}
+		case metrics.KindFloat64Histogram:
+			switch sample.Name {
+			case rmGCPauses, rmGCPausesLegacy:
+				s.gcPauses = newHistogram(v.Float64Histogram())
+			case rmSchedLatencies:
+				s.schedLatencies = newHistogram(v.Float64Histogram())
+			}
+		}
+	}
+	return s
+}
+
+// histogramStats summarizes the observations made between two samples of a
+// histogram.  Since only the bucket of each observation is known, values are
+// estimates: each observation is counted at the middle of its bucket.
+type histogramStats struct {
+	count      uint64
+	total      float64
+	min        float64
+	max        float64
+	sumSquares float64
+	p50        float64
+	p95        float64
+	p99        float64
+}
+
+// bucketBounds returns the finite bounds of bucket i: the first and last
+// buckets of a histogram may extend to infinity.
+func (h histogram) bucketBounds(i int) (lower, upper float64) {
+	lower, upper = h.buckets[i], h.buckets[i+1]
+	if math.IsInf(lower, -1) {
+		lower = upper
+	}
+	if math.IsInf(upper, 1) {
+		upper = lower
+	}
+	return
+}
+
+// getHistogramStats combines two samples of a histogram.
+func getHistogramStats(prev, cur histogram) histogramStats {
+	var hs histogramStats
+	if len(prev.counts) != len(cur.counts) || len(cur.buckets) != len(cur.counts)+1 {
+		return hs
+	}
+	deltas := make([]uint64, len(cur.counts))
+	for i := range cur.counts {
+		deltas[i] = cur.counts[i] - prev.counts[i]
+		if 0 == deltas[i] {
+			continue
+		}
+		lower, upper := cur.bucketBounds(i)
+		if 0 == hs.count {
+			hs.min = lower
+		}
+		hs.max = upper
+		mid := (lower + upper) / 2
+		hs.count += deltas[i]
+		hs.total += float64(deltas[i]) * mid
+		hs.sumSquares += float64(deltas[i]) * mid * mid
+	}
+	if 0 == hs.count {
+		return hs
+	}
+	hs.p50 = cur.percentile(deltas, hs.count, 0.50)
+	hs.p95 = cur.percentile(deltas, hs.count, 0.95)
+	hs.p99 = cur.percentile(deltas, hs.count, 0.99)
+	return hs
+}
+
+// percentile returns the upper bound of the bucket containing the q quantile
+// of the observations counted by deltas.
+func (h histogram) percentile(deltas []uint64, count uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(count)))
+	var seen uint64
+	for i, d := range deltas {
+		seen += d
+		if seen >= rank && d > 0 {
+			_, upper := h.bucketBounds(i)
+			return upper
+		}
+	}
+	return 0
+}
+
+func (hs histogramStats) metricData() metricData {
+	return metricData{
+		countSatisfied:  float64(hs.count),
+		totalTolerated:  hs.total,
+		exclusiveFailed: 0,
+		min:             hs.min,
+		max:             hs.max,
+		sumSquares:      hs.sumSquares,
+	}
+}
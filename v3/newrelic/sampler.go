@@ -4,6 +4,7 @@
 package newrelic
 
 import (
+	"math"
 	"runtime"
 	"time"
 
@@ -13,8 +14,9 @@ import (
 // systemSample is a system/runtime snapshot.
 type systemSample struct {
 	when         time.Time
-	memStats     runtime.MemStats
+	runtime      runtimeSample
 	usage        sysinfo.Usage
+	throttling   *sysinfo.CPUThrottling
 	numGoroutine int
 	numCPU       int
 }
@@ -27,6 +29,7 @@ func bytesToMebibytesFloat(bts uint64) float64 {
 func getSystemSample(now time.Time, lg Logger) *systemSample {
 	s := systemSample{
 		when:         now,
+		runtime:      readRuntimeSample(),
 		numGoroutine: runtime.NumGoroutine(),
 		numCPU:       runtime.NumCPU(),
 	}
@@ -39,7 +42,10 @@ func getSystemSample(now time.Time, lg Logger) *systemSample {
 		})
 	}
 
-	runtime.ReadMemStats(&s.memStats)
+	// Most processes do not run with a CPU limit: the error is expected.
+	if throttling, err := sysinfo.GetCPUThrottling(); err == nil {
+		s.throttling = &throttling
+	}
 
 	return &s
 }
@@ -49,18 +55,43 @@ type cpuStats struct {
 	fraction float64 // used / (elapsed * numCPU)
 }
 
+// memoryStats contains the memory classes of the runtime.
+type memoryStats struct {
+	stacks       uint64
+	heapReleased uint64
+	metadata     uint64
+	total        uint64
+	heapGoal     uint64
+	// limit is zero when no GOMEMLIMIT is set.
+	limit uint64
+	// limitFraction is the share of the limit in use.  The runtime
+	// counts all of its memory but the released heap against the limit.
+	limitFraction float64
+}
+
+// throttlingStats contains the CPU throttling of the process' cgroup.
+type throttlingStats struct {
+	periods   uint64
+	throttled uint64
+	time      time.Duration
+	fraction  float64 // throttled / periods
+}
+
 // systemStats contains system information for a period of time.
 type systemStats struct {
 	numGoroutine    int
+	gomaxprocs      int
 	allocBytes      uint64
 	heapObjects     uint64
+	memory          memoryStats
 	user            cpuStats
 	system          cpuStats
 	gcPauseFraction float64
-	deltaNumGC      uint32
-	deltaPauseTotal time.Duration
-	minPause        time.Duration
-	maxPause        time.Duration
+	gcPauses        histogramStats
+	schedLatencies  histogramStats
+	// mutexWait is negative if the runtime does not report it.
+	mutexWait  time.Duration
+	throttling *throttlingStats
 }
 
 // systemSamples is used as the parameter to getSystemStats to avoid mixing up the previous
@@ -78,8 +109,23 @@ func getSystemStats(ss systemSamples) systemStats {
 
 	s := systemStats{
 		numGoroutine: cur.numGoroutine,
-		allocBytes:   cur.memStats.Alloc,
-		heapObjects:  cur.memStats.HeapObjects,
+		gomaxprocs:   cur.runtime.gomaxprocs,
+		allocBytes:   cur.runtime.heapObjectBytes,
+		heapObjects:  cur.runtime.heapObjects,
+		memory: memoryStats{
+			stacks:       cur.runtime.stacks,
+			heapReleased: cur.runtime.heapReleased,
+			metadata:     cur.runtime.metadata,
+			total:        cur.runtime.total,
+			heapGoal:     cur.runtime.heapGoal,
+		},
+		mutexWait: -1,
+	}
+
+	// GOMEMLIMIT Proximity
+	if limit := cur.runtime.memoryLimit; limit > 0 && limit < math.MaxInt64 {
+		s.memory.limit = uint64(limit)
+		s.memory.limitFraction = float64(cur.runtime.total-cur.runtime.heapReleased) / float64(limit)
 	}
 
 	// CPU Utilization
@@ -93,37 +139,39 @@ func getSystemStats(ss systemSamples) systemStats {
 		s.system.fraction = s.system.used.Seconds() / totalCPUSeconds
 	}
 
-	// GC Pause Fraction
-	deltaPauseTotalNs := cur.memStats.PauseTotalNs - prev.memStats.PauseTotalNs
-	frac := float64(deltaPauseTotalNs) / float64(elapsed.Nanoseconds())
-	s.gcPauseFraction = frac
-
-	// GC Pauses
-	if deltaNumGC := cur.memStats.NumGC - prev.memStats.NumGC; deltaNumGC > 0 {
-		// In case more than 256 pauses have happened between samples
-		// and we are examining a subset of the pauses, we ensure that
-		// the min and max are not on the same side of the average by
-		// using the average as the starting min and max.
-		maxPauseNs := deltaPauseTotalNs / uint64(deltaNumGC)
-		minPauseNs := deltaPauseTotalNs / uint64(deltaNumGC)
-		for i := prev.memStats.NumGC + 1; i <= cur.memStats.NumGC; i++ {
-			pause := cur.memStats.PauseNs[(i+255)%256]
-			if pause > maxPauseNs {
-				maxPauseNs = pause
-			}
-			if pause < minPauseNs {
-				minPauseNs = pause
-			}
+	// GC Pauses and Scheduler Latencies
+	s.gcPauses = getHistogramStats(prev.runtime.gcPauses, cur.runtime.gcPauses)
+	s.gcPauseFraction = s.gcPauses.total / elapsed.Seconds()
+	s.schedLatencies = getHistogramStats(prev.runtime.schedLatencies, cur.runtime.schedLatencies)
+
+	// Mutex Wait Time
+	if prev.runtime.mutexWait >= 0 && cur.runtime.mutexWait >= prev.runtime.mutexWait {
+		s.mutexWait = time.Duration((cur.runtime.mutexWait - prev.runtime.mutexWait) * float64(time.Second))
+	}
+
+	// CPU Throttling
+	if nil != prev.throttling && nil != cur.throttling && cur.throttling.Periods >= prev.throttling.Periods {
+		t := &throttlingStats{
+			periods:   cur.throttling.Periods - prev.throttling.Periods,
+			throttled: cur.throttling.Throttled - prev.throttling.Throttled,
+			time:      cur.throttling.ThrottledTime - prev.throttling.ThrottledTime,
 		}
-		s.deltaPauseTotal = time.Duration(deltaPauseTotalNs) * time.Nanosecond
-		s.deltaNumGC = deltaNumGC
-		s.minPause = time.Duration(minPauseNs) * time.Nanosecond
-		s.maxPause = time.Duration(maxPauseNs) * time.Nanosecond
+		if t.periods > 0 {
+			t.fraction = float64(t.throttled) / float64(t.periods)
+		}
+		s.throttling = t
 	}
 
 	return s
 }
 
+// addPercentiles records the percentiles of a histogram as gauges.
+func addPercentiles(h *harvest, name string, hs histogramStats) {
+	h.Metrics.addValue(name+"/p50", "", hs.p50, forced)
+	h.Metrics.addValue(name+"/p95", "", hs.p95, forced)
+	h.Metrics.addValue(name+"/p99", "", hs.p99, forced)
+}
+
 // MergeIntoHarvest implements Harvestable.
 func (s systemStats) MergeIntoHarvest(h *harvest) {
 	h.Metrics.addValue(heapObjectsAllocated, "", float64(s.heapObjects), forced)
@@ -134,14 +182,35 @@ func (s systemStats) MergeIntoHarvest(h *harvest) {
 	h.Metrics.addValue(cpuUserTime, "", s.user.used.Seconds(), forced)
 	h.Metrics.addValue(cpuSystemTime, "", s.system.used.Seconds(), forced)
 	h.Metrics.addValueExclusive(gcPauseFraction, "", s.gcPauseFraction, 0, forced)
-	if s.deltaNumGC > 0 {
-		h.Metrics.add(gcPauses, "", metricData{
-			countSatisfied:  float64(s.deltaNumGC),
-			totalTolerated:  s.deltaPauseTotal.Seconds(),
-			exclusiveFailed: 0,
-			min:             s.minPause.Seconds(),
-			max:             s.maxPause.Seconds(),
-			sumSquares:      s.deltaPauseTotal.Seconds() * s.deltaPauseTotal.Seconds(),
-		}, forced)
+	if s.gcPauses.count > 0 {
+		h.Metrics.add(gcPauses, "", s.gcPauses.metricData(), forced)
+		addPercentiles(h, gcPauses, s.gcPauses)
+	}
+	if s.schedLatencies.count > 0 {
+		h.Metrics.add(schedLatency, "", s.schedLatencies.metricData(), forced)
+		addPercentiles(h, schedLatency, s.schedLatencies)
+	}
+
+	if s.gomaxprocs > 0 {
+		h.Metrics.addValue(runGOMAXPROCS, "", float64(s.gomaxprocs), forced)
+	}
+	if s.memory.total > 0 {
+		h.Metrics.addValue(memoryStacks, "", bytesToMebibytesFloat(s.memory.stacks), forced)
+		h.Metrics.addValue(memoryHeapReleased, "", bytesToMebibytesFloat(s.memory.heapReleased), forced)
+		h.Metrics.addValue(memoryMetadata, "", bytesToMebibytesFloat(s.memory.metadata), forced)
+		h.Metrics.addValue(memoryTotal, "", bytesToMebibytesFloat(s.memory.total), forced)
+		h.Metrics.addValue(memoryHeapGoal, "", bytesToMebibytesFloat(s.memory.heapGoal), forced)
+	}
+	if s.memory.limit > 0 {
+		h.Metrics.addValue(memoryLimit, "", bytesToMebibytesFloat(s.memory.limit), forced)
+		h.Metrics.addValueExclusive(memoryLimitUtilization, "", s.memory.limitFraction, 0, forced)
+	}
+	if s.mutexWait >= 0 {
+		h.Metrics.addValue(mutexWaitTime, "", s.mutexWait.Seconds(), forced)
+	}
+	if nil != s.throttling {
+		h.Metrics.addValue(cpuThrottledPeriods, "", float64(s.throttling.throttled), forced)
+		h.Metrics.addValue(cpuThrottledTime, "", s.throttling.time.Seconds(), forced)
+		h.Metrics.addValueExclusive(cpuThrottledFraction, "", s.throttling.fraction, 0, forced)
 	}
 }
@@ -4,6 +4,8 @@
 package newrelic
 
 import (
+	"math"
+	"runtime"
 	"testing"
 	"time"
 
@@ -26,8 +28,69 @@ func TestGetSample(t *testing.T) {
 	if sample.numCPU <= 0 {
 		t.Error(sample.numCPU)
 	}
-	if sample.memStats.HeapObjects == 0 {
-		t.Error(sample.memStats.HeapObjects)
+	if sample.runtime.heapObjects == 0 {
+		t.Error(sample.runtime.heapObjects)
+	}
+	if sample.runtime.total == 0 || sample.runtime.stacks == 0 || sample.runtime.metadata == 0 {
+		t.Error(sample.runtime)
+	}
+	if sample.runtime.gomaxprocs <= 0 {
+		t.Error(sample.runtime.gomaxprocs)
+	}
+	if len(sample.runtime.gcPauses.counts) == 0 || len(sample.runtime.schedLatencies.counts) == 0 {
+		t.Error(sample.runtime)
+	}
+}
+
+func TestGetSystemStats(t *testing.T) {
+	now := time.Now()
+	prev := getSystemSample(now, logger.ShimLogger{})
+	runtime.GC()
+	cur := getSystemSample(now.Add(time.Minute), logger.ShimLogger{})
+
+	stats := getSystemStats(systemSamples{Previous: prev, Current: cur})
+	if stats.gcPauses.count == 0 {
+		t.Error("gc pauses missing", stats.gcPauses)
+	}
+	if stats.gcPauses.min > stats.gcPauses.max || stats.gcPauses.p50 > stats.gcPauses.p99 {
+		t.Error(stats.gcPauses)
+	}
+	if stats.gcPauseFraction <= 0 {
+		t.Error(stats.gcPauseFraction)
+	}
+	if stats.memory.total == 0 {
+		t.Error(stats.memory)
+	}
+}
+
+func TestGetHistogramStats(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 0, 1, 2, 4, math.Inf(1)}
+	prev := histogram{counts: []uint64{0, 1, 0, 0, 0}, buckets: buckets}
+	cur := histogram{counts: []uint64{0, 11, 5, 0, 4}, buckets: buckets}
+
+	hs := getHistogramStats(prev, cur)
+	// 10 observations in [0,1), 5 in [1,2) and 4 in [4,+Inf).
+	expect := histogramStats{
+		count:      19,
+		total:      10*0.5 + 5*1.5 + 4*4,
+		min:        0,
+		max:        4,
+		sumSquares: 10*0.25 + 5*2.25 + 4*16,
+		p50:        1,
+		p95:        4,
+		p99:        4,
+	}
+	if hs != expect {
+		t.Errorf("%+v", hs)
+	}
+
+	// Histograms without new observations and mismatched histograms are
+	// empty.
+	if hs := getHistogramStats(cur, cur); hs != (histogramStats{}) {
+		t.Errorf("%+v", hs)
+	}
+	if hs := getHistogramStats(histogram{}, cur); hs != (histogramStats{}) {
+		t.Errorf("%+v", hs)
 	}
 }
 
@@ -48,10 +111,43 @@ func TestMetricsCreated(t *testing.T) {
 			fraction: 0.02,
 		},
 		gcPauseFraction: 3e-05,
-		deltaNumGC:      2,
-		deltaPauseTotal: 500 * time.Microsecond,
-		minPause:        100 * time.Microsecond,
-		maxPause:        400 * time.Microsecond,
+		gcPauses: histogramStats{
+			count:      2,
+			total:      0.0005,
+			min:        0.0001,
+			max:        0.0004,
+			sumSquares: 2.5e-7,
+			p50:        0.0001,
+			p95:        0.0004,
+			p99:        0.0004,
+		},
+		schedLatencies: histogramStats{
+			count:      10,
+			total:      0.001,
+			min:        0.00005,
+			max:        0.0002,
+			sumSquares: 2e-7,
+			p50:        0.0001,
+			p95:        0.0002,
+			p99:        0.0002,
+		},
+		gomaxprocs: 4,
+		memory: memoryStats{
+			stacks:        1024 * 1024,
+			heapReleased:  2 * 1024 * 1024,
+			metadata:      3 * 1024 * 1024,
+			total:         64 * 1024 * 1024,
+			heapGoal:      48 * 1024 * 1024,
+			limit:         128 * 1024 * 1024,
+			limitFraction: 0.5,
+		},
+		mutexWait: 250 * time.Millisecond,
+		throttling: &throttlingStats{
+			periods:   600,
+			throttled: 300,
+			time:      1500 * time.Millisecond,
+			fraction:  0.5,
+		},
 	}
 
 	stats.MergeIntoHarvest(h)
@@ -66,6 +162,25 @@ func TestMetricsCreated(t *testing.T) {
 		{Name: "Go/Runtime/Goroutines", Scope: "", Forced: true, Data: []float64{1, 23, 23, 23, 23, 529}},
 		{Name: "GC/System/Pause Fraction", Scope: "", Forced: true, Data: []float64{1, 3e-05, 0, 3e-05, 3e-05, 9e-10}},
 		{Name: "GC/System/Pauses", Scope: "", Forced: true, Data: []float64{2, 0.0005, 0, 0.0001, 0.0004, 2.5e-7}},
+		{Name: "GC/System/Pauses/p50", Scope: "", Forced: true, Data: []float64{1, 0.0001, 0.0001, 0.0001, 0.0001, 1e-8}},
+		{Name: "GC/System/Pauses/p95", Scope: "", Forced: true, Data: []float64{1, 0.0004, 0.0004, 0.0004, 0.0004, 1.6e-7}},
+		{Name: "GC/System/Pauses/p99", Scope: "", Forced: true, Data: []float64{1, 0.0004, 0.0004, 0.0004, 0.0004, 1.6e-7}},
+		{Name: "Go/Runtime/Scheduler/Latency", Scope: "", Forced: true, Data: []float64{10, 0.001, 0, 0.00005, 0.0002, 2e-7}},
+		{Name: "Go/Runtime/Scheduler/Latency/p50", Scope: "", Forced: true, Data: []float64{1, 0.0001, 0.0001, 0.0001, 0.0001, 1e-8}},
+		{Name: "Go/Runtime/Scheduler/Latency/p95", Scope: "", Forced: true, Data: []float64{1, 0.0002, 0.0002, 0.0002, 0.0002, 4e-8}},
+		{Name: "Go/Runtime/Scheduler/Latency/p99", Scope: "", Forced: true, Data: []float64{1, 0.0002, 0.0002, 0.0002, 0.0002, 4e-8}},
+		{Name: "Go/Runtime/GOMAXPROCS", Scope: "", Forced: true, Data: []float64{1, 4, 4, 4, 4, 16}},
+		{Name: "Go/Runtime/Memory/Stacks", Scope: "", Forced: true, Data: []float64{1, 1, 1, 1, 1, 1}},
+		{Name: "Go/Runtime/Memory/HeapReleased", Scope: "", Forced: true, Data: []float64{1, 2, 2, 2, 2, 4}},
+		{Name: "Go/Runtime/Memory/Metadata", Scope: "", Forced: true, Data: []float64{1, 3, 3, 3, 3, 9}},
+		{Name: "Go/Runtime/Memory/Total", Scope: "", Forced: true, Data: []float64{1, 64, 64, 64, 64, 4096}},
+		{Name: "Go/Runtime/Memory/HeapGoal", Scope: "", Forced: true, Data: []float64{1, 48, 48, 48, 48, 2304}},
+		{Name: "Go/Runtime/Memory/Limit", Scope: "", Forced: true, Data: []float64{1, 128, 128, 128, 128, 16384}},
+		{Name: "Go/Runtime/Memory/Limit/Utilization", Scope: "", Forced: true, Data: []float64{1, 0.5, 0, 0.5, 0.5, 0.25}},
+		{Name: "Go/Runtime/Mutex/WaitTime", Scope: "", Forced: true, Data: []float64{1, 0.25, 0.25, 0.25, 0.25, 0.0625}},
+		{Name: "CPU/Throttled/Periods", Scope: "", Forced: true, Data: []float64{1, 300, 300, 300, 300, 90000}},
+		{Name: "CPU/Throttled/Time", Scope: "", Forced: true, Data: []float64{1, 1.5, 1.5, 1.5, 1.5, 2.25}},
+		{Name: "CPU/Throttled/Fraction", Scope: "", Forced: true, Data: []float64{1, 0.5, 0, 0.5, 0.5, 0.25}},
 	})
 }
 
@@ -85,5 +200,6 @@ func TestMetricsCreatedEmpty(t *testing.T) {
 		{Name: "CPU/System/Utilization", Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
 		{Name: "Go/Runtime/Goroutines", Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
 		{Name: "GC/System/Pause Fraction", Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
+		{Name: "Go/Runtime/Mutex/WaitTime", Scope: "", Forced: true, Data: []float64{1, 0, 0, 0, 0, 0}},
 	})
 }
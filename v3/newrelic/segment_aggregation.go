@@ -0,0 +1,130 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import "time"
+
+// segmentKind is a type of segment, used to enable the merging of the
+// segments of each type with Config.SegmentAggregation.
+type segmentKind int
+
+const (
+	segmentKindCustom segmentKind = 1 << iota
+	segmentKindDatastore
+	segmentKindExternal
+	segmentKindMessage
+)
+
+func aggregatedSegmentKinds(c *Config) segmentKind {
+	var kinds segmentKind
+	if c.SegmentAggregation.Custom {
+		kinds |= segmentKindCustom
+	}
+	if c.SegmentAggregation.Datastore {
+		kinds |= segmentKindDatastore
+	}
+	if c.SegmentAggregation.External {
+		kinds |= segmentKindExternal
+	}
+	if c.SegmentAggregation.Message {
+		kinds |= segmentKindMessage
+	}
+	return kinds
+}
+
+// segmentAggregate contains consecutive sibling segments with the same name,
+// which are merged into the trace node and the span event of the first one.
+type segmentAggregate struct {
+	kind        segmentKind
+	name        string
+	threadID    uint64
+	parentStamp segmentStamp
+	start       segmentTime
+	stop        segmentTime
+
+	count int
+	total time.Duration
+	min   time.Duration
+	max   time.Duration
+
+	// node is the index of the trace node of the segments in the trace
+	// nodes, or -1 if it has not been kept.
+	node         int
+	attributes   spanAttributeMap
+	externalGUID string
+	span         *spanEvent
+}
+
+// duration returns the time from the start of the first segment to the end
+// of the last.
+func (agg *segmentAggregate) duration() time.Duration {
+	if agg.stop.Time.After(agg.start.Time) {
+		return agg.stop.Time.Sub(agg.start.Time)
+	}
+	return 0
+}
+
+// addAttributes adds the aggregate attributes to the map, then filtered for
+// the destination.
+func (agg *segmentAggregate) addAttributes(attrs *spanAttributeMap, a *attributes, d destinationSet) {
+	attrs.addInt(SpanAttributeAggregateCount, agg.count)
+	attrs.addFloat(SpanAttributeAggregateTotalDuration, agg.total.Seconds())
+	attrs.addFloat(SpanAttributeAggregateMinDuration, agg.min.Seconds())
+	attrs.addFloat(SpanAttributeAggregateMaxDuration, agg.max.Seconds())
+	*attrs = a.filterSpanAttributes(*attrs, d)
+}
+
+// merge adds the segment to the aggregate.
+func (agg *segmentAggregate) merge(end segmentEnd) {
+	agg.count++
+	agg.total += end.duration
+	if end.duration < agg.min {
+		agg.min = end.duration
+	}
+	if end.duration > agg.max {
+		agg.max = end.duration
+	}
+	agg.stop = end.stop
+}
+
+// aggregateSegment merges the segment which has ended into the previous
+// segment of the thread, if both are of a kind for which aggregation is
+// enabled, have the same name and the same parent, and have no children.
+// It returns true if the segment has been merged, in which case it must not
+// create a trace node nor a span event.  Otherwise, the segment may start a
+// new aggregate, whose trace node and span event are recorded as usual.
+func (t *txnData) aggregateSegment(thread *tracingThread, kind segmentKind, end *segmentEnd, name string) bool {
+	if t.aggregatedKinds&kind == 0 || end.hasChildren {
+		return false
+	}
+	if agg := end.previous; nil != agg && agg.kind == kind && agg.name == name && agg.parentStamp == end.parentStamp {
+		agg.merge(*end)
+		thread.aggregate = agg
+		if t.TxnTrace.Enabled {
+			agg.addAttributes(&agg.attributes, t.Attrs, destSegment)
+			t.TxnTrace.witnessAggregate(agg)
+		}
+		if nil != agg.span {
+			agg.span.Duration = agg.duration()
+			agg.addAttributes(&agg.span.AgentAttributes, t.Attrs, destSpan)
+		}
+		return true
+	}
+	agg := &segmentAggregate{
+		kind:        kind,
+		name:        name,
+		threadID:    end.threadID,
+		parentStamp: end.parentStamp,
+		start:       end.start,
+		stop:        end.stop,
+		count:       1,
+		total:       end.duration,
+		min:         end.duration,
+		max:         end.duration,
+		node:        -1,
+	}
+	end.aggregate = agg
+	thread.aggregate = agg
+	return false
+}
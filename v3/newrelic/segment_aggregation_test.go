@@ -0,0 +1,256 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+type aggregationTestSegment struct {
+	name     string
+	duration int // seconds
+}
+
+func aggregationTestTrace(t *testing.T, txndata *txnData, segments []aggregationTestSegment) *harvestTraces {
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	thread := &tracingThread{}
+	now := start
+	for _, seg := range segments {
+		s := startSegment(txndata, thread, now)
+		now = now.Add(time.Duration(seg.duration) * time.Second)
+		if err := endBasicSegment(txndata, thread, s, now, seg.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ht := newHarvestTraces()
+	ht.regular.addTxnTrace(&harvestTrace{
+		txnEvent: txnEvent{
+			Start:     start,
+			Duration:  now.Sub(start),
+			TotalTime: now.Sub(start),
+			FinalName: "WebTransaction/Go/hello",
+			Attrs:     newAttributes(createAttributeConfig(config{Config: defaultConfig()}, true)),
+		},
+		Trace: txndata.TxnTrace,
+	})
+	return ht
+}
+
+func aggregationTestTxnData() *txnData {
+	txndata := &txnData{aggregatedKinds: segmentKindCustom}
+	txndata.TxnTrace.Enabled = true
+	txndata.TxnTrace.StackTraceThreshold = 1 * time.Hour
+	txndata.TxnTrace.SegmentThreshold = 0
+	return txndata
+}
+
+func aggregationTestWant(durationMillis float64, children []internal.WantTraceSegment) []internal.WantTxnTrace {
+	return []internal.WantTxnTrace{{
+		MetricName: "WebTransaction/Go/hello",
+		Root: internal.WantTraceSegment{
+			SegmentName: "ROOT",
+			Attributes:  map[string]interface{}{},
+			Children: []internal.WantTraceSegment{{
+				SegmentName: "WebTransaction/Go/hello",
+				Attributes:  map[string]interface{}{"exclusive_duration_millis": durationMillis},
+				Children:    children,
+			}},
+		},
+	}}
+}
+
+func TestSegmentAggregationTrace(t *testing.T) {
+	txndata := aggregationTestTxnData()
+	ht := aggregationTestTrace(t, txndata, []aggregationTestSegment{
+		{"a", 1}, {"a", 2}, {"a", 3}, {"b", 1}, {"a", 1},
+	})
+	expectTxnTraces(t, ht, aggregationTestWant(8000, []internal.WantTraceSegment{{
+		SegmentName:         "Custom/a",
+		RelativeStartMillis: 0,
+		RelativeStopMillis:  6000,
+		Attributes: map[string]interface{}{
+			"aggregate.count":         3,
+			"aggregate.totalDuration": 6,
+			"aggregate.minDuration":   1,
+			"aggregate.maxDuration":   3,
+		},
+		Children: []internal.WantTraceSegment{},
+	}, {
+		SegmentName:         "Custom/b",
+		RelativeStartMillis: 6000,
+		RelativeStopMillis:  7000,
+		Attributes:          map[string]interface{}{},
+		Children:            []internal.WantTraceSegment{},
+	}, {
+		SegmentName:         "Custom/a",
+		RelativeStartMillis: 7000,
+		RelativeStopMillis:  8000,
+		Attributes:          map[string]interface{}{},
+		Children:            []internal.WantTraceSegment{},
+	}}))
+	// Metrics still record each segment.
+	if data := txndata.customSegments["a"]; data.countSatisfied != 4 || data.totalTolerated != 7 {
+		t.Error(data)
+	}
+}
+
+func TestSegmentAggregationSegmentThreshold(t *testing.T) {
+	// The aggregate is kept when it exceeds the threshold, even though
+	// none of its segments does.
+	txndata := aggregationTestTxnData()
+	txndata.TxnTrace.SegmentThreshold = 5 * time.Second
+	ht := aggregationTestTrace(t, txndata, []aggregationTestSegment{
+		{"a", 2}, {"a", 2}, {"a", 2}, {"b", 2},
+	})
+	expectTxnTraces(t, ht, aggregationTestWant(8000, []internal.WantTraceSegment{{
+		SegmentName:         "Custom/a",
+		RelativeStartMillis: 0,
+		RelativeStopMillis:  6000,
+		Attributes: map[string]interface{}{
+			"aggregate.count":         3,
+			"aggregate.totalDuration": 6,
+			"aggregate.minDuration":   2,
+			"aggregate.maxDuration":   2,
+		},
+		Children: []internal.WantTraceSegment{},
+	}}))
+}
+
+func TestSegmentAggregationMaxNodes(t *testing.T) {
+	// The node of the aggregate is tracked as it moves in the heap of
+	// the slowest nodes.
+	txndata := aggregationTestTxnData()
+	txndata.TxnTrace.maxNodes = 2
+	ht := aggregationTestTrace(t, txndata, []aggregationTestSegment{
+		{"b", 5}, {"c", 4}, {"a", 3}, {"a", 3}, {"a", 3}, {"d", 8},
+	})
+	expectTxnTraces(t, ht, aggregationTestWant(26000, []internal.WantTraceSegment{{
+		SegmentName:         "Custom/a",
+		RelativeStartMillis: 9000,
+		RelativeStopMillis:  18000,
+		Attributes: map[string]interface{}{
+			"aggregate.count":         3,
+			"aggregate.totalDuration": 9,
+			"aggregate.minDuration":   3,
+			"aggregate.maxDuration":   3,
+		},
+		Children: []internal.WantTraceSegment{},
+	}, {
+		SegmentName:         "Custom/d",
+		RelativeStartMillis: 18000,
+		RelativeStopMillis:  26000,
+		Attributes:          map[string]interface{}{},
+		Children:            []internal.WantTraceSegment{},
+	}}))
+}
+
+func TestSegmentAggregationKindDisabled(t *testing.T) {
+	txndata := aggregationTestTxnData()
+	txndata.aggregatedKinds = segmentKindDatastore
+	ht := aggregationTestTrace(t, txndata, []aggregationTestSegment{
+		{"a", 1}, {"a", 1},
+	})
+	expectTxnTraces(t, ht, aggregationTestWant(2000, []internal.WantTraceSegment{{
+		SegmentName: "Custom/a",
+		Attributes:  map[string]interface{}{},
+		Children:    []internal.WantTraceSegment{},
+	}, {
+		SegmentName: "Custom/a",
+		Attributes:  map[string]interface{}{},
+		Children:    []internal.WantTraceSegment{},
+	}}))
+}
+
+func TestSegmentAggregationChildren(t *testing.T) {
+	// Segments with children are not merged, and neither are the
+	// segments of different parents.
+	start := time.Date(2014, time.November, 28, 1, 1, 0, 0, time.UTC)
+	txndata := aggregationTestTxnData()
+	thread := &tracingThread{}
+	now := start
+	for i := 0; i < 2; i++ {
+		parent := startSegment(txndata, thread, now)
+		child := startSegment(txndata, thread, now)
+		now = now.Add(time.Second)
+		endBasicSegment(txndata, thread, child, now, "child")
+		endBasicSegment(txndata, thread, parent, now, "parent")
+	}
+	if n := txndata.TxnTrace.nodes.Len(); n != 4 {
+		t.Error("segments merged", n)
+	}
+}
+
+func TestSegmentAggregationSpanEvents(t *testing.T) {
+	app := testApp(func(reply *internal.ConnectReply) {
+		reply.SetSampleEverything()
+	}, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = true
+		cfg.SegmentAggregation.Datastore = true
+	}, t)
+	txn := app.StartTransaction("hello")
+	datastoreSegment := func() {
+		s := DatastoreSegment{
+			StartTime:          txn.StartSegmentNow(),
+			Product:            DatastoreMySQL,
+			Collection:         "users",
+			Operation:          "select",
+			ParameterizedQuery: "SELECT * FROM users WHERE id = ?",
+		}
+		s.End()
+	}
+	for i := 0; i < 3; i++ {
+		datastoreSegment()
+	}
+	txn.StartSegment("render").End()
+	datastoreSegment()
+	txn.End()
+	app.expectNoLoggedErrors(t)
+
+	datastoreSpan := func(agentAttributes map[string]interface{}) internal.WantEvent {
+		agentAttributes["db.statement"] = "SELECT * FROM users WHERE id = ?"
+		agentAttributes["db.collection"] = "users"
+		return internal.WantEvent{
+			Intrinsics: map[string]interface{}{
+				"parentId":  internal.MatchAnything,
+				"name":      "Datastore/statement/MySQL/users/select",
+				"category":  "datastore",
+				"component": "MySQL",
+				"span.kind": "client",
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: agentAttributes,
+		}
+	}
+	app.ExpectSpanEvents(t, []internal.WantEvent{
+		datastoreSpan(map[string]interface{}{
+			"aggregate.count":         3,
+			"aggregate.totalDuration": internal.MatchAnything,
+			"aggregate.minDuration":   internal.MatchAnything,
+			"aggregate.maxDuration":   internal.MatchAnything,
+		}),
+		{
+			Intrinsics: map[string]interface{}{
+				"parentId": internal.MatchAnything,
+				"name":     "Custom/render",
+				"category": "generic",
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{},
+		},
+		datastoreSpan(map[string]interface{}{}),
+		{
+			Intrinsics: map[string]interface{}{
+				"name":             "OtherTransaction/Go/hello",
+				"transaction.name": "OtherTransaction/Go/hello",
+				"category":         "generic",
+				"nr.entryPoint":    true,
+			},
+			UserAttributes:  map[string]interface{}{},
+			AgentAttributes: map[string]interface{}{},
+		},
+	})
+}
@@ -52,7 +52,11 @@ type DatastoreSegment struct {
 	// ParameterizedQuery may be set to the query being performed.  It must
 	// not contain any raw parameters, only placeholders.
 	ParameterizedQuery string
-	// RawQuery stores the original raw query
+	// RawQuery stores the original raw query.  When ParameterizedQuery is
+	// empty and the Product is a SQL database, the literals of RawQuery
+	// are replaced with ? placeholders to create the ParameterizedQuery.
+	// If Config.DatastoreTracer.RawQuery.Enabled is true, RawQuery is used
+	// as is instead.
 	RawQuery string
 
 	// QueryParameters may be used to provide query parameters.  Care should
@@ -125,6 +129,10 @@ type ExternalSegment struct {
 	// secureAgentEvent records security information when vulnerability
 	// scanning is enabled.
 	secureAgentEvent any
+
+	// timings records the phases of the request when
+	// Config.ExternalTimings is enabled.
+	timings *externalTimings
 }
 
 // MessageProducerSegment instruments calls to add messages to a queueing system.
@@ -327,6 +335,11 @@ func StartSegment(txn *Transaction, name string) *Segment {
 // nil then StartExternalSegment will look for a Transaction in the request's
 // context using FromContext.
 //
+// When Config.ExternalTimings is enabled, the Request of the segment is a
+// copy of the request given, whose context has an httptrace.ClientTrace
+// recording the phases of the request: send the Request of the segment to
+// record them.  The request given is not modified.
+//
 // Using the same http.Client for all of your external requests?  Check out
 // NewRoundTripper: You may not need to use StartExternalSegment at all!
 func StartExternalSegment(txn *Transaction, request *http.Request) *ExternalSegment {
@@ -350,6 +363,7 @@ func StartExternalSegment(txn *Transaction, request *http.Request) *ExternalSegm
 			secureAgent.DistributedTraceHeaders(request, s.secureAgentEvent)
 		}
 	}
+	s.timings, s.Request = traceExternalRequest(txn, request)
 
 	return s
 }
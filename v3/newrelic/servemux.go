@@ -0,0 +1,129 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build go1.22
+// +build go1.22
+
+package newrelic
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WrapServeMux instruments the requests served by the mux with Transactions
+// named after the pattern which matched the request, as set by the mux in
+// http.Request.Pattern.  The routes do not need to be wrapped with
+// WrapHandle.  To instrument this code:
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("GET /users/{id}", getUser)
+//	http.ListenAndServe(":8000", mux)
+//
+// Perform this replacement:
+//
+//	http.ListenAndServe(":8000", newrelic.WrapServeMux(app, mux))
+//
+// A request for /users/42 then creates the transaction
+// "WebTransaction/Go/GET /users/{id}".  The method of the request is added to
+// the patterns which have none.  The requests which match no pattern are named
+// "NotFound" or "MethodNotAllowed", and the redirects to canonical paths made
+// by the mux are named "Redirect".  When code level metrics are enabled, they
+// reference the handler matched.
+//
+// The patterns of the requests are set by the routing introduced in Go 1.22.
+// When the httpmuxgo121 GODEBUG setting is enabled, which is the default for
+// modules declaring an older Go version, Transactions are named after the
+// pattern returned by the Handler method of the mux instead.
+//
+// WrapServeMux adds the Transaction to the request's context.  Access it
+// using FromContext.  The WrapServeMux function is safe to call if app is nil,
+// and uses http.DefaultServeMux if mux is nil.  It accepts zero or more
+// TraceOption functions, in the same fashion as WrapHandle.
+func WrapServeMux(app *Application, mux *http.ServeMux, options ...TraceOption) http.Handler {
+	if nil == mux {
+		mux = http.DefaultServeMux
+	}
+	if nil == app {
+		return mux
+	}
+	// locations contains a *CachedCodeLocation for each pattern.
+	var locations sync.Map
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		txnOptionList := options
+		if run := handlerAppRun(app); run != nil && run.Config.CodeLevelMetrics.Enabled {
+			// The handler is needed before the Transaction starts.
+			if handler, pattern := mux.Handler(r); pattern != "" {
+				cache, ok := locations.Load(pattern)
+				if !ok {
+					cache, _ = locations.LoadOrStore(pattern, NewCachedCodeLocation())
+				}
+				txnOptionList = handlerTraceOptions(app, cache.(*CachedCodeLocation), handler, options)
+			}
+		}
+
+		// The Transaction is named once the request has been routed.
+		txn := app.StartTransaction(r.Method, txnOptionList...)
+		defer txn.End()
+
+		w = txn.SetWebResponse(w)
+		txn.SetWebRequestHTTP(r)
+
+		r = RequestWithTransactionContext(r, txn)
+		defer func() {
+			txn.SetName(serveMuxTransactionName(mux, w.Header(), r, transactionResponseCode(txn)))
+		}()
+
+		serveWithProfilerLabels(txn, func() string {
+			return serveMuxTransactionName(mux, nil, r, 0)
+		}, mux, w, r)
+	})
+}
+
+// serveMuxTransactionName returns the name of the Transaction of a request
+// served by the mux.
+func serveMuxTransactionName(mux *http.ServeMux, hdr http.Header, r *http.Request, code int) string {
+	pattern := r.Pattern
+	if pattern == "" {
+		// The routing of Go 1.21 does not set the pattern.
+		_, pattern = mux.Handler(r)
+	}
+	switch {
+	case pattern == "" && code == http.StatusMethodNotAllowed:
+		return "MethodNotAllowed"
+	case pattern == "":
+		return "NotFound"
+	case isServeMuxRedirect(hdr, pattern, code):
+		// The pattern of a redirect made by the mux is the path of its
+		// location, which must not be used as a name.
+		return "Redirect"
+	}
+	if strings.IndexAny(pattern, " \t") >= 0 {
+		// The pattern starts with a method.
+		return pattern
+	}
+	return r.Method + " " + pattern
+}
+
+func isServeMuxRedirect(hdr http.Header, pattern string, code int) bool {
+	if code != http.StatusMovedPermanently && code != http.StatusTemporaryRedirect && code != http.StatusPermanentRedirect {
+		return false
+	}
+	u, err := url.Parse(hdr.Get("Location"))
+	return err == nil && u.Path == pattern
+}
+
+// transactionResponseCode returns the code of the response of the
+// Transaction, or zero if no response has been written.
+func transactionResponseCode(txn *Transaction) int {
+	if nil == txn || nil == txn.thread || nil == txn.thread.txn {
+		return 0
+	}
+	t := txn.thread.txn
+	t.Lock()
+	defer t.Unlock()
+	return t.responseCode
+}
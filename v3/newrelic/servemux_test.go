@@ -0,0 +1,129 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build go1.22
+// +build go1.22
+
+//go:debug httpmuxgo121=0
+
+package newrelic
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal"
+)
+
+func serveMuxTestMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if txn := FromContext(r.Context()); nil == txn {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, "user "+r.PathValue("id"))
+	})
+	mux.HandleFunc("/orders/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "orders")
+	})
+	return mux
+}
+
+func TestWrapServeMuxNames(t *testing.T) {
+	testcases := []struct {
+		method string
+		path   string
+		code   int
+		name   string
+	}{
+		{method: "GET", path: "/users/42", code: 200, name: "WebTransaction/Go/GET /users/{id}"},
+		{method: "POST", path: "/orders/7", code: 200, name: "WebTransaction/Go/POST /orders/"},
+		{method: "GET", path: "/missing", code: 404, name: "WebTransaction/Go/NotFound"},
+		{method: "DELETE", path: "/users/42", code: 405, name: "WebTransaction/Go/MethodNotAllowed"},
+		// The code of the redirect depends on the version of Go.
+		{method: "GET", path: "/orders", name: "WebTransaction/Go/Redirect"},
+	}
+	for _, tc := range testcases {
+		app := testApp(nil, func(cfg *Config) {
+			cfg.DistributedTracer.Enabled = false
+			cfg.ErrorCollector.IgnoreStatusCodes = []int{404, 405}
+		}, t)
+		h := WrapServeMux(app.Application, serveMuxTestMux())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if tc.code != 0 && w.Code != tc.code {
+			t.Errorf("%s %s: code=%d want=%d", tc.method, tc.path, w.Code, tc.code)
+		}
+		app.ExpectTxnEvents(t, []internal.WantEvent{{
+			Intrinsics: map[string]interface{}{
+				"name":             tc.name,
+				"nr.apdexPerfZone": internal.MatchAnything,
+			},
+			AgentAttributes: map[string]interface{}{
+				"request.method":               tc.method,
+				"request.uri":                  internal.MatchAnything,
+				"request.headers.host":         internal.MatchAnything,
+				"httpResponseCode":             internal.MatchAnything,
+				"http.statusCode":              internal.MatchAnything,
+				"response.headers.contentType": internal.MatchAnything,
+			},
+		}})
+	}
+}
+
+func TestWrapServeMuxCodeLevelMetrics(t *testing.T) {
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DistributedTracer.Enabled = false
+		cfg.CodeLevelMetrics.Enabled = true
+	}, t)
+	h := WrapServeMux(app.Application, serveMuxTestMux())
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+	}
+	// The location of the handler is reported by each transaction.
+	want := internal.WantEvent{
+		Intrinsics: map[string]interface{}{
+			"name":             "WebTransaction/Go/GET /users/{id}",
+			"nr.apdexPerfZone": internal.MatchAnything,
+		},
+		AgentAttributes: map[string]interface{}{
+			"request.method":               "GET",
+			"request.uri":                  "/users/42",
+			"request.headers.host":         internal.MatchAnything,
+			"httpResponseCode":             "200",
+			"http.statusCode":              200,
+			"response.headers.contentType": internal.MatchAnything,
+			"code.function":                "func1",
+			"code.namespace":               "github.com/newrelic/go-agent/v3/newrelic.serveMuxTestMux",
+			"code.filepath":                internal.MatchAnything,
+			"code.lineno":                  internal.MatchAnything,
+		},
+	}
+	app.ExpectTxnEvents(t, []internal.WantEvent{want, want})
+}
+
+func TestWrapServeMuxNilApplication(t *testing.T) {
+	mux := serveMuxTestMux()
+	if h := WrapServeMux(nil, mux); h != mux {
+		t.Error(h)
+	}
+	if h := WrapServeMux(nil, nil); h != http.DefaultServeMux {
+		t.Error(h)
+	}
+}
+
+func TestWrapServeMuxProfilerLabels(t *testing.T) {
+	app := testApp(nil, enableContinuousProfiler, t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if name, _ := pprof.Label(r.Context(), "transaction.name"); name != "GET /users/{id}" {
+			t.Error(name)
+		}
+	})
+	h := WrapServeMux(app.Application, mux)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/42", nil))
+}
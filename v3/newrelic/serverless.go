@@ -21,11 +21,23 @@ const (
 	lambdaMetadataVersion = 2
 )
 
+// Serverless platforms recognized from the environment of the process.
+const (
+	serverlessProviderAWS   = "aws"
+	serverlessProviderGCP   = "gcp"
+	serverlessProviderAzure = "azure"
+)
+
 // serverlessHarvest is used to store and log data when the agent is running in
 // serverless mode.
 type serverlessHarvest struct {
-	logger          Logger
-	awsExecutionEnv string
+	logger Logger
+	// provider, executionEnv and resourceID describe the platform the
+	// function is running on.  The provider is left empty for AWS Lambda
+	// to keep the Lambda payload unchanged.
+	provider     string
+	executionEnv string
+	resourceID   string
 
 	// The Lambda handler could be using multiple goroutines so we use a
 	// mutex to prevent race conditions.
@@ -35,9 +47,15 @@ type serverlessHarvest struct {
 
 // newServerlessHarvest creates a new serverlessHarvest.
 func newServerlessHarvest(logger Logger, getEnv func(string) string) *serverlessHarvest {
+	provider, executionEnv, resourceID := serverlessEnvironment(getEnv)
+	if provider == serverlessProviderAWS {
+		provider = ""
+	}
 	return &serverlessHarvest{
-		logger:          logger,
-		awsExecutionEnv: getEnv("AWS_EXECUTION_ENV"),
+		logger:       logger,
+		provider:     provider,
+		executionEnv: executionEnv,
+		resourceID:   resourceID,
 
 		// We can use dfltHarvestCfgr because
 		// serverless mode doesn't have a connect, and therefore won't
@@ -46,6 +64,30 @@ func newServerlessHarvest(logger Logger, getEnv func(string) string) *serverless
 	}
 }
 
+// serverlessEnvironment detects the serverless platform using the environment
+// variables set by each provider's runtime.
+func serverlessEnvironment(getEnv func(string) string) (provider, executionEnv, resourceID string) {
+	if env := getEnv("AWS_EXECUTION_ENV"); env != "" {
+		return serverlessProviderAWS, env, ""
+	}
+	// Cloud Run services and 2nd gen Cloud Functions set K_SERVICE,
+	// functions additionally set FUNCTION_TARGET.  1st gen Cloud
+	// Functions only set FUNCTION_NAME.
+	if service := getEnv("K_SERVICE"); service != "" {
+		if getEnv("FUNCTION_TARGET") != "" {
+			return serverlessProviderGCP, "gcp_cloud_functions", service
+		}
+		return serverlessProviderGCP, "gcp_cloud_run", service
+	}
+	if name := getEnv("FUNCTION_NAME"); name != "" && getEnv("GCP_PROJECT") != "" {
+		return serverlessProviderGCP, "gcp_cloud_functions", name
+	}
+	if getEnv("FUNCTIONS_WORKER_RUNTIME") != "" {
+		return serverlessProviderAzure, "azure_functions", getEnv("WEBSITE_SITE_NAME")
+	}
+	return "", "", ""
+}
+
 // Consume adds data to the harvest.
 func (sh *serverlessHarvest) Consume(data harvestable) {
 	if nil == sh {
@@ -72,6 +114,17 @@ func (sh *serverlessHarvest) Write(arn string, writer io.Writer) {
 	if nil == sh {
 		return
 	}
+	if err := sh.write(arn, writer); nil != err {
+		sh.logger.Error("unable to write serverless data", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// write is the implementation of Write and Application.FlushServerless.
+// Only failures writing to the writer are returned: problems creating
+// individual payloads are logged and the payload is dropped.
+func (sh *serverlessHarvest) write(arn string, writer io.Writer) error {
 	harvest := sh.swapHarvest()
 	payloads := harvest.Payloads(false)
 	// Note that *json.RawMessage (instead of json.RawMessage) is used to
@@ -112,7 +165,7 @@ func (sh *serverlessHarvest) Write(arn string, writer io.Writer) {
 		// The harvest may not contain any data if the serverless
 		// transaction was ignored.
 		sh.logger.Debug("go agent serverless harvest contained no payload data", nil)
-		return
+		return nil
 	}
 
 	data, err := json.Marshal(harvestPayloads)
@@ -120,7 +173,7 @@ func (sh *serverlessHarvest) Write(arn string, writer io.Writer) {
 		sh.logger.Error("error creating serverless data json", map[string]interface{}{
 			"error": err.Error(),
 		})
-		return
+		return nil
 	}
 
 	var dataBuf bytes.Buffer
@@ -137,13 +190,17 @@ func (sh *serverlessHarvest) Write(arn string, writer io.Writer) {
 			ARN                  string `json:"arn,omitempty"`
 			ProtocolVersion      int    `json:"protocol_version"`
 			ExecutionEnvironment string `json:"execution_environment,omitempty"`
+			Provider             string `json:"provider,omitempty"`
+			ResourceID           string `json:"resource_id,omitempty"`
 			AgentVersion         string `json:"agent_version"`
 			AgentLanguage        string `json:"agent_language"`
 		}{
 			MetadataVersion:      lambdaMetadataVersion,
 			ProtocolVersion:      procotolVersion,
 			AgentVersion:         Version,
-			ExecutionEnvironment: sh.awsExecutionEnv,
+			ExecutionEnvironment: sh.executionEnv,
+			Provider:             sh.provider,
+			ResourceID:           sh.resourceID,
 			ARN:                  arn,
 			AgentLanguage:        agentLanguage,
 		},
@@ -154,10 +211,11 @@ func (sh *serverlessHarvest) Write(arn string, writer io.Writer) {
 		sh.logger.Error("error creating serverless json", map[string]interface{}{
 			"error": err.Error(),
 		})
-		return
+		return nil
 	}
 
 	// log json data to stdout if the agent is in debug mode to help troubleshoot lambda issues
 	sh.logger.Debug("harvest data: " + string(js), nil)
-	fmt.Fprintln(writer, string(js))
+	_, err = fmt.Fprintln(writer, string(js))
+	return err
 }
@@ -90,6 +90,115 @@ func TestServerlessHarvestEmpty(t *testing.T) {
 	}
 }
 
+func TestServerlessEnvironment(t *testing.T) {
+	testcases := []struct {
+		name         string
+		env          map[string]string
+		provider     string
+		executionEnv string
+		resourceID   string
+	}{
+		{name: "unknown", env: map[string]string{}},
+		{
+			name:         "lambda",
+			env:          map[string]string{"AWS_EXECUTION_ENV": "AWS_Lambda_go1.x"},
+			provider:     "aws",
+			executionEnv: "AWS_Lambda_go1.x",
+		},
+		{
+			name:         "cloud run",
+			env:          map[string]string{"K_SERVICE": "my-service"},
+			provider:     "gcp",
+			executionEnv: "gcp_cloud_run",
+			resourceID:   "my-service",
+		},
+		{
+			name:         "cloud functions",
+			env:          map[string]string{"K_SERVICE": "my-function", "FUNCTION_TARGET": "Handle"},
+			provider:     "gcp",
+			executionEnv: "gcp_cloud_functions",
+			resourceID:   "my-function",
+		},
+		{
+			name:         "cloud functions 1st gen",
+			env:          map[string]string{"FUNCTION_NAME": "my-function", "GCP_PROJECT": "my-project"},
+			provider:     "gcp",
+			executionEnv: "gcp_cloud_functions",
+			resourceID:   "my-function",
+		},
+		{
+			name:         "azure functions",
+			env:          map[string]string{"FUNCTIONS_WORKER_RUNTIME": "custom", "WEBSITE_SITE_NAME": "my-app"},
+			provider:     "azure",
+			executionEnv: "azure_functions",
+			resourceID:   "my-app",
+		},
+	}
+	for _, tc := range testcases {
+		getEnv := func(key string) string { return tc.env[key] }
+		provider, executionEnv, resourceID := serverlessEnvironment(getEnv)
+		if provider != tc.provider || executionEnv != tc.executionEnv || resourceID != tc.resourceID {
+			t.Error(tc.name, provider, executionEnv, resourceID)
+		}
+	}
+}
+
+func TestServerlessHarvestProviderMetadata(t *testing.T) {
+	getEnv := func(key string) string {
+		if key == "K_SERVICE" {
+			return "my-service"
+		}
+		return ""
+	}
+	sh := newServerlessHarvest(logger.ShimLogger{}, getEnv)
+	event, err := createCustomEvent("myEvent", nil, time.Now())
+	if nil != err {
+		t.Fatal(err)
+	}
+	sh.Consume(event)
+	buf := &bytes.Buffer{}
+	if err := sh.write("", buf); nil != err {
+		t.Fatal(err)
+	}
+	metadata, _, err := parseServerlessPayload(buf.Bytes())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if v := string(metadata["provider"]); v != `"gcp"` {
+		t.Error(v)
+	}
+	if v := string(metadata["resource_id"]); v != `"my-service"` {
+		t.Error(v)
+	}
+	if v := string(metadata["execution_environment"]); v != `"gcp_cloud_run"` {
+		t.Error(v)
+	}
+	if v, ok := metadata["arn"]; ok {
+		t.Error(string(v))
+	}
+}
+
+func TestServerlessHarvestLambdaHasNoProvider(t *testing.T) {
+	sh := newServerlessHarvest(logger.ShimLogger{}, serverlessGetenvShim)
+	event, err := createCustomEvent("myEvent", nil, time.Now())
+	if nil != err {
+		t.Fatal(err)
+	}
+	sh.Consume(event)
+	buf := &bytes.Buffer{}
+	sh.Write("arn", buf)
+	metadata, _, err := parseServerlessPayload(buf.Bytes())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if v, ok := metadata["provider"]; ok {
+		t.Error(string(v))
+	}
+	if v, ok := metadata["resource_id"]; ok {
+		t.Error(string(v))
+	}
+}
+
 func BenchmarkServerless(b *testing.B) {
 	// The JSON creation in ServerlessHarvest.Write has not been optimized.
 	// This benchmark would be useful for doing so.
@@ -57,6 +57,10 @@ type slowQueryInstance struct {
 	DatabaseName       string
 	StackTrace         stackTrace
 
+	// ExplainPlan is added once the query has been explained, after the
+	// segment has finished.
+	ExplainPlan *explainPlan
+
 	txnEvent
 }
 
@@ -125,7 +129,27 @@ func (slow *slowQuery) merge(other slowQuery) {
 		slow.Min = other.Min
 	}
 	if other.Duration > slow.Duration {
+		plan := slow.ExplainPlan
 		slow.slowQueryInstance = other.slowQueryInstance
+		if nil == slow.ExplainPlan {
+			slow.ExplainPlan = plan
+		}
+	} else if nil == slow.ExplainPlan {
+		slow.ExplainPlan = other.ExplainPlan
+	}
+}
+
+// needsExplainPlan returns true if the query has been observed and has no
+// explain plan yet.
+func (slows *slowQueries) needsExplainPlan(query string) bool {
+	idx, ok := slows.lookup[query]
+	return ok && nil == slows.priorityQueue[idx].ExplainPlan
+}
+
+// addExplainPlan adds the plan to the slow query with the query string given.
+func (slows *slowQueries) addExplainPlan(query string, plan *explainPlan) {
+	if idx, ok := slows.lookup[query]; ok {
+		slows.priorityQueue[idx].ExplainPlan = plan
 	}
 }
 
@@ -220,6 +244,9 @@ func (slow *slowQuery) WriteJSON(buf *bytes.Buffer) {
 	if nil != slow.QueryParameters {
 		w.writerField("query_parameters", slow.QueryParameters)
 	}
+	if nil != slow.ExplainPlan {
+		w.writerField("explain_plan", slow.ExplainPlan)
+	}
 
 	sharedBetterCATIntrinsics(&slow.txnEvent, &w)
 
@@ -143,3 +143,11 @@ func (events *spanEvents) Data(agentRunID string, harvestStart time.Time) ([]byt
 func (events *spanEvents) EndpointMethod() string {
 	return cmdSpanEvents
 }
+
+func (events *spanEvents) splitPayload() (payloadCreator, payloadCreator) {
+	if len(events.events) < 2 {
+		return nil, nil
+	}
+	e1, e2 := events.split()
+	return &spanEvents{analyticsEvents: e1}, &spanEvents{analyticsEvents: e2}
+}
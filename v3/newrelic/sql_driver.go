@@ -10,6 +10,7 @@ import (
 	"context"
 	"database/sql/driver"
 	"fmt"
+	"sync/atomic"
 	"time"
 )
 
@@ -93,11 +94,24 @@ type wrapConnector struct {
 type wrapConn struct {
 	bld      SQLDriverSegmentBuilder
 	original driver.Conn
+	// inTx is set while a transaction is open on the connection, during
+	// which slow queries are not explained: a failed explain statement
+	// would abort the transaction.
+	inTx int32
 }
 
 type wrapStmt struct {
 	bld      SQLDriverSegmentBuilder
 	original driver.Stmt
+	// conn and query are used to explain slow queries.
+	conn  *wrapConn
+	query string
+}
+
+// wrapTx tracks the end of a transaction opened on the connection.
+type wrapTx struct {
+	original driver.Tx
+	conn     *wrapConn
 }
 
 func (w *wrapDriver) Open(name string) (driver.Conn, error) {
@@ -141,13 +155,15 @@ func (w *wrapConnector) Driver() driver.Driver {
 	})
 }
 
-func prepare(original driver.Stmt, err error, bld SQLDriverSegmentBuilder, query string) (driver.Stmt, error) {
+func prepare(original driver.Stmt, err error, w *wrapConn, query string) (driver.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
 	return optionalMethodsStmt(&wrapStmt{
-		bld:      bld.useQuery(query),
+		bld:      w.bld.useQuery(query),
 		original: original,
+		conn:     w,
+		query:    query,
 	}), nil
 }
 
@@ -156,7 +172,7 @@ func (w *wrapConn) Prepare(query string) (driver.Stmt, error) {
 	if IsSecurityAgentPresent() {
 		sendSecureEventSQLPrepare(query, original)
 	}
-	return prepare(original, err, w.bld, query)
+	return prepare(original, err, w, query)
 }
 
 // PrepareContext implements ConnPrepareContext.
@@ -165,7 +181,7 @@ func (w *wrapConn) PrepareContext(ctx context.Context, query string) (driver.Stm
 	if IsSecurityAgentPresent() {
 		sendSecureEventSQLPrepare(query, original)
 	}
-	return prepare(original, err, w.bld, query)
+	return prepare(original, err, w, query)
 }
 
 func (w *wrapConn) Close() error {
@@ -173,12 +189,35 @@ func (w *wrapConn) Close() error {
 }
 
 func (w *wrapConn) Begin() (driver.Tx, error) {
-	return w.original.Begin()
+	return w.wrapTx(w.original.Begin())
 }
 
 // BeginTx implements ConnBeginTx.
 func (w *wrapConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
-	return w.original.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	return w.wrapTx(w.original.(driver.ConnBeginTx).BeginTx(ctx, opts))
+}
+
+func (w *wrapConn) wrapTx(original driver.Tx, err error) (driver.Tx, error) {
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreInt32(&w.inTx, 1)
+	return &wrapTx{original: original, conn: w}, nil
+}
+
+// inTransaction returns true if a transaction is open on the connection.
+func (w *wrapConn) inTransaction() bool {
+	return atomic.LoadInt32(&w.inTx) == 1
+}
+
+func (w *wrapTx) Commit() error {
+	atomic.StoreInt32(&w.conn.inTx, 0)
+	return w.original.Commit()
+}
+
+func (w *wrapTx) Rollback() error {
+	atomic.StoreInt32(&w.conn.inTx, 0)
+	return w.original.Rollback()
 }
 
 // Exec implements Execer.
@@ -212,6 +251,9 @@ func (w *wrapConn) ExecContext(ctx context.Context, query string, args []driver.
 	if err != driver.ErrSkip {
 		seg := w.bld.useQuery(query).startSegmentAt(ctx, startTime)
 		seg.End()
+		if err == nil {
+			explainAfterExec(ctx, w, &seg, query, args)
+		}
 	}
 	return result, err
 }
@@ -256,6 +298,9 @@ func (w *wrapConn) QueryContext(ctx context.Context, query string, args []driver
 	if err != driver.ErrSkip {
 		seg := w.bld.useQuery(query).startSegmentAt(ctx, startTime)
 		seg.End()
+		if err == nil {
+			rows = explainAfterQuery(ctx, w, &seg, query, args, rows)
+		}
 	}
 	return rows, err
 }
@@ -325,6 +370,9 @@ func (w *wrapStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (d
 	segment := w.bld.startSegment(ctx)
 	result, err = w.original.(driver.StmtExecContext).ExecContext(ctx, args)
 	segment.End()
+	if err == nil {
+		explainAfterExec(ctx, w.conn, &segment, w.query, args)
+	}
 	return result, err
 }
 
@@ -342,6 +390,9 @@ func (w *wrapStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (
 	segment := w.bld.startSegment(ctx)
 	rows, err = w.original.(driver.StmtQueryContext).QueryContext(ctx, args)
 	segment.End()
+	if err == nil {
+		rows = explainAfterQuery(ctx, w.conn, &segment, w.query, args, rows)
+	}
 	return rows, err
 }
 
@@ -371,4 +422,5 @@ var (
 		driver.StmtExecContext
 		driver.StmtQueryContext
 	} = &wrapStmt{}
+	_ driver.Tx = &wrapTx{}
 )
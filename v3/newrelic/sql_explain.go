@@ -0,0 +1,324 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal/jsonx"
+)
+
+// explainStatements are the statements prepended to a query to get its
+// execution plan, by product.  Queries of other products are not explained.
+var explainStatements = map[DatastoreProduct]string{
+	DatastorePostgres: "EXPLAIN ",
+	DatastoreMySQL:    "EXPLAIN ",
+	DatastoreSQLite:   "EXPLAIN QUERY PLAN ",
+}
+
+// maxExplainPlanRows limits the size of the plans captured.
+const maxExplainPlanRows = 1000
+
+var errExplainUnsupported = errors.New("driver connection does not implement QueryerContext")
+
+// explainPlan is the execution plan of a slow query, as returned by the
+// database.
+type explainPlan struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// WriteJSON writes the plan as [[columns...], [[values...], ...]].
+func (plan *explainPlan) WriteJSON(buf *bytes.Buffer) {
+	buf.WriteByte('[')
+	buf.WriteByte('[')
+	for i, c := range plan.columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		jsonx.AppendString(buf, c)
+	}
+	buf.WriteByte(']')
+	buf.WriteByte(',')
+	buf.WriteByte('[')
+	for i, row := range plan.rows {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('[')
+		for j, v := range row {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			writeExplainValueJSON(buf, v)
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteByte(']')
+	buf.WriteByte(']')
+}
+
+func writeExplainValueJSON(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case string:
+		jsonx.AppendString(buf, v)
+	case int64:
+		jsonx.AppendInt(buf, v)
+	case float64:
+		jsonx.AppendFloat(buf, v)
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case time.Time:
+		jsonx.AppendString(buf, v.Format(time.RFC3339Nano))
+	default:
+		jsonx.AppendString(buf, fmt.Sprint(v))
+	}
+}
+
+var (
+	explainQuotedRegex = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"`)
+	explainLabelRegex  = regexp.MustCompile(`(?m)^([^:\n]*:\s+).*$`)
+)
+
+// obfuscatePostgresExplain obfuscates a Postgres plan, whose conditions may
+// contain the literals of the query: strings are replaced with ?, and so is
+// everything that follows a label such as "Filter: " or "Index Cond: ".
+// Quoted identifiers are preserved.
+func obfuscatePostgresExplain(plan string) string {
+	plan = explainQuotedRegex.ReplaceAllStringFunc(plan, func(quoted string) string {
+		if strings.HasPrefix(quoted, `"`) {
+			return quoted
+		}
+		return "?"
+	})
+	return explainLabelRegex.ReplaceAllString(plan, "${1}?")
+}
+
+// obfuscate removes the literals from the plan.  Only Postgres plans contain
+// the literals of the query.
+func (plan *explainPlan) obfuscate(product DatastoreProduct) {
+	if product != DatastorePostgres {
+		return
+	}
+	for _, row := range plan.rows {
+		for i, v := range row {
+			if s, ok := v.(string); ok {
+				row[i] = obfuscatePostgresExplain(s)
+			}
+		}
+	}
+}
+
+// explainQuery runs the explain statement of the query on the connection.
+func explainQuery(ctx context.Context, conn driver.Conn, product DatastoreProduct, query string, args []driver.NamedValue) (*explainPlan, error) {
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return nil, errExplainUnsupported
+	}
+	rows, err := queryer.QueryContext(ctx, explainStatements[product]+query, args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plan := &explainPlan{columns: rows.Columns()}
+	dest := make([]driver.Value, len(plan.columns))
+	for len(plan.rows) < maxExplainPlanRows {
+		if err := rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(dest))
+		for i, v := range dest {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row[i] = v
+		}
+		plan.rows = append(plan.rows, row)
+	}
+	return plan, nil
+}
+
+// explainPlanWanted returns true if the segment, which must have ended, is a
+// slow query whose plan should be captured.
+func explainPlanWanted(segment *DatastoreSegment) bool {
+	if _, ok := explainStatements[segment.Product]; !ok {
+		return false
+	}
+	if !strings.EqualFold(segment.Operation, "select") || segment.ParameterizedQuery == "" {
+		return false
+	}
+	thd := segment.StartTime.thread
+	if nil == thd {
+		return false
+	}
+	return thd.txn.explainPlanWanted(segment.ParameterizedQuery)
+}
+
+// explainSlowQuery captures the plan of the query of the segment, which has
+// been executed on the connection.  Queries made in a transaction are not
+// explained, since a failed explain statement would abort the transaction.
+func explainSlowQuery(ctx context.Context, conn *wrapConn, segment *DatastoreSegment, query string, args []driver.NamedValue) {
+	if nil != ctx.Err() || conn.inTransaction() {
+		return
+	}
+	thd := segment.StartTime.thread
+	plan, err := explainQuery(ctx, conn.original, segment.Product, query, args)
+	if err != nil {
+		thd.Config.Logger.Debug("unable to explain slow query", map[string]interface{}{
+			"product": segment.Product,
+			"reason":  err.Error(),
+		})
+		return
+	}
+	thd.txn.addExplainPlan(segment.ParameterizedQuery, segment.Product, plan)
+}
+
+// explainAfterExec captures the plan of a slow query made with Exec.
+func explainAfterExec(ctx context.Context, conn *wrapConn, segment *DatastoreSegment, query string, args []driver.NamedValue) {
+	if explainPlanWanted(segment) {
+		explainSlowQuery(ctx, conn, segment, query, args)
+	}
+}
+
+// explainAfterQuery arranges for the plan of a slow query made with Query to
+// be captured.  The connection is busy until the rows are closed, so the
+// query is explained then.
+func explainAfterQuery(ctx context.Context, conn *wrapConn, segment *DatastoreSegment, query string, args []driver.NamedValue, rows driver.Rows) driver.Rows {
+	if nil == rows || !explainPlanWanted(segment) {
+		return rows
+	}
+	return &explainRows{
+		Rows: rows,
+		explain: func() {
+			explainSlowQuery(ctx, conn, segment, query, args)
+		},
+	}
+}
+
+// explainRows explains the query once the rows are closed.  The optional
+// methods of the rows default to the behavior of database/sql when the
+// original rows do not implement them.
+type explainRows struct {
+	driver.Rows
+	explain func()
+}
+
+func (r *explainRows) Close() error {
+	err := r.Rows.Close()
+	if nil == err && nil != r.explain {
+		r.explain()
+	}
+	r.explain = nil
+	return err
+}
+
+// HasNextResultSet implements RowsNextResultSet.
+func (r *explainRows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+// NextResultSet implements RowsNextResultSet.
+func (r *explainRows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
+
+// ColumnTypeScanType implements RowsColumnTypeScanType.
+func (r *explainRows) ColumnTypeScanType(index int) reflect.Type {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return rs.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+// ColumnTypeDatabaseTypeName implements RowsColumnTypeDatabaseTypeName.
+func (r *explainRows) ColumnTypeDatabaseTypeName(index int) string {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return rs.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+// ColumnTypeLength implements RowsColumnTypeLength.
+func (r *explainRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return rs.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+// ColumnTypeNullable implements RowsColumnTypeNullable.
+func (r *explainRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return rs.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+// ColumnTypePrecisionScale implements RowsColumnTypePrecisionScale.
+func (r *explainRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if rs, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return rs.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+// explainPlanBudget limits the number of plans captured between two
+// harvests.
+type explainPlanBudget struct {
+	sync.Mutex
+	used int
+}
+
+func (b *explainPlanBudget) take(max int) bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.used >= max {
+		return false
+	}
+	b.used++
+	return true
+}
+
+func (b *explainPlanBudget) reset() {
+	b.Lock()
+	b.used = 0
+	b.Unlock()
+}
+
+var (
+	_ interface {
+		driver.Rows
+		driver.RowsNextResultSet
+		driver.RowsColumnTypeScanType
+		driver.RowsColumnTypeDatabaseTypeName
+		driver.RowsColumnTypeLength
+		driver.RowsColumnTypeNullable
+		driver.RowsColumnTypePrecisionScale
+	} = &explainRows{}
+)
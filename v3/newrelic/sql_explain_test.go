@@ -0,0 +1,317 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/go-agent/v3/internal"
+	"github.com/newrelic/go-agent/v3/internal/crossagent"
+)
+
+func TestPostgresExplainObfuscation(t *testing.T) {
+	dir := "postgres_explain_obfuscation"
+	files, err := crossagent.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		if !strings.HasSuffix(name, ".explain.txt") {
+			continue
+		}
+		testName := strings.TrimSuffix(name, ".explain.txt")
+		explain, err := crossagent.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect, err := crossagent.ReadFile(filepath.Join(dir, testName+".colon_obfuscated.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := obfuscatePostgresExplain(string(explain)); got != string(expect) {
+			t.Errorf("%s: got=%q want=%q", testName, got, string(expect))
+		}
+	}
+}
+
+func TestExplainPlanJSON(t *testing.T) {
+	plan := &explainPlan{
+		columns: []string{"id", "select_type", "table", "key", "rows", "filtered", "Extra"},
+		rows: [][]interface{}{
+			{int64(1), "SIMPLE", "users", nil, int64(12), 10.5, "Using where"},
+		},
+	}
+	buf := &bytes.Buffer{}
+	plan.WriteJSON(buf)
+	expect := `[["id","select_type","table","key","rows","filtered","Extra"],[[1,"SIMPLE","users",null,12,10.5,"Using where"]]]`
+	if got := buf.String(); got != expect {
+		t.Errorf("got=%s want=%s", got, expect)
+	}
+}
+
+func TestSlowQueryMergeKeepsExplainPlan(t *testing.T) {
+	plan := &explainPlan{columns: []string{"id"}}
+	slow := slowQuery{Count: 1, slowQueryInstance: slowQueryInstance{Duration: 2 * time.Second}}
+	// The faster observation was explained, the slower one was not.
+	slow.merge(slowQuery{Count: 1, slowQueryInstance: slowQueryInstance{Duration: time.Second, ExplainPlan: plan}})
+	if slow.ExplainPlan != plan || slow.Duration != 2*time.Second {
+		t.Error(slow.ExplainPlan, slow.Duration)
+	}
+	// The slower observation is kept with the plan of the faster one.
+	slow = slowQuery{Count: 1, slowQueryInstance: slowQueryInstance{Duration: time.Second, ExplainPlan: plan}}
+	slow.merge(slowQuery{Count: 1, slowQueryInstance: slowQueryInstance{Duration: 2 * time.Second}})
+	if slow.ExplainPlan != plan || slow.Duration != 2*time.Second {
+		t.Error(slow.ExplainPlan, slow.Duration)
+	}
+}
+
+// explainTestConn returns a plan for EXPLAIN statements, and a single row
+// for other queries.
+type explainTestConn struct {
+	testConn
+	queries []string
+}
+
+func (c *explainTestConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.queries = append(c.queries, query)
+	if strings.HasPrefix(query, "EXPLAIN ") {
+		return &explainTestRows{
+			columns: []string{"QUERY PLAN"},
+			values: [][]driver.Value{
+				{[]byte("Seq Scan on users  (cost=0.00..1.01 rows=1 width=36)")},
+				{[]byte("  Filter: (name = 'alice'::text)")},
+			},
+		}, nil
+	}
+	return &explainTestRows{
+		columns: []string{"name"},
+		values:  [][]driver.Value{{"alice"}},
+	}, nil
+}
+
+func (c *explainTestConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.queries = append(c.queries, query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *explainTestConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return explainTestStmt{}, nil
+}
+
+func (c *explainTestConn) Begin() (driver.Tx, error) { return explainTestTx{}, nil }
+
+type explainTestTx struct{}
+
+func (explainTestTx) Commit() error   { return nil }
+func (explainTestTx) Rollback() error { return nil }
+
+type explainTestStmt struct {
+	testStmt
+}
+
+func (s explainTestStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return &explainTestRows{
+		columns: []string{"name"},
+		values:  [][]driver.Value{{"alice"}},
+	}, nil
+}
+
+type explainTestRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *explainTestRows) Columns() []string { return r.columns }
+func (r *explainTestRows) Close() error      { return nil }
+func (r *explainTestRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+type explainTestConnector struct {
+	conn *explainTestConn
+}
+
+func (c explainTestConnector) Connect(context.Context) (driver.Conn, error) { return c.conn, nil }
+func (c explainTestConnector) Driver() driver.Driver                        { return testDriver{} }
+
+var explainTestBuilder = SQLDriverSegmentBuilder{
+	BaseSegment: DatastoreSegment{
+		Product: DatastorePostgres,
+	},
+	ParseQuery: func(segment *DatastoreSegment, query string) {
+		segment.Operation = strings.ToLower(strings.Fields(query)[0])
+		segment.Collection = "users"
+		segment.RawQuery = query
+	},
+}
+
+func explainTestApp(t *testing.T, cfgfn func(*Config)) (expectApp, *explainTestConn, driver.Conn) {
+	app := testApp(nil, func(cfg *Config) {
+		cfg.DatastoreTracer.SlowQuery.Threshold = 0
+		cfg.DatastoreTracer.SlowQuery.ExplainPlan.Enabled = true
+		cfg.DistributedTracer.Enabled = false
+		if nil != cfgfn {
+			cfgfn(cfg)
+		}
+	}, t)
+	tc := &explainTestConn{}
+	conn, _ := InstrumentSQLConnector(explainTestConnector{conn: tc}, explainTestBuilder).Connect(context.Background())
+	return app, tc, conn
+}
+
+const explainTestPlan = `[["QUERY PLAN"],[["Seq Scan on users  (cost=0.00..1.01 rows=1 width=36)"],["  Filter: ?"]]]`
+
+func TestExplainPlanQuery(t *testing.T) {
+	app, tc, conn := explainTestApp(t, nil)
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	query := "SELECT name FROM users WHERE name = 'alice'"
+	rows, err := conn.(driver.QueryerContext).QueryContext(ctx, query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tc.queries) != 1 {
+		t.Error("query explained before its rows were closed", tc.queries)
+	}
+	rows.Close()
+	if len(tc.queries) != 2 || tc.queries[1] != "EXPLAIN "+query {
+		t.Error("query not explained", tc.queries)
+	}
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:       1,
+		MetricName:  "Datastore/statement/Postgres/users/select",
+		Query:       "SELECT name FROM users WHERE name = ?",
+		TxnName:     "OtherTransaction/Go/hello",
+		ExplainPlan: explainTestPlan,
+	}})
+}
+
+func TestExplainPlanRawQuery(t *testing.T) {
+	app, _, conn := explainTestApp(t, func(cfg *Config) {
+		cfg.DatastoreTracer.RawQuery.Enabled = true
+	})
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	query := "SELECT name FROM users WHERE name = 'alice'"
+	conn.(driver.ExecerContext).ExecContext(ctx, query, nil)
+	txn.End()
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:       1,
+		MetricName:  "Datastore/statement/Postgres/users/select",
+		Query:       query,
+		TxnName:     "OtherTransaction/Go/hello",
+		ExplainPlan: `[["QUERY PLAN"],[["Seq Scan on users  (cost=0.00..1.01 rows=1 width=36)"],["  Filter: (name = 'alice'::text)"]]]`,
+	}})
+}
+
+func TestExplainPlanStmt(t *testing.T) {
+	app, tc, conn := explainTestApp(t, nil)
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	query := "SELECT name FROM users WHERE name = $1"
+	stmt, _ := conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	rows, _ := stmt.(driver.StmtQueryContext).QueryContext(ctx, []driver.NamedValue{{Ordinal: 1, Value: "alice"}})
+	rows.Close()
+	txn.End()
+	if len(tc.queries) != 1 || tc.queries[0] != "EXPLAIN "+query {
+		t.Error("statement not explained", tc.queries)
+	}
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:       1,
+		MetricName:  "Datastore/statement/Postgres/users/select",
+		Query:       "SELECT name FROM users WHERE name = $?",
+		TxnName:     "OtherTransaction/Go/hello",
+		ExplainPlan: explainTestPlan,
+	}})
+}
+
+func TestExplainPlanTransaction(t *testing.T) {
+	app, tc, conn := explainTestApp(t, nil)
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	query := "SELECT name FROM users WHERE name = 'alice'"
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, _ := conn.(driver.QueryerContext).QueryContext(ctx, query, nil)
+	rows.Close()
+	if len(tc.queries) != 1 {
+		t.Error("query explained in a transaction", tc.queries)
+	}
+	tx.Rollback()
+	rows, _ = conn.(driver.QueryerContext).QueryContext(ctx, query, nil)
+	rows.Close()
+	txn.End()
+	if len(tc.queries) != 3 || tc.queries[2] != "EXPLAIN "+query {
+		t.Error("query not explained after the transaction", tc.queries)
+	}
+}
+
+func TestExplainPlanDisabled(t *testing.T) {
+	app, tc, conn := explainTestApp(t, func(cfg *Config) {
+		cfg.DatastoreTracer.SlowQuery.ExplainPlan.Enabled = false
+	})
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	rows, _ := conn.(driver.QueryerContext).QueryContext(ctx, "SELECT name FROM users", nil)
+	rows.Close()
+	txn.End()
+	if len(tc.queries) != 1 {
+		t.Error("query explained", tc.queries)
+	}
+}
+
+func TestExplainPlanOnlySelect(t *testing.T) {
+	app, tc, conn := explainTestApp(t, nil)
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	conn.(driver.ExecerContext).ExecContext(ctx, "DELETE FROM users", nil)
+	txn.End()
+	if len(tc.queries) != 1 {
+		t.Error("query explained", tc.queries)
+	}
+}
+
+func TestExplainPlanBudget(t *testing.T) {
+	app, tc, conn := explainTestApp(t, func(cfg *Config) {
+		cfg.DatastoreTracer.SlowQuery.ExplainPlan.MaxPerHarvest = 1
+	})
+	txn := app.StartTransaction("hello")
+	ctx := NewContext(context.Background(), txn)
+	for _, query := range []string{"SELECT name FROM users", "SELECT age FROM users", "SELECT name FROM users"} {
+		rows, _ := conn.(driver.QueryerContext).QueryContext(ctx, query, nil)
+		rows.Close()
+	}
+	txn.End()
+	if len(tc.queries) != 4 || tc.queries[1] != "EXPLAIN SELECT name FROM users" {
+		t.Error("wrong queries explained", tc.queries)
+	}
+	app.ExpectSlowQueries(t, []internal.WantSlowQuery{{
+		Count:       2,
+		MetricName:  "Datastore/statement/Postgres/users/select",
+		Query:       "SELECT name FROM users",
+		TxnName:     "OtherTransaction/Go/hello",
+		ExplainPlan: explainTestPlan,
+	}, {
+		Count:      1,
+		MetricName: "Datastore/statement/Postgres/users/select",
+		Query:      "SELECT age FROM users",
+		TxnName:    "OtherTransaction/Go/hello",
+	}})
+}
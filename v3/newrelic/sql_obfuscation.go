@@ -0,0 +1,341 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"strings"
+)
+
+// sqlDialect controls which literals and quoting rules obfuscateSQL
+// recognizes.
+type sqlDialect int
+
+const (
+	// sqlDialectFallback is used for SQL datastores without a dialect of
+	// their own: it recognizes every kind of literal.
+	sqlDialectFallback sqlDialect = iota
+	sqlDialectMySQL
+	sqlDialectPostgres
+	sqlDialectMSSQL
+	sqlDialectOracle
+	sqlDialectSQLite
+	sqlDialectCassandra
+)
+
+// sqlDialects maps the SQL datastore products to their dialect.  Products
+// which are missing do not use SQL, and their queries are not obfuscated.
+var sqlDialects = map[DatastoreProduct]sqlDialect{
+	DatastoreMySQL:     sqlDialectMySQL,
+	DatastorePostgres:  sqlDialectPostgres,
+	DatastoreMSSQL:     sqlDialectMSSQL,
+	DatastoreOracle:    sqlDialectOracle,
+	DatastoreSQLite:    sqlDialectSQLite,
+	DatastoreCassandra: sqlDialectCassandra,
+	DatastoreDerby:     sqlDialectFallback,
+	DatastoreFirebird:  sqlDialectFallback,
+	DatastoreIBMDB2:    sqlDialectFallback,
+	DatastoreInformix:  sqlDialectFallback,
+	DatastoreSnowflake: sqlDialectFallback,
+	DatastoreVoltDB:    sqlDialectFallback,
+}
+
+// Double quotes enclose strings in MySQL and MSSQL, and identifiers
+// elsewhere.
+func (d sqlDialect) doubleQuotedStrings() bool {
+	return d == sqlDialectMySQL || d == sqlDialectMSSQL || d == sqlDialectFallback
+}
+
+func (d sqlDialect) dollarQuotes() bool { return d == sqlDialectPostgres }
+
+func (d sqlDialect) oracleQuotes() bool {
+	return d == sqlDialectOracle || d == sqlDialectFallback
+}
+
+func (d sqlDialect) uuids() bool {
+	return d == sqlDialectPostgres || d == sqlDialectCassandra || d == sqlDialectFallback
+}
+
+func (d sqlDialect) booleans() bool {
+	return d != sqlDialectOracle && d != sqlDialectMSSQL
+}
+
+func (d sqlDialect) bracketIdentifiers() bool {
+	return d == sqlDialectMSSQL || d == sqlDialectSQLite
+}
+
+// # starts a temporary table name in MSSQL.
+func (d sqlDialect) hashComments() bool { return d != sqlDialectMSSQL }
+
+// obfuscatedMalformedSQL replaces queries which cannot be obfuscated safely,
+// such as queries with an unterminated string or comment.
+const obfuscatedMalformedSQL = "?"
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c >= 0x80
+}
+
+func isIdentChar(c byte) bool { return isIdentStart(c) || isDigit(c) || c == '$' }
+
+// obfuscateSQL replaces the literals of a query (strings, numbers, booleans,
+// hexadecimal values and UUIDs) and its comments with ?.  Identifiers,
+// keywords and placeholders are preserved, except for the number of the
+// Postgres $1 placeholders, which the cross agent tests expect to be
+// obfuscated like the other numbers.  If the query is malformed, for
+// example if it contains an unterminated string, ? is returned instead so
+// that no literal can leak.
+func obfuscateSQL(query string, dialect sqlDialect) string {
+	var buf strings.Builder
+	buf.Grow(len(query))
+
+	n := len(query)
+	next := func(i int) byte {
+		if i < n {
+			return query[i]
+		}
+		return 0
+	}
+
+	for i := 0; i < n; {
+		c := query[i]
+		switch {
+		case c == '\'' || (c == '"' && dialect.doubleQuotedStrings()):
+			end, ok := scanString(query, i)
+			if !ok {
+				return obfuscatedMalformedSQL
+			}
+			buf.WriteByte('?')
+			i = end
+		case c == '"' || c == '`' || (c == '[' && dialect.bracketIdentifiers()):
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(query[i+1:], closing)
+			if end < 0 {
+				return obfuscatedMalformedSQL
+			}
+			end += i + 2
+			buf.WriteString(query[i:end])
+			i = end
+		case (c == '-' && next(i+1) == '-') || (c == '#' && dialect.hashComments()):
+			end := strings.IndexAny(query[i:], "\r\n")
+			if end < 0 {
+				end = n - i
+			}
+			buf.WriteByte('?')
+			i += end
+		case c == '/' && next(i+1) == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return obfuscatedMalformedSQL
+			}
+			buf.WriteByte('?')
+			i += end + 4
+		case c == '*' && next(i+1) == '/':
+			// The end of a comment which was never opened: part of the
+			// query may be a comment which hides a literal.
+			return obfuscatedMalformedSQL
+		case c == '$' && dialect.dollarQuotes() && dollarQuoteTag(query[i:]) != "":
+			tag := dollarQuoteTag(query[i:])
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				return obfuscatedMalformedSQL
+			}
+			buf.WriteByte('?')
+			i += end + 2*len(tag)
+		case dialect.uuids() && (c == '{' || isHexDigit(c)) && scanUUID(query, i) > i:
+			buf.WriteByte('?')
+			i = scanUUID(query, i)
+		case c == '0' && (next(i+1) == 'x' || next(i+1) == 'X') && isHexDigit(next(i+2)):
+			end := i + 2
+			for end < n && isHexDigit(query[end]) {
+				end++
+			}
+			i = writeNumber(&buf, query, i, end)
+		case isDigit(c) || (c == '-' && isDigit(next(i+1))):
+			i = writeNumber(&buf, query, i, scanNumber(query, i))
+		case isIdentStart(c):
+			end := i + 1
+			for end < n && isIdentChar(query[end]) {
+				end++
+			}
+			word := query[i:end]
+			if dialect.oracleQuotes() && next(end) == '\'' && (word == "q" || word == "Q" || strings.EqualFold(word, "nq")) {
+				quoteEnd, ok := scanOracleQuote(query, end)
+				if !ok {
+					return obfuscatedMalformedSQL
+				}
+				buf.WriteByte('?')
+				i = quoteEnd
+				continue
+			}
+			if dialect.booleans() && (strings.EqualFold(word, "true") || strings.EqualFold(word, "false")) {
+				buf.WriteByte('?')
+			} else {
+				buf.WriteString(word)
+			}
+			i = end
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String()
+}
+
+// scanString returns the end of the string starting with the quote at
+// query[start].  A doubled quote is part of the string.  A backslash escaped
+// quote may or may not end the string depending on the server settings: the
+// rest of the query is considered part of the string so that nothing leaks.
+func scanString(query string, start int) (end int, ok bool) {
+	quote := query[start]
+	n := len(query)
+	for i := start + 1; i < n; i++ {
+		switch query[i] {
+		case '\\':
+			if i+1 < n && query[i+1] == '\\' {
+				i++
+			} else if i+1 < n && query[i+1] == quote {
+				return n, true
+			}
+		case quote:
+			if i+1 < n && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1, true
+		}
+	}
+	return n, false
+}
+
+// dollarQuoteTag returns the $tag$ or $$ which starts s, or the empty string
+// if s does not start with a dollar quote.  $1 is a placeholder, not a tag.
+func dollarQuoteTag(s string) string {
+	if len(s) < 2 || s[0] != '$' {
+		return ""
+	}
+	if s[1] == '$' {
+		return "$$"
+	}
+	if !isIdentStart(s[1]) {
+		return ""
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] == '$' {
+			return s[:i+1]
+		}
+		if !isIdentChar(s[i]) {
+			return ""
+		}
+	}
+	return ""
+}
+
+// scanOracleQuote returns the end of an Oracle alternative quoting string,
+// such as q'[it's]', whose quote is at query[start].
+func scanOracleQuote(query string, start int) (end int, ok bool) {
+	if start+1 >= len(query) {
+		return len(query), false
+	}
+	closing := query[start+1]
+	switch closing {
+	case '[':
+		closing = ']'
+	case '{':
+		closing = '}'
+	case '<':
+		closing = '>'
+	case '(':
+		closing = ')'
+	}
+	idx := strings.Index(query[start+2:], string(closing)+"'")
+	if idx < 0 {
+		return len(query), false
+	}
+	return start + 2 + idx + 2, true
+}
+
+// scanUUID returns the end of the UUID starting at query[start], or start if
+// there is none.  UUIDs are 32 hexadecimal digits, possibly separated by
+// dashes and enclosed in braces.
+func scanUUID(query string, start int) int {
+	n := len(query)
+	i := start
+	if query[i] == '{' {
+		i++
+	}
+	digits := 0
+	for i < n && digits < 32 {
+		if isHexDigit(query[i]) {
+			digits++
+		} else if query[i] != '-' || digits == 0 {
+			break
+		}
+		i++
+	}
+	if digits < 32 {
+		return start
+	}
+	if i < n && query[i] == '}' {
+		i++
+	}
+	if i < n && isIdentChar(query[i]) {
+		return start
+	}
+	return i
+}
+
+// scanNumber returns the end of the number, possibly negative, decimal or
+// in scientific notation, starting at query[start].
+func scanNumber(query string, start int) int {
+	n := len(query)
+	i := start
+	if query[i] == '-' {
+		i++
+	}
+	for i < n && isDigit(query[i]) {
+		i++
+	}
+	if i+1 < n && query[i] == '.' && isDigit(query[i+1]) {
+		i++
+		for i < n && isDigit(query[i]) {
+			i++
+		}
+	}
+	if i+1 < n && (query[i] == 'e' || query[i] == 'E') {
+		j := i + 1
+		if query[j] == '+' || query[j] == '-' {
+			j++
+		}
+		if j < n && isDigit(query[j]) {
+			for j < n && isDigit(query[j]) {
+				j++
+			}
+			i = j
+		}
+	}
+	return i
+}
+
+// writeNumber writes ? for the number query[start:end] and returns end.  If
+// the number is followed by identifier characters, it is part of a word and
+// the word is written instead.
+func writeNumber(buf *strings.Builder, query string, start, end int) int {
+	if end < len(query) && isIdentChar(query[end]) {
+		for end < len(query) && isIdentChar(query[end]) {
+			end++
+		}
+		buf.WriteString(query[start:end])
+		return end
+	}
+	buf.WriteByte('?')
+	return end
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package newrelic
+
+import (
+	"testing"
+
+	"github.com/newrelic/go-agent/v3/internal/crossagent"
+)
+
+func TestSQLObfuscationCrossAgent(t *testing.T) {
+	var tcs []struct {
+		Name       string   `json:"name"`
+		SQL        string   `json:"sql"`
+		Obfuscated []string `json:"obfuscated"`
+		Dialects   []string `json:"dialects"`
+	}
+	if err := crossagent.ReadJSON("sql_obfuscation/sql_obfuscation.json", &tcs); err != nil {
+		t.Fatal(err)
+	}
+	dialects := map[string]sqlDialect{
+		"mysql":     sqlDialectMySQL,
+		"postgres":  sqlDialectPostgres,
+		"mssql":     sqlDialectMSSQL,
+		"oracle":    sqlDialectOracle,
+		"sqlite":    sqlDialectSQLite,
+		"cassandra": sqlDialectCassandra,
+	}
+	for _, tc := range tcs {
+		for _, name := range tc.Dialects {
+			dialect, ok := dialects[name]
+			if !ok {
+				t.Errorf("%s: unknown dialect %s", tc.Name, name)
+				continue
+			}
+			got := obfuscateSQL(tc.SQL, dialect)
+			match := false
+			for _, want := range tc.Obfuscated {
+				if got == want {
+					match = true
+				}
+			}
+			if !match {
+				t.Errorf("%s (%s):\ngot:  %q\nwant: %q", tc.Name, name, got, tc.Obfuscated)
+			}
+		}
+	}
+}
+
+func TestSQLObfuscation(t *testing.T) {
+	testcases := []struct {
+		dialect sqlDialect
+		input   string
+		expect  string
+	}{
+		// The ? and named placeholders are preserved.
+		{dialect: sqlDialectMySQL, input: "SELECT * FROM t WHERE a = ? AND b = ?", expect: "SELECT * FROM t WHERE a = ? AND b = ?"},
+		{dialect: sqlDialectMSSQL, input: "SELECT * FROM t WHERE a = @p1", expect: "SELECT * FROM t WHERE a = @p1"},
+		{dialect: sqlDialectOracle, input: "SELECT * FROM t WHERE a = :name", expect: "SELECT * FROM t WHERE a = :name"},
+		// Words containing digits are not numbers.
+		{dialect: sqlDialectMySQL, input: "SELECT 1st FROM t2 WHERE c = 3", expect: "SELECT 1st FROM t2 WHERE c = ?"},
+		// MSSQL identifiers.
+		{dialect: sqlDialectMSSQL, input: "SELECT [col 1] FROM #temp WHERE x = N'secret'", expect: "SELECT [col 1] FROM #temp WHERE x = N?"},
+		// Postgres dollar quotes without a tag.  Unlike the placeholders
+		// above, the number of a $1 placeholder is obfuscated like any
+		// other number, as the cross agent tests expect.
+		{dialect: sqlDialectPostgres, input: "SELECT $$it's$$, $1", expect: "SELECT ?, $?"},
+		// Malformed queries.
+		{dialect: sqlDialectPostgres, input: `SELECT "unterminated FROM t WHERE a = 'b'`, expect: "?"},
+		{dialect: sqlDialectPostgres, input: "SELECT $tag$ unterminated", expect: "?"},
+		{dialect: sqlDialectMySQL, input: "SELECT * FROM t /* unterminated 'secret'", expect: "?"},
+		{dialect: sqlDialectMySQL, input: "SELECT * FROM t WHERE a = 1 */ AND b = 'secret'", expect: "?"},
+		{dialect: sqlDialectOracle, input: "SELECT q'[unterminated' FROM dual", expect: "?"},
+		// The fallback dialect obfuscates every kind of literal.
+		{dialect: sqlDialectFallback, input: `SELECT * FROM t WHERE a = "b" AND c = q'<d>' AND e = TRUE`, expect: "SELECT * FROM t WHERE a = ? AND c = ? AND e = ?"},
+	}
+	for _, tc := range testcases {
+		if got := obfuscateSQL(tc.input, tc.dialect); got != tc.expect {
+			t.Errorf("%q:\ngot:  %q\nwant: %q", tc.input, got, tc.expect)
+		}
+	}
+}
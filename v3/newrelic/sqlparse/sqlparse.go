@@ -4,68 +4,237 @@
 package sqlparse
 
 import (
-	"regexp"
 	"strings"
 
 	newrelic "github.com/newrelic/go-agent/v3/newrelic"
 )
 
-func extractTable(s string) string {
-	s = extractTableRegex.ReplaceAllString(s, "")
-	if idx := strings.Index(s, "."); idx > 0 {
-		s = s[idx+1:]
-	}
-	return s
-}
-
 var (
-	basicTable        = `[^)(\]\[\}\{\s,;]+`
-	enclosedTable     = `[\[\(\{]` + `\s*` + basicTable + `\s*` + `[\]\)\}]`
-	tablePattern      = `(` + `\s+` + basicTable + `|` + `\s*` + enclosedTable + `)`
-	extractTableRegex = regexp.MustCompile(`[\s` + "`" + `"'\(\)\{\}\[\]]*`)
-	updateRegex       = regexp.MustCompile(`(?is)^update(?:\s+(?:low_priority|ignore|or|rollback|abort|replace|fail|only))*` + tablePattern)
-	sqlOperations     = map[string]*regexp.Regexp{
-		"select":   regexp.MustCompile(`(?is)^.*\sfrom` + tablePattern),
-		"delete":   regexp.MustCompile(`(?is)^.*\sfrom` + tablePattern),
-		"insert":   regexp.MustCompile(`(?is)^.*\sinto?` + tablePattern),
-		"update":   updateRegex,
-		"call":     nil,
-		"create":   nil,
-		"drop":     nil,
-		"show":     nil,
-		"set":      nil,
-		"exec":     nil,
-		"execute":  nil,
-		"alter":    nil,
-		"commit":   nil,
-		"rollback": nil,
-	}
-	firstWordRegex   = regexp.MustCompile(`^\w+`)
-	cCommentRegex    = regexp.MustCompile(`(?is)/\*.*?\*/`)
-	lineCommentRegex = regexp.MustCompile(`(?im)(?:--|#).*?$`)
-	sqlPrefixRegex   = regexp.MustCompile(`^[\s;]*`)
+	// sqlOperations are the statements recognized.  The value indicates
+	// whether the collection is extracted.
+	sqlOperations = map[string]bool{
+		"select":   true,
+		"delete":   true,
+		"insert":   true,
+		"update":   true,
+		"merge":    true,
+		"upsert":   true,
+		"replace":  true,
+		"create":   true,
+		"drop":     true,
+		"alter":    true,
+		"truncate": true,
+		"call":     false,
+		"show":     false,
+		"set":      false,
+		"exec":     false,
+		"execute":  false,
+		"commit":   false,
+		"rollback": false,
+	}
+	// cteOperations are the statements which may follow the common table
+	// expressions of a WITH clause.
+	cteOperations = map[string]bool{
+		"select":  true,
+		"delete":  true,
+		"insert":  true,
+		"update":  true,
+		"merge":   true,
+		"upsert":  true,
+		"replace": true,
+	}
+	// insertModifiers may appear between INSERT and the table, e.g.
+	// INSERT LOW_PRIORITY IGNORE INTO, INSERT OR REPLACE INTO, INSERT
+	// OVERWRITE TABLE.
+	insertModifiers = wordSet("low_priority", "delayed", "high_priority", "ignore",
+		"into", "or", "replace", "rollback", "abort", "fail", "overwrite", "table")
+	// updateModifiers may appear between UPDATE and the table.
+	updateModifiers = wordSet("low_priority", "ignore", "or", "rollback", "abort",
+		"replace", "fail", "only")
+	// ddlModifiers may appear between CREATE, DROP, ALTER or TRUNCATE and
+	// TABLE, or between TABLE and its name.
+	ddlModifiers = wordSet("or", "replace", "temporary", "temp", "global", "local",
+		"unlogged", "external", "transient", "volatile", "if", "not", "exists", "only")
 )
 
+func wordSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
 // ParseQuery parses table and operation from the SQL query string.  It is
 // a helper meant to be used when writing database/sql driver instrumentation.
 // Check out full example usage here:
 // https://github.com/newrelic/go-agent/blob/master/v3/integrations/nrmysql/nrmysql.go
 //
-// ParseQuery is designed to work with MySQL, Postgres, and SQLite drivers.
-// Ability to correctly parse queries for other SQL databases is not
-// guaranteed.
+// ParseQuery tokenizes the query, so that comments, quoted identifiers
+// (`name`, "name" and [name]) and strings are handled for every SQL
+// DatastoreProduct.  It recognizes common table expressions (WITH ...
+// SELECT), MERGE, UPSERT, joins, subqueries and Snowflake's identifier().
+// The collection is the primary table of the statement: the first table
+// after the outermost FROM of a SELECT or DELETE, or the target table of an
+// INSERT, UPDATE, MERGE, CREATE TABLE, DROP TABLE, ALTER TABLE or TRUNCATE.
+// The Product of the segment should be set before ParseQuery is called.
 func ParseQuery(segment *newrelic.DatastoreSegment, query string) {
-	s := cCommentRegex.ReplaceAllString(query, "")
-	s = lineCommentRegex.ReplaceAllString(s, "")
-	s = sqlPrefixRegex.ReplaceAllString(s, "")
-	op := strings.ToLower(firstWordRegex.FindString(s))
-	if rg, ok := sqlOperations[op]; ok {
-		segment.Operation = op
-		segment.RawQuery = query
-		if nil != rg {
-			if m := rg.FindStringSubmatch(s); len(m) > 1 {
-				segment.Collection = extractTable(m[1])
+	op, table := parseStatement(tokenize(query, segment.Product))
+	if op == "" {
+		return
+	}
+	segment.Operation = op
+	segment.RawQuery = query
+	if table != "" {
+		segment.Collection = table
+	}
+}
+
+// parseStatement returns the operation and the collection of the statement
+// tokens.  The operation is empty if the statement is not recognized.
+func parseStatement(tokens []token) (op, table string) {
+	i := 0
+	for i < len(tokens) && (tokens[i].is(";") || tokens[i].is("(")) {
+		i++
+	}
+	if i >= len(tokens) || tokens[i].kind != tokenWord {
+		return "", ""
+	}
+	op = strings.ToLower(tokens[i].text)
+
+	if op == "with" {
+		// The statement follows the common table expressions, whose
+		// queries are enclosed in parentheses.
+		for j := i + 1; j < len(tokens); j++ {
+			t := tokens[j]
+			if t.kind == tokenWord && t.depth <= tokens[i].depth && cteOperations[strings.ToLower(t.text)] {
+				return parseStatement(tokens[j:])
+			}
+		}
+		return "", ""
+	}
+
+	extract, ok := sqlOperations[op]
+	if !ok {
+		return "", ""
+	}
+	if !extract {
+		return op, ""
+	}
+	rest := tokens[i+1:]
+
+	switch op {
+	case "select":
+		table = fromTable(rest)
+	case "delete":
+		// DELETE FROM table, or DELETE table in MSSQL.
+		if table = fromTable(rest); table == "" {
+			table = tableName(rest, skipWords(rest, 0, updateModifiers))
+		}
+	case "insert", "replace", "upsert":
+		table = tableName(rest, skipWords(rest, 0, insertModifiers))
+	case "update":
+		table = tableName(rest, skipTop(rest, skipWords(rest, 0, updateModifiers)))
+	case "merge":
+		table = tableName(rest, skipTop(rest, skipWords(rest, 0, wordSet("into"))))
+	case "create", "drop", "alter", "truncate":
+		j := skipWords(rest, 0, ddlModifiers)
+		if j < len(rest) && rest[j].is("table") {
+			j = skipWords(rest, j+1, ddlModifiers)
+		} else if op != "truncate" {
+			// Only tables are collections.
+			return op, ""
+		}
+		table = tableName(rest, j)
+	}
+	return op, table
+}
+
+// skipWords returns the index of the first token from i which is not one of
+// the words given.
+func skipWords(tokens []token, i int, words map[string]bool) int {
+	for i < len(tokens) && tokens[i].kind == tokenWord && words[strings.ToLower(tokens[i].text)] {
+		i++
+	}
+	return i
+}
+
+// skipTop skips the MSSQL TOP (n) [PERCENT] clause at tokens[i], if any.
+func skipTop(tokens []token, i int) int {
+	if i >= len(tokens) || !tokens[i].is("top") {
+		return i
+	}
+	i++
+	if i < len(tokens) && tokens[i].is("(") {
+		for i < len(tokens) && !tokens[i].is(")") {
+			i++
+		}
+	}
+	i++
+	if i < len(tokens) && tokens[i].is("percent") {
+		i++
+	}
+	return i
+}
+
+// fromTable returns the table following the outermost FROM of the tokens.
+// When several FROM are equally outermost, the first one is used.
+func fromTable(tokens []token) string {
+	from := -1
+	for i, t := range tokens {
+		if t.is("from") && (from < 0 || t.depth < tokens[from].depth) {
+			from = i
+		}
+	}
+	if from < 0 {
+		return ""
+	}
+	return tableName(tokens, from+1)
+}
+
+// tableName returns the table whose possibly qualified name starts at
+// tokens[i].  Only the last part of a qualified name is returned.
+func tableName(tokens []token, i int) string {
+	for i < len(tokens) && (tokens[i].is("(") || tokens[i].is("[") || tokens[i].is("{")) {
+		i++
+	}
+	if i < len(tokens) && (tokens[i].is("only") || tokens[i].is("lateral")) {
+		i++
+	}
+	if i >= len(tokens) || !tokens[i].isName() {
+		return ""
+	}
+	t := tokens[i]
+	if t.kind == tokenWord {
+		switch strings.ToLower(t.text) {
+		case "select", "with":
+			// The table of a subquery.
+			_, table := parseStatement(tokens[i:])
+			return table
+		case "identifier", "table":
+			// Snowflake's IDENTIFIER('name') and TABLE('name').
+			if i+2 < len(tokens) && tokens[i+1].is("(") && tokens[i+2].isName() {
+				return lastPart(tokens[i+2].text)
 			}
 		}
 	}
+	name := t.text
+	for i+2 < len(tokens) && tokens[i+1].is(".") && tokens[i+2].isName() {
+		name = tokens[i+2].text
+		i += 2
+	}
+	return lastPart(name)
+}
+
+// lastPart returns the table of a qualified name such as database.table.
+func lastPart(name string) string {
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return strings.TrimSpace(name)
+}
+
+// extractTable returns the table of a possibly quoted and qualified name.
+func extractTable(s string) string {
+	return tableName(tokenize(s, ""), 0)
 }
@@ -196,3 +196,86 @@ func TestExtractTable(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSQLCommonTableExpressions(t *testing.T) {
+	for _, tc := range []sqlTestcase{
+		{Input: "WITH recent AS (SELECT * FROM orders WHERE age < 7) SELECT * FROM recent", Operation: "select", Table: "recent"},
+		{Input: "WITH RECURSIVE t(n) AS (VALUES (1) UNION ALL SELECT n+1 FROM t WHERE n < 100) SELECT sum(n) FROM t", Operation: "select", Table: "t"},
+		{Input: "WITH a AS (SELECT 1), b AS (SELECT 2) INSERT INTO archive SELECT * FROM a", Operation: "insert", Table: "archive"},
+		{Input: "WITH moved AS (DELETE FROM queue RETURNING *) UPDATE stats SET n = n + 1", Operation: "update", Table: "stats"},
+		{Input: "WITH", Operation: "other", Table: ""},
+	} {
+		tc.test(t)
+	}
+}
+
+func TestParseSQLMergeAndUpsert(t *testing.T) {
+	for _, tc := range []sqlTestcase{
+		{Input: "MERGE INTO target t USING source s ON t.id = s.id WHEN MATCHED THEN UPDATE SET t.v = s.v", Operation: "merge", Table: "target"},
+		{Input: "MERGE TOP (10) dbo.target AS t USING source AS s ON t.id = s.id", Operation: "merge", Table: "target"},
+		{Input: "UPSERT INTO users (id, name) VALUES (1, 'a')", Operation: "upsert", Table: "users"},
+		{Input: "REPLACE INTO users (id, name) VALUES (1, 'a')", Operation: "replace", Table: "users"},
+		{Input: "INSERT OR REPLACE INTO users VALUES (1)", Operation: "insert", Table: "users"},
+		{Input: "INSERT OVERWRITE TABLE events SELECT * FROM staging", Operation: "insert", Table: "events"},
+	} {
+		tc.test(t)
+	}
+}
+
+func TestParseSQLJoinsAndSubqueries(t *testing.T) {
+	for _, tc := range []sqlTestcase{
+		{Input: "SELECT u.name, o.total FROM users u JOIN orders o ON o.user_id = u.id", Operation: "select", Table: "users"},
+		{Input: "SELECT * FROM users WHERE id IN (SELECT user_id FROM orders)", Operation: "select", Table: "users"},
+		{Input: "SELECT (SELECT count(*) FROM orders o WHERE o.user_id = u.id) FROM users u", Operation: "select", Table: "users"},
+		{Input: "SELECT EXTRACT(YEAR FROM created) FROM users", Operation: "select", Table: "users"},
+		{Input: "DELETE FROM users WHERE id IN (SELECT user_id FROM banned)", Operation: "delete", Table: "users"},
+		{Input: "SELECT * FROM ONLY parent", Operation: "select", Table: "parent"},
+		{Input: "SELECT 'FROM fake' FROM real", Operation: "select", Table: "real"},
+	} {
+		tc.test(t)
+	}
+}
+
+func TestParseSQLMSSQL(t *testing.T) {
+	for _, tc := range []sqlTestcase{
+		{Input: "SELECT TOP 10 * FROM [dbo].[Users] WHERE [Name] = @p1", Operation: "select", Table: "Users"},
+		{Input: "SELECT * FROM [my db].[dbo].[order items]", Operation: "select", Table: "order items"},
+		{Input: "SELECT * FROM #temp", Operation: "select", Table: "#temp"},
+		{Input: "UPDATE TOP (5) dbo.Users SET Active = 0", Operation: "update", Table: "Users"},
+		{Input: "DELETE dbo.Users WHERE Id = @p1", Operation: "delete", Table: "Users"},
+		{Input: "EXEC sp_who", Operation: "exec", Table: ""},
+	} {
+		var segment newrelic.DatastoreSegment
+		segment.Product = newrelic.DatastoreMSSQL
+		ParseQuery(&segment, tc.Input)
+		if segment.Operation != tc.Operation || segment.Collection != tc.Table {
+			t.Errorf("query='%s' wanted='%s %s' got='%s %s'",
+				tc.Input, tc.Operation, tc.Table, segment.Operation, segment.Collection)
+		}
+	}
+}
+
+func TestParseSQLSnowflake(t *testing.T) {
+	for _, tc := range []sqlTestcase{
+		{Input: "SELECT * FROM identifier('mydb.public.events')", Operation: "select", Table: "events"},
+		{Input: "INSERT INTO IDENTIFIER($target) VALUES (1)", Operation: "insert", Table: "$target"},
+		{Input: "SELECT * FROM TABLE('events')", Operation: "select", Table: "events"},
+		{Input: "SELECT * FROM \"MYDB\".\"PUBLIC\".\"EVENTS\"", Operation: "select", Table: "EVENTS"},
+	} {
+		tc.test(t)
+	}
+}
+
+func TestParseSQLDDL(t *testing.T) {
+	for _, tc := range []sqlTestcase{
+		{Input: "CREATE TABLE IF NOT EXISTS users (id int)", Operation: "create", Table: "users"},
+		{Input: "CREATE OR REPLACE TEMPORARY TABLE scratch AS SELECT * FROM users", Operation: "create", Table: "scratch"},
+		{Input: "DROP TABLE IF EXISTS public.users", Operation: "drop", Table: "users"},
+		{Input: "ALTER TABLE users ADD COLUMN age int", Operation: "alter", Table: "users"},
+		{Input: "TRUNCATE TABLE users", Operation: "truncate", Table: "users"},
+		{Input: "TRUNCATE users", Operation: "truncate", Table: "users"},
+		{Input: "CREATE INDEX idx ON users (name)", Operation: "create", Table: ""},
+	} {
+		tc.test(t)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package sqlparse
+
+import (
+	"strings"
+
+	newrelic "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+type tokenKind int
+
+const (
+	// tokenWord is a keyword or an unquoted identifier.
+	tokenWord tokenKind = iota
+	// tokenIdentifier is a quoted identifier: `name`, "name" or [name].
+	// Its text does not include the quotes.
+	tokenIdentifier
+	// tokenString is a single quoted string.  Its text does not include
+	// the quotes.
+	tokenString
+	tokenNumber
+	// tokenPunct is any other character, such as ( or ,.
+	tokenPunct
+)
+
+// token is a lexical element of a query.  Whitespace and comments are not
+// tokens.
+type token struct {
+	kind tokenKind
+	text string
+	// depth is the number of parentheses enclosing the token.  It may be
+	// negative if the query has unbalanced parentheses.
+	depth int
+}
+
+// is reports whether the token is the keyword or punctuation given.
+// Keywords are compared case insensitively.
+func (t token) is(s string) bool {
+	return (t.kind == tokenWord || t.kind == tokenPunct) && strings.EqualFold(t.text, s)
+}
+
+// isName reports whether the token may be the name of a table.
+func (t token) isName() bool {
+	return t.kind == tokenWord || t.kind == tokenIdentifier || t.kind == tokenString
+}
+
+func isWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '_' || c == '$' || c == '@' || c == '#' || c >= 0x80
+}
+
+// tokenize splits a query into tokens.  The product determines the dialect:
+// # starts a comment everywhere but in MSSQL, where it starts the name of a
+// temporary table.
+func tokenize(query string, product newrelic.DatastoreProduct) []token {
+	var tokens []token
+	depth := 0
+	hashComments := product != newrelic.DatastoreMSSQL
+	n := len(query)
+
+	for i := 0; i < n; {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case (c == '-' && i+1 < n && query[i+1] == '-') || (c == '#' && hashComments):
+			end := strings.IndexAny(query[i:], "\r\n")
+			if end < 0 {
+				end = n - i
+			}
+			i += end
+		case c == '/' && i+1 < n && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 4
+			}
+		case c == '\'':
+			text, end := scanQuoted(query, i, '\'', true)
+			tokens = append(tokens, token{kind: tokenString, text: text, depth: depth})
+			i = end
+		case c == '"' || c == '`':
+			text, end := scanQuoted(query, i, c, false)
+			tokens = append(tokens, token{kind: tokenIdentifier, text: text, depth: depth})
+			i = end
+		case c == '[' && isBracketIdentifier(query[i:]):
+			end := strings.IndexByte(query[i:], ']')
+			text := strings.TrimSpace(query[i+1 : i+end])
+			tokens = append(tokens, token{kind: tokenIdentifier, text: text, depth: depth})
+			i += end + 1
+		case isWordChar(c):
+			end := i + 1
+			for end < n && isWordChar(query[end]) {
+				end++
+			}
+			kind := tokenWord
+			if c >= '0' && c <= '9' {
+				kind = tokenNumber
+			}
+			tokens = append(tokens, token{kind: kind, text: query[i:end], depth: depth})
+			i = end
+		default:
+			if c == ')' {
+				depth--
+			}
+			tokens = append(tokens, token{kind: tokenPunct, text: query[i : i+1], depth: depth})
+			if c == '(' {
+				depth++
+			}
+			i++
+		}
+	}
+	return tokens
+}
+
+// scanQuoted returns the text of the string or identifier starting with the
+// quote at query[start], and its end.  A doubled quote stands for the quote.
+// Unterminated strings extend to the end of the query.
+func scanQuoted(query string, start int, quote byte, backslashEscapes bool) (string, int) {
+	var buf strings.Builder
+	n := len(query)
+	for i := start + 1; i < n; i++ {
+		c := query[i]
+		switch {
+		case c == '\\' && backslashEscapes && i+1 < n:
+			i++
+			buf.WriteByte(query[i])
+		case c == quote && i+1 < n && query[i+1] == quote:
+			i++
+			buf.WriteByte(quote)
+		case c == quote:
+			return buf.String(), i + 1
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), n
+}
+
+// isBracketIdentifier reports whether s starts with an MSSQL or SQLite
+// bracket quoted identifier such as [my table].  Brackets enclosing quoted
+// names are not identifiers themselves.
+func isBracketIdentifier(s string) bool {
+	end := strings.IndexByte(s, ']')
+	return end > 0 && !strings.ContainsAny(s[1:end], "'\"`[")
+}
@@ -388,6 +388,15 @@ func (to *gRPCtraceObserver) initialConnCompleted() bool {
 	}
 }
 
+func (to *gRPCtraceObserver) diagnostics() observerDiagnostics {
+	return observerDiagnostics{
+		InitialConnCompleted: to.initialConnCompleted(),
+		Shutdown:             to.isShutdownComplete(),
+		QueueDepth:           len(to.messages),
+		QueueCapacity:        cap(to.messages),
+	}
+}
+
 // errShouldShutdown returns true if the given error is an Unimplemented error
 // meaning the connection to the trace observer should be shutdown.
 func errShouldShutdown(err error) bool {
@@ -25,6 +25,17 @@ type traceObserver interface {
 	// observer was made, but it does NOT indicate anything about the current state of the
 	// connection
 	initialConnCompleted() bool
+	// diagnostics returns the state of the trace observer.
+	diagnostics() observerDiagnostics
+}
+
+// observerDiagnostics is the state of the trace observer reported by
+// DiagnosticsHandler.
+type observerDiagnostics struct {
+	InitialConnCompleted bool `json:"initial_connection_completed"`
+	Shutdown             bool `json:"shutdown"`
+	QueueDepth           int  `json:"queue_depth"`
+	QueueCapacity        int  `json:"queue_capacity"`
 }
 
 type observerConfig struct {
@@ -95,6 +95,12 @@ type txnData struct {
 
 	SlowQueries *slowQueries
 
+	// repeatedCalls is nil unless Config.RepeatedCalls is enabled.
+	repeatedCalls *repeatedCalls
+	// aggregatedKinds are the kinds of segments merged when they follow a
+	// sibling with the same name.
+	aggregatedKinds segmentKind
+
 	// These better CAT supportability fields are left outside of
 	// TxnEvent.BetterCAT to minimize the size of transaction event memory.
 	DistributedTracingSupport distributedTracingSupport
@@ -115,6 +121,10 @@ type txnData struct {
 
 func (t *txnData) saveTraceSegment(end segmentEnd, name string, attrs spanAttributeMap, externalGUID string) {
 	attrs = t.Attrs.filterSpanAttributes(attrs, destSegment)
+	if agg := end.aggregate; nil != agg {
+		agg.attributes = attrs
+		agg.externalGUID = externalGUID
+	}
 	t.TxnTrace.witnessNode(end, name, attrs, externalGUID)
 }
 
@@ -126,6 +136,9 @@ type tracingThread struct {
 	// start and end are used to track the TotalTime this tracingThread was active.
 	start time.Time
 	end   time.Time
+	// aggregate is the last segment ended, if it may be merged with the
+	// next one.
+	aggregate *segmentAggregate
 }
 
 // RecordActivity indicates that activity happened at this time on this
@@ -299,6 +312,7 @@ func addAttr(m *spanAttributeMap, key string, val any) {
 type segmentFrame struct {
 	segmentTime
 	children        time.Duration
+	hasChildren     bool
 	spanID          string
 	agentAttributes spanAttributeMap
 	userAttributes  spanAttributeMap
@@ -314,13 +328,23 @@ type segmentEnd struct {
 	threadID        uint64
 	agentAttributes spanAttributeMap
 	userAttributes  spanAttributeMap
+
+	// parentStamp is the start stamp of the parent segment, zero for the
+	// segments of the root.
+	parentStamp segmentStamp
+	hasChildren bool
+	// previous is the previous segment ended on the thread, if it may be
+	// merged with this one.
+	previous *segmentAggregate
+	// aggregate is set when this segment may be merged with the next.
+	aggregate *segmentAggregate
 }
 
 func (end segmentEnd) spanEvent() *spanEvent {
 	if end.SpanID == "" {
 		return nil
 	}
-	return &spanEvent{
+	evt := &spanEvent{
 		GUID:            end.SpanID,
 		ParentID:        end.ParentID,
 		Timestamp:       end.start.Time,
@@ -329,6 +353,10 @@ func (end segmentEnd) spanEvent() *spanEvent {
 		UserAttributes:  end.userAttributes,
 		IsEntrypoint:    false,
 	}
+	if nil != end.aggregate {
+		end.aggregate.span = evt
+	}
+	return evt
 }
 
 const (
@@ -392,6 +420,9 @@ func (thread *tracingThread) RemoveErrorSpanAttribute(key string) {
 // startSegment begins a segment.
 func startSegment(t *txnData, thread *tracingThread, now time.Time) segmentStartTime {
 	tm := t.time(now)
+	if len(thread.stack) > 0 {
+		thread.stack[len(thread.stack)-1].hasChildren = true
+	}
 	thread.stack = append(thread.stack, segmentFrame{
 		segmentTime: tm,
 		children:    0,
@@ -462,7 +493,10 @@ func endSegment(t *txnData, thread *tracingThread, start segmentStartTime, now t
 		start:           frame.segmentTime,
 		agentAttributes: frame.agentAttributes,
 		userAttributes:  frame.userAttributes,
+		hasChildren:     frame.hasChildren,
+		previous:        thread.aggregate,
 	}
+	thread.aggregate = nil
 	if s.stop.Time.After(s.start.Time) {
 		s.duration = s.stop.Time.Sub(s.start.Time)
 	}
@@ -476,6 +510,7 @@ func endSegment(t *txnData, thread *tracingThread, start segmentStartTime, now t
 
 	if start.Depth > 0 {
 		thread.stack[start.Depth-1].children += s.duration
+		s.parentStamp = thread.stack[start.Depth-1].Stamp
 	}
 
 	thread.stack = thread.stack[0:start.Depth]
@@ -522,6 +557,10 @@ func endBasicSegment(t *txnData, thread *tracingThread, start segmentStartTime,
 		t.customSegments[name] = cpy
 	}
 
+	if t.aggregateSegment(thread, segmentKindCustom, &end, customSegmentMetric(name)) {
+		return nil
+	}
+
 	if t.TxnTrace.considerNode(end) {
 		attributes := end.agentAttributes.copy()
 		t.saveTraceSegment(end, customSegmentMetric(name), attributes, "")
@@ -549,15 +588,17 @@ type endExternalParams struct {
 	Library    string
 	Method     string
 	StatusCode *int
+	// Timings contains the phases of the request, if recorded, which
+	// are added as child segments when PhaseSegments is true.
+	Timings       *externalTimings
+	PhaseSegments bool
+	// RequestHeader is the header of the request, if any.
+	RequestHeader http.Header
 }
 
 // endExternalSegment ends an external segment.
 func endExternalSegment(p endExternalParams) error {
 	t := p.TxnData
-	end, err := endSegment(t, p.Thread, p.Start, p.Now)
-	if err != nil {
-		return err
-	}
 
 	// Use the Host field if present, otherwise use host in the URL.
 	if p.Host == "" && p.URL != nil {
@@ -570,6 +611,16 @@ func endExternalSegment(p endExternalParams) error {
 		p.Library = "http"
 	}
 
+	phases, reused := p.Timings.phases(p.Now)
+	if p.PhaseSegments {
+		addExternalPhaseSegments(t, p.Thread, p.Start, p.Host, phases)
+	}
+
+	end, err := endSegment(t, p.Thread, p.Start, p.Now)
+	if err != nil {
+		return err
+	}
+
 	var appData *cat.AppDataHeader
 	if p.Response != nil {
 		hdr := httpHeaderToAppData(p.Response.Header)
@@ -616,11 +667,24 @@ func endExternalSegment(p endExternalParams) error {
 		t.externalSegments[key] = cpy
 	}
 
+	repeated := t.repeatedCalls.observe(repeatedCallKey{
+		category: repeatedCallExternal,
+		name:     p.Host,
+		method:   p.Method,
+		detail:   repeatedCallPath(p.URL),
+	})
+
+	if t.aggregateSegment(p.Thread, segmentKindExternal, &end, key.scopedMetric()) {
+		return nil
+	}
+
 	if t.TxnTrace.considerNode(end) {
 		attributes := end.agentAttributes.copy()
 		if p.Library == "http" {
 			attributes.addString(SpanAttributeHTTPURL, safeURL(p.URL))
 		}
+		addExternalPhaseAttributes(&attributes, phases, reused)
+		externalHeaderAttributes(t.Attrs, &attributes, p.RequestHeader, p.Response)
 		t.saveTraceSegment(end, key.scopedMetric(), attributes, transactionGUID)
 	}
 
@@ -638,6 +702,11 @@ func endExternalSegment(p endExternalParams) error {
 		} else if p.Response != nil {
 			evt.AgentAttributes.addInt(SpanAttributeHTTPStatusCode, p.Response.StatusCode)
 		}
+		if repeated > 0 {
+			evt.AgentAttributes.addInt(AttributeRepeatedCallCount, repeated)
+		}
+		addExternalPhaseAttributes(&evt.AgentAttributes, phases, reused)
+		externalHeaderAttributes(t.Attrs, &evt.AgentAttributes, p.RequestHeader, p.Response)
 		t.saveSpanEvent(evt)
 	}
 
@@ -686,6 +755,10 @@ func endMessageSegment(p endMessageParams) error {
 		t.messageSegments[key] = cpy
 	}
 
+	if t.aggregateSegment(p.Thread, segmentKindMessage, &end, key.Name()) {
+		return nil
+	}
+
 	if t.TxnTrace.considerNode(end) {
 		attributes := end.agentAttributes.copy()
 		t.saveTraceSegment(end, key.Name(), attributes, "")
@@ -715,6 +788,10 @@ type endDatastoreParams struct {
 	PortPathOrID       string
 	Database           string
 	ThisHost           string
+
+	// RepeatedCallQuery is the query used to count repeated calls.  The
+	// ParameterizedQuery is used if empty.
+	RepeatedCallQuery string
 }
 
 const (
@@ -809,17 +886,15 @@ func endDatastoreSegment(p endDatastoreParams) error {
 	// errors in QueryParameters must not stop the recording of the segment
 	queryParams, err := vetQueryParameters(p.QueryParameters)
 
-	if p.TxnData.TxnTrace.considerNode(end) {
-		attributes := end.agentAttributes.copy()
-		attributes.addString(SpanAttributeDBStatement, p.ParameterizedQuery)
-		attributes.addString(SpanAttributeDBInstance, p.Database)
-		attributes.addString(SpanAttributePeerAddress, datastoreSpanAddress(p.Host, p.PortPathOrID))
-		attributes.addString(SpanAttributePeerHostname, p.Host)
-		if len(queryParams) > 0 {
-			attributes.add(spanAttributeQueryParameters, queryParams)
-		}
-		p.TxnData.saveTraceSegment(end, scopedMetric, attributes, "")
+	repeatedCallQuery := p.RepeatedCallQuery
+	if repeatedCallQuery == "" {
+		repeatedCallQuery = p.ParameterizedQuery
 	}
+	repeated := p.TxnData.repeatedCalls.observe(repeatedCallKey{
+		category: repeatedCallDatastore,
+		name:     scopedMetric,
+		detail:   repeatedCallQuery,
+	})
 
 	if p.TxnData.slowQueryWorthy(end.duration) {
 		if nil == p.TxnData.SlowQueries {
@@ -837,6 +912,22 @@ func endDatastoreSegment(p endDatastoreParams) error {
 		})
 	}
 
+	if p.TxnData.aggregateSegment(p.Thread, segmentKindDatastore, &end, scopedMetric) {
+		return err
+	}
+
+	if p.TxnData.TxnTrace.considerNode(end) {
+		attributes := end.agentAttributes.copy()
+		attributes.addString(SpanAttributeDBStatement, p.ParameterizedQuery)
+		attributes.addString(SpanAttributeDBInstance, p.Database)
+		attributes.addString(SpanAttributePeerAddress, datastoreSpanAddress(p.Host, p.PortPathOrID))
+		attributes.addString(SpanAttributePeerHostname, p.Host)
+		if len(queryParams) > 0 {
+			attributes.add(spanAttributeQueryParameters, queryParams)
+		}
+		p.TxnData.saveTraceSegment(end, scopedMetric, attributes, "")
+	}
+
 	if evt := end.spanEvent(); evt != nil {
 		evt.Name = scopedMetric
 		evt.Category = spanCategoryDatastore
@@ -847,6 +938,9 @@ func endDatastoreSegment(p endDatastoreParams) error {
 		evt.AgentAttributes.addString(SpanAttributePeerAddress, datastoreSpanAddress(p.Host, p.PortPathOrID))
 		evt.AgentAttributes.addString(SpanAttributePeerHostname, p.Host)
 		evt.AgentAttributes.addString(SpanAttributeDBCollection, p.Collection)
+		if repeated > 0 {
+			evt.AgentAttributes.addInt(AttributeRepeatedCallCount, repeated)
+		}
 		p.TxnData.saveSpanEvent(evt)
 	}
 
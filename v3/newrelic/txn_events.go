@@ -188,6 +188,14 @@ func (events *txnEvents) EndpointMethod() string {
 	return cmdTxnEvents
 }
 
+func (events *txnEvents) splitPayload() (payloadCreator, payloadCreator) {
+	if len(events.events) < 2 {
+		return nil, nil
+	}
+	e1, e2 := events.split()
+	return &txnEvents{analyticsEvents: e1}, &txnEvents{analyticsEvents: e2}
+}
+
 func (events *txnEvents) payloads(limit int) []payloadCreator {
 	if events.NumSaved() < float64(limit) {
 		return []payloadCreator{events}
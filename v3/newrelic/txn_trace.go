@@ -30,11 +30,24 @@ type traceNode struct {
 	duration time.Duration
 	traceNodeParams
 	name string
+	// aggregate is set when the node contains segments merged together,
+	// whose index in the heap must be tracked.
+	aggregate *segmentAggregate
 }
 
 func (h traceNodeHeap) Len() int           { return len(h) }
 func (h traceNodeHeap) Less(i, j int) bool { return h[i].duration < h[j].duration }
-func (h traceNodeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h traceNodeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h.setIndex(i)
+	h.setIndex(j)
+}
+
+func (h traceNodeHeap) setIndex(i int) {
+	if agg := h[i].aggregate; nil != agg {
+		agg.node = i
+	}
+}
 
 // Push and Pop are unused: only heap.Init and heap.Fix are used.
 func (h traceNodeHeap) Push(x interface{}) {}
@@ -59,22 +72,25 @@ func (trace *txnTrace) getMaxNodes() int {
 }
 
 // considerNode exists to prevent unnecessary calls to witnessNode: constructing
-// the metric name and params map requires allocations.
+// the metric name and params map requires allocations.  The segments which may
+// be merged with the next are considered regardless of their duration, since
+// their aggregate may exceed the threshold.
 func (trace *txnTrace) considerNode(end segmentEnd) bool {
-	return trace.Enabled && (end.duration >= trace.SegmentThreshold)
+	return trace.Enabled && (end.duration >= trace.SegmentThreshold || nil != end.aggregate)
 }
 
 func (trace *txnTrace) witnessNode(end segmentEnd, name string, attrs spanAttributeMap, externalGUID string) {
 	node := traceNode{
-		start:    end.start,
-		stop:     end.stop,
-		duration: end.duration,
-		threadID: end.threadID,
-		name:     name,
+		start:     end.start,
+		stop:      end.stop,
+		duration:  end.duration,
+		threadID:  end.threadID,
+		name:      name,
+		aggregate: end.aggregate,
 	}
 	node.attributes = attrs
 	node.TransactionGUID = externalGUID
-	if !trace.considerNode(end) {
+	if !trace.Enabled || end.duration < trace.SegmentThreshold {
 		return
 	}
 	if trace.nodes == nil {
@@ -85,6 +101,7 @@ func (trace *txnTrace) witnessNode(end segmentEnd, name string, attrs spanAttrib
 	}
 	if max := trace.getMaxNodes(); len(trace.nodes) < max {
 		trace.nodes = append(trace.nodes, node)
+		trace.nodes.setIndex(len(trace.nodes) - 1)
 		if len(trace.nodes) == max {
 			heap.Init(trace.nodes)
 		}
@@ -94,10 +111,41 @@ func (trace *txnTrace) witnessNode(end segmentEnd, name string, attrs spanAttrib
 	if node.duration <= trace.nodes[0].duration {
 		return
 	}
+	if agg := trace.nodes[0].aggregate; nil != agg {
+		agg.node = -1
+	}
 	trace.nodes[0] = node
+	trace.nodes.setIndex(0)
 	heap.Fix(trace.nodes, 0)
 }
 
+// witnessAggregate updates the node of segments merged together after another
+// segment has been merged, or witnesses it if it has not been kept.
+func (trace *txnTrace) witnessAggregate(agg *segmentAggregate) {
+	if agg.node < 0 {
+		if len(trace.nodes) == trace.getMaxNodes() && agg.duration() <= trace.nodes[0].duration {
+			// Avoid capturing a stack trace for each segment merged.
+			return
+		}
+		trace.witnessNode(segmentEnd{
+			start:     agg.start,
+			stop:      agg.stop,
+			duration:  agg.duration(),
+			exclusive: agg.total,
+			threadID:  agg.threadID,
+			aggregate: agg,
+		}, agg.name, agg.attributes, agg.externalGUID)
+		return
+	}
+	node := &trace.nodes[agg.node]
+	node.stop = agg.stop
+	node.duration = agg.duration()
+	node.attributes = agg.attributes
+	if len(trace.nodes) == trace.getMaxNodes() {
+		heap.Fix(trace.nodes, agg.node)
+	}
+}
+
 // harvestTrace contains a finished transaction trace ready for serialization to
 // the collector.
 type harvestTrace struct {